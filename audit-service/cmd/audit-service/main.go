@@ -7,15 +7,24 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"audit-service/config"
 	"audit-service/db"
+	"audit-service/internal/archive"
+	"audit-service/internal/auth"
+	"audit-service/internal/certs"
+	"audit-service/internal/chain"
+	"audit-service/internal/encryption"
 	"audit-service/internal/handler"
 	"audit-service/internal/repository"
 	"audit-service/internal/service"
+	"audit-service/internal/spool"
 	"audit-service/pkg/postgres"
+	"audit-service/pkg/sqlite"
 
 	"github.com/gorilla/mux"
 )
@@ -29,40 +38,318 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// 2. Подключение к БД
-	dbConn, err := postgres.NewConnection(
-		cfg.DBHost,
-		cfg.DBPort,
-		cfg.DBUser,
-		cfg.DBPassword,
-		cfg.DBName,
-	)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	// 2. Хранилище событий. Встроенные хранилища (в памяти и SQLite) - для
+	// разработки и CI: секции, сроки хранения, архив, цепочка хешей с
+	// контрольными точками и шифрование есть только у Postgres.
+	var dbConn, purgeConn, readConn *sql.DB
+	var encryptor *encryption.Encryptor
+	var auditRepo, readRepo repository.AuditRepository
+	var apiKeyRepo repository.APIKeyRepository
+	switch cfg.StorageBackend {
+	case config.BackendMemory:
+		auditRepo = repository.NewMemoryRepository()
+		readRepo = auditRepo
+		apiKeyRepo = repository.NewMemoryAPIKeyRepository()
+		log.Printf("Using in-memory storage, events are lost on restart")
+	case config.BackendSQLite:
+		sqliteConn, err := sqlite.NewConnection(cfg.SQLitePath)
+		if err != nil {
+			log.Fatalf("Failed to open SQLite database: %v", err)
+		}
+		defer sqliteConn.Close()
+		if err := db.RunSQLiteMigrations(sqliteConn); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+		auditRepo = repository.NewSQLiteRepository(sqliteConn)
+		readRepo = auditRepo
+		apiKeyRepo = repository.NewSQLiteAPIKeyRepository(sqliteConn)
+		// Для проверки доступности и переноса спула
+		dbConn = sqliteConn
+		log.Printf("Using SQLite storage in %s", cfg.SQLitePath)
+	default:
+		dbConn, purgeConn, readConn, encryptor = openPostgres(cfg)
+		defer dbConn.Close()
+		if purgeConn != dbConn {
+			defer purgeConn.Close()
+		}
+		if readConn != dbConn {
+			defer readConn.Close()
+		}
+		auditRepo = repository.NewAuditRepository(dbConn, encryptor)
+		readRepo = repository.NewAuditRepository(readConn, encryptor)
+		apiKeyRepo = repository.NewAPIKeyRepository(dbConn)
 	}
-	defer dbConn.Close()
+	postgresBackend := cfg.StorageBackend == config.BackendPostgres
 
-	// 3. Применение миграций
-	if err := db.RunMigrations(dbConn); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	// 4. Инициализация слоев
+	var eventSpool *spool.Spool
+	var replayer *service.Replayer
+	if cfg.SpoolDir != "" {
+		eventSpool, err = spool.Open(cfg.SpoolDir, cfg.SpoolSegmentSize)
+		if err != nil {
+			log.Fatalf("Failed to open spool: %v", err)
+		}
+		replayer = service.NewReplayer(auditRepo, eventSpool)
+		log.Printf("Spool enabled in %s", cfg.SpoolDir)
 	}
 
-	// 4. Инициализация слоев
-	auditRepo := repository.NewAuditRepository(dbConn)
-	auditService := service.NewAuditService(auditRepo)
+	var asyncWriter *service.AsyncWriter
+	if cfg.AsyncWrites {
+		asyncWriter = service.NewAsyncWriter(auditRepo, eventSpool, service.AsyncConfig{
+			QueueSize:     cfg.AsyncQueueSize,
+			Workers:       cfg.AsyncWorkers,
+			BatchSize:     cfg.AsyncBatchSize,
+			FlushInterval: cfg.AsyncFlushInterval,
+		})
+		log.Printf("Async writes enabled: %d workers, queue size %d", cfg.AsyncWorkers, cfg.AsyncQueueSize)
+	}
+
+	// Холодный архив старых событий
+	var eventArchive *archive.Archive
+	var archiver *service.Archiver
+	if cfg.ArchiveEnabled() {
+		var store archive.Store
+		if cfg.ArchiveDir != "" {
+			store, err = archive.NewFSStore(cfg.ArchiveDir)
+		} else {
+			store, err = archive.NewS3Store(context.Background(), archive.S3Config{
+				Endpoint:  cfg.ArchiveS3Endpoint,
+				Bucket:    cfg.ArchiveS3Bucket,
+				Prefix:    cfg.ArchiveS3Prefix,
+				AccessKey: cfg.ArchiveS3AccessKey,
+				SecretKey: cfg.ArchiveS3SecretKey,
+				UseSSL:    cfg.ArchiveS3UseSSL,
+			})
+		}
+		if err != nil {
+			log.Fatalf("Failed to open archive storage: %v", err)
+		}
+		eventArchive = archive.New(store)
+		// Сроки хранения действуют и на архив; в режиме dry-run архив не трогается
+		var archiveRetention *repository.RetentionPolicy
+		if !cfg.RetentionDryRun {
+			archiveRetention = repository.NewRetentionPolicy(cfg.RetentionRules, cfg.RetentionDefaultDays, encryptor)
+		}
+		archiver = service.NewArchiver(repository.NewArchiveRepository(purgeConn), eventArchive, service.ArchiveConfig{
+			AfterDays:   cfg.ArchiveAfterDays,
+			Interval:    cfg.ArchiveInterval,
+			SegmentRows: cfg.ArchiveSegmentRows,
+			RestoreHold: cfg.ArchiveRestoreHold,
+			Retention:   archiveRetention,
+		})
+	}
+
+	// Квоты записи арендаторов
+	var tenantQuotas *service.TenantQuotas
+	if len(cfg.TenantQuotas) > 0 {
+		// Встроенные хранилища работают в одном процессе, им хватает счётчиков в памяти
+		quotaRepo := repository.NewMemoryQuotaRepository()
+		if postgresBackend {
+			quotaRepo = repository.NewQuotaRepository(dbConn)
+		}
+		tenantQuotas = service.NewTenantQuotas(quotaRepo, cfg.TenantQuotas)
+		log.Printf("Tenant quotas enabled for %d tenants", len(cfg.TenantQuotas))
+	}
+
+	eventStream := service.NewEventStream(auditRepo)
+	auditService := service.NewAuditService(auditRepo, asyncWriter, eventSpool, eventStream, eventArchive, tenantQuotas)
 	auditHandler := handler.NewAuditHandler(auditService)
+	exportHandler := handler.NewExportHandler(service.NewExportService(readRepo))
+	// Проверка цепочки и контрольные точки читают таблицы Postgres напрямую
+	var chainHandler *handler.ChainHandler
+	var checkpointHandler *handler.CheckpointHandler
+	checkpointConfig := service.CheckpointConfig{Window: cfg.CheckpointWindow, Interval: cfg.CheckpointInterval}
+	if postgresBackend {
+		readChainVerifier := service.NewChainVerifier(repository.NewChainRepository(readConn, encryptor))
+		chainHandler = handler.NewChainHandler(readChainVerifier)
+		// Доказательства включения строятся с реплики, контрольные точки - на primary
+		checkpointHandler = handler.NewCheckpointHandler(service.NewCheckpointer(repository.NewCheckpointRepository(readConn),
+			readRepo, readChainVerifier, nil, checkpointConfig))
+	}
 	statsHandler := handler.NewStatsHandler(cfg.AppVersion)
 
-	// 5. Настройка health-check для БД
-	go monitorDBConnection(dbConn, statsHandler)
+	// 5. Настройка health-check для БД и фоновых задач
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if replayer != nil {
+		go replayer.Run(backgroundCtx)
+		// Остатки спула с прошлого запуска
+		replayer.Trigger()
+	}
+	if dbConn != nil {
+		go monitorDBConnection(dbConn, statsHandler, replayer)
+	} else {
+		// Хранилище в памяти всегда доступно
+		statsHandler.SetDBConnected(true)
+	}
+
+	if postgresBackend {
+		// Месячные секции audit_events
+		partitionManager := service.NewPartitionManager(repository.NewPartitionRepository(purgeConn), service.PartitionConfig{
+			MonthsAhead:  cfg.PartitionMonthsAhead,
+			RetainMonths: cfg.PartitionRetainMonths,
+			Interval:     cfg.PartitionCheckInterval,
+		})
+		go partitionManager.Run(backgroundCtx)
+
+		// Очистка по срокам хранения
+		retentionPurger := service.NewRetentionPurger(repository.NewRetentionRepository(purgeConn, encryptor), service.RetentionConfig{
+			Rules:       cfg.RetentionRules,
+			DefaultDays: cfg.RetentionDefaultDays,
+			Interval:    cfg.RetentionInterval,
+			BatchSize:   cfg.RetentionBatchSize,
+			DryRun:      cfg.RetentionDryRun,
+		})
+		if retentionPurger.Enabled() {
+			statsHandler.SetRetentionSource(retentionPurger)
+			go retentionPurger.Run(backgroundCtx)
+			log.Printf("Retention enabled: %d rules, default %d days, dry run %t",
+				len(cfg.RetentionRules), cfg.RetentionDefaultDays, cfg.RetentionDryRun)
+		}
+	}
+
+	if archiver != nil && archiver.Enabled() {
+		go archiver.Run(backgroundCtx)
+		if cfg.ArchiveAfterDays > 0 {
+			log.Printf("Archiving events older than %d days", cfg.ArchiveAfterDays)
+		}
+	}
+
+	// Подписанные контрольные точки цепочки хешей
+	if cfg.CheckpointKeyFile != "" {
+		key, err := chain.LoadPrivateKey(cfg.CheckpointKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load checkpoint key: %v", err)
+		}
+		checkpointer := service.NewCheckpointer(repository.NewCheckpointRepository(dbConn), auditRepo,
+			service.NewChainVerifier(repository.NewChainRepository(dbConn, encryptor)), key, checkpointConfig)
+		go checkpointer.Run(backgroundCtx)
+		log.Printf("Checkpoints enabled every %d chain links", cfg.CheckpointWindow)
+	}
+
+	// Ротация ключей шифрования запускается вручную через API
+	var encryptionHandler *handler.EncryptionHandler
+	if encryptor != nil {
+		keyRotator := service.NewKeyRotator(repository.NewKeyRepository(purgeConn), encryptor, cfg.EncryptionRotateBatch)
+		go keyRotator.Run(backgroundCtx)
+		encryptionHandler = handler.NewEncryptionHandler(keyRotator)
+	}
+
+	// Живая лента: уведомления о вставках от всех реплик приходят через LISTEN,
+	// встроенные хранилища сообщают о вставках сами
+	if notifier, ok := auditRepo.(repository.EventNotifier); ok {
+		notifier.NotifyInserts(eventStream.Publish)
+	} else {
+		eventListener, err := repository.NewEventListener(postgres.ConnString(
+			cfg.DBHost,
+			cfg.DBPort,
+			cfg.DBUser,
+			cfg.DBPassword,
+			cfg.DBName,
+		))
+		if err != nil {
+			log.Fatalf("Failed to start event listener: %v", err)
+		}
+		defer eventListener.Close()
+		go eventListener.Run(backgroundCtx, eventStream.Publish)
+	}
 
 	// 6. Настройка маршрутизатора
 	router := mux.NewRouter()
 
-	// API эндпоинты
+	// Клиентские сертификаты: с TLS_CLIENT_CA_FILE их владелец становится
+	// клиентом запроса
+	var certMapper *auth.CertMapper
+	if cfg.TLSClientCAFile != "" {
+		certMapper = auth.NewCertMapper(cfg.TLSClientIdentity, cfg.TLSClientScopes, cfg.TLSClientTenants)
+	}
+
+	// API эндпоинты. Маршруты разложены по правам, которые нужны клиенту:
+	// с AUTH_ENABLED каждую группу закрывает проверка ключа API, JWT или
+	// клиентского сертификата.
 	apiRouter := router.PathPrefix("/audit").Subrouter()
-	apiRouter.HandleFunc("/events/", auditHandler.StoreEvent).Methods("POST")
-	apiRouter.HandleFunc("/events/query", auditHandler.FindEvents).Methods("GET")
+	if certMapper != nil && !cfg.AuthEnabled {
+		apiRouter.Use(handler.IdentifyClientCert(certMapper))
+	}
+	writeRouter := apiRouter.NewRoute().Subrouter()
+	readRouter := apiRouter.NewRoute().Subrouter()
+	adminRouter := apiRouter.NewRoute().Subrouter()
+	if cfg.AuthEnabled {
+		apiKeyService := service.NewAPIKeyService(apiKeyRepo, cfg.AuthBootstrapKey)
+		var tokenVerifier *auth.TokenVerifier
+		if cfg.AuthJWKS != "" {
+			jwks, err := auth.LoadJWKS(backgroundCtx, cfg.AuthJWKS)
+			if err != nil {
+				log.Fatalf("Failed to load JWKS: %v", err)
+			}
+			go jwks.Run(backgroundCtx, cfg.AuthJWKSRefresh)
+
+			tokenVerifier = auth.NewTokenVerifier(jwks, auth.TokenConfig{
+				Issuer:      cfg.AuthJWTIssuer,
+				Audience:    cfg.AuthJWTAudience,
+				ScopeClaim:  cfg.AuthJWTScopeClaim,
+				ScopeMap:    cfg.AuthJWTScopeMap,
+				StrictUser:  cfg.AuthStrictUser,
+				TenantClaim: cfg.AuthJWTTenantClaim,
+			})
+			log.Printf("JWT authentication enabled (issuer %s, strict user: %v)", cfg.AuthJWTIssuer, cfg.AuthStrictUser)
+		}
+
+		authHandler := handler.NewAuthHandler(service.NewAuthenticator(apiKeyService, tokenVerifier, certMapper))
+		writeRouter.Use(authHandler.Require(auth.ScopeEventsWrite))
+		readRouter.Use(authHandler.Require(auth.ScopeEventsRead))
+		adminRouter.Use(authHandler.Require(auth.ScopeAdmin))
+
+		apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService)
+
+		adminRouter.HandleFunc("/keys", apiKeyHandler.Create).Methods("POST")
+		adminRouter.HandleFunc("/keys", apiKeyHandler.List).Methods("GET")
+		adminRouter.HandleFunc("/keys/{id}", apiKeyHandler.Revoke).Methods("DELETE")
+		log.Printf("API key authentication enabled")
+	}
+	// Арендатор запроса определяется после аутентификации: от него зависит,
+	// какие события видит и куда пишет клиент
+	writeRouter.Use(handler.ResolveTenant)
+	readRouter.Use(handler.ResolveTenant)
+	adminRouter.Use(handler.ResolveTenant)
+	// Архив, цепочка хешей и ключи шифрования общие для всех арендаторов:
+	// их видит только администратор кластера
+	clusterRouter := adminRouter.NewRoute().Subrouter()
+	clusterRouter.Use(handler.AllTenants())
+
+	writeRouter.HandleFunc("/events/", auditHandler.IngestNDJSON).Methods("POST").
+		HeadersRegexp("Content-Type", "^application/x-ndjson")
+	writeRouter.HandleFunc("/events/", auditHandler.StoreEvent).Methods("POST")
+	writeRouter.HandleFunc("/events/batch", auditHandler.StoreEvents).Methods("POST")
+	readRouter.HandleFunc("/events/query", auditHandler.FindEvents).Methods("GET")
+	readRouter.HandleFunc("/events/aggregate", auditHandler.AggregateEvents).Methods("GET")
+	readRouter.HandleFunc("/events/stream", auditHandler.StreamEvents).Methods("GET")
+	readRouter.HandleFunc("/events/export", exportHandler.Export).Methods("GET")
+	// Только числовые id, чтобы не пересекаться с /events/query, /events/stream и т.п.
+	readRouter.HandleFunc("/events/{id:[0-9]+}", auditHandler.GetEvent).Methods("GET")
+	readRouter.HandleFunc("/sessions/{id:-?[0-9]+}/timeline", auditHandler.SessionTimeline).Methods("GET")
+	readRouter.HandleFunc("/requests/{id:-?[0-9]+}/timeline", auditHandler.RequestTimeline).Methods("GET")
+	if postgresBackend {
+		// Доказательство - для своего события: остальные звенья окна видны
+		// только хешами
+		readRouter.HandleFunc("/events/{id:[0-9]+}/proof", checkpointHandler.Proof).Methods("GET")
+		clusterRouter.HandleFunc("/verify", chainHandler.Verify).Methods("GET")
+		clusterRouter.HandleFunc("/checkpoints", checkpointHandler.List).Methods("GET")
+	}
+
+	if archiver != nil {
+		archiveHandler := handler.NewArchiveHandler(archiver)
+		clusterRouter.HandleFunc("/archive/manifest", archiveHandler.Manifest).Methods("GET")
+		clusterRouter.HandleFunc("/archive/restore", archiveHandler.Restore).Methods("POST")
+	}
+	if encryptionHandler != nil {
+		clusterRouter.HandleFunc("/encryption/keys", encryptionHandler.Keys).Methods("GET")
+		clusterRouter.HandleFunc("/encryption/rotate", encryptionHandler.Rotate).Methods("POST")
+	}
+	if tenantQuotas != nil {
+		// Расход квот всех арендаторов - только администратору кластера
+		clusterRouter.HandleFunc("/tenants/quotas", handler.NewQuotaHandler(tenantQuotas).Report).Methods("GET")
+	}
 
 	// Сервисные эндпоинты
 	router.HandleFunc("/stats", statsHandler.Stats).Methods("GET")
@@ -81,12 +368,53 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// С TLS_PORT HTTPS слушает отдельный сервер с тем же роутером: клиенты
+	// с сертификатами приходят на него через TLS passthrough шлюза, а шлюз
+	// по HTTP и health-check остаются на основном порту
+	var tlsSrv *http.Server
+	if cfg.TLSEnabled() {
+		certReloader, err := certs.Load(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile, cfg.TLSClientCertRequire)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+		go certReloader.Run(backgroundCtx, cfg.TLSReloadInterval)
+		tlsSrv = srv
+		if cfg.TLSPort > 0 {
+			tlsSrv = &http.Server{
+				Addr:         ":" + strconv.Itoa(cfg.TLSPort),
+				Handler:      router,
+				ReadTimeout:  srv.ReadTimeout,
+				WriteTimeout: srv.WriteTimeout,
+				IdleTimeout:  srv.IdleTimeout,
+			}
+		}
+		tlsSrv.TLSConfig = certReloader.TLSConfig()
+	}
+
 	go func() {
-		log.Printf("Starting audit service on port %d", cfg.ServerPort)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Starting audit service on port %d (TLS: %v, TLS port: %d, client certificates: %v)",
+			cfg.ServerPort, cfg.TLSEnabled(), cfg.TLSPort, cfg.TLSClientCAFile != "")
+		var err error
+		if tlsSrv == srv {
+			// Сертификат берётся из srv.TLSConfig и обновляется без перезапуска
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
+	// Shutdown не ждёт живые ленты: они закрываются в начале остановки
+	srv.RegisterOnShutdown(eventStream.Close)
+	if tlsSrv != nil && tlsSrv != srv {
+		tlsSrv.RegisterOnShutdown(eventStream.Close)
+		go func() {
+			if err := tlsSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("TLS server failed: %v", err)
+			}
+		}()
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -97,14 +425,147 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	if tlsSrv != nil && tlsSrv != srv {
+		if err := tlsSrv.Shutdown(ctx); err != nil {
+			log.Printf("TLS server forced to shutdown: %v", err)
+		}
+	}
+	// Ошибка остановки не повод бросать очередь записи и спул
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+
+	// Новые запросы уже не принимаются, дописываем то, что осталось в
+	// очереди. Своё время, чтобы долгая остановка сервера его не съела.
+	if asyncWriter != nil {
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), 15*time.Second)
+		if err := asyncWriter.Shutdown(drainCtx); err != nil {
+			log.Printf("Failed to drain async write queue: %v", err)
+		}
+		cancelDrain()
+	}
+
+	stopBackground()
+	if eventSpool != nil {
+		if err := eventSpool.Close(); err != nil {
+			log.Printf("Failed to close spool: %v", err)
+		}
 	}
 
 	log.Println("Server exited properly")
 }
 
-func monitorDBConnection(db *sql.DB, statsHandler *handler.StatsHandler) {
+// openPostgres подключается к Postgres, применяет миграции, проверяет права
+// и открывает соединения пользователя очистки и реплики (они совпадают с
+// dbConn, если не настроены отдельно). encryptor - nil без связки ключей.
+func openPostgres(cfg *config.Config) (dbConn, purgeConn, readConn *sql.DB, encryptor *encryption.Encryptor) {
+	dbConn, err := postgres.NewConnection(
+		cfg.DBHost,
+		cfg.DBPort,
+		cfg.DBUser,
+		cfg.DBPassword,
+		cfg.DBName,
+	)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	// Применение миграций. Таблицами владеет пользователь миграций, сам
+	// сервис журнал только читает и дописывает.
+	migrationConn := dbConn
+	if cfg.DBMigrationUser != "" {
+		migrationConn, err = postgres.NewConnection(
+			cfg.DBHost,
+			cfg.DBPort,
+			cfg.DBMigrationUser,
+			cfg.DBMigrationPassword,
+			cfg.DBName,
+		)
+		if err != nil {
+			log.Fatalf("Failed to connect to database as migration user: %v", err)
+		}
+	}
+	if err := db.RunMigrations(migrationConn); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := db.GrantRoles(migrationConn, cfg.DBUser, cfg.DBPurgeUser); err != nil {
+		log.Fatalf("Failed to grant database roles: %v", err)
+	}
+	if migrationConn != dbConn {
+		migrationConn.Close()
+	}
+
+	// Права сверх чтения и дописывания журнала - отказ от запуска
+	excess, err := repository.ExcessPrivileges(context.Background(), dbConn)
+	if err != nil {
+		log.Fatalf("Failed to check database privileges: %v", err)
+	}
+	if len(excess) > 0 {
+		if !cfg.DBAllowPrivileged {
+			log.Fatalf("Database user %s has excess privileges: %s", cfg.DBUser, strings.Join(excess, ", "))
+		}
+		log.Printf("Database user %s has excess privileges, allowed by DB_ALLOW_PRIVILEGED: %s",
+			cfg.DBUser, strings.Join(excess, ", "))
+	}
+
+	// Удаление событий, архивация и обслуживание секций идут от пользователя
+	// очистки
+	purgeConn = dbConn
+	if cfg.DBPurgeUser != "" {
+		purgeConn, err = postgres.NewConnection(
+			cfg.DBHost,
+			cfg.DBPort,
+			cfg.DBPurgeUser,
+			cfg.DBPurgePassword,
+			cfg.DBName,
+		)
+		if err != nil {
+			log.Fatalf("Failed to connect to database as purge user: %v", err)
+		}
+	}
+
+	// Реплика для выгрузок; если недоступна, выгрузки идут с primary
+	readConn = dbConn
+	if cfg.DBReadHost != cfg.DBHost || cfg.DBReadPort != cfg.DBPort {
+		readConn, err = postgres.NewConnection(
+			cfg.DBReadHost,
+			cfg.DBReadPort,
+			cfg.DBUser,
+			cfg.DBPassword,
+			cfg.DBName,
+		)
+		if err != nil {
+			log.Printf("Read replica unavailable, exports will use primary: %v", err)
+			readConn = dbConn
+		}
+	}
+
+	// Шифрование полей событий: без связки ключей поля пишутся открытыми
+	if cfg.EncryptionKeyringFile != "" {
+		keyring, err := encryption.LoadKeyring(cfg.EncryptionKeyringFile)
+		if err != nil {
+			log.Fatalf("Failed to load encryption keyring: %v", err)
+		}
+		fields, err := encryption.ParseFields(cfg.EncryptionPaths)
+		if err != nil {
+			log.Fatalf("Invalid ENCRYPTION_PATHS: %v", err)
+		}
+		encryptor = encryption.New(keyring, fields)
+
+		// Значения внутри зашифрованного поля в Postgres не видны
+		for _, rule := range cfg.RetentionRules {
+			path := strings.Split(rule.Attribute, ".")
+			if field, ok := encryptor.Field("attributes", path); ok && rule.Attribute != "" && len(path) > len(field.Path) {
+				log.Fatalf("Retention rule %q matches on %s inside encrypted %s", rule.Name, rule.Attribute, field)
+			}
+		}
+		log.Printf("Field encryption enabled: %d paths, active key %q", len(fields), keyring.ActiveID())
+	}
+
+	return dbConn, purgeConn, readConn, encryptor
+}
+
+func monitorDBConnection(db *sql.DB, statsHandler *handler.StatsHandler, replayer *service.Replayer) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
@@ -116,6 +577,12 @@ func monitorDBConnection(db *sql.DB, statsHandler *handler.StatsHandler) {
 		statsHandler.SetDBConnected(err == nil)
 		if err != nil {
 			log.Printf("Database connection check failed: %v", err)
+			continue
+		}
+
+		// БД доступна: переносим накопленное в спуле
+		if replayer != nil {
+			replayer.Trigger()
 		}
 	}
 }
@@ -1,10 +1,24 @@
 package config
 
 import (
+    "encoding/json"
     "fmt"
     "os"
     "strconv"
     "strings"
+    "time"
+
+    "audit-service/internal/auth"
+    "audit-service/internal/model"
+    "audit-service/internal/tenant"
+)
+
+// Хранилища событий: Postgres - основное, в памяти и SQLite - для
+// разработки и CI без кластера из docker-compose.yaml
+const (
+    BackendPostgres = "postgres"
+    BackendMemory   = "memory"
+    BackendSQLite   = "sqlite"
 )
 
 type Config struct {
@@ -14,13 +28,159 @@ type Config struct {
     DBUser     string `json:"db_user"`
     DBPassword string `json:"db_password"`
     DBName     string `json:"db_name"`
+    // Реплика для тяжёлого чтения (выгрузки), по умолчанию совпадает с DBHost/DBPort
+    DBReadHost string `json:"db_read_host"`
+    DBReadPort int    `json:"db_read_port"`
     LogLevel   string `json:"log_level"`
     AppVersion string `json:"app_version"`
+
+    // Хранилище событий: postgres, memory или sqlite (файл SQLitePath).
+    // Секции, сроки хранения, архив, контрольные точки и шифрование есть
+    // только у postgres, настройки DB_* остальным не нужны.
+    StorageBackend string `json:"storage_backend"`
+    SQLitePath     string `json:"sqlite_path"`
+
+    // Разделение прав в БД: DB_USER только читает и дописывает журнал,
+    // удаляет события и обслуживает секции DB_PURGE_USER, миграции
+    // выполняет DB_MIGRATION_USER (по умолчанию DB_USER). Без DB_PURGE_USER
+    // всё делает DB_USER, а это допустимо только с DB_ALLOW_PRIVILEGED.
+    DBPurgeUser         string `json:"db_purge_user"`
+    DBPurgePassword     string `json:"-"`
+    DBMigrationUser     string `json:"db_migration_user"`
+    DBMigrationPassword string `json:"-"`
+    DBAllowPrivileged   bool   `json:"db_allow_privileged"`
+
+    // Асинхронная запись с групповым коммитом
+    AsyncWrites        bool          `json:"async_writes"`
+    AsyncQueueSize     int           `json:"async_queue_size"`
+    AsyncWorkers       int           `json:"async_workers"`
+    AsyncBatchSize     int           `json:"async_batch_size"`
+    AsyncFlushInterval time.Duration `json:"async_flush_interval"`
+
+    // Локальный спул на время недоступности БД, пустой SpoolDir выключает его
+    SpoolDir         string `json:"spool_dir"`
+    SpoolSegmentSize int64  `json:"spool_segment_size"`
+
+    // Обслуживание месячных секций audit_events
+    PartitionMonthsAhead   int           `json:"partition_months_ahead"`
+    PartitionRetainMonths  int           `json:"partition_retain_months"`
+    PartitionCheckInterval time.Duration `json:"partition_check_interval"`
+
+    // Сроки хранения событий: правила из RETENTION_RULES (JSON-массив) и
+    // срок по умолчанию в днях, 0 - хранить бессрочно
+    RetentionRules       []model.RetentionRule `json:"retention_rules"`
+    RetentionDefaultDays int                   `json:"retention_default_days"`
+    RetentionInterval    time.Duration         `json:"retention_interval"`
+    RetentionBatchSize   int                   `json:"retention_batch_size"`
+    RetentionDryRun      bool                  `json:"retention_dry_run"`
+
+    // Холодный архив: каталог ArchiveDir или S3-совместимое хранилище.
+    // ArchiveAfterDays=0 выключает фоновую архивацию, но поиск по архиву и
+    // восстановление остаются доступны.
+    ArchiveDir         string        `json:"archive_dir"`
+    ArchiveS3Endpoint  string        `json:"archive_s3_endpoint"`
+    ArchiveS3Bucket    string        `json:"archive_s3_bucket"`
+    ArchiveS3Prefix    string        `json:"archive_s3_prefix"`
+    ArchiveS3AccessKey string        `json:"-"`
+    ArchiveS3SecretKey string        `json:"-"`
+    ArchiveS3UseSSL    bool          `json:"archive_s3_use_ssl"`
+    ArchiveAfterDays   int           `json:"archive_after_days"`
+    ArchiveInterval    time.Duration `json:"archive_interval"`
+    ArchiveSegmentRows int           `json:"archive_segment_rows"`
+    ArchiveRestoreHold time.Duration `json:"archive_restore_hold"`
+
+    // Контрольные точки цепочки хешей: корень дерева Меркла над каждым
+    // окном из CheckpointWindow звеньев подписывается ключом Ed25519 из
+    // CheckpointKeyFile. Без ключа точки не строятся, доказательства по
+    // уже построенным отдаются.
+    CheckpointKeyFile  string        `json:"checkpoint_key_file"`
+    CheckpointWindow   int64         `json:"checkpoint_window"`
+    CheckpointInterval time.Duration `json:"checkpoint_interval"`
+
+    // Шифрование полей attributes и res: связка ключей из EncryptionKeyringFile
+    // и пути через запятую (attributes.user.email,res). Без связки поля не
+    // шифруются, а зашифрованные ранее события не читаются.
+    EncryptionKeyringFile string `json:"encryption_keyring_file"`
+    EncryptionPaths       string `json:"encryption_paths"`
+    EncryptionRotateBatch int    `json:"encryption_rotate_batch"`
+
+    // Аутентификация по ключам API. AuthBootstrapKey - ключ с правом admin,
+    // который не хранится в БД: им выпускаются первые ключи.
+    AuthEnabled      bool   `json:"auth_enabled"`
+    AuthBootstrapKey string `json:"-"`
+
+    // JWT от внешнего издателя. AuthJWKS - файл или http(s) URL с открытыми
+    // ключами. AuthJWTScopeMap - пары значение=право через запятую
+    // (audit.read=events:read); пустая - значения claim и есть права.
+    // С AuthStrictUser поле user события должно совпадать с sub токена.
+    // AuthJWTTenantClaim - claim с арендатором, к которому привязан токен.
+    AuthJWKS           string            `json:"auth_jwks"`
+    AuthJWKSRefresh    time.Duration     `json:"auth_jwks_refresh"`
+    AuthJWTIssuer      string            `json:"auth_jwt_issuer"`
+    AuthJWTAudience    string            `json:"auth_jwt_audience"`
+    AuthJWTScopeClaim  string            `json:"auth_jwt_scope_claim"`
+    AuthJWTScopeMap    map[string]string `json:"auth_jwt_scope_map"`
+    AuthJWTTenantClaim string            `json:"auth_jwt_tenant_claim"`
+    AuthStrictUser     bool              `json:"auth_strict_user"`
+
+    // TLS на HTTP-листенере. С TLSClientCAFile клиентские сертификаты
+    // проверяются по этому CA, а их идентичность (TLSClientIdentity: san
+    // или cn) записывается в события как mtls:<идентичность>.
+    // TLSClientScopes - права по идентичности: пары идентичность=права
+    // через запятую, права через пробел; "*" - любой проверенный клиент.
+    // TLSClientTenants - арендаторы по идентичности: пары
+    // идентичность=арендатор через запятую.
+    // Файлы перечитываются при изменении раз в TLSReloadInterval.
+    // С TLSPort HTTPS слушается на отдельном порту, а основной остаётся HTTP
+    // для шлюза и health-check; 0 - HTTPS на основном порту.
+    TLSCertFile          string              `json:"tls_cert_file"`
+    TLSKeyFile           string              `json:"-"`
+    TLSClientCAFile      string              `json:"tls_client_ca_file"`
+    TLSClientCertRequire bool                `json:"tls_client_cert_require"`
+    TLSClientIdentity    string              `json:"tls_client_identity"`
+    TLSClientScopes      map[string][]string `json:"tls_client_scopes"`
+    TLSClientTenants     map[string]string   `json:"tls_client_tenants"`
+    TLSReloadInterval    time.Duration       `json:"tls_reload_interval"`
+    TLSPort              int                 `json:"tls_port"`
+
+    // Квоты арендаторов: пары арендатор=событий в минуту через запятую,
+    // "*" - для арендаторов без своей квоты. С Postgres квота общая для
+    // всех реплик.
+    TenantQuotas map[string]int `json:"tenant_quotas"`
 }
 
 func Load() (*Config, error) {
     port, _ := strconv.Atoi(getEnv("APP_PORT", "8080"))
     dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "5432"))
+    dbReadPort, _ := strconv.Atoi(getEnv("DB_READ_PORT", strconv.Itoa(dbPort)))
+    dbAllowPrivileged, _ := strconv.ParseBool(getEnv("DB_ALLOW_PRIVILEGED", "false"))
+    asyncWrites, _ := strconv.ParseBool(getEnv("ASYNC_WRITES", "false"))
+    asyncQueueSize, _ := strconv.Atoi(getEnv("ASYNC_QUEUE_SIZE", "10000"))
+    asyncWorkers, _ := strconv.Atoi(getEnv("ASYNC_WORKERS", "4"))
+    asyncBatchSize, _ := strconv.Atoi(getEnv("ASYNC_BATCH_SIZE", "500"))
+    asyncFlushInterval, _ := time.ParseDuration(getEnv("ASYNC_FLUSH_INTERVAL", "50ms"))
+    spoolSegmentSize, _ := strconv.ParseInt(getEnv("SPOOL_SEGMENT_SIZE", "67108864"), 10, 64)
+    partitionMonthsAhead, _ := strconv.Atoi(getEnv("PARTITION_MONTHS_AHEAD", "3"))
+    partitionRetainMonths, _ := strconv.Atoi(getEnv("PARTITION_RETAIN_MONTHS", "0"))
+    partitionCheckInterval, _ := time.ParseDuration(getEnv("PARTITION_CHECK_INTERVAL", "1h"))
+    retentionDefaultDays, _ := strconv.Atoi(getEnv("RETENTION_DEFAULT_DAYS", "0"))
+    retentionInterval, _ := time.ParseDuration(getEnv("RETENTION_INTERVAL", "1h"))
+    retentionBatchSize, _ := strconv.Atoi(getEnv("RETENTION_BATCH_SIZE", "1000"))
+    retentionDryRun, _ := strconv.ParseBool(getEnv("RETENTION_DRY_RUN", "false"))
+    archiveS3UseSSL, _ := strconv.ParseBool(getEnv("ARCHIVE_S3_USE_SSL", "false"))
+    archiveAfterDays, _ := strconv.Atoi(getEnv("ARCHIVE_AFTER_DAYS", "0"))
+    archiveInterval, _ := time.ParseDuration(getEnv("ARCHIVE_INTERVAL", "1h"))
+    archiveSegmentRows, _ := strconv.Atoi(getEnv("ARCHIVE_SEGMENT_ROWS", "100000"))
+    archiveRestoreHold, _ := time.ParseDuration(getEnv("ARCHIVE_RESTORE_HOLD", "168h"))
+    checkpointWindow, _ := strconv.ParseInt(getEnv("CHECKPOINT_WINDOW", "10000"), 10, 64)
+    checkpointInterval, _ := time.ParseDuration(getEnv("CHECKPOINT_INTERVAL", "5m"))
+    encryptionRotateBatch, _ := strconv.Atoi(getEnv("ENCRYPTION_ROTATE_BATCH", "1000"))
+    authEnabled, _ := strconv.ParseBool(getEnv("AUTH_ENABLED", "false"))
+    authJWKSRefresh, _ := time.ParseDuration(getEnv("AUTH_JWKS_REFRESH", "10m"))
+    authStrictUser, _ := strconv.ParseBool(getEnv("AUTH_STRICT_USER", "false"))
+    tlsClientCertRequire, _ := strconv.ParseBool(getEnv("TLS_CLIENT_CERT_REQUIRE", "true"))
+    tlsReloadInterval, _ := time.ParseDuration(getEnv("TLS_RELOAD_INTERVAL", "30s"))
+    tlsPort, _ := strconv.Atoi(getEnv("TLS_PORT", "0"))
     
     cfg := &Config{
         ServerPort: port,
@@ -29,17 +189,329 @@ func Load() (*Config, error) {
         DBUser:     getEnv("DB_USER", "audit_user"),
         DBPassword: getEnv("DB_PASSWORD", ""),
         DBName:     getEnv("DB_NAME", "audit_db"),
+        DBReadHost: getEnv("DB_READ_HOST", getEnv("DB_HOST", "localhost")),
+        DBReadPort: dbReadPort,
         LogLevel:   strings.ToUpper(getEnv("LOG_LEVEL", "INFO")),
         AppVersion: getEnv("APP_VERSION", "1.0.0"),
+
+        StorageBackend: strings.ToLower(getEnv("STORAGE_BACKEND", BackendPostgres)),
+        SQLitePath:     getEnv("SQLITE_PATH", "audit.db"),
+
+        DBPurgeUser:         getEnv("DB_PURGE_USER", ""),
+        DBPurgePassword:     getEnv("DB_PURGE_PASSWORD", ""),
+        DBMigrationUser:     getEnv("DB_MIGRATION_USER", ""),
+        DBMigrationPassword: getEnv("DB_MIGRATION_PASSWORD", ""),
+        DBAllowPrivileged:   dbAllowPrivileged,
+
+        AsyncWrites:        asyncWrites,
+        AsyncQueueSize:     asyncQueueSize,
+        AsyncWorkers:       asyncWorkers,
+        AsyncBatchSize:     asyncBatchSize,
+        AsyncFlushInterval: asyncFlushInterval,
+
+        SpoolDir:         getEnv("SPOOL_DIR", ""),
+        SpoolSegmentSize: spoolSegmentSize,
+
+        PartitionMonthsAhead:   partitionMonthsAhead,
+        PartitionRetainMonths:  partitionRetainMonths,
+        PartitionCheckInterval: partitionCheckInterval,
+
+        RetentionDefaultDays: retentionDefaultDays,
+        RetentionInterval:    retentionInterval,
+        RetentionBatchSize:   retentionBatchSize,
+        RetentionDryRun:      retentionDryRun,
+
+        ArchiveDir:         getEnv("ARCHIVE_DIR", ""),
+        ArchiveS3Endpoint:  getEnv("ARCHIVE_S3_ENDPOINT", ""),
+        ArchiveS3Bucket:    getEnv("ARCHIVE_S3_BUCKET", "audit-archive"),
+        ArchiveS3Prefix:    getEnv("ARCHIVE_S3_PREFIX", ""),
+        ArchiveS3AccessKey: getEnv("ARCHIVE_S3_ACCESS_KEY", ""),
+        ArchiveS3SecretKey: getEnv("ARCHIVE_S3_SECRET_KEY", ""),
+        ArchiveS3UseSSL:    archiveS3UseSSL,
+        ArchiveAfterDays:   archiveAfterDays,
+        ArchiveInterval:    archiveInterval,
+        ArchiveSegmentRows: archiveSegmentRows,
+        ArchiveRestoreHold: archiveRestoreHold,
+
+        CheckpointKeyFile:  getEnv("CHECKPOINT_KEY_FILE", ""),
+        CheckpointWindow:   checkpointWindow,
+        CheckpointInterval: checkpointInterval,
+
+        EncryptionKeyringFile: getEnv("ENCRYPTION_KEYRING_FILE", ""),
+        EncryptionPaths:       getEnv("ENCRYPTION_PATHS", ""),
+        EncryptionRotateBatch: encryptionRotateBatch,
+
+        AuthEnabled:      authEnabled,
+        AuthBootstrapKey: getEnv("AUTH_BOOTSTRAP_KEY", ""),
+
+        AuthJWKS:           getEnv("AUTH_JWKS", ""),
+        AuthJWKSRefresh:    authJWKSRefresh,
+        AuthJWTIssuer:      getEnv("AUTH_JWT_ISSUER", ""),
+        AuthJWTAudience:    getEnv("AUTH_JWT_AUDIENCE", ""),
+        AuthJWTScopeClaim:  getEnv("AUTH_JWT_SCOPE_CLAIM", "scope"),
+        AuthJWTTenantClaim: getEnv("AUTH_JWT_TENANT_CLAIM", "tenant"),
+        AuthStrictUser:     authStrictUser,
+
+        TLSCertFile:          getEnv("TLS_CERT_FILE", ""),
+        TLSKeyFile:           getEnv("TLS_KEY_FILE", ""),
+        TLSClientCAFile:      getEnv("TLS_CLIENT_CA_FILE", ""),
+        TLSClientCertRequire: tlsClientCertRequire,
+        TLSClientIdentity:    getEnv("TLS_CLIENT_IDENTITY", auth.CertIdentitySAN),
+        TLSReloadInterval:    tlsReloadInterval,
+        TLSPort:              tlsPort,
+    }
+    
+    switch cfg.StorageBackend {
+    case BackendPostgres:
+        if cfg.DBPassword == "" {
+            return nil, fmt.Errorf("DB_PASSWORD environment variable is required")
+        }
+        if cfg.DBPurgeUser != "" && cfg.DBPurgePassword == "" {
+            return nil, fmt.Errorf("DB_PURGE_PASSWORD is required with DB_PURGE_USER")
+        }
+        if cfg.DBMigrationUser != "" && cfg.DBMigrationPassword == "" {
+            return nil, fmt.Errorf("DB_MIGRATION_PASSWORD is required with DB_MIGRATION_USER")
+        }
+        if cfg.DBPurgeUser == "" && !cfg.DBAllowPrivileged {
+            return nil, fmt.Errorf("DB_PURGE_USER is required unless DB_ALLOW_PRIVILEGED is set")
+        }
+    case BackendMemory:
+    case BackendSQLite:
+        if cfg.SQLitePath == "" {
+            return nil, fmt.Errorf("SQLITE_PATH is required with STORAGE_BACKEND=sqlite")
+        }
+    default:
+        return nil, fmt.Errorf("STORAGE_BACKEND must be postgres, memory or sqlite, got %q", cfg.StorageBackend)
+    }
+    
+    if cfg.AsyncWrites && (cfg.AsyncQueueSize <= 0 || cfg.AsyncWorkers <= 0 || cfg.AsyncBatchSize <= 0 || cfg.AsyncFlushInterval <= 0) {
+        return nil, fmt.Errorf("ASYNC_QUEUE_SIZE, ASYNC_WORKERS, ASYNC_BATCH_SIZE and ASYNC_FLUSH_INTERVAL must be positive")
+    }
+    
+    if cfg.SpoolDir != "" && cfg.SpoolSegmentSize <= 0 {
+        return nil, fmt.Errorf("SPOOL_SEGMENT_SIZE must be positive")
+    }
+    
+    // PARTITION_RETAIN_MONTHS=0 - старые секции не отсоединяются
+    if cfg.PartitionMonthsAhead < 0 || cfg.PartitionRetainMonths < 0 || cfg.PartitionCheckInterval <= 0 {
+        return nil, fmt.Errorf("PARTITION_MONTHS_AHEAD and PARTITION_RETAIN_MONTHS must not be negative, PARTITION_CHECK_INTERVAL must be positive")
+    }
+    
+    if rules := getEnv("RETENTION_RULES", ""); rules != "" {
+        if err := json.Unmarshal([]byte(rules), &cfg.RetentionRules); err != nil {
+            return nil, fmt.Errorf("RETENTION_RULES must be a JSON array of rules: %w", err)
+        }
+    }
+    for _, rule := range cfg.RetentionRules {
+        if rule.Name == "" || rule.KeepDays < 0 {
+            return nil, fmt.Errorf("retention rule needs a name and non-negative keep_days")
+        }
+        if rule.Component == "" && rule.Operation == "" && rule.Attribute == "" {
+            return nil, fmt.Errorf("retention rule %q must match on component, operation or attribute", rule.Name)
+        }
+    }
+    if cfg.RetentionDefaultDays < 0 || cfg.RetentionInterval <= 0 || cfg.RetentionBatchSize <= 0 {
+        return nil, fmt.Errorf("RETENTION_DEFAULT_DAYS must not be negative, RETENTION_INTERVAL and RETENTION_BATCH_SIZE must be positive")
     }
     
-    if cfg.DBPassword == "" {
-        return nil, fmt.Errorf("DB_PASSWORD environment variable is required")
+    if cfg.ArchiveDir != "" && cfg.ArchiveS3Endpoint != "" {
+        return nil, fmt.Errorf("ARCHIVE_DIR and ARCHIVE_S3_ENDPOINT are mutually exclusive")
+    }
+    if cfg.ArchiveAfterDays > 0 && !cfg.ArchiveEnabled() {
+        return nil, fmt.Errorf("ARCHIVE_AFTER_DAYS requires ARCHIVE_DIR or ARCHIVE_S3_ENDPOINT")
+    }
+    if cfg.ArchiveAfterDays < 0 || cfg.ArchiveInterval <= 0 || cfg.ArchiveSegmentRows <= 0 || cfg.ArchiveRestoreHold < 0 {
+        return nil, fmt.Errorf("ARCHIVE_AFTER_DAYS and ARCHIVE_RESTORE_HOLD must not be negative, ARCHIVE_INTERVAL and ARCHIVE_SEGMENT_ROWS must be positive")
+    }
+    
+    if cfg.CheckpointWindow <= 0 || cfg.CheckpointInterval <= 0 {
+        return nil, fmt.Errorf("CHECKPOINT_WINDOW and CHECKPOINT_INTERVAL must be positive")
+    }
+    
+    if cfg.EncryptionPaths != "" && cfg.EncryptionKeyringFile == "" {
+        return nil, fmt.Errorf("ENCRYPTION_PATHS requires ENCRYPTION_KEYRING_FILE")
+    }
+    if cfg.EncryptionRotateBatch <= 0 {
+        return nil, fmt.Errorf("ENCRYPTION_ROTATE_BATCH must be positive")
+    }
+    
+    if cfg.AuthBootstrapKey != "" && !cfg.AuthEnabled {
+        return nil, fmt.Errorf("AUTH_BOOTSTRAP_KEY requires AUTH_ENABLED")
+    }
+    if cfg.AuthBootstrapKey != "" && len(cfg.AuthBootstrapKey) < 32 {
+        return nil, fmt.Errorf("AUTH_BOOTSTRAP_KEY must be at least 32 characters")
+    }
+    if scopeMap := getEnv("AUTH_JWT_SCOPE_MAP", ""); scopeMap != "" {
+        m, err := parseScopeMap(scopeMap)
+        if err != nil {
+            return nil, fmt.Errorf("invalid AUTH_JWT_SCOPE_MAP: %w", err)
+        }
+        cfg.AuthJWTScopeMap = m
+    }
+    if cfg.AuthJWKS != "" {
+        if !cfg.AuthEnabled {
+            return nil, fmt.Errorf("AUTH_JWKS requires AUTH_ENABLED")
+        }
+        // Без издателя и аудитории подошёл бы любой токен, подписанный
+        // ключом из набора, в том числе выпущенный для другого сервиса
+        if cfg.AuthJWTIssuer == "" || cfg.AuthJWTAudience == "" {
+            return nil, fmt.Errorf("AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE are required with AUTH_JWKS")
+        }
+        if cfg.AuthJWKSRefresh <= 0 {
+            return nil, fmt.Errorf("AUTH_JWKS_REFRESH must be positive")
+        }
+        if cfg.AuthJWTScopeClaim == "" {
+            return nil, fmt.Errorf("AUTH_JWT_SCOPE_CLAIM must not be empty")
+        }
+    }
+    if cfg.AuthStrictUser && cfg.AuthJWKS == "" {
+        return nil, fmt.Errorf("AUTH_STRICT_USER requires AUTH_JWKS")
+    }
+    
+    if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+        return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+    }
+    if cfg.TLSClientCAFile != "" && !cfg.TLSEnabled() {
+        return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+    }
+    if cfg.TLSClientIdentity != auth.CertIdentitySAN && cfg.TLSClientIdentity != auth.CertIdentityCN {
+        return nil, fmt.Errorf("TLS_CLIENT_IDENTITY must be san or cn")
+    }
+    if cfg.TLSReloadInterval <= 0 {
+        return nil, fmt.Errorf("TLS_RELOAD_INTERVAL must be positive")
+    }
+    if cfg.TLSPort < 0 || cfg.TLSPort > 65535 {
+        return nil, fmt.Errorf("TLS_PORT must be a port number")
+    }
+    if scopes := getEnv("TLS_CLIENT_SCOPES", ""); scopes != "" {
+        if cfg.TLSClientCAFile == "" || !cfg.AuthEnabled {
+            return nil, fmt.Errorf("TLS_CLIENT_SCOPES requires TLS_CLIENT_CA_FILE and AUTH_ENABLED")
+        }
+        m, err := parseIdentityScopes(scopes)
+        if err != nil {
+            return nil, fmt.Errorf("invalid TLS_CLIENT_SCOPES: %w", err)
+        }
+        cfg.TLSClientScopes = m
+    }
+    if tenants := getEnv("TLS_CLIENT_TENANTS", ""); tenants != "" {
+        if cfg.TLSClientCAFile == "" {
+            return nil, fmt.Errorf("TLS_CLIENT_TENANTS requires TLS_CLIENT_CA_FILE")
+        }
+        m, err := parseIdentityTenants(tenants)
+        if err != nil {
+            return nil, fmt.Errorf("invalid TLS_CLIENT_TENANTS: %w", err)
+        }
+        cfg.TLSClientTenants = m
+    }
+    if quotas := getEnv("TENANT_QUOTAS", ""); quotas != "" {
+        m, err := parseTenantQuotas(quotas)
+        if err != nil {
+            return nil, fmt.Errorf("invalid TENANT_QUOTAS: %w", err)
+        }
+        cfg.TenantQuotas = m
+    }
+    
+    // Встроенные хранилища не умеют того, что держится на Postgres: молча
+    // выключать настроенную защиту данных нельзя
+    if cfg.StorageBackend != BackendPostgres {
+        switch {
+        case len(cfg.RetentionRules) > 0 || cfg.RetentionDefaultDays > 0:
+            return nil, fmt.Errorf("retention requires STORAGE_BACKEND=postgres")
+        case cfg.ArchiveEnabled():
+            return nil, fmt.Errorf("archive requires STORAGE_BACKEND=postgres")
+        case cfg.CheckpointKeyFile != "":
+            return nil, fmt.Errorf("CHECKPOINT_KEY_FILE requires STORAGE_BACKEND=postgres")
+        case cfg.EncryptionKeyringFile != "":
+            return nil, fmt.Errorf("field encryption requires STORAGE_BACKEND=postgres")
+        }
     }
     
     return cfg, nil
 }
 
+// ArchiveEnabled сообщает, задано ли хранилище холодного архива
+func (c *Config) ArchiveEnabled() bool {
+    return c.ArchiveDir != "" || c.ArchiveS3Endpoint != ""
+}
+
+// TLSEnabled сообщает, слушает ли сервис по HTTPS
+func (c *Config) TLSEnabled() bool {
+    return c.TLSCertFile != ""
+}
+
+// parseScopeMap разбирает пары значение=право через запятую
+func parseScopeMap(s string) (map[string]string, error) {
+    m := make(map[string]string)
+    for _, pair := range strings.Split(s, ",") {
+        value, scope, ok := strings.Cut(strings.TrimSpace(pair), "=")
+        value, scope = strings.TrimSpace(value), strings.TrimSpace(scope)
+        if !ok || value == "" {
+            return nil, fmt.Errorf("expected value=scope, got %q", pair)
+        }
+        if !auth.ValidScope(scope) {
+            return nil, fmt.Errorf("unknown scope %q, must be one of %v", scope, auth.Scopes)
+        }
+        m[value] = scope
+    }
+    return m, nil
+}
+
+// parseIdentityScopes разбирает пары идентичность=права через запятую.
+// Идентичность может содержать '=' (URI), права - нет.
+func parseIdentityScopes(s string) (map[string][]string, error) {
+    m := make(map[string][]string)
+    for _, pair := range strings.Split(s, ",") {
+        i := strings.LastIndex(pair, "=")
+        if i <= 0 || strings.TrimSpace(pair[:i]) == "" {
+            return nil, fmt.Errorf("expected identity=scopes, got %q", pair)
+        }
+        identity := strings.TrimSpace(pair[:i])
+        for _, scope := range strings.Fields(pair[i+1:]) {
+            if !auth.ValidScope(scope) {
+                return nil, fmt.Errorf("unknown scope %q, must be one of %v", scope, auth.Scopes)
+            }
+            m[identity] = append(m[identity], scope)
+        }
+    }
+    return m, nil
+}
+
+// parseIdentityTenants разбирает пары идентичность=арендатор через запятую.
+// Идентичность может содержать '=' (URI), арендатор - нет.
+func parseIdentityTenants(s string) (map[string]string, error) {
+    m := make(map[string]string)
+    for _, pair := range strings.Split(s, ",") {
+        i := strings.LastIndex(pair, "=")
+        if i <= 0 || strings.TrimSpace(pair[:i]) == "" {
+            return nil, fmt.Errorf("expected identity=tenant, got %q", pair)
+        }
+        id := strings.TrimSpace(pair[i+1:])
+        if !tenant.Valid(id) {
+            return nil, fmt.Errorf("invalid tenant %q", id)
+        }
+        m[strings.TrimSpace(pair[:i])] = id
+    }
+    return m, nil
+}
+
+// parseTenantQuotas разбирает пары арендатор=событий в минуту через запятую
+func parseTenantQuotas(s string) (map[string]int, error) {
+    m := make(map[string]int)
+    for _, pair := range strings.Split(s, ",") {
+        id, limit, ok := strings.Cut(strings.TrimSpace(pair), "=")
+        id = strings.TrimSpace(id)
+        if !ok || (id != "*" && !tenant.Valid(id)) {
+            return nil, fmt.Errorf("expected tenant=events per minute, got %q", pair)
+        }
+        n, err := strconv.Atoi(strings.TrimSpace(limit))
+        if err != nil || n <= 0 {
+            return nil, fmt.Errorf("quota for %s must be a positive number, got %q", id, limit)
+        }
+        m[id] = n
+    }
+    return m, nil
+}
+
 func getEnv(key, defaultValue string) string {
     if value, exists := os.LookupEnv(key); exists {
         return value
@@ -6,12 +6,16 @@ import (
     "fmt"
     "io/fs"
 
+    "github.com/lib/pq"
     "github.com/pressly/goose/v3"
 )
 
 //go:embed migrations/*.sql
 var migrations embed.FS
 
+//go:embed sqlite_migrations/*.sql
+var sqliteMigrations embed.FS
+
 func RunMigrations(db *sql.DB) error {
     goose.SetBaseFS(migrations)
     
@@ -34,4 +38,37 @@ func RunMigrations(db *sql.DB) error {
     }
     
     return nil
-}
\ No newline at end of file
+}
+
+// RunSQLiteMigrations применяет миграции встроенного хранилища SQLite. У
+// него своя схема: секции, роли и шифрование Postgres в ней не нужны.
+func RunSQLiteMigrations(db *sql.DB) error {
+    goose.SetBaseFS(sqliteMigrations)
+    
+    if err := goose.SetDialect("sqlite3"); err != nil {
+        return fmt.Errorf("failed to set dialect: %w", err)
+    }
+    
+    if err := goose.Up(db, "sqlite_migrations"); err != nil {
+        return fmt.Errorf("failed to run sqlite migrations: %w", err)
+    }
+    
+    return nil
+}
+
+// GrantRoles включает логин сервиса в audit_writer, а логин очистки - в
+// audit_purger (роли создаёт миграция 010). Выполняется соединением
+// пользователя миграций; пустой purgeUser пропускается.
+func GrantRoles(db *sql.DB, appUser, purgeUser string) error {
+    grants := map[string]string{"audit_writer": appUser, "audit_purger": purgeUser}
+    for role, user := range grants {
+        if user == "" {
+            continue
+        }
+        if _, err := db.Exec("GRANT " + role + " TO " + pq.QuoteIdentifier(user)); err != nil {
+            return fmt.Errorf("failed to grant %s to %s: %w", role, user, err)
+        }
+    }
+    
+    return nil
+}
@@ -0,0 +1,10 @@
+-- +goose Up
+-- Идентичность события: по ней повторная запись (воспроизведение спула,
+-- повтор пачки) не создаёт дубликатов
+ALTER TABLE audit_events ADD COLUMN event_id UUID;
+
+CREATE UNIQUE INDEX idx_audit_events_event_id ON audit_events(event_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_audit_events_event_id;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS event_id;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Ключ идемпотентности из заголовка Idempotency-Key: повтор запроса
+-- с тем же ключом возвращает уже сохранённое событие
+ALTER TABLE audit_events ADD COLUMN idempotency_key TEXT;
+
+ALTER TABLE audit_events
+    ADD CONSTRAINT uq_audit_events_idempotency_key UNIQUE (idempotency_key);
+
+-- +goose Down
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS uq_audit_events_idempotency_key;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS idempotency_key;
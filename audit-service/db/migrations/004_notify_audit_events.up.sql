@@ -0,0 +1,34 @@
+-- +goose Up
+-- Уведомления о вставках для живой ленты событий: каждая реплика сервиса
+-- слушает канал audit_events и получает id новых строк. Триггер уровня
+-- оператора, чтобы пакетная загрузка через COPY давала несколько уведомлений
+-- на пачку, а не по одному на строку. id режутся на куски по 300: полезная
+-- нагрузка NOTIFY ограничена 8000 байтами.
+-- +goose StatementBegin
+CREATE FUNCTION notify_audit_events() RETURNS trigger AS $$
+DECLARE
+    ids TEXT;
+BEGIN
+    FOR ids IN
+        SELECT string_agg(id::text, ',' ORDER BY id)
+        FROM (
+            SELECT id, (row_number() OVER (ORDER BY id) - 1) / 300 AS chunk
+            FROM new_rows
+        ) numbered
+        GROUP BY chunk
+    LOOP
+        PERFORM pg_notify('audit_events', ids);
+    END LOOP;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_notify
+    AFTER INSERT ON audit_events
+    REFERENCING NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_audit_events();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS audit_events_notify ON audit_events;
+DROP FUNCTION IF EXISTS notify_audit_events();
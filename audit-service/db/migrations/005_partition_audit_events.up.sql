@@ -0,0 +1,137 @@
+-- +goose Up
+-- audit_events становится таблицей, секционированной по timestamp помесячно
+-- (секции audit_events_pYYYYMM). Дальнейшие секции создаёт менеджер секций
+-- сервиса; события вне созданных секций попадают в audit_events_default.
+--
+-- Уникальность в секционированной таблице возможна только вместе с ключом
+-- секционирования, поэтому глобальная уникальность event_id и ключа
+-- идемпотентности переезжает в отдельную таблицу audit_event_identities.
+ALTER TABLE audit_events RENAME TO audit_events_old;
+
+CREATE TABLE audit_events (
+    id BIGINT NOT NULL DEFAULT nextval('audit_events_id_seq'),
+    timestamp TIMESTAMP NOT NULL,
+    user_id TEXT NOT NULL,
+    component TEXT,
+    operation TEXT NOT NULL,
+    session_id BIGINT,
+    request_id BIGINT,
+    response JSONB,
+    attributes JSONB,
+    created_at TIMESTAMP DEFAULT NOW(),
+    event_id UUID,
+    idempotency_key TEXT
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE audit_events_default PARTITION OF audit_events DEFAULT;
+
+-- Секции на уже накопленные данные (не дальше двух лет назад, более старое
+-- остаётся в audit_events_default) и на три месяца вперёд
+-- +goose StatementBegin
+DO $$
+DECLARE
+    month DATE;
+    last_month DATE := date_trunc('month', NOW()) + INTERVAL '3 months';
+BEGIN
+    SELECT GREATEST(
+        COALESCE(date_trunc('month', MIN(timestamp)), date_trunc('month', NOW())),
+        date_trunc('month', NOW()) - INTERVAL '24 months'
+    ) INTO month FROM audit_events_old;
+
+    WHILE month <= last_month LOOP
+        EXECUTE format(
+            'CREATE TABLE %I PARTITION OF audit_events FOR VALUES FROM (%L) TO (%L)',
+            'audit_events_p' || to_char(month, 'YYYYMM'), month, month + INTERVAL '1 month'
+        );
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END;
+$$;
+-- +goose StatementEnd
+
+INSERT INTO audit_events
+    (id, timestamp, user_id, component, operation, session_id, request_id, response, attributes, created_at, event_id, idempotency_key)
+SELECT id, timestamp, user_id, component, operation, session_id, request_id, response, attributes, created_at, event_id, idempotency_key
+FROM audit_events_old;
+
+CREATE TABLE audit_event_identities (
+    event_id UUID PRIMARY KEY,
+    idempotency_key TEXT UNIQUE,
+    id BIGINT NOT NULL,
+    timestamp TIMESTAMP NOT NULL
+);
+
+INSERT INTO audit_event_identities (event_id, idempotency_key, id, timestamp)
+SELECT event_id, idempotency_key, id, timestamp
+FROM audit_events_old
+WHERE event_id IS NOT NULL;
+
+-- Последовательность принадлежала старой таблице и удалилась бы вместе с ней
+ALTER SEQUENCE audit_events_id_seq OWNED BY audit_events.id;
+DROP TABLE audit_events_old;
+
+-- Индексы создаются на родительской таблице и наследуются всеми секциями,
+-- в том числе будущими
+ALTER TABLE audit_events ADD PRIMARY KEY (id, timestamp);
+
+CREATE INDEX idx_audit_events_timestamp ON audit_events(timestamp);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_component ON audit_events(component);
+CREATE INDEX idx_audit_events_operation ON audit_events(operation);
+CREATE INDEX idx_audit_events_session_id ON audit_events(session_id);
+CREATE INDEX idx_audit_events_request_id ON audit_events(request_id);
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX idx_audit_events_event_id ON audit_events(event_id);
+CREATE INDEX idx_audit_events_attributes ON audit_events USING GIN (attributes);
+CREATE INDEX idx_audit_events_response ON audit_events USING GIN (response);
+CREATE INDEX idx_audit_events_user_timestamp ON audit_events(user_id, timestamp DESC);
+
+CREATE TRIGGER audit_events_notify
+    AFTER INSERT ON audit_events
+    REFERENCING NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_audit_events();
+
+-- +goose Down
+ALTER TABLE audit_events RENAME TO audit_events_partitioned;
+
+CREATE TABLE audit_events (
+    id BIGINT PRIMARY KEY DEFAULT nextval('audit_events_id_seq'),
+    timestamp TIMESTAMP NOT NULL,
+    user_id TEXT NOT NULL,
+    component TEXT,
+    operation TEXT NOT NULL,
+    session_id BIGINT,
+    request_id BIGINT,
+    response JSONB,
+    attributes JSONB,
+    created_at TIMESTAMP DEFAULT NOW(),
+    event_id UUID,
+    idempotency_key TEXT
+);
+
+INSERT INTO audit_events SELECT
+    id, timestamp, user_id, component, operation, session_id, request_id, response, attributes, created_at, event_id, idempotency_key
+FROM audit_events_partitioned;
+
+ALTER SEQUENCE audit_events_id_seq OWNED BY audit_events.id;
+DROP TABLE audit_events_partitioned;
+DROP TABLE audit_event_identities;
+
+CREATE INDEX idx_audit_events_timestamp ON audit_events(timestamp);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_component ON audit_events(component);
+CREATE INDEX idx_audit_events_operation ON audit_events(operation);
+CREATE INDEX idx_audit_events_session_id ON audit_events(session_id);
+CREATE INDEX idx_audit_events_request_id ON audit_events(request_id);
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+CREATE UNIQUE INDEX idx_audit_events_event_id ON audit_events(event_id);
+CREATE INDEX idx_audit_events_attributes ON audit_events USING GIN (attributes);
+CREATE INDEX idx_audit_events_response ON audit_events USING GIN (response);
+CREATE INDEX idx_audit_events_user_timestamp ON audit_events(user_id, timestamp DESC);
+ALTER TABLE audit_events
+    ADD CONSTRAINT uq_audit_events_idempotency_key UNIQUE (idempotency_key);
+
+CREATE TRIGGER audit_events_notify
+    AFTER INSERT ON audit_events
+    REFERENCING NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_audit_events();
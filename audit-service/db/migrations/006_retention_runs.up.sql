@@ -0,0 +1,18 @@
+-- +goose Up
+-- Итоги прогонов очистки по срокам хранения. Очистку выполняет одна
+-- реплика, а /stats любой реплики показывает последний прогон отсюда.
+CREATE TABLE retention_runs (
+    id BIGSERIAL PRIMARY KEY,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    dry_run BOOLEAN NOT NULL,
+    total BIGINT NOT NULL,
+    report JSONB NOT NULL
+);
+
+-- Удаление идентичностей вместе с целыми секциями идёт по диапазону времени
+CREATE INDEX idx_audit_event_identities_timestamp ON audit_event_identities(timestamp);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_audit_event_identities_timestamp;
+DROP TABLE IF EXISTS retention_runs;
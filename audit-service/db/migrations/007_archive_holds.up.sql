@@ -0,0 +1,15 @@
+-- +goose Up
+-- Диапазоны, восстановленные из холодного архива. Архиватор не трогает
+-- события из них до hold_until, иначе он сразу вернул бы их обратно в архив.
+CREATE TABLE archive_holds (
+    id BIGSERIAL PRIMARY KEY,
+    range_start TIMESTAMP NOT NULL,
+    range_end TIMESTAMP NOT NULL,
+    hold_until TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_archive_holds_hold_until ON archive_holds(hold_until);
+
+-- +goose Down
+DROP TABLE IF EXISTS archive_holds;
@@ -0,0 +1,37 @@
+-- +goose Up
+-- Цепочка хешей над audit_events: каждое событие хранит свой номер в
+-- цепочке, хеш предыдущего звена и хеш от него и своего содержимого.
+-- События, сохранённые до этой миграции, в цепочку не входят.
+ALTER TABLE audit_events
+    ADD COLUMN chain_seq BIGINT,
+    ADD COLUMN prev_hash BYTEA,
+    ADD COLUMN hash BYTEA;
+
+CREATE INDEX idx_audit_events_chain_seq ON audit_events(chain_seq);
+
+-- Последнее звено цепочки. Строка блокируется на время каждой вставки,
+-- этим задаётся порядок звеньев при параллельной записи с разных реплик.
+CREATE TABLE audit_chain_head (
+    id SMALLINT PRIMARY KEY CHECK (id = 1),
+    seq BIGINT NOT NULL,
+    hash BYTEA NOT NULL
+);
+
+INSERT INTO audit_chain_head (id, seq, hash) VALUES (1, 0, '');
+
+-- Хеши звеньев, удалённых штатно (сроки хранения, архив, отсоединение
+-- секций): по ним проверка цепочки отличает штатное удаление от подлога
+CREATE TABLE audit_chain_pruned (
+    chain_seq BIGINT PRIMARY KEY,
+    hash BYTEA NOT NULL,
+    pruned_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_chain_pruned;
+DROP TABLE IF EXISTS audit_chain_head;
+DROP INDEX IF EXISTS idx_audit_events_chain_seq;
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS hash,
+    DROP COLUMN IF EXISTS prev_hash,
+    DROP COLUMN IF EXISTS chain_seq;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Контрольные точки цепочки хешей: корень дерева Меркла над хешами звеньев
+-- [first_seq, last_seq], подписанный Ed25519. Открытый ключ хранится в
+-- каждой точке, чтобы старые точки проверялись и после смены ключа.
+CREATE TABLE audit_checkpoints (
+    id BIGSERIAL PRIMARY KEY,
+    first_seq BIGINT NOT NULL UNIQUE,
+    last_seq BIGINT NOT NULL UNIQUE,
+    root BYTEA NOT NULL,
+    last_hash BYTEA NOT NULL,
+    signature BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (last_seq >= first_seq)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_checkpoints;
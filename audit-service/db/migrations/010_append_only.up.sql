@@ -0,0 +1,187 @@
+-- +goose Up
+-- Журнал аудита только дописывается. Права раздаются двум групповым ролям:
+--   audit_writer - сервис: чтение и вставка событий, без UPDATE и DELETE;
+--   audit_purger - очистка по срокам хранения, архив и секции: удаление
+--                  событий и DDL секций через функции ниже.
+-- Логины сервиса и очистки включаются в эти роли при запуске сервиса
+-- (DB_USER и DB_PURGE_USER), таблицами владеет пользователь миграций.
+-- +goose StatementBegin
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'audit_writer') THEN
+        CREATE ROLE audit_writer NOLOGIN;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'audit_purger') THEN
+        CREATE ROLE audit_purger NOLOGIN;
+    END IF;
+END;
+$$;
+-- +goose StatementEnd
+
+-- Триггеры срабатывают и для владельца таблиц. UPDATE и TRUNCATE запрещены
+-- всегда, DELETE - вне транзакции очистки, которая ставит audit.purge = on.
+-- Сам флаг прав не даёт: без DELETE из audit_purger удалить всё равно нельзя.
+-- +goose StatementBegin
+CREATE FUNCTION audit_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND current_setting('audit.purge', true) = 'on' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION '% on % is not allowed: audit log is append-only', TG_OP, TG_TABLE_NAME
+        USING ERRCODE = 'insufficient_privilege';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- Строчные триггеры секционированной audit_events наследуются всеми её
+-- секциями, в том числе будущими
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_append_only();
+
+CREATE TRIGGER audit_event_identities_append_only
+    BEFORE UPDATE OR DELETE ON audit_event_identities
+    FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+CREATE TRIGGER audit_event_identities_no_truncate
+    BEFORE TRUNCATE ON audit_event_identities
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_append_only();
+
+CREATE TRIGGER audit_chain_pruned_append_only
+    BEFORE UPDATE OR DELETE ON audit_chain_pruned
+    FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+CREATE TRIGGER audit_chain_pruned_no_truncate
+    BEFORE TRUNCATE ON audit_chain_pruned
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_append_only();
+
+CREATE TRIGGER audit_checkpoints_append_only
+    BEFORE UPDATE OR DELETE ON audit_checkpoints
+    FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+CREATE TRIGGER audit_checkpoints_no_truncate
+    BEFORE TRUNCATE ON audit_checkpoints
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_append_only();
+
+-- DDL секций требует владельца таблицы, поэтому роль очистки получает его
+-- только через эти функции. Они выполняются с правами владельца.
+
+-- audit_monthly_partition проверяет, что name - подключённая месячная секция
+-- audit_events, и возвращает её месяц
+-- +goose StatementBegin
+CREATE FUNCTION audit_monthly_partition(name TEXT) RETURNS DATE AS $$
+BEGIN
+    IF name !~ '^audit_events_p[0-9]{6}$' OR NOT EXISTS (
+        SELECT 1 FROM pg_inherits WHERE inhparent = 'audit_events'::regclass AND inhrelid = to_regclass(name)
+    ) THEN
+        RAISE EXCEPTION '% is not a monthly partition of audit_events', name;
+    END IF;
+    RETURN to_date(substr(name, length('audit_events_p') + 1), 'YYYYMM');
+END;
+$$ LANGUAGE plpgsql SET search_path = public, pg_temp;
+-- +goose StatementEnd
+
+-- События месяца могли попасть в audit_events_default, пока секции не было;
+-- с ними CREATE TABLE ... PARTITION OF не пройдёт. Поэтому секция создаётся
+-- отдельной таблицей, строки переносятся из default, и она подключается к
+-- audit_events. Возвращает имя созданной секции или NULL, если она уже есть.
+-- +goose StatementBegin
+CREATE FUNCTION audit_create_partition(month DATE) RETURNS TEXT AS $$
+DECLARE
+    part TEXT := 'audit_events_p' || to_char(month, 'YYYYMM');
+    month_start DATE := date_trunc('month', month);
+    month_end DATE := date_trunc('month', month) + INTERVAL '1 month';
+BEGIN
+    IF to_regclass(part) IS NOT NULL THEN
+        RETURN NULL;
+    END IF;
+
+    PERFORM set_config('audit.purge', 'on', true);
+    EXECUTE format('CREATE TABLE %I (LIKE audit_events INCLUDING DEFAULTS)', part);
+    EXECUTE format(
+        'WITH moved AS (DELETE FROM audit_events_default WHERE timestamp >= %L AND timestamp < %L RETURNING *) '
+        'INSERT INTO %I SELECT * FROM moved',
+        month_start, month_end, part
+    );
+    EXECUTE format('ALTER TABLE audit_events ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)', part, month_start, month_end);
+    PERFORM set_config('audit.purge', '', true);
+
+    RETURN part;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public, pg_temp;
+-- +goose StatementEnd
+
+-- Отсоединённая секция выпадает из цепочки хешей так же, как удалённая
+-- +goose StatementBegin
+CREATE FUNCTION audit_detach_partition(name TEXT) RETURNS VOID AS $$
+BEGIN
+    PERFORM audit_monthly_partition(name);
+    EXECUTE format(
+        'INSERT INTO audit_chain_pruned (chain_seq, hash) SELECT chain_seq, hash FROM %I '
+        'WHERE chain_seq IS NOT NULL ON CONFLICT DO NOTHING',
+        name
+    );
+    EXECUTE format('ALTER TABLE audit_events DETACH PARTITION %I', name);
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public, pg_temp;
+-- +goose StatementEnd
+
+-- Удаляет секцию вместе с идентичностями её событий, запоминая хеши звеньев
+-- +goose StatementBegin
+CREATE FUNCTION audit_drop_partition(name TEXT) RETURNS VOID AS $$
+DECLARE
+    month_start DATE := audit_monthly_partition(name);
+BEGIN
+    PERFORM set_config('audit.purge', 'on', true);
+    DELETE FROM audit_event_identities
+    WHERE timestamp >= month_start AND timestamp < month_start + INTERVAL '1 month';
+    EXECUTE format(
+        'INSERT INTO audit_chain_pruned (chain_seq, hash) SELECT chain_seq, hash FROM %I '
+        'WHERE chain_seq IS NOT NULL ON CONFLICT DO NOTHING',
+        name
+    );
+    EXECUTE format('DROP TABLE %I', name);
+    PERFORM set_config('audit.purge', '', true);
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public, pg_temp;
+-- +goose StatementEnd
+
+REVOKE ALL ON FUNCTION audit_monthly_partition(TEXT), audit_create_partition(DATE),
+    audit_detach_partition(TEXT), audit_drop_partition(TEXT) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION audit_create_partition(DATE), audit_detach_partition(TEXT),
+    audit_drop_partition(TEXT) TO audit_purger;
+
+REVOKE ALL ON audit_events, audit_event_identities, audit_chain_head, audit_chain_pruned,
+    audit_checkpoints, retention_runs, archive_holds FROM PUBLIC;
+
+GRANT SELECT, INSERT ON audit_events, audit_event_identities, audit_checkpoints TO audit_writer;
+GRANT SELECT, UPDATE ON audit_chain_head TO audit_writer;
+GRANT SELECT ON audit_chain_pruned, retention_runs, archive_holds TO audit_writer;
+GRANT USAGE ON SEQUENCE audit_events_id_seq, audit_checkpoints_id_seq TO audit_writer;
+
+GRANT SELECT, INSERT, DELETE ON audit_events, audit_event_identities TO audit_purger;
+GRANT SELECT, INSERT ON audit_chain_pruned, retention_runs, archive_holds TO audit_purger;
+GRANT USAGE ON SEQUENCE retention_runs_id_seq, archive_holds_id_seq TO audit_purger;
+
+-- +goose Down
+-- Роли общие для всего кластера и могут быть выданы логинам, поэтому
+-- остаются; у них отзываются только права на объекты этой базы
+REVOKE ALL ON audit_events, audit_event_identities, audit_chain_head, audit_chain_pruned,
+    audit_checkpoints, retention_runs, archive_holds FROM audit_writer, audit_purger;
+REVOKE ALL ON SEQUENCE audit_events_id_seq, audit_checkpoints_id_seq, retention_runs_id_seq,
+    archive_holds_id_seq FROM audit_writer, audit_purger;
+
+DROP FUNCTION IF EXISTS audit_drop_partition(TEXT);
+DROP FUNCTION IF EXISTS audit_detach_partition(TEXT);
+DROP FUNCTION IF EXISTS audit_create_partition(DATE);
+DROP FUNCTION IF EXISTS audit_monthly_partition(TEXT);
+
+DROP TRIGGER IF EXISTS audit_checkpoints_no_truncate ON audit_checkpoints;
+DROP TRIGGER IF EXISTS audit_checkpoints_append_only ON audit_checkpoints;
+DROP TRIGGER IF EXISTS audit_chain_pruned_no_truncate ON audit_chain_pruned;
+DROP TRIGGER IF EXISTS audit_chain_pruned_append_only ON audit_chain_pruned;
+DROP TRIGGER IF EXISTS audit_event_identities_no_truncate ON audit_event_identities;
+DROP TRIGGER IF EXISTS audit_event_identities_append_only ON audit_event_identities;
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS audit_append_only();
@@ -0,0 +1,46 @@
+-- +goose Up
+-- Шифрование полей attributes и response: ключ данных строки, зашифрованный
+-- ключом связки enc_key_id. У незашифрованных строк обе колонки NULL.
+ALTER TABLE audit_events ADD COLUMN enc_key_id TEXT, ADD COLUMN enc_dek BYTEA;
+
+-- Ротация ищет строки, зашифрованные не активным ключом
+CREATE INDEX idx_audit_events_enc_key_id ON audit_events (enc_key_id) WHERE enc_key_id IS NOT NULL;
+
+-- Ротация ключей перешифровывает ключ данных строки. Это единственное
+-- разрешённое изменение события: в транзакции с audit.rekey = on и только
+-- колонок enc_key_id и enc_dek, сами поля и звено цепочки не меняются.
+-- Остальное - как в audit_append_only.
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_setting('audit.rekey', true) = 'on'
+        AND to_jsonb(NEW) - ARRAY['enc_key_id', 'enc_dek'] = to_jsonb(OLD) - ARRAY['enc_key_id', 'enc_dek'] THEN
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'DELETE' AND current_setting('audit.purge', true) = 'on' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION '% on % is not allowed: audit log is append-only', TG_OP, TG_TABLE_NAME
+        USING ERRCODE = 'insufficient_privilege';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+GRANT UPDATE (enc_key_id, enc_dek) ON audit_events TO audit_purger;
+
+-- +goose Down
+REVOKE UPDATE (enc_key_id, enc_dek) ON audit_events FROM audit_purger;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+DROP FUNCTION IF EXISTS audit_events_append_only();
+
+DROP INDEX IF EXISTS idx_audit_events_enc_key_id;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS enc_key_id, DROP COLUMN IF EXISTS enc_dek;
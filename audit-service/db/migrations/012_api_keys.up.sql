@@ -0,0 +1,24 @@
+-- +goose Up
+-- Ключи API. Хранится только SHA-256 ключа, сам ключ клиент получает один
+-- раз при создании. Отозванные ключи остаются: на них ссылаются события.
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_hash BYTEA NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP
+);
+
+-- Кто записал событие: идентичность клиента (api_key:<id>). NULL - событие
+-- записано без аутентификации.
+ALTER TABLE audit_events ADD COLUMN actor TEXT;
+
+-- Сервис управляет ключами сам, но ключ можно только отозвать
+GRANT SELECT, INSERT, UPDATE (revoked_at) ON api_keys TO audit_writer;
+
+-- +goose Down
+REVOKE ALL ON api_keys FROM audit_writer;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS actor;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,57 @@
+-- +goose Up
+-- Арендаторы: команды, которые делят один кластер аудита. События,
+-- записанные раньше, принадлежат арендатору default. Значение по умолчанию
+-- задаётся без перезаписи строк, поэтому триггер только-дописывания не
+-- срабатывает.
+ALTER TABLE audit_events ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX idx_audit_events_tenant_timestamp ON audit_events (tenant_id, timestamp DESC, id DESC);
+
+-- Ключ идемпотентности уникален в пределах арендатора: команды выбирают
+-- ключи независимо. event_id - UUID и остаётся уникальным глобально.
+ALTER TABLE audit_event_identities ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE audit_event_identities DROP CONSTRAINT audit_event_identities_idempotency_key_key;
+ALTER TABLE audit_event_identities
+    ADD CONSTRAINT audit_event_identities_tenant_key UNIQUE (tenant_id, idempotency_key);
+
+-- Ключ API может быть закреплён за арендатором. NULL - ключ кластера.
+ALTER TABLE api_keys ADD COLUMN tenant_id TEXT;
+
+-- Вторая линия защиты после условий по tenant_id в запросах: сервис
+-- выполняет запросы к событиям в транзакции с audit.tenant_id, и строки
+-- чужого арендатора не видны и не пишутся, даже если условие в запрос не
+-- попало. Без переменной не видно ничего: все арендаторы открываются только
+-- значением '*', которое сервис задаёт для администратора кластера и своих
+-- фоновых задач. Очистка, архив и секции работают под audit_purger, ему
+-- политика открывает все строки. FORCE распространяет политику и на
+-- владельца таблиц.
+ALTER TABLE audit_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_events FORCE ROW LEVEL SECURITY;
+CREATE POLICY audit_events_tenant_isolation ON audit_events
+    USING (current_setting('audit.tenant_id', true) IN ('*', tenant_id))
+    WITH CHECK (current_setting('audit.tenant_id', true) IN ('*', tenant_id));
+CREATE POLICY audit_events_purger ON audit_events TO audit_purger
+    USING (true) WITH CHECK (true);
+
+ALTER TABLE audit_event_identities ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_event_identities FORCE ROW LEVEL SECURITY;
+CREATE POLICY audit_event_identities_tenant_isolation ON audit_event_identities
+    USING (current_setting('audit.tenant_id', true) IN ('*', tenant_id))
+    WITH CHECK (current_setting('audit.tenant_id', true) IN ('*', tenant_id));
+CREATE POLICY audit_event_identities_purger ON audit_event_identities TO audit_purger
+    USING (true) WITH CHECK (true);
+
+-- +goose Down
+DROP POLICY IF EXISTS audit_event_identities_purger ON audit_event_identities;
+DROP POLICY IF EXISTS audit_event_identities_tenant_isolation ON audit_event_identities;
+ALTER TABLE audit_event_identities NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE audit_event_identities DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS audit_events_purger ON audit_events;
+DROP POLICY IF EXISTS audit_events_tenant_isolation ON audit_events;
+ALTER TABLE audit_events NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE audit_events DISABLE ROW LEVEL SECURITY;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE audit_event_identities DROP CONSTRAINT IF EXISTS audit_event_identities_tenant_key;
+ALTER TABLE audit_event_identities ADD CONSTRAINT audit_event_identities_idempotency_key_key UNIQUE (idempotency_key);
+ALTER TABLE audit_event_identities DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS idx_audit_events_tenant_timestamp;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,42 @@
+-- +goose Up
+-- Расход квот арендаторов по минутным окнам, общий для всех реплик: за
+-- балансировщиком арендатор получает одну квоту, а не по квоте на реплику.
+-- Старые окна сервис удаляет сам.
+CREATE TABLE tenant_quota_usage (
+    tenant_id TEXT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    used BIGINT NOT NULL DEFAULT 0,
+    rejected BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, window_start)
+);
+
+-- audit_take_quota списывает до n событий арендатора в окне, не выходя за
+-- лимит, остальные засчитывает отклонёнными, и возвращает, сколько списано.
+-- Строка окна блокируется вставкой, поэтому реплики не списывают одну квоту
+-- дважды.
+-- +goose StatementBegin
+CREATE FUNCTION audit_take_quota(p_tenant TEXT, p_window TIMESTAMPTZ, p_n INT, p_limit INT) RETURNS INT AS $$
+DECLARE
+    v_used BIGINT;
+    v_taken INT;
+BEGIN
+    INSERT INTO tenant_quota_usage AS u (tenant_id, window_start) VALUES (p_tenant, p_window)
+    ON CONFLICT (tenant_id, window_start) DO UPDATE SET used = u.used
+    RETURNING used INTO v_used;
+
+    v_taken := LEAST(p_n, GREATEST(p_limit - v_used, 0));
+    UPDATE tenant_quota_usage SET used = used + v_taken, rejected = rejected + p_n - v_taken
+    WHERE tenant_id = p_tenant AND window_start = p_window;
+    RETURN v_taken;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+REVOKE ALL ON tenant_quota_usage FROM PUBLIC;
+REVOKE ALL ON FUNCTION audit_take_quota(TEXT, TIMESTAMPTZ, INT, INT) FROM PUBLIC;
+GRANT SELECT, INSERT, UPDATE, DELETE ON tenant_quota_usage TO audit_writer;
+GRANT EXECUTE ON FUNCTION audit_take_quota(TEXT, TIMESTAMPTZ, INT, INT) TO audit_writer;
+
+-- +goose Down
+DROP FUNCTION IF EXISTS audit_take_quota(TEXT, TIMESTAMPTZ, INT, INT);
+DROP TABLE IF EXISTS tenant_quota_usage;
@@ -0,0 +1,47 @@
+-- +goose Up
+-- Уведомления о вставках несут границы времени событий: "<от>|<до>|<id,...>".
+-- Живая лента читает события по id вместе с границами, и Postgres отсекает
+-- секции вне них, а не просматривает все помесячные секции на каждое
+-- уведомление. Куски по 300 id со временем укладываются в 8000 байт.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_audit_events() RETURNS trigger AS $$
+DECLARE
+    payload TEXT;
+BEGIN
+    FOR payload IN
+        SELECT to_char(min(timestamp), 'YYYY-MM-DD HH24:MI:SS.US') || '|' ||
+            to_char(max(timestamp), 'YYYY-MM-DD HH24:MI:SS.US') || '|' ||
+            string_agg(id::text, ',' ORDER BY id)
+        FROM (
+            SELECT id, timestamp, (row_number() OVER (ORDER BY id) - 1) / 300 AS chunk
+            FROM new_rows
+        ) numbered
+        GROUP BY chunk
+    LOOP
+        PERFORM pg_notify('audit_events', payload);
+    END LOOP;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notify_audit_events() RETURNS trigger AS $$
+DECLARE
+    ids TEXT;
+BEGIN
+    FOR ids IN
+        SELECT string_agg(id::text, ',' ORDER BY id)
+        FROM (
+            SELECT id, (row_number() OVER (ORDER BY id) - 1) / 300 AS chunk
+            FROM new_rows
+        ) numbered
+        GROUP BY chunk
+    LOOP
+        PERFORM pg_notify('audit_events', ids);
+    END LOOP;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
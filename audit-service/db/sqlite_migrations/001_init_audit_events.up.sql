@@ -0,0 +1,58 @@
+-- +goose Up
+-- Схема встроенного хранилища SQLite. Повторяет audit_events из Postgres
+-- без секций и шифрования. Время хранится текстом фиксированной ширины
+-- (2006-01-02 15:04:05.000000, UTC), поэтому сортируется как строка,
+-- attributes и response - JSON-текстом.
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY,
+    event_id TEXT UNIQUE,
+    idempotency_key TEXT UNIQUE,
+    timestamp TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    component TEXT,
+    operation TEXT NOT NULL,
+    session_id INTEGER,
+    request_id INTEGER,
+    response TEXT,
+    attributes TEXT,
+    created_at TEXT NOT NULL,
+    chain_seq INTEGER NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_events_timestamp ON audit_events(timestamp, id);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_component ON audit_events(component);
+CREATE INDEX idx_audit_events_operation ON audit_events(operation);
+CREATE INDEX idx_audit_events_session_id ON audit_events(session_id);
+CREATE INDEX idx_audit_events_request_id ON audit_events(request_id);
+
+-- Голова цепочки хешей - единственная строка
+CREATE TABLE audit_chain_head (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    seq INTEGER NOT NULL,
+    hash TEXT NOT NULL
+);
+INSERT INTO audit_chain_head (id, seq, hash) VALUES (1, 0, '');
+
+-- Журнал только дописывается, как и в Postgres
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'UPDATE on audit_events is not allowed: audit log is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'DELETE on audit_events is not allowed: audit log is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER IF EXISTS audit_events_no_delete;
+DROP TRIGGER IF EXISTS audit_events_no_update;
+DROP TABLE IF EXISTS audit_chain_head;
+DROP TABLE IF EXISTS audit_events;
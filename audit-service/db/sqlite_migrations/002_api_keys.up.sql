@@ -0,0 +1,17 @@
+-- +goose Up
+-- Ключи API, как в Postgres. Права хранятся через пробел.
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_hash BLOB NOT NULL,
+    scopes TEXT NOT NULL,
+    created_by TEXT,
+    created_at TEXT NOT NULL,
+    revoked_at TEXT
+);
+
+ALTER TABLE audit_events ADD COLUMN actor TEXT;
+
+-- +goose Down
+ALTER TABLE audit_events DROP COLUMN actor;
+DROP TABLE IF EXISTS api_keys;
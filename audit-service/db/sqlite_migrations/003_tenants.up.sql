@@ -0,0 +1,62 @@
+-- +goose Up
+-- Арендаторы, как в Postgres. Ключ идемпотентности становится уникальным в
+-- пределах арендатора, а ограничение UNIQUE колонки SQLite снять не умеет,
+-- поэтому таблица пересоздаётся. DROP TABLE триггеров не запускает.
+CREATE TABLE audit_events_new (
+    id INTEGER PRIMARY KEY,
+    event_id TEXT UNIQUE,
+    idempotency_key TEXT,
+    timestamp TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    component TEXT,
+    operation TEXT NOT NULL,
+    session_id INTEGER,
+    request_id INTEGER,
+    response TEXT,
+    attributes TEXT,
+    created_at TEXT NOT NULL,
+    chain_seq INTEGER NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    actor TEXT,
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    UNIQUE (tenant_id, idempotency_key)
+);
+
+INSERT INTO audit_events_new (id, event_id, idempotency_key, timestamp, user_id, component, operation,
+    session_id, request_id, response, attributes, created_at, chain_seq, prev_hash, hash, actor)
+SELECT id, event_id, idempotency_key, timestamp, user_id, component, operation,
+    session_id, request_id, response, attributes, created_at, chain_seq, prev_hash, hash, actor
+FROM audit_events;
+
+DROP TABLE audit_events;
+ALTER TABLE audit_events_new RENAME TO audit_events;
+
+CREATE INDEX idx_audit_events_timestamp ON audit_events(timestamp, id);
+CREATE INDEX idx_audit_events_tenant_timestamp ON audit_events(tenant_id, timestamp, id);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_component ON audit_events(component);
+CREATE INDEX idx_audit_events_operation ON audit_events(operation);
+CREATE INDEX idx_audit_events_session_id ON audit_events(session_id);
+CREATE INDEX idx_audit_events_request_id ON audit_events(request_id);
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'UPDATE on audit_events is not allowed: audit log is append-only');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'DELETE on audit_events is not allowed: audit log is append-only');
+END;
+-- +goose StatementEnd
+
+ALTER TABLE api_keys ADD COLUMN tenant_id TEXT;
+
+-- +goose Down
+-- Таблица событий не пересоздаётся обратно: ключи идемпотентности разных
+-- арендаторов уже могут совпадать
+ALTER TABLE api_keys DROP COLUMN tenant_id;
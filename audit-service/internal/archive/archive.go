@@ -0,0 +1,303 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/tenant"
+)
+
+// Имя файла оглавления архива в хранилище
+const manifestName = "manifest.json"
+
+// Segment описывает один файл архива: gzip-сжатый NDJSON событий одного дня,
+// упорядоченных по id
+type Segment struct {
+	Name      string    `json:"name"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Count     int       `json:"count"`
+	FirstID   int64     `json:"first_id"`
+	LastID    int64     `json:"last_id"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	// Retention - сроки хранения событий сегмента; nil - не посчитаны
+	Retention *Retention `json:"retention,omitempty"`
+}
+
+// Retention - когда истекает срок хранения первого из событий сегмента по
+// правилам с отпечатком Policy. Нулевой ExpiresAt - все события бессрочные.
+type Retention struct {
+	Policy    string    `json:"policy"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Manifest - оглавление архива, сегменты упорядочены по From
+type Manifest struct {
+	Segments []Segment `json:"segments"`
+}
+
+// Archive пишет и читает сегменты событий в хранилище и ведёт их оглавление.
+// Оглавление меняют только под advisory-блокировкой архива, поэтому
+// параллельных записей manifest.json нет.
+type Archive struct {
+	store Store
+}
+
+func New(store Store) *Archive {
+	return &Archive{store: store}
+}
+
+// Manifest загружает оглавление; пустой архив - пустое оглавление
+func (a *Archive) Manifest(ctx context.Context) (*Manifest, error) {
+	data, err := a.store.Get(ctx, manifestName)
+	if errors.Is(err, ErrNotFound) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode archive manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func (a *Archive) saveManifest(ctx context.Context, manifest *Manifest) error {
+	sort.Slice(manifest.Segments, func(i, j int) bool {
+		if manifest.Segments[i].From.Equal(manifest.Segments[j].From) {
+			return manifest.Segments[i].FirstID < manifest.Segments[j].FirstID
+		}
+		return manifest.Segments[i].From.Before(manifest.Segments[j].From)
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode archive manifest: %w", err)
+	}
+	return a.store.Put(ctx, manifestName, data)
+}
+
+// Segments возвращает сегменты, пересекающиеся с [from, to]. Нулевые
+// границы не ограничивают выборку.
+func (a *Archive) Segments(ctx context.Context, from, to time.Time) ([]Segment, error) {
+	manifest, err := a.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	for _, segment := range manifest.Segments {
+		if !from.IsZero() && segment.To.Before(from) {
+			continue
+		}
+		if !to.IsZero() && segment.From.After(to) {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// WriteSegment сохраняет события одного дня сегментом и добавляет его в
+// оглавление. Имя сегмента выводится из дня и диапазона id, поэтому
+// повторная запись тех же событий после сбоя заменяет сегмент, а не
+// дублирует его.
+func (a *Archive) WriteSegment(ctx context.Context, day time.Time, events []*model.AuditEvent, retention *Retention) (*Segment, error) {
+	segment, data, err := encodeSegment(events, retention)
+	if err != nil {
+		return nil, err
+	}
+	segment.Name = fmt.Sprintf("segments/%s/%d-%d.ndjson.gz", day.Format("2006/01/02"), segment.FirstID, segment.LastID)
+
+	// Сначала файл, потом оглавление: сегмент без записи в оглавлении
+	// безвреден и будет перезаписан при повторе
+	if err := a.store.Put(ctx, segment.Name, data); err != nil {
+		return nil, err
+	}
+	if err := a.updateManifest(ctx, nil, segment); err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// RewriteSegment заменяет сегмент новым из events - оставшейся части его
+// событий - и удаляет старый файл; пустой events удаляет сегмент целиком.
+// Имя нового сегмента включает число событий, поэтому оно отличается от
+// старого, и до смены оглавления старый файл читается как прежде.
+func (a *Archive) RewriteSegment(ctx context.Context, old Segment, events []*model.AuditEvent, retention *Retention) (*Segment, error) {
+	if len(events) == 0 {
+		return nil, a.RemoveSegments(ctx, []string{old.Name})
+	}
+	if len(events) >= old.Count {
+		return nil, fmt.Errorf("rewrite of archive segment %s must drop events", old.Name)
+	}
+
+	segment, data, err := encodeSegment(events, retention)
+	if err != nil {
+		return nil, err
+	}
+	segment.Name = fmt.Sprintf("segments/%s/%d-%d-%d.ndjson.gz",
+		old.From.Format("2006/01/02"), segment.FirstID, segment.LastID, segment.Count)
+	if err := a.store.Put(ctx, segment.Name, data); err != nil {
+		return nil, err
+	}
+	if err := a.updateManifest(ctx, []string{old.Name}, segment); err != nil {
+		return nil, err
+	}
+	if err := a.store.Delete(ctx, old.Name); err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// SetRetention записывает в оглавление пересчитанные сроки сегмента, не
+// трогая его файл
+func (a *Archive) SetRetention(ctx context.Context, segment Segment, retention *Retention) error {
+	segment.Retention = retention
+	return a.updateManifest(ctx, nil, &segment)
+}
+
+// encodeSegment сжимает события в файл сегмента; имя задаёт вызывающий
+func encodeSegment(events []*model.AuditEvent, retention *Retention) (*Segment, []byte, error) {
+	if len(events) == 0 {
+		return nil, nil, errors.New("empty archive segment")
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	segment := &Segment{
+		FirstID:   events[0].ID,
+		LastID:    events[len(events)-1].ID,
+		From:      events[0].Timestamp,
+		To:        events[0].Timestamp,
+		Count:     len(events),
+		Retention: retention,
+	}
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode archived event %d: %w", event.ID, err)
+		}
+		if event.Timestamp.Before(segment.From) {
+			segment.From = event.Timestamp
+		}
+		if event.Timestamp.After(segment.To) {
+			segment.To = event.Timestamp
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to compress archive segment: %w", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	segment.Size = int64(buf.Len())
+	segment.SHA256 = hex.EncodeToString(sum[:])
+	segment.CreatedAt = time.Now().UTC()
+	return segment, buf.Bytes(), nil
+}
+
+// updateManifest убирает из оглавления сегменты remove и добавляет или
+// заменяет по имени сегмент add
+func (a *Archive) updateManifest(ctx context.Context, remove []string, add *Segment) error {
+	manifest, err := a.Manifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	kept := manifest.Segments[:0]
+	replaced := false
+	for _, segment := range manifest.Segments {
+		if containsName(remove, segment.Name) {
+			continue
+		}
+		if add != nil && segment.Name == add.Name {
+			segment = *add
+			replaced = true
+		}
+		kept = append(kept, segment)
+	}
+	manifest.Segments = kept
+	if add != nil && !replaced {
+		manifest.Segments = append(manifest.Segments, *add)
+	}
+	return a.saveManifest(ctx, manifest)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSegment читает события сегмента, проверяя контрольную сумму файла
+func (a *Archive) ReadSegment(ctx context.Context, segment Segment) ([]*model.AuditEvent, error) {
+	data, err := a.store.Get(ctx, segment.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != segment.SHA256 {
+		return nil, fmt.Errorf("archive segment %s is corrupted: checksum mismatch", segment.Name)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive segment %s: %w", segment.Name, err)
+	}
+	defer zr.Close()
+
+	events := make([]*model.AuditEvent, 0, segment.Count)
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event model.AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode archive segment %s: %w", segment.Name, err)
+		}
+		// Сегменты, записанные до появления арендаторов
+		if event.TenantID == "" {
+			event.TenantID = tenant.Default
+		}
+		events = append(events, &event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive segment %s: %w", segment.Name, err)
+	}
+
+	return events, nil
+}
+
+// RemoveSegments убирает сегменты из оглавления и удаляет их файлы.
+// Оглавление обновляется первым, чтобы запросы не ссылались на удалённые файлы.
+func (a *Archive) RemoveSegments(ctx context.Context, names []string) error {
+	if err := a.updateManifest(ctx, names, nil); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := a.store.Delete(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package auth описывает, кто обращается к API и что ему разрешено:
+// права (scopes), аутентифицированный клиент в контексте запроса и формат
+// ключей API.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Права клиента. admin включает все остальные.
+const (
+	ScopeEventsWrite = "events:write"
+	ScopeEventsRead  = "events:read"
+	ScopeAdmin       = "admin"
+)
+
+// Scopes - все известные права
+var Scopes = []string{ScopeEventsWrite, ScopeEventsRead, ScopeAdmin}
+
+// ValidScope сообщает, известно ли право
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal - аутентифицированный клиент
+type Principal struct {
+	// Actor - идентичность клиента, которая записывается в события
+	// (api_key:<id>, jwt:<sub>, mtls:<идентичность сертификата>)
+	Actor  string
+	Scopes []string
+	// Subject - claim sub токена, у клиентов с ключом API пустой
+	Subject string
+	// User - если не пустой, клиент записывает события только от имени
+	// этого пользователя (строгий режим)
+	User string
+	// Tenant - если не пустой, клиент работает только с событиями этого
+	// арендатора
+	Tenant string
+}
+
+// HasScope сообщает, есть ли у клиента право scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext возвращает клиента запроса; nil, если аутентификация
+// выключена
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Actor возвращает идентичность клиента запроса или пустую строку
+func Actor(ctx context.Context) string {
+	if p := FromContext(ctx); p != nil {
+		return p.Actor
+	}
+	return ""
+}
+
+// Ключ API имеет вид audit_<id>_<секрет>: id открыт и по нему ключ ищется в
+// хранилище, секрет проверяется по хешу всего ключа
+const keyPrefix = "audit_"
+
+// APIKeyActor - идентичность клиента с ключом id
+func APIKeyActor(id string) string {
+	return "api_key:" + id
+}
+
+// GenerateKey создаёт новый ключ и его id
+func GenerateKey() (id, key string, err error) {
+	idBytes := make([]byte, 8)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate key id: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate key secret: %w", err)
+	}
+
+	id = hex.EncodeToString(idBytes)
+	return id, keyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// KeyID возвращает id из ключа; false - строка не похожа на ключ API
+func KeyID(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(id) != 16 || secret == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// HashKey - хеш ключа, который хранится вместо него. Секрет случайный и
+// длинный, поэтому медленный хеш для паролей не нужен.
+func HashKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
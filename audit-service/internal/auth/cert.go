@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// Откуда берётся идентичность клиента в сертификате
+const (
+	// CertIdentitySAN - первый URI из SAN (spiffe://...), иначе первое
+	// DNS-имя, иначе первый email
+	CertIdentitySAN = "san"
+	// CertIdentityCN - Common Name субъекта
+	CertIdentityCN = "cn"
+)
+
+// CertIdentity возвращает идентичность клиента из сертификата или пустую
+// строку, если нужного поля в нём нет
+func CertIdentity(cert *x509.Certificate, source string) string {
+	if source == CertIdentityCN {
+		return cert.Subject.CommonName
+	}
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
+
+// CertMapper превращает проверенный клиентский сертификат в клиента
+// сервиса. Права задаются по идентичности, "*" - права любого клиента с
+// сертификатом от доверенного CA. Арендатор тоже задаётся по идентичности.
+type CertMapper struct {
+	source  string
+	scopes  map[string][]string
+	tenants map[string]string
+}
+
+func NewCertMapper(source string, scopes map[string][]string, tenants map[string]string) *CertMapper {
+	return &CertMapper{source: source, scopes: scopes, tenants: tenants}
+}
+
+// Principal возвращает клиента соединения; nil - сертификат не прислан,
+// не проверен или в нём нет идентичности
+func (m *CertMapper) Principal(state *tls.ConnectionState) *Principal {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	identity := CertIdentity(state.VerifiedChains[0][0], m.source)
+	if identity == "" {
+		return nil
+	}
+
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, scope := range append(m.scopes[identity], m.scopes["*"]...) {
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return &Principal{Actor: "mtls:" + identity, Scopes: scopes, Tenant: m.tenants[identity]}
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Не чаще этого JWKS перечитывается из-за токена с неизвестным kid:
+// иначе поток поддельных токенов превратился бы в поток запросов к IdP
+const minUnknownKIDRefresh = time.Minute
+
+// Максимальный размер документа JWKS
+const maxJWKSSize = 1 << 20
+
+// JWKS - набор открытых ключей издателя токенов (RFC 7517). Источник -
+// локальный файл или http(s) URL. Набор перечитывается периодически и при
+// встрече неизвестного kid; если перечитать не удалось, остаётся последний
+// успешно загруженный.
+type JWKS struct {
+	source string
+	client *http.Client
+
+	mu       sync.RWMutex
+	keys     map[string]interface{}
+	loadedAt time.Time
+
+	// refreshMu не даёт одновременным запросам перечитывать набор
+	// несколько раз
+	refreshMu sync.Mutex
+}
+
+// LoadJWKS загружает набор из source. Ошибка загрузки при старте
+// фатальна: без ключей ни один токен не пройдёт проверку.
+func LoadJWKS(ctx context.Context, source string) (*JWKS, error) {
+	j := &JWKS{source: source, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := j.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Run перечитывает набор каждые interval до отмены ctx
+func (j *JWKS) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.Refresh(ctx); err != nil {
+				log.Printf("Failed to refresh JWKS, keeping previous keys: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh перечитывает набор из источника
+func (j *JWKS) Refresh(ctx context.Context) error {
+	data, err := j.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS from %s: %w", j.source, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse JWKS from %s: %w", j.source, err)
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.loadedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return os.ReadFile(j.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// Key возвращает открытый ключ с идентификатором kid. Токен без kid
+// принимается, только если ключ в наборе один.
+func (j *JWKS) Key(ctx context.Context, kid string) (interface{}, error) {
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+
+	// Издатель мог добавить ключ после последнего обновления
+	if j.refreshUnknown(ctx) {
+		if key, ok := j.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (j *JWKS) lookup(kid string) (interface{}, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// refreshUnknown перечитывает набор, если с прошлой загрузки прошло не
+// меньше minUnknownKIDRefresh. true - набор обновлён.
+func (j *JWKS) refreshUnknown(ctx context.Context) bool {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	j.mu.RLock()
+	recent := time.Since(j.loadedAt) < minUnknownKIDRefresh
+	j.mu.RUnlock()
+	if recent {
+		return false
+	}
+
+	if err := j.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh JWKS for unknown key: %v", err)
+		// Следующая попытка - не раньше чем через minUnknownKIDRefresh
+		j.mu.Lock()
+		j.loadedAt = time.Now()
+		j.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS разбирает ключи подписи RSA, EC (P-256, P-384, P-521) и
+// Ed25519. Ключи шифрования и ключи неизвестных типов пропускаются.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%q): %w", i, k.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		if _, ok := keys[k.Kid]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey возвращает nil без ошибки для неподдерживаемых типов ключей
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid e")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"audit-service/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken - подпись, издатель, аудитория или срок токена не
+// прошли проверку
+var ErrInvalidToken = errors.New("invalid token")
+
+// Допустимое расхождение часов с издателем
+const tokenLeeway = 30 * time.Second
+
+// Алгоритмы подписи, которые принимаются. HS* нет: общий секрет с
+// издателем JWKS не описывает.
+var tokenMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// TokenConfig - что проверяется в токене и как его claims становятся
+// правами
+type TokenConfig struct {
+	Issuer   string
+	Audience string
+	// ScopeClaim - claim с правами: строка через пробел (scope) или
+	// массив строк (scp, roles)
+	ScopeClaim string
+	// ScopeMap переводит значения ScopeClaim в права сервиса. Пустая -
+	// значения и есть права (events:read, events:write, admin).
+	// Значения, которых нет в карте, игнорируются.
+	ScopeMap map[string]string
+	// StrictUser - клиент с токеном пишет события только с user = sub
+	StrictUser bool
+	// TenantClaim - строковый claim с арендатором клиента. Токен без него
+	// к арендатору не привязан.
+	TenantClaim string
+}
+
+// TokenVerifier проверяет JWT по ключам JWKS
+type TokenVerifier struct {
+	keys   *JWKS
+	cfg    TokenConfig
+	parser *jwt.Parser
+}
+
+func NewTokenVerifier(keys *JWKS, cfg TokenConfig) *TokenVerifier {
+	return &TokenVerifier{
+		keys: keys,
+		cfg:  cfg,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(tokenMethods),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(tokenLeeway),
+		),
+	}
+}
+
+// Verify проверяет токен и возвращает клиента с правами из его claims
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, fmt.Errorf("%w: claim sub is required", ErrInvalidToken)
+	}
+
+	p := &Principal{Actor: "jwt:" + sub, Subject: sub, Scopes: v.scopes(claims)}
+	if v.cfg.StrictUser {
+		p.User = sub
+	}
+	if v.cfg.TenantClaim != "" && claims[v.cfg.TenantClaim] != nil {
+		id, ok := claims[v.cfg.TenantClaim].(string)
+		if !ok || !tenant.Valid(id) {
+			return nil, fmt.Errorf("%w: claim %s is not a valid tenant", ErrInvalidToken, v.cfg.TenantClaim)
+		}
+		p.Tenant = id
+	}
+	return p, nil
+}
+
+// scopes переводит значения claim с правами в права сервиса
+func (v *TokenVerifier) scopes(claims jwt.MapClaims) []string {
+	var values []string
+	switch raw := claims[v.cfg.ScopeClaim].(type) {
+	case string:
+		values = strings.Fields(raw)
+	case []interface{}:
+		for _, item := range raw {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		scope := value
+		if v.cfg.ScopeMap != nil {
+			scope = v.cfg.ScopeMap[value]
+		}
+		if ValidScope(scope) && !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,106 @@
+// Package chain считает звенья цепочки хешей над событиями аудита. Хеш звена
+// - SHA-256 от хеша предыдущего звена и канонического представления
+// события, поэтому изменение любого сохранённого события или удаление звена
+// видно при проверке цепочки.
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/tenant"
+)
+
+// Формат времени в каноническом представлении: время без пояса с точностью
+// до микросекунд, как его хранит колонка TIMESTAMP
+const timeLayout = "2006-01-02T15:04:05.000000"
+
+// content - каноническое представление события. Порядок полей фиксирован,
+// ключи JSONB json.Marshal сортирует сам.
+type content struct {
+	Seq            int64        `json:"seq"`
+	ID             int64        `json:"id"`
+	EventID        string       `json:"event_id"`
+	IdempotencyKey string       `json:"idempotency_key"`
+	Timestamp      string       `json:"timestamp"`
+	User           string       `json:"user"`
+	Component      *string      `json:"component"`
+	Operation      string       `json:"op"`
+	SessionID      *int64       `json:"session_id"`
+	RequestID      *int64       `json:"req_id"`
+	Response       *model.JSONB `json:"res"`
+	Attributes     *model.JSONB `json:"attributes"`
+	CreatedAt      string       `json:"created_at"`
+	// Пустой не попадает в представление, поэтому хеши событий, записанных
+	// до появления поля, не меняются
+	Actor string `json:"actor,omitempty"`
+	// Арендатор по умолчанию в представление не попадает по той же причине:
+	// им помечены все события, записанные до появления арендаторов
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// Canonical возвращает байты, от которых считается хеш события
+func Canonical(event *model.AuditEvent) ([]byte, error) {
+	return json.Marshal(content{
+		Seq:            event.ChainSeq,
+		ID:             event.ID,
+		EventID:        event.EventID,
+		IdempotencyKey: event.IdempotencyKey,
+		Timestamp:      event.Timestamp.Format(timeLayout),
+		User:           event.User,
+		Component:      event.Component,
+		Operation:      event.Operation,
+		SessionID:      event.SessionID,
+		RequestID:      event.RequestID,
+		Response:       event.Response,
+		Attributes:     event.Attributes,
+		CreatedAt:      event.CreatedAt.Format(timeLayout),
+		Actor:          event.Actor,
+		TenantID:       canonicalTenant(event.TenantID),
+	})
+}
+
+func canonicalTenant(id string) string {
+	if id == tenant.Default {
+		return ""
+	}
+	return id
+}
+
+// Hash считает хеш звена события по хешу предыдущего звена (hex, пустой у
+// первого звена)
+func Hash(prevHash string, event *model.AuditEvent) (string, error) {
+	prev, err := hex.DecodeString(prevHash)
+	if err != nil {
+		return "", fmt.Errorf("invalid previous hash: %w", err)
+	}
+	canonical, err := Canonical(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode event for hashing: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Link делает событие звеном seq после звена с хешем prevHash. Время события
+// округляется до микросекунд, чтобы хеш совпал с тем, что прочитается из БД.
+func Link(event *model.AuditEvent, seq int64, prevHash string) error {
+	event.Timestamp = event.Timestamp.Round(time.Microsecond)
+	event.CreatedAt = event.CreatedAt.Round(time.Microsecond)
+	event.ChainSeq = seq
+	event.PrevHash = prevHash
+
+	hash, err := Hash(prevHash, event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+	return nil
+}
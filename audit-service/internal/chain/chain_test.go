@@ -0,0 +1,160 @@
+package chain
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/tenant"
+)
+
+func chainEvent() *model.AuditEvent {
+	component := "web"
+	session := int64(42)
+	return &model.AuditEvent{
+		ID:             7,
+		EventID:        "0f8fad5b-d9cb-469f-a165-70867728950e",
+		IdempotencyKey: "key-1",
+		Timestamp:      time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("MSK", 3*60*60)),
+		User:           "alice",
+		Component:      &component,
+		Operation:      "login",
+		SessionID:      &session,
+		Response:       &model.JSONB{"status": 200, "ok": true},
+		Attributes:     &model.JSONB{"ip": "10.0.0.1", "http": map[string]interface{}{"method": "GET", "code": 1.5}},
+		CreatedAt:      time.Date(2024, 3, 1, 9, 30, 46, 999999600, time.UTC),
+		TenantID:       tenant.Default,
+	}
+}
+
+// readBack возвращает событие таким, каким его прочитает Postgres: время -
+// показания часов без пояса с точностью до микросекунд, JSONB разобран
+// заново
+func readBack(t *testing.T, event *model.AuditEvent) *model.AuditEvent {
+	t.Helper()
+
+	stored := *event
+	wall := func(ts time.Time) time.Time {
+		return time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), time.UTC).
+			Truncate(time.Microsecond)
+	}
+	stored.Timestamp = wall(event.Timestamp)
+	stored.CreatedAt = wall(event.CreatedAt)
+	for _, doc := range []**model.JSONB{&stored.Response, &stored.Attributes} {
+		raw, err := json.Marshal(*doc)
+		if err != nil {
+			t.Fatalf("failed to encode JSONB: %v", err)
+		}
+		var parsed model.JSONB
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			t.Fatalf("failed to decode JSONB: %v", err)
+		}
+		*doc = &parsed
+	}
+	return &stored
+}
+
+func TestCanonical(t *testing.T) {
+	event := chainEvent()
+	if err := Link(event, 3, ""); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	canonical, err := Canonical(event)
+	if err != nil {
+		t.Fatalf("Canonical failed: %v", err)
+	}
+	// Изменение представления меняет хеши всех сохранённых событий
+	want := `{"seq":3,"id":7,"event_id":"0f8fad5b-d9cb-469f-a165-70867728950e","idempotency_key":"key-1",` +
+		`"timestamp":"2024-03-01T12:30:45.123457","user":"alice","component":"web","op":"login",` +
+		`"session_id":42,"req_id":null,"res":{"ok":true,"status":200},` +
+		`"attributes":{"http":{"code":1.5,"method":"GET"},"ip":"10.0.0.1"},"created_at":"2024-03-01T09:30:47.000000"}`
+	if string(canonical) != want {
+		t.Fatalf("canonical form changed:\n got %s\nwant %s", canonical, want)
+	}
+}
+
+func TestHashSurvivesReadBack(t *testing.T) {
+	first := chainEvent()
+	if err := Link(first, 1, ""); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	second := chainEvent()
+	second.ID, second.TenantID, second.Actor = 8, "acme", "svc-billing"
+	second.Timestamp = time.Date(2024, 3, 1, 23, 59, 59, 999999999, time.FixedZone("", -5*60*60))
+	if err := Link(second, 2, first.Hash); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	// Время с ненулевым смещением хешируется показаниями часов, как его
+	// сохраняет колонка TIMESTAMP, поэтому хеш прочитанного события тот же
+	for _, event := range []*model.AuditEvent{first, second} {
+		stored := readBack(t, event)
+		hash, err := Hash(stored.PrevHash, stored)
+		if err != nil {
+			t.Fatalf("Hash failed: %v", err)
+		}
+		if hash != event.Hash {
+			t.Errorf("event %d: hash after read back %s, linked %s", event.ID, hash, event.Hash)
+		}
+	}
+	if second.PrevHash != first.Hash || second.ChainSeq != 2 {
+		t.Errorf("second link has seq %d, prev %s", second.ChainSeq, second.PrevHash)
+	}
+}
+
+func TestHashDetectsChanges(t *testing.T) {
+	event := chainEvent()
+	if err := Link(event, 1, ""); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	changes := map[string]func(e *model.AuditEvent){
+		"user":       func(e *model.AuditEvent) { e.User = "mallory" },
+		"timestamp":  func(e *model.AuditEvent) { e.Timestamp = e.Timestamp.Add(time.Microsecond) },
+		"attributes": func(e *model.AuditEvent) { e.Attributes = &model.JSONB{"ip": "10.0.0.2"} },
+		"seq":        func(e *model.AuditEvent) { e.ChainSeq = 2 },
+		"tenant":     func(e *model.AuditEvent) { e.TenantID = "acme" },
+	}
+	for name, change := range changes {
+		changed := *event
+		change(&changed)
+		hash, err := Hash(event.PrevHash, &changed)
+		if err != nil {
+			t.Fatalf("Hash failed: %v", err)
+		}
+		if hash == event.Hash {
+			t.Errorf("changed %s does not change the hash", name)
+		}
+	}
+
+	if hash, err := Hash(strings.Repeat("00", 32), event); err != nil || hash == event.Hash {
+		t.Errorf("previous hash does not change the hash: %s, %v", hash, err)
+	}
+	if _, err := Hash("not hex", event); err == nil {
+		t.Error("expected error for invalid previous hash")
+	}
+}
+
+func TestCanonicalTenant(t *testing.T) {
+	canonical := func(tenantID string) string {
+		event := chainEvent()
+		event.TenantID = tenantID
+		data, err := Canonical(event)
+		if err != nil {
+			t.Fatalf("Canonical failed: %v", err)
+		}
+		return string(data)
+	}
+
+	// Арендатор по умолчанию не попадает в представление: хеши событий,
+	// записанных до появления арендаторов, не меняются
+	if got := canonical(tenant.Default); got != canonical("") || strings.Contains(got, "tenant_id") {
+		t.Errorf("default tenant is part of the canonical form: %s", got)
+	}
+	if got := canonical("acme"); !strings.HasSuffix(got, `,"tenant_id":"acme"}`) {
+		t.Errorf("tenant missing from the canonical form: %s", got)
+	}
+}
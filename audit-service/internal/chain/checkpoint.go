@@ -0,0 +1,41 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CheckpointMessage - подписываемое представление контрольной точки: окно
+// звеньев [firstSeq, lastSeq], корень дерева Меркла над их хешами и хеш
+// последнего звена окна (hex)
+func CheckpointMessage(firstSeq, lastSeq int64, root, lastHash string) []byte {
+	return []byte(fmt.Sprintf("audit-checkpoint/v1\n%d\n%d\n%s\n%s\n", firstSeq, lastSeq, root, lastHash))
+}
+
+// LoadPrivateKey читает ключ Ed25519 из PEM-файла PKCS#8, например
+// созданного openssl genpkey -algorithm ed25519
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an Ed25519 key")
+	}
+
+	return private, nil
+}
@@ -0,0 +1,96 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Дерево Меркла по RFC 6962: листья и внутренние узлы хешируются с разными
+// префиксами, чтобы лист нельзя было выдать за узел
+
+func leafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split - наибольшая степень двойки меньше n
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// MerkleRoot возвращает корень дерева над листьями
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leafHash(leaves[0])
+	}
+	k := split(len(leaves))
+	return nodeHash(MerkleRoot(leaves[:k]), MerkleRoot(leaves[k:]))
+}
+
+// InclusionProof возвращает путь аудита для листа index: хеши соседних
+// поддеревьев от листа к корню
+func InclusionProof(leaves [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", index, len(leaves))
+	}
+	return inclusionPath(leaves, index), nil
+}
+
+func inclusionPath(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if index < k {
+		return append(inclusionPath(leaves[:k], index), MerkleRoot(leaves[k:]))
+	}
+	return append(inclusionPath(leaves[k:], index-k), MerkleRoot(leaves[:k]))
+}
+
+// VerifyInclusion проверяет, что лист leaf с номером index входит в дерево
+// из size листьев с корнем root (RFC 9162, 2.1.3.2)
+func VerifyInclusion(leaf []byte, index, size int64, proof [][]byte, root []byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+
+	fn, sn := index, size-1
+	r := leafHash(leaf)
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && bytes.Equal(r, root)
+}
@@ -0,0 +1,126 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func merkleLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	return leaves
+}
+
+func TestMerkleRoot(t *testing.T) {
+	// Пустое дерево - SHA-256 от пустой строки (RFC 6962, 2.1)
+	if got := hex.EncodeToString(MerkleRoot(nil)); got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("empty root = %s", got)
+	}
+
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	sum := func(parts ...[]byte) []byte {
+		h := sha256.New()
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return h.Sum(nil)
+	}
+	leaf := func(data []byte) []byte { return sum([]byte{0x00}, data) }
+	node := func(l, r []byte) []byte { return sum([]byte{0x01}, l, r) }
+
+	tests := []struct {
+		leaves [][]byte
+		want   []byte
+	}{
+		{[][]byte{a}, leaf(a)},
+		{[][]byte{a, b}, node(leaf(a), leaf(b))},
+		// Левое поддерево - наибольшая степень двойки, меньшая размера
+		{[][]byte{a, b, c}, node(node(leaf(a), leaf(b)), leaf(c))},
+	}
+	for _, tt := range tests {
+		if got := MerkleRoot(tt.leaves); !bytes.Equal(got, tt.want) {
+			t.Errorf("root of %q = %x, want %x", tt.leaves, got, tt.want)
+		}
+	}
+}
+
+func TestInclusionProofRoundTrip(t *testing.T) {
+	for size := 1; size <= 33; size++ {
+		leaves := merkleLeaves(size)
+		root := MerkleRoot(leaves)
+		for index := range leaves {
+			proof, err := InclusionProof(leaves, index)
+			if err != nil {
+				t.Fatalf("size %d, index %d: %v", size, index, err)
+			}
+			if !VerifyInclusion(leaves[index], int64(index), int64(size), proof, root) {
+				t.Fatalf("size %d, index %d: valid proof rejected", size, index)
+			}
+		}
+	}
+}
+
+func TestVerifyInclusionRejectsTampering(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		leaves := merkleLeaves(size)
+		root := MerkleRoot(leaves)
+		for index := range leaves {
+			proof, _ := InclusionProof(leaves, index)
+			i, n := int64(index), int64(size)
+			name := fmt.Sprintf("size %d, index %d", size, index)
+
+			for j := range proof {
+				tampered := clonePath(proof)
+				tampered[j][0] ^= 0x01
+				if VerifyInclusion(leaves[index], i, n, tampered, root) {
+					t.Fatalf("%s: proof with tampered element %d accepted", name, j)
+				}
+			}
+			for other := int64(0); other < n; other++ {
+				if other != i && VerifyInclusion(leaves[index], other, n, proof, root) {
+					t.Fatalf("%s: proof accepted for index %d", name, other)
+				}
+			}
+			if VerifyInclusion([]byte("forged"), i, n, proof, root) {
+				t.Fatalf("%s: proof accepted for another leaf", name)
+			}
+			if len(proof) > 0 && VerifyInclusion(leaves[index], i, n, proof[:len(proof)-1], root) {
+				t.Fatalf("%s: truncated proof accepted", name)
+			}
+			if VerifyInclusion(leaves[index], i, n, append(clonePath(proof), root), root) {
+				t.Fatalf("%s: extended proof accepted", name)
+			}
+
+			wrongRoot := append([]byte(nil), root...)
+			wrongRoot[len(wrongRoot)-1] ^= 0x01
+			if VerifyInclusion(leaves[index], i, n, proof, wrongRoot) {
+				t.Fatalf("%s: proof accepted for another root", name)
+			}
+		}
+	}
+}
+
+func TestInclusionProofOutOfRange(t *testing.T) {
+	leaves := merkleLeaves(4)
+	for _, index := range []int{-1, 4} {
+		if _, err := InclusionProof(leaves, index); err == nil {
+			t.Errorf("index %d: expected error", index)
+		}
+	}
+	if VerifyInclusion(leaves[0], 4, 4, nil, MerkleRoot(leaves)) || VerifyInclusion(leaves[0], -1, 4, nil, MerkleRoot(leaves)) {
+		t.Error("proof accepted for index out of range")
+	}
+}
+
+func clonePath(proof [][]byte) [][]byte {
+	cloned := make([][]byte, len(proof))
+	for i, p := range proof {
+		cloned[i] = append([]byte(nil), p...)
+	}
+	return cloned
+}
@@ -0,0 +1,436 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"audit-service/internal/model"
+)
+
+// Ключи объекта, которым в JSONB заменяется зашифрованное значение:
+// {"$enc": "<base64 nonce||шифротекст>", "$bi": "<hex слепого индекса>"}
+const (
+	CipherKey = "$enc"
+	IndexKey  = "$bi"
+)
+
+// Сколько байт HMAC оставляется в слепом индексе
+const blindIndexSize = 16
+
+// Колонки, поля которых можно шифровать: имя в ENCRYPTION_PATHS -> колонка
+// audit_events
+var fieldColumns = map[string]string{
+	"attributes": "attributes",
+	"res":        "response",
+	"response":   "response",
+}
+
+// Field - шифруемое значение: путь внутри JSONB-колонки, пустой путь -
+// колонка целиком
+type Field struct {
+	Column string
+	Path   []string
+}
+
+func (f Field) String() string {
+	return strings.Join(append([]string{f.Column}, f.Path...), ".")
+}
+
+// aad привязывает шифротекст и слепой индекс к колонке и пути: значение,
+// перенесённое в другое поле, не расшифруется и не совпадёт по индексу
+func (f Field) aad() []byte {
+	return []byte(strings.Join(append([]string{f.Column}, f.Path...), "\x00"))
+}
+
+// hasPrefix - путь f совпадает с prefix или лежит внутри него
+func (f Field) hasPrefix(column string, prefix []string) bool {
+	if f.Column != column || len(f.Path) < len(prefix) {
+		return false
+	}
+	for i, key := range prefix {
+		if f.Path[i] != key {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseFields разбирает список шифруемых полей через запятую, например
+// "attributes.user.email,res". Поля не должны вкладываться друг в друга.
+func ParseFields(spec string) ([]Field, error) {
+	var fields []Field
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ".")
+		column, ok := fieldColumns[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("cannot encrypt '%s': only attributes and res can be encrypted", item)
+		}
+		for _, key := range parts[1:] {
+			if key == "" {
+				return nil, fmt.Errorf("invalid encrypted path '%s'", item)
+			}
+		}
+		field := Field{Column: column, Path: parts[1:]}
+
+		for _, other := range fields {
+			if field.hasPrefix(other.Column, other.Path) || other.hasPrefix(field.Column, field.Path) {
+				return nil, fmt.Errorf("encrypted paths '%s' and '%s' overlap", other, field)
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// Encryptor шифрует настроенные поля событий конвертом: у каждого события
+// свой случайный ключ данных (DEK, AES-256-GCM), который хранится в строке
+// зашифрованным ключом связки (KEK). Рядом с шифротекстом лежит слепой
+// индекс значения для поиска по равенству. Nil-Encryptor ничего не шифрует.
+type Encryptor struct {
+	keyring *Keyring
+	fields  []Field
+}
+
+func New(keyring *Keyring, fields []Field) *Encryptor {
+	return &Encryptor{keyring: keyring, fields: fields}
+}
+
+// ActiveKeyID - id ключа, которым шифруются новые события
+func (e *Encryptor) ActiveKeyID() string {
+	if e == nil {
+		return ""
+	}
+	return e.keyring.ActiveID()
+}
+
+// KeyIDs - id всех ключей связки
+func (e *Encryptor) KeyIDs() []string {
+	if e == nil {
+		return nil
+	}
+	return e.keyring.IDs()
+}
+
+// Seal возвращает копию события, в которой настроенные поля заменены
+// зашифрованными значениями. Исходное событие не меняется, событие без
+// настроенных полей возвращается как есть. Хеш звена цепочки считается до
+// Seal, по открытым значениям.
+func (e *Encryptor) Seal(event *model.AuditEvent) (*model.AuditEvent, error) {
+	if e == nil || len(e.fields) == 0 {
+		return event, nil
+	}
+
+	sealed := *event
+	var dek []byte
+	for _, field := range e.fields {
+		ref := columnRef(&sealed, field.Column)
+		if *ref == nil {
+			continue
+		}
+		value, ok := lookup(map[string]interface{}(**ref), field.Path)
+		if !ok {
+			continue
+		}
+
+		if dek == nil {
+			dek = make([]byte, keySize)
+			if _, err := rand.Read(dek); err != nil {
+				return nil, fmt.Errorf("failed to generate data key: %w", err)
+			}
+		}
+		marker, err := e.sealValue(dek, field, value)
+		if err != nil {
+			return nil, err
+		}
+
+		doc := model.JSONB(marker)
+		if len(field.Path) > 0 {
+			doc = replace(**ref, field.Path, marker)
+		}
+		*ref = &doc
+	}
+	if dek == nil {
+		return event, nil
+	}
+
+	active := e.keyring.ActiveID()
+	wrapped, err := sealBytes(e.keyring.keys[active], dek, []byte(active))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	sealed.EncKeyID = active
+	sealed.EncDEK = hex.EncodeToString(wrapped)
+
+	return &sealed, nil
+}
+
+func (e *Encryptor) sealValue(dek []byte, field Field, value interface{}) (map[string]interface{}, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", field, err)
+	}
+	ciphertext, err := sealBytes(dek, plaintext, field.aad())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", field, err)
+	}
+	index, err := e.BlindIndex(field, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		CipherKey: base64.StdEncoding.EncodeToString(ciphertext),
+		IndexKey:  index,
+	}, nil
+}
+
+// Open расшифровывает поля события на месте и убирает с него ключ строки.
+// Расшифровываются все зашифрованные значения, а не только настроенные
+// сейчас: список полей мог поменяться после записи события.
+func (e *Encryptor) Open(event *model.AuditEvent) error {
+	if event.EncKeyID == "" {
+		return nil
+	}
+	if e == nil {
+		return fmt.Errorf("event %d is encrypted with key %q, but no keyring is configured", event.ID, event.EncKeyID)
+	}
+
+	dek, err := e.unwrap(event.EncKeyID, event.EncDEK)
+	if err != nil {
+		return fmt.Errorf("failed to open event %d: %w", event.ID, err)
+	}
+
+	for _, column := range []string{"attributes", "response"} {
+		ref := columnRef(event, column)
+		if *ref == nil {
+			continue
+		}
+		value, err := openValue(dek, Field{Column: column}, map[string]interface{}(**ref))
+		if err != nil {
+			return fmt.Errorf("failed to open event %d: %w", event.ID, err)
+		}
+		doc, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("failed to open event %d: decrypted %s is not an object", event.ID, column)
+		}
+		opened := model.JSONB(doc)
+		*ref = &opened
+	}
+	event.EncKeyID, event.EncDEK = "", ""
+
+	return nil
+}
+
+func openValue(dek []byte, field Field, value interface{}) (interface{}, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return value, nil
+	}
+
+	if encoded, ok := marker(obj); ok {
+		ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", field, err)
+		}
+		plaintext, err := openBytes(dek, ciphertext, field.aad())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", field, err)
+		}
+		var opened interface{}
+		if err := json.Unmarshal(plaintext, &opened); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", field, err)
+		}
+		return opened, nil
+	}
+
+	for key, v := range obj {
+		path := append(field.Path[:len(field.Path):len(field.Path)], key)
+		opened, err := openValue(dek, Field{Column: field.Column, Path: path}, v)
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = opened
+	}
+	return obj, nil
+}
+
+// marker возвращает шифротекст, если obj - зашифрованное значение
+func marker(obj map[string]interface{}) (string, bool) {
+	if len(obj) != 2 {
+		return "", false
+	}
+	ciphertext, ok := obj[CipherKey].(string)
+	if _, indexed := obj[IndexKey].(string); !ok || !indexed {
+		return "", false
+	}
+	return ciphertext, true
+}
+
+// Rewrap перешифровывает ключ строки, зашифрованный ключом keyID, активным
+// ключом связки. Возвращает id активного ключа и новый ключ строки (hex).
+// Сами поля при этом не перешифровываются.
+func (e *Encryptor) Rewrap(keyID, wrapped string) (string, string, error) {
+	dek, err := e.unwrap(keyID, wrapped)
+	if err != nil {
+		return "", "", err
+	}
+
+	active := e.keyring.ActiveID()
+	rewrapped, err := sealBytes(e.keyring.keys[active], dek, []byte(active))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return active, hex.EncodeToString(rewrapped), nil
+}
+
+func (e *Encryptor) unwrap(keyID, wrapped string) ([]byte, error) {
+	kek, ok := e.keyring.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key %q is not in the keyring", keyID)
+	}
+	data, err := hex.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data key: %w", err)
+	}
+	dek, err := openBytes(kek, data, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %q: %w", keyID, err)
+	}
+	return dek, nil
+}
+
+// Field возвращает шифруемое поле, которое совпадает с путём column.path
+// или содержит его
+func (e *Encryptor) Field(column string, path []string) (Field, bool) {
+	if e == nil {
+		return Field{}, false
+	}
+	for _, field := range e.fields {
+		if (Field{Column: column, Path: path}).hasPrefix(field.Column, field.Path) {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// FieldsWithin возвращает шифруемые поля, лежащие строго внутри column.path
+func (e *Encryptor) FieldsWithin(column string, path []string) []Field {
+	if e == nil {
+		return nil
+	}
+	var within []Field
+	for _, field := range e.fields {
+		if len(field.Path) > len(path) && field.hasPrefix(column, path) {
+			within = append(within, field)
+		}
+	}
+	return within
+}
+
+// BlindIndex - HMAC значения поля ключом индекса: равные значения дают
+// равный индекс, и по нему события ищутся без расшифровки. Числа
+// приводятся к float64, как после чтения из JSONB, поэтому 200 из запроса
+// совпадает с 200 из события.
+func (e *Encryptor) BlindIndex(field Field, value interface{}) (string, error) {
+	if n, ok := value.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return "", fmt.Errorf("invalid number %s: %w", n, err)
+		}
+		value = f
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", field, err)
+	}
+
+	mac := hmac.New(sha256.New, e.keyring.indexKey)
+	mac.Write(field.aad())
+	mac.Write([]byte{0})
+	mac.Write(canonical)
+	return hex.EncodeToString(mac.Sum(nil)[:blindIndexSize]), nil
+}
+
+// sealBytes шифрует AES-256-GCM, результат - nonce||шифротекст
+func sealBytes(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openBytes(key, data, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func columnRef(event *model.AuditEvent, column string) **model.JSONB {
+	if column == "response" {
+		return &event.Response
+	}
+	return &event.Attributes
+}
+
+// lookup возвращает значение по пути во вложенных объектах
+func lookup(doc map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, key := range path {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// replace возвращает копию doc, в которой значение по пути заменено value.
+// Копируются только объекты на пути, остальное разделяется с doc.
+func replace(doc map[string]interface{}, path []string, value interface{}) model.JSONB {
+	out := make(model.JSONB, len(doc))
+	for k, v := range doc {
+		out[k] = v
+	}
+	if len(path) == 1 {
+		out[path[0]] = value
+	} else {
+		out[path[0]] = map[string]interface{}(replace(doc[path[0]].(map[string]interface{}), path[1:], value))
+	}
+	return out
+}
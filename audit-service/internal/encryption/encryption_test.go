@@ -0,0 +1,322 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"audit-service/internal/model"
+)
+
+// testKeyring записывает связку из ключей ids и загружает её, как при
+// запуске сервиса
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+
+	// У каждого id свой ключ
+	key := func(id string) string {
+		sum := sha256.Sum256([]byte(id))
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	file := keyringFile{Active: active, IndexKey: key("index"), Keys: map[string]string{}}
+	for _, id := range ids {
+		file.Keys[id] = key(id)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("failed to load keyring: %v", err)
+	}
+	return keyring
+}
+
+func testEncryptor(t *testing.T, keyring *Keyring, spec string) *Encryptor {
+	t.Helper()
+
+	fields, err := ParseFields(spec)
+	if err != nil {
+		t.Fatalf("ParseFields(%q) failed: %v", spec, err)
+	}
+	return New(keyring, fields)
+}
+
+func sensitiveEvent() *model.AuditEvent {
+	return &model.AuditEvent{
+		ID:        1,
+		User:      "alice",
+		Operation: "login",
+		Attributes: &model.JSONB{
+			"ip":   "10.0.0.1",
+			"user": map[string]interface{}{"email": "alice@example.com", "name": "Alice"},
+		},
+		Response: &model.JSONB{"status": 200, "token": map[string]interface{}{"value": "secret"}},
+	}
+}
+
+// stored возвращает событие таким, каким его прочитает БД: JSONB разобран
+// заново
+func stored(t *testing.T, event *model.AuditEvent) *model.AuditEvent {
+	t.Helper()
+
+	copied := *event
+	for _, doc := range []**model.JSONB{&copied.Attributes, &copied.Response} {
+		if *doc == nil {
+			continue
+		}
+		raw, err := json.Marshal(*doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed model.JSONB
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		*doc = &parsed
+	}
+	return &copied
+}
+
+func assertSameJSON(t *testing.T, got, want interface{}) {
+	t.Helper()
+
+	g, _ := json.Marshal(got)
+	w, _ := json.Marshal(want)
+	if !bytes.Equal(g, w) {
+		t.Fatalf("got %s, want %s", g, w)
+	}
+}
+
+func TestSealOpenPaths(t *testing.T) {
+	enc := testEncryptor(t, testKeyring(t, "k1", "k1"), "attributes.user.email,res.token")
+	event := sensitiveEvent()
+
+	sealed, err := enc.Seal(event)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	// Исходное событие не меняется
+	assertSameJSON(t, event, sensitiveEvent())
+
+	if sealed.EncKeyID != "k1" || sealed.EncDEK == "" {
+		t.Fatalf("sealed event has key %q, data key %q", sealed.EncKeyID, sealed.EncDEK)
+	}
+	user := (*sealed.Attributes)["user"].(map[string]interface{})
+	if _, ok := marker(user["email"].(map[string]interface{})); !ok {
+		t.Errorf("attributes.user.email is not encrypted: %v", user["email"])
+	}
+	if user["name"] != "Alice" || (*sealed.Attributes)["ip"] != "10.0.0.1" || (*sealed.Response)["status"] != 200 {
+		t.Errorf("fields outside the encrypted paths changed: %v, %v", *sealed.Attributes, *sealed.Response)
+	}
+	if raw, _ := json.Marshal(sealed); bytes.Contains(raw, []byte("alice@example.com")) || bytes.Contains(raw, []byte("secret")) {
+		t.Errorf("sealed event leaks plaintext: %s", raw)
+	}
+
+	opened := stored(t, sealed)
+	if err := enc.Open(opened); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if opened.EncKeyID != "" || opened.EncDEK != "" {
+		t.Errorf("opened event keeps key %q", opened.EncKeyID)
+	}
+	assertSameJSON(t, opened, sensitiveEvent())
+}
+
+func TestSealOpenColumn(t *testing.T) {
+	enc := testEncryptor(t, testKeyring(t, "k1", "k1"), "res")
+	event := sensitiveEvent()
+
+	sealed, err := enc.Seal(event)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if _, ok := marker(*sealed.Response); !ok {
+		t.Fatalf("res is not encrypted as a whole: %v", *sealed.Response)
+	}
+	if sealed.Attributes != event.Attributes {
+		t.Error("attributes changed although only res is encrypted")
+	}
+
+	opened := stored(t, sealed)
+	if err := enc.Open(opened); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	assertSameJSON(t, opened, sensitiveEvent())
+}
+
+func TestSealWithoutEncryptedFields(t *testing.T) {
+	enc := testEncryptor(t, testKeyring(t, "k1", "k1"), "attributes.card")
+	event := sensitiveEvent()
+
+	sealed, err := enc.Seal(event)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if sealed != event || sealed.EncKeyID != "" {
+		t.Fatalf("event without encrypted fields was sealed: %+v", sealed)
+	}
+	if err := enc.Open(sealed); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	var none *Encryptor
+	if sealed, err := none.Seal(event); err != nil || sealed != event {
+		t.Fatalf("nil encryptor sealed the event: %v", err)
+	}
+}
+
+// Шифротекст привязан к колонке и пути: перенесённый в другое поле, он не
+// расшифровывается
+func TestOpenRejectsMovedCiphertext(t *testing.T) {
+	enc := testEncryptor(t, testKeyring(t, "k1", "k1"), "attributes.user.email")
+
+	moves := map[string]func(e *model.AuditEvent, value interface{}){
+		"another key": func(e *model.AuditEvent, value interface{}) {
+			(*e.Attributes)["user"].(map[string]interface{})["phone"] = value
+		},
+		"another level": func(e *model.AuditEvent, value interface{}) {
+			(*e.Attributes)["email"] = value
+		},
+		"another column": func(e *model.AuditEvent, value interface{}) {
+			(*e.Response)["user"] = map[string]interface{}{"email": value}
+		},
+	}
+	for name, move := range moves {
+		t.Run(name, func(t *testing.T) {
+			sealed, err := enc.Seal(sensitiveEvent())
+			if err != nil {
+				t.Fatalf("Seal failed: %v", err)
+			}
+			event := stored(t, sealed)
+			user := (*event.Attributes)["user"].(map[string]interface{})
+			value := user["email"]
+			delete(user, "email")
+			move(event, value)
+
+			if err := enc.Open(event); err == nil {
+				t.Fatal("moved ciphertext was decrypted")
+			}
+		})
+	}
+}
+
+func TestOpenRequiresKey(t *testing.T) {
+	sealed, err := testEncryptor(t, testKeyring(t, "k1", "k1"), "res").Seal(sensitiveEvent())
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	var none *Encryptor
+	if err := none.Open(stored(t, sealed)); err == nil {
+		t.Error("encrypted event opened without a keyring")
+	}
+	other := testEncryptor(t, testKeyring(t, "k2", "k2"), "res")
+	if err := other.Open(stored(t, sealed)); err == nil {
+		t.Error("encrypted event opened without its key")
+	}
+
+	// Ключ строки не открывается чужим ключом связки
+	event := stored(t, sealed)
+	event.EncKeyID = "k2"
+	if err := other.Open(event); err == nil {
+		t.Error("encrypted event opened with a different key")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old := testEncryptor(t, testKeyring(t, "k1", "k1"), "attributes.user.email")
+	sealed, err := old.Seal(sensitiveEvent())
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// Новый активный ключ, старый ещё в связке
+	rotated := testEncryptor(t, testKeyring(t, "k2", "k1", "k2"), "attributes.user.email")
+	keyID, wrapped, err := rotated.Rewrap(sealed.EncKeyID, sealed.EncDEK)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if keyID != "k2" || wrapped == sealed.EncDEK {
+		t.Fatalf("Rewrap returned key %q, data key unchanged: %t", keyID, wrapped == sealed.EncDEK)
+	}
+
+	// После перешифровки старый ключ не нужен, поля не менялись
+	event := stored(t, sealed)
+	event.EncKeyID, event.EncDEK = keyID, wrapped
+	withoutOld := testEncryptor(t, testKeyring(t, "k2", "k2"), "attributes.user.email")
+	if err := withoutOld.Open(event); err != nil {
+		t.Fatalf("Open after rewrap failed: %v", err)
+	}
+	assertSameJSON(t, event, sensitiveEvent())
+
+	if _, _, err := withoutOld.Rewrap("k1", sealed.EncDEK); err == nil {
+		t.Error("Rewrap succeeded without the old key")
+	}
+	if _, _, err := rotated.Rewrap("k2", sealed.EncDEK); err == nil {
+		t.Error("Rewrap succeeded with the wrong key id")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	enc := testEncryptor(t, testKeyring(t, "k1", "k1"), "attributes.user.email,res.status")
+	email := Field{Column: "attributes", Path: []string{"user", "email"}}
+	status := Field{Column: "response", Path: []string{"status"}}
+
+	index := func(e *Encryptor, field Field, value interface{}) string {
+		t.Helper()
+		s, err := e.BlindIndex(field, value)
+		if err != nil {
+			t.Fatalf("BlindIndex failed: %v", err)
+		}
+		return s
+	}
+
+	first := index(enc, email, "alice@example.com")
+	if len(first) != 2*blindIndexSize {
+		t.Errorf("index %s has %d hex digits, want %d", first, len(first), 2*blindIndexSize)
+	}
+	// Индекс зависит только от ключа индекса, поля и значения: ключи
+	// шифрования и их смена на него не влияют
+	rotated := testEncryptor(t, testKeyring(t, "k2", "k1", "k2"), "attributes.user.email")
+	if again := index(rotated, email, "alice@example.com"); again != first {
+		t.Errorf("index changed between calls: %s, %s", first, again)
+	}
+	if other := index(enc, email, "bob@example.com"); other == first {
+		t.Error("different values share an index")
+	}
+	if other := index(enc, Field{Column: "attributes", Path: []string{"email"}}, "alice@example.com"); other == first {
+		t.Error("the same value in different fields shares an index")
+	}
+
+	// Числа из запроса и из разобранного JSONB совпадают
+	want := index(enc, status, float64(200))
+	for _, value := range []interface{}{200, json.Number("200"), json.Number("2e2")} {
+		if got := index(enc, status, value); got != want {
+			t.Errorf("index of %v (%T) = %s, want %s", value, value, got, want)
+		}
+	}
+
+	// Индекс рядом с шифротекстом - тот же, по нему ищет БД
+	sealed, err := enc.Seal(sensitiveEvent())
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	user := (*sealed.Attributes)["user"].(map[string]interface{})
+	if got := user["email"].(map[string]interface{})[IndexKey]; got != first {
+		t.Errorf("sealed index = %v, want %s", got, first)
+	}
+	if got := (*sealed.Response)["status"].(map[string]interface{})[IndexKey]; got != want {
+		t.Errorf("sealed index = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Длина ключей связки: AES-256 и HMAC-SHA256
+const keySize = 32
+
+// Keyring - связка ключей шифрования полей. Ключи с id шифруют ключи строк
+// (KEK), новые строки шифруются активным ключом, старые остаются читаемыми,
+// пока их ключ есть в связке. Ключ слепого индекса один на всё время жизни
+// данных: при его смене прежние значения индекса перестают находиться.
+type Keyring struct {
+	active   string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// Формат файла связки:
+//
+//	{"active": "2024-06", "index_key": "<base64>", "keys": {"2024-01": "<base64>", "2024-06": "<base64>"}}
+type keyringFile struct {
+	Active   string            `json:"active"`
+	IndexKey string            `json:"index_key"`
+	Keys     map[string]string `json:"keys"`
+}
+
+// LoadKeyring читает связку ключей из JSON-файла. Ключи - 32 байта в base64.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	keyring := &Keyring{active: file.Active, keys: make(map[string][]byte, len(file.Keys))}
+	for id, encoded := range file.Keys {
+		if id == "" {
+			return nil, errors.New("keyring contains a key with an empty id")
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keyring.keys[id] = key
+	}
+	if _, ok := keyring.keys[file.Active]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the keyring", file.Active)
+	}
+	if keyring.indexKey, err = decodeKey(file.IndexKey); err != nil {
+		return nil, fmt.Errorf("invalid index_key: %w", err)
+	}
+
+	return keyring, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// ActiveID - id ключа, которым шифруются новые строки
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+// IDs возвращает id всех ключей связки по порядку
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,58 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+
+	"audit-service/internal/model"
+)
+
+type csvWriter struct {
+	w           *csv.Writer
+	columns     *model.ExportColumns
+	wroteHeader bool
+	record      []string
+}
+
+func newCSVWriter(w io.Writer, columns *model.ExportColumns) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), columns: columns}
+}
+
+func (c *csvWriter) writeHeader() error {
+	c.wroteHeader = true
+	header := append(append([]string{}, baseColumns...), jsonColumnNames(c.columns)...)
+	return c.w.Write(header)
+}
+
+func (c *csvWriter) Write(event *model.AuditEvent) error {
+	if !c.wroteHeader {
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	// Отсутствующее значение и пустая строка в CSV неразличимы
+	c.record = c.record[:0]
+	for _, values := range [][]*string{baseValues(event), jsonValues(event, c.columns)} {
+		for _, v := range values {
+			if v == nil {
+				c.record = append(c.record, "")
+			} else {
+				c.record = append(c.record, *v)
+			}
+		}
+	}
+
+	return c.w.Write(c.record)
+}
+
+// Close пишет заголовок даже для пустой выгрузки
+func (c *csvWriter) Close() error {
+	if !c.wroteHeader {
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,149 @@
+// Package export кодирует поток событий аудита в файлы выгрузки: CSV,
+// NDJSON и Parquet. В CSV и Parquet поля attributes и res раскладываются по
+// колонкам attributes.<путь> и res.<путь>.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"audit-service/internal/model"
+)
+
+// Writer пишет события по одному; Close дописывает хвост файла
+// (для Parquet - метаданные) и обязателен
+type Writer interface {
+	Write(event *model.AuditEvent) error
+	Close() error
+}
+
+// ContentType возвращает MIME-тип формата и false для неизвестного формата
+func ContentType(format string) (string, bool) {
+	switch format {
+	case model.ExportCSV:
+		return "text/csv; charset=utf-8", true
+	case model.ExportNDJSON:
+		return "application/x-ndjson", true
+	case model.ExportParquet:
+		return "application/vnd.apache.parquet", true
+	}
+	return "", false
+}
+
+func NewWriter(format string, w io.Writer, columns *model.ExportColumns) (Writer, error) {
+	switch format {
+	case model.ExportCSV:
+		return newCSVWriter(w, columns), nil
+	case model.ExportNDJSON:
+		return newNDJSONWriter(w), nil
+	case model.ExportParquet:
+		return newParquetWriter(w, columns), nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// Колонки события, общие для всех табличных форматов
+var baseColumns = []string{
+	"id", "event_id", "idempotency_key", "timestamp", "user", "component",
+	"op", "session_id", "req_id", "created_at", "actor", "tenant",
+}
+
+// baseValues - значения baseColumns; nil для отсутствующих
+func baseValues(event *model.AuditEvent) []*string {
+	str := func(s string) *string { return &s }
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+	optionalInt := func(n *int64) *string {
+		if n == nil {
+			return nil
+		}
+		return str(strconv.FormatInt(*n, 10))
+	}
+
+	return []*string{
+		str(strconv.FormatInt(event.ID, 10)),
+		optional(event.EventID),
+		optional(event.IdempotencyKey),
+		str(event.Timestamp.Format(time.RFC3339Nano)),
+		str(event.User),
+		event.Component,
+		str(event.Operation),
+		optionalInt(event.SessionID),
+		optionalInt(event.RequestID),
+		str(event.CreatedAt.Format(time.RFC3339Nano)),
+		optional(event.Actor),
+		optional(event.TenantID),
+	}
+}
+
+// jsonColumnNames - имена колонок для путей внутри attributes и res
+func jsonColumnNames(columns *model.ExportColumns) []string {
+	names := make([]string, 0, len(columns.Attributes)+len(columns.Response))
+	for _, path := range columns.Attributes {
+		names = append(names, "attributes."+path)
+	}
+	for _, path := range columns.Response {
+		names = append(names, "res."+path)
+	}
+	return names
+}
+
+// jsonValues раскладывает attributes и res события по колонкам
+// jsonColumnNames. Пути из columns, которых нет в событии, дают nil.
+func jsonValues(event *model.AuditEvent, columns *model.ExportColumns) []*string {
+	values := make([]*string, 0, len(columns.Attributes)+len(columns.Response))
+	for _, part := range []struct {
+		doc   *model.JSONB
+		paths []string
+	}{
+		{event.Attributes, columns.Attributes},
+		{event.Response, columns.Response},
+	} {
+		flat := make(map[string]string)
+		if part.doc != nil {
+			flatten("", map[string]interface{}(*part.doc), flat)
+		}
+		for _, path := range part.paths {
+			if v, ok := flat[path]; ok {
+				values = append(values, &v)
+			} else {
+				values = append(values, nil)
+			}
+		}
+	}
+	return values
+}
+
+// flatten раскрывает вложенные объекты в пути через точку так же, как
+// ExportColumns в репозитории: массивы и скаляры становятся значениями,
+// null пропускается
+func flatten(prefix string, doc map[string]interface{}, out map[string]string) {
+	for key, value := range doc {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		switch v := value.(type) {
+		case nil:
+		case map[string]interface{}:
+			flatten(path, v, out)
+		case string:
+			out[path] = v
+		case float64:
+			out[path] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			out[path] = strconv.FormatBool(v)
+		default:
+			b, _ := json.Marshal(v)
+			out[path] = string(b)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+
+	"audit-service/internal/model"
+)
+
+// В NDJSON события пишутся как есть, в том же виде, что и в ответах API
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *ndjsonWriter) Write(event *model.AuditEvent) error {
+	return n.enc.Encode(event)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,117 @@
+package export
+
+import (
+	"io"
+
+	"audit-service/internal/model"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Строк в одной группе строк Parquet: группа собирается в памяти целиком,
+// поэтому размер ограничен
+const parquetRowGroupSize = 10000
+
+type parquetWriter struct {
+	w       *parquet.Writer
+	columns *model.ExportColumns
+	// Номер колонки схемы для каждой колонки из baseColumns и jsonColumnNames
+	index []int
+	row   parquet.Row
+}
+
+func newParquetWriter(w io.Writer, columns *model.ExportColumns) *parquetWriter {
+	text := parquet.Optional(parquet.String())
+	group := parquet.Group{
+		"id":              parquet.Int(64),
+		"event_id":        text,
+		"idempotency_key": text,
+		"timestamp":       parquet.Timestamp(parquet.Microsecond),
+		"user":            parquet.String(),
+		"component":       text,
+		"op":              parquet.String(),
+		"session_id":      parquet.Optional(parquet.Int(64)),
+		"req_id":          parquet.Optional(parquet.Int(64)),
+		"created_at":      parquet.Timestamp(parquet.Microsecond),
+		"actor":           text,
+		"tenant":          text,
+	}
+	// Значения внутри JSON бывают разных типов, поэтому всегда строки
+	names := append(append([]string{}, baseColumns...), jsonColumnNames(columns)...)
+	for _, name := range names[len(baseColumns):] {
+		group[name] = text
+	}
+
+	// Group упорядочивает колонки по имени, запоминаем их номера
+	schema := parquet.NewSchema("audit_event", group)
+	index := make([]int, len(names))
+	for i, name := range names {
+		leaf, _ := schema.Lookup(name)
+		index[i] = leaf.ColumnIndex
+	}
+
+	return &parquetWriter{
+		w: parquet.NewWriter(w, schema,
+			parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+			parquet.Compression(&parquet.Snappy),
+		),
+		columns: columns,
+		index:   index,
+		row:     make(parquet.Row, len(names)),
+	}
+}
+
+func (p *parquetWriter) Write(event *model.AuditEvent) error {
+	required := func(i int, v parquet.Value) {
+		p.row[p.index[i]] = v.Level(0, 0, p.index[i])
+	}
+	optional := func(i int, v *parquet.Value) {
+		if v == nil {
+			p.row[p.index[i]] = parquet.NullValue().Level(0, 0, p.index[i])
+		} else {
+			p.row[p.index[i]] = v.Level(0, 1, p.index[i])
+		}
+	}
+	optionalText := func(i int, s *string) {
+		if s == nil {
+			optional(i, nil)
+			return
+		}
+		v := parquet.ByteArrayValue([]byte(*s))
+		optional(i, &v)
+	}
+	optionalInt := func(i int, n *int64) {
+		if n == nil {
+			optional(i, nil)
+			return
+		}
+		v := parquet.Int64Value(*n)
+		optional(i, &v)
+	}
+
+	// Порядок соответствует baseColumns
+	base := baseValues(event)
+	required(0, parquet.Int64Value(event.ID))
+	optionalText(1, base[1])
+	optionalText(2, base[2])
+	required(3, parquet.Int64Value(event.Timestamp.UnixMicro()))
+	required(4, parquet.ByteArrayValue([]byte(event.User)))
+	optionalText(5, event.Component)
+	required(6, parquet.ByteArrayValue([]byte(event.Operation)))
+	optionalInt(7, event.SessionID)
+	optionalInt(8, event.RequestID)
+	required(9, parquet.Int64Value(event.CreatedAt.UnixMicro()))
+	optionalText(10, base[10])
+	optionalText(11, base[11])
+
+	for i, v := range jsonValues(event, p.columns) {
+		optionalText(len(baseColumns)+i, v)
+	}
+
+	_, err := p.w.WriteRows([]parquet.Row{p.row})
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
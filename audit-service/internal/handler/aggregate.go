@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"audit-service/internal/model"
+)
+
+// AggregateEvents принимает те же фильтры, что и FindEvents, плюс group_by,
+// interval и top, и возвращает посчитанные в БД счётчики
+func (h *AuditHandler) AggregateEvents(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	filters, err := parseQueryFilters(params)
+	if err != nil {
+		respondFilterError(w, err)
+		return
+	}
+
+	req := model.AggregateRequest{
+		Filters:  filters,
+		GroupBy:  params.Get("group_by"),
+		Interval: params.Get("interval"),
+	}
+	if top := params.Get("top"); top != "" {
+		req.Top, err = strconv.Atoi(top)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "top must be an integer")
+			return
+		}
+	}
+
+	result, err := h.service.AggregateEvents(r.Context(), req)
+	if err != nil {
+		respondServiceError(w, err, "Failed to aggregate events")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
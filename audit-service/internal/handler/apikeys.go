@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"audit-service/internal/model"
+	"audit-service/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type APIKeyHandler struct {
+	keys *service.APIKeyService
+}
+
+func NewAPIKeyHandler(keys *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{keys: keys}
+}
+
+// Create выпускает ключ. Ключ есть только в этом ответе.
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req model.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	key, err := h.keys.Create(r.Context(), req)
+	if err != nil {
+		respondServiceError(w, err, "Failed to create API key")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, key)
+}
+
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List(r.Context())
+	if err != nil {
+		respondServiceError(w, err, "Failed to list API keys")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// Revoke отзывает ключ, повторный отзыв не ошибка
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	err := h.keys.Revoke(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, service.ErrAPIKeyNotFound) {
+		respondWithError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		respondServiceError(w, err, "Failed to revoke API key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"audit-service/internal/service"
+)
+
+type ArchiveHandler struct {
+	archiver *service.Archiver
+}
+
+func NewArchiveHandler(archiver *service.Archiver) *ArchiveHandler {
+	return &ArchiveHandler{archiver: archiver}
+}
+
+// Manifest отдаёт оглавление холодного архива
+func (h *ArchiveHandler) Manifest(w http.ResponseWriter, r *http.Request) {
+	manifest, err := h.archiver.Manifest(r.Context())
+	if err != nil {
+		respondServiceError(w, err, "Failed to load archive manifest")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, manifest)
+}
+
+// Restore возвращает в БД архивные сегменты, пересекающиеся с диапазоном
+// from..to (RFC3339 или YYYY-MM-DD)
+func (h *ArchiveHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	from, errFrom := parseRangeTime(r.URL.Query().Get("from"))
+	to, errTo := parseRangeTime(r.URL.Query().Get("to"))
+	if errFrom != nil || errTo != nil {
+		respondWithError(w, http.StatusBadRequest, "from and to must be RFC3339 timestamps or dates")
+		return
+	}
+
+	report, err := h.archiver.Restore(r.Context(), from, to)
+	if errors.Is(err, service.ErrArchiveBusy) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondServiceError(w, err, "Failed to restore archived events")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func parseRangeTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -2,14 +2,18 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	// REMOVE: "github.com/gorilla/mux" - not used
 	"audit-service/internal/model"
+	"audit-service/internal/query"
 	"audit-service/internal/service"
+
+	"github.com/gorilla/mux"
 )
 
 type AuditHandler struct {
@@ -20,10 +24,22 @@ func NewAuditHandler(s service.AuditService) *AuditHandler {
 	return &AuditHandler{service: s}
 }
 
+// Максимальный размер тела одного события, как и строки NDJSON. Шлюз
+// снимает ограничение с /audit/events/ ради потоковой загрузки, поэтому
+// одиночное событие ограничивает сам сервис.
+const maxEventBytes = ndjsonMaxLineSize
+
 func (h *AuditHandler) StoreEvent(w http.ResponseWriter, r *http.Request) {
 	var event model.AuditEvent
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxEventBytes)
 	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge,
+				"Event body cannot exceed "+strconv.Itoa(maxEventBytes)+" bytes")
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
@@ -38,33 +54,198 @@ func (h *AuditHandler) StoreEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Повтор запроса с тем же ключом вернёт уже сохранённое событие
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		event.IdempotencyKey = key
+	}
+
+	// В асинхронном режиме событие уходит в очередь, клиент получает квитанцию,
+	// даже если это повтор: дубликат отбрасывается при записи очереди
+	if h.service.AsyncEnabled() {
+		receipt, err := h.service.EnqueueEvent(r.Context(), &event)
+		if err != nil {
+			if errors.Is(err, service.ErrQueueFull) || errors.Is(err, service.ErrWriterClosed) {
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			if errors.Is(err, service.ErrQuotaExceeded) {
+				respondQuotaExceeded(w)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, receipt)
+		return
+	}
+
 	storedEvent, err := h.service.StoreEvent(r.Context(), &event)
+	if errors.Is(err, service.ErrEventSpooled) {
+		// БД недоступна, событие надёжно сохранено в спул и будет записано позже
+		respondWithJSON(w, http.StatusAccepted, &model.Receipt{
+			ReceiptID:  storedEvent.EventID,
+			Status:     "spooled",
+			AcceptedAt: time.Now().UTC(),
+		})
+		return
+	}
+	if errors.Is(err, service.ErrDuplicateEvent) {
+		w.Header().Set("Idempotent-Replayed", "true")
+		respondWithJSON(w, http.StatusOK, storedEvent)
+		return
+	}
+	if errors.Is(err, service.ErrQuotaExceeded) {
+		respondQuotaExceeded(w)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to store event")
+		respondServiceError(w, err, "Failed to store event")
 		return
 	}
 
 	respondWithJSON(w, http.StatusCreated, storedEvent)
 }
 
+// Максимальный размер пачки в одном запросе
+const maxBatchSize = 10000
+
+// Максимальный размер тела пачки: массив разбирается в память целиком, и
+// проверка maxBatchSize срабатывает только после разбора. Большие объёмы
+// грузятся потоком через NDJSON.
+const maxBatchBytes = 32 << 20
+
+func (h *AuditHandler) StoreEvents(w http.ResponseWriter, r *http.Request) {
+	var events []*model.AuditEvent
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge,
+				"Batch body cannot exceed "+strconv.Itoa(maxBatchBytes)+" bytes")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON format, expected an array of events")
+		return
+	}
+
+	if len(events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Batch must contain at least one event")
+		return
+	}
+	if len(events) > maxBatchSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge,
+			"Batch cannot contain more than "+strconv.Itoa(maxBatchSize)+" events")
+		return
+	}
+
+	result, err := h.service.StoreEvents(r.Context(), events)
+	if err != nil {
+		respondServiceError(w, err, "Failed to store events")
+		return
+	}
+
+	// Частично некорректная пачка: хорошие события сохранены, статус по каждому в items
+	code := http.StatusCreated
+	if result.Rejected > 0 {
+		code = http.StatusMultiStatus
+	}
+
+	respondWithJSON(w, code, result)
+}
+
+// GetEvent отдаёт одно событие по id. События неизменяемы, поэтому ETag и
+// Last-Modified строятся из id и created_at, а условные запросы получают 304.
+func (h *AuditHandler) GetEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	event, err := h.service.GetEvent(r.Context(), id)
+	if errors.Is(err, service.ErrEventNotFound) {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		respondServiceError(w, err, "Failed to retrieve event")
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d-%d"`, event.ID, event.CreatedAt.UnixNano())
+	lastModified := event.CreatedAt.UTC().Truncate(time.Second)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, event)
+}
+
+// notModified проверяет условные заголовки запроса. If-None-Match важнее
+// If-Modified-Since, как требует RFC 9110.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" {
+		t, err := http.ParseTime(since)
+		return err == nil && !lastModified.After(t)
+	}
+
+	return false
+}
+
 func (h *AuditHandler) FindEvents(w http.ResponseWriter, r *http.Request) {
-	filters := parseQueryFilters(r.URL.Query())
+	filters, err := parseQueryFilters(r.URL.Query())
+	if err != nil {
+		respondFilterError(w, err)
+		return
+	}
 
-	events, err := h.service.FindEvents(r.Context(), filters)
+	page, err := h.service.FindEvents(r.Context(), filters)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve events")
+		respondServiceError(w, err, "Failed to retrieve events")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, events)
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// Параметры запроса, которые не являются фильтрами по атрибутам
+var reservedQueryParams = map[string]bool{
+	"limit":  true,
+	"cursor": true,
+	"q":      true,
+	// Параметры агрегации
+	"group_by": true,
+	"interval": true,
+	"top":      true,
+	// Формат выгрузки
+	"format": true,
+	// Подмешивание холодного архива
+	"include_archive": true,
 }
 
-func parseQueryFilters(query map[string][]string) model.EventFilters {
+func parseQueryFilters(params map[string][]string) (model.EventFilters, error) {
 	var filters model.EventFilters
 
 	// Helper function to get first value from query parameter
 	getFirst := func(key string) string {
-		if values, ok := query[key]; ok && len(values) > 0 {
+		if values, ok := params[key]; ok && len(values) > 0 {
 			return values[0]
 		}
 		return ""
@@ -102,7 +283,7 @@ func parseQueryFilters(query map[string][]string) model.EventFilters {
 
 	// Парсинг списков
 	getList := func(key string) []string {
-		if values, ok := query[key]; ok && len(values) > 0 {
+		if values, ok := params[key]; ok && len(values) > 0 {
 			return strings.Split(values[0], ",")
 		}
 		return nil
@@ -131,17 +312,99 @@ func parseQueryFilters(query map[string][]string) model.EventFilters {
 	filters.SessionIDs = parseInt64List("ev_session_id")
 	filters.RequestIDs = parseInt64List("ev_req_id")
 
-	// Парсинг пользовательских атрибутов
+	// Парсинг пользовательских атрибутов и полей ответа (ev_res.<путь>)
 	filters.Attributes = make(map[string][]string)
-	for key, values := range query {
+	filters.Response = make(map[string][]string)
+	for key, values := range params {
+		if reservedQueryParams[key] || len(values) == 0 {
+			continue
+		}
+		if path := strings.TrimPrefix(key, "ev_res."); path != key && path != "" {
+			filters.Response[path] = strings.Split(values[0], ",")
+			continue
+		}
 		if !strings.HasPrefix(key, "ev_") && key != "ev_ts" && key != "ev_ts_start" && key != "ev_ts_end" {
-			if len(values) > 0 {
-				filters.Attributes[key] = strings.Split(values[0], ",")
-			}
+			filters.Attributes[key] = strings.Split(values[0], ",")
 		}
 	}
 
-	return filters
+	// Постраничная выдача
+	if limit := getFirst("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return filters, errors.New("limit must be an integer")
+		}
+		filters.Limit = n
+	}
+	if q := getFirst("q"); strings.TrimSpace(q) != "" {
+		node, err := query.Parse(q)
+		if err != nil {
+			return filters, err
+		}
+		filters.Query = node
+	}
+	if include := getFirst("include_archive"); include != "" {
+		v, err := strconv.ParseBool(include)
+		if err != nil {
+			return filters, errors.New("include_archive must be true or false")
+		}
+		filters.IncludeArchive = v
+	}
+	if cursor := getFirst("cursor"); cursor != "" {
+		c, err := model.DecodeCursor(cursor)
+		if err != nil {
+			return filters, err
+		}
+		filters.Cursor = c
+	}
+
+	return filters, nil
+}
+
+// respondFilterError отвечает 400 на некорректные параметры поиска
+func respondFilterError(w http.ResponseWriter, err error) {
+	var queryErr *query.Error
+	if errors.As(err, &queryErr) {
+		respondWithQueryError(w, queryErr)
+		return
+	}
+	respondWithError(w, http.StatusBadRequest, err.Error())
+}
+
+// respondServiceError отвечает 400 на ошибки валидации из сервиса и
+// message с кодом 500 на всё остальное
+func respondServiceError(w http.ResponseWriter, err error, message string) {
+	var validationErr *service.ValidationError
+	if errors.As(err, &validationErr) {
+		respondWithError(w, http.StatusBadRequest, validationErr.Message)
+		return
+	}
+	var queryErr *query.Error
+	if errors.As(err, &queryErr) {
+		respondWithQueryError(w, queryErr)
+		return
+	}
+	var encryptedErr *service.EncryptedFieldError
+	if errors.As(err, &encryptedErr) {
+		respondWithError(w, http.StatusBadRequest, encryptedErr.Error())
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, message)
+}
+
+// respondQuotaExceeded просит повторить запись с началом следующего окна квоты
+func respondQuotaExceeded(w http.ResponseWriter) {
+	seconds := int(service.QuotaRetryAfter().Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, http.StatusTooManyRequests, service.ErrQuotaExceeded.Error())
+}
+
+// respondWithQueryError указывает клиенту позицию ошибки в выражении q=
+func respondWithQueryError(w http.ResponseWriter, err *query.Error) {
+	respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":    err.Error(),
+		"position": err.Pos,
+	})
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
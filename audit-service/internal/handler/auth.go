@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"audit-service/internal/auth"
+	"audit-service/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type AuthHandler struct {
+	auth *service.Authenticator
+}
+
+func NewAuthHandler(authenticator *service.Authenticator) *AuthHandler {
+	return &AuthHandler{auth: authenticator}
+}
+
+// Require - middleware маршрутов, которым нужно право scope. Ключ API
+// или JWT передаётся в заголовке Authorization: Bearer <...>, ключ - также
+// в X-API-Key. Без них клиентом считается владелец сертификата соединения.
+func (h *AuthHandler) Require(scope string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := h.authenticate(r)
+			if errors.Is(err, service.ErrUnauthenticated) {
+				respondUnauthenticated(w)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to authenticate request: %v", err)
+				respondWithError(w, http.StatusServiceUnavailable, "Failed to check credentials")
+				return
+			}
+			if !principal.HasScope(scope) {
+				respondWithError(w, http.StatusForbidden, "Credentials lack scope "+scope)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+func (h *AuthHandler) authenticate(r *http.Request) (*auth.Principal, error) {
+	if credential := credentialFromRequest(r); credential != "" {
+		return h.auth.Authenticate(r.Context(), credential)
+	}
+	if principal := h.auth.AuthenticateCertificate(r.TLS); principal != nil {
+		return principal, nil
+	}
+	return nil, service.ErrUnauthenticated
+}
+
+// IdentifyClientCert - middleware для mTLS без AUTH_ENABLED: ничего не
+// запрещает, только записывает владельца сертификата клиентом запроса,
+// чтобы его идентичность попала в события
+func IdentifyClientCert(certs *auth.CertMapper) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if principal := certs.Principal(r.TLS); principal != nil {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func credentialFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, credential, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(credential)
+	}
+	return ""
+}
+
+func respondUnauthenticated(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="audit"`)
+	respondWithError(w, http.StatusUnauthorized, service.ErrUnauthenticated.Error())
+}
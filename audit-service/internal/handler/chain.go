@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"audit-service/internal/service"
+)
+
+type ChainHandler struct {
+	verifier *service.ChainVerifier
+}
+
+func NewChainHandler(verifier *service.ChainVerifier) *ChainHandler {
+	return &ChainHandler{verifier: verifier}
+}
+
+// Verify проверяет цепочку хешей событий, созданных в диапазоне from..to
+// (RFC3339 или YYYY-MM-DD, оба необязательны), и сообщает о первом разрыве
+func (h *ChainHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	var from, to *time.Time
+	for key, dest := range map[string]**time.Time{"from": &from, "to": &to} {
+		value := r.URL.Query().Get(key)
+		if value == "" {
+			continue
+		}
+		t, err := parseRangeTime(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, key+" must be an RFC3339 timestamp or a date")
+			return
+		}
+		*dest = &t
+	}
+
+	// Проверка большого диапазона дольше общего таймаута записи
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	result, err := h.verifier.Verify(r.Context(), from, to)
+	if err != nil {
+		respondServiceError(w, err, "Failed to verify hash chain")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
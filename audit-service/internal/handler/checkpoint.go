@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"audit-service/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// Сколько контрольных точек отдаётся без limit
+const defaultCheckpointsLimit = 20
+
+type CheckpointHandler struct {
+	checkpointer *service.Checkpointer
+}
+
+func NewCheckpointHandler(checkpointer *service.Checkpointer) *CheckpointHandler {
+	return &CheckpointHandler{checkpointer: checkpointer}
+}
+
+// List отдаёт последние контрольные точки, новые первыми
+func (h *CheckpointHandler) List(w http.ResponseWriter, r *http.Request) {
+	limit := defaultCheckpointsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "limit must be an integer")
+			return
+		}
+		limit = n
+	}
+
+	checkpoints, err := h.checkpointer.List(r.Context(), limit)
+	if err != nil {
+		respondServiceError(w, err, "Failed to list checkpoints")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, checkpoints)
+}
+
+// Proof отдаёт доказательство включения события в подписанную контрольную
+// точку: хеш звена события с путём до корня даёт подписанный корень
+func (h *CheckpointHandler) Proof(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	// Окно точки проверяется целиком, на больших окнах это дольше таймаута записи
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	proof, err := h.checkpointer.Proof(r.Context(), id)
+	switch {
+	case errors.Is(err, service.ErrEventNotFound):
+		respondWithError(w, http.StatusNotFound, "Event not found")
+	case errors.Is(err, service.ErrNotCheckpointed):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrCheckpointMismatch):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		respondServiceError(w, err, "Failed to build inclusion proof")
+	default:
+		respondWithJSON(w, http.StatusOK, proof)
+	}
+}
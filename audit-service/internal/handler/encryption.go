@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+
+	"audit-service/internal/service"
+)
+
+type EncryptionHandler struct {
+	rotator *service.KeyRotator
+}
+
+func NewEncryptionHandler(rotator *service.KeyRotator) *EncryptionHandler {
+	return &EncryptionHandler{rotator: rotator}
+}
+
+// Keys отдаёт ключи связки и сколько событий зашифровано каждым
+func (h *EncryptionHandler) Keys(w http.ResponseWriter, r *http.Request) {
+	status, err := h.rotator.Status(r.Context())
+	if err != nil {
+		respondServiceError(w, err, "Failed to load encryption status")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, status)
+}
+
+// Rotate запускает в фоне перешифровку ключей событий активным ключом
+// связки. Ход ротации виден в Keys.
+func (h *EncryptionHandler) Rotate(w http.ResponseWriter, r *http.Request) {
+	h.rotator.Trigger()
+	respondWithJSON(w, http.StatusAccepted, map[string]string{"status": "rotation started"})
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"audit-service/internal/export"
+	"audit-service/internal/model"
+	"audit-service/internal/service"
+)
+
+// На сколько продлевается дедлайн записи после каждой порции выгрузки
+const exportWriteDeadline = 60 * time.Second
+
+type ExportHandler struct {
+	service service.ExportService
+}
+
+func NewExportHandler(s service.ExportService) *ExportHandler {
+	return &ExportHandler{service: s}
+}
+
+// Export отдаёт все события, подходящие под фильтры /events/query, файлом
+// в формате format (csv, ndjson или parquet, по умолчанию csv). Ответ
+// пишется потоком по мере чтения из БД.
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseQueryFilters(r.URL.Query())
+	if err != nil {
+		respondFilterError(w, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = model.ExportCSV
+	}
+	contentType, ok := export.ContentType(format)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "format must be one of csv, ndjson, parquet")
+		return
+	}
+
+	out := &exportResponseWriter{
+		w:           w,
+		rc:          http.NewResponseController(w),
+		contentType: contentType,
+		filename:    fmt.Sprintf("audit-events-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format),
+	}
+	if err := h.service.ExportEvents(r.Context(), filters, format, out); err != nil {
+		if !out.started {
+			respondServiceError(w, err, "Failed to export events")
+			return
+		}
+		// Заголовки уже отправлены, клиент увидит оборванный файл
+		log.Printf("Export aborted after %d bytes: %v", out.written, err)
+	}
+}
+
+// exportResponseWriter отправляет заголовки файла только при первой записи,
+// чтобы до неё ещё можно было ответить ошибкой, и продлевает дедлайн записи,
+// который иначе оборвал бы долгую выгрузку
+type exportResponseWriter struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	contentType string
+	filename    string
+	started     bool
+	written     int64
+}
+
+func (e *exportResponseWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", e.contentType)
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+		e.w.WriteHeader(http.StatusOK)
+	}
+
+	_ = e.rc.SetWriteDeadline(time.Now().Add(exportWriteDeadline))
+	n, err := e.w.Write(p)
+	e.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"audit-service/internal/model"
+)
+
+const (
+	// Количество событий, сбрасываемых в БД за один COPY
+	ndjsonChunkSize = 1000
+	// Пачка сбрасывается раньше, если её строки заняли столько байт: 1000
+	// строк по мегабайту держали бы в памяти гигабайт на запрос
+	ndjsonChunkBytes = 8 << 20
+	// Максимальная длина одной строки NDJSON
+	ndjsonMaxLineSize = 1 << 20
+	// На сколько продлеваются дедлайны соединения после каждой строки и
+	// каждой пачки
+	ndjsonDeadline = 60 * time.Second
+)
+
+// Строка ответа потоковой загрузки
+type ndjsonStatus struct {
+	Type     string `json:"type"`
+	Line     int    `json:"line,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Lines    int    `json:"lines"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+}
+
+// IngestNDJSON принимает события в формате application/x-ndjson (одно
+// JSON-событие на строку), читает тело построчно и пишет в БД пачками не
+// больше ndjsonChunkSize событий и ndjsonChunkBytes байт, так что расход
+// памяти не зависит от размера загрузки.
+// В ответ потоком отдаются ошибки по строкам, прогресс после каждой пачки
+// и итоговая сводка.
+func (h *AuditHandler) IngestNDJSON(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// Ответ пишется, пока тело запроса ещё читается
+	_ = rc.EnableFullDuplex()
+	extendDeadlines := func() {
+		deadline := time.Now().Add(ndjsonDeadline)
+		_ = rc.SetReadDeadline(deadline)
+		_ = rc.SetWriteDeadline(deadline)
+	}
+	extendDeadlines()
+
+	// Статус уходит с первой строкой ответа, уже после начала чтения тела:
+	// ответ до чтения отменил бы Expect: 100-continue, и сервер закрыл бы
+	// тело, которое клиент ещё не отправил
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	enc := json.NewEncoder(w)
+	var total ndjsonStatus
+	emit := func(status ndjsonStatus) {
+		status.Lines = total.Lines
+		status.Accepted = total.Accepted
+		status.Rejected = total.Rejected
+		enc.Encode(status)
+		rc.Flush()
+	}
+
+	chunk := make([]*model.AuditEvent, 0, ndjsonChunkSize)
+	chunkLines := make([]int, 0, ndjsonChunkSize)
+	chunkBytes := 0
+
+	flush := func() bool {
+		if len(chunk) == 0 {
+			return true
+		}
+		result, err := h.service.StoreEvents(r.Context(), chunk)
+		if err != nil {
+			emit(ndjsonStatus{
+				Type:  "error",
+				Line:  chunkLines[0],
+				Error: fmt.Sprintf("failed to store events from line %d: %v", chunkLines[0], err),
+			})
+			return false
+		}
+		for _, item := range result.Items {
+			if item.Error != "" {
+				total.Rejected++
+				emit(ndjsonStatus{Type: "error", Line: chunkLines[item.Index], Error: item.Error})
+			}
+		}
+		total.Accepted += result.Accepted
+		emit(ndjsonStatus{Type: "progress", Line: chunkLines[len(chunkLines)-1]})
+
+		chunk = chunk[:0]
+		chunkLines = chunkLines[:0]
+		chunkBytes = 0
+		extendDeadlines()
+		return true
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), ndjsonMaxLineSize)
+
+	for scanner.Scan() {
+		// Дедлайн продлевается по мере чтения, а не только после пачки:
+		// длинная полоса отклонённых строк не должна обрывать загрузку
+		extendDeadlines()
+		total.Lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var event model.AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			total.Rejected++
+			emit(ndjsonStatus{Type: "error", Line: total.Lines, Error: "invalid JSON: " + err.Error()})
+			continue
+		}
+
+		chunk = append(chunk, &event)
+		chunkLines = append(chunkLines, total.Lines)
+		chunkBytes += len(line)
+		if (len(chunk) == ndjsonChunkSize || chunkBytes >= ndjsonChunkBytes) && !flush() {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		// Уже прочитанное сохраняем, дальше читать поток невозможно
+		if flush() {
+			emit(ndjsonStatus{Type: "error", Line: total.Lines + 1, Error: "failed to read body: " + err.Error()})
+		}
+		return
+	}
+
+	if !flush() {
+		return
+	}
+
+	emit(ndjsonStatus{Type: "summary"})
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net/http"
+
+	"audit-service/internal/service"
+)
+
+type QuotaHandler struct {
+	quotas *service.TenantQuotas
+}
+
+func NewQuotaHandler(quotas *service.TenantQuotas) *QuotaHandler {
+	return &QuotaHandler{quotas: quotas}
+}
+
+// Report отдаёт расход квот арендаторами в текущем окне
+func (h *QuotaHandler) Report(w http.ResponseWriter, r *http.Request) {
+	report, err := h.quotas.Report(r.Context())
+	if err != nil {
+		respondServiceError(w, err, "Failed to load tenant quotas")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
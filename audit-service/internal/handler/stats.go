@@ -6,6 +6,8 @@ import (
     "runtime"
     "sync/atomic"
     "time"
+
+    "audit-service/internal/model"
 )
 
 type StatsHandler struct {
@@ -14,6 +16,12 @@ type StatsHandler struct {
     totalRequests uint64
     totalErrors   uint64
     dbConnected   atomic.Bool
+    retention     RetentionSource
+}
+
+// RetentionSource отдаёт итог последнего прогона очистки по срокам хранения
+type RetentionSource interface {
+    LastReport() *model.RetentionReport
 }
 
 func NewStatsHandler(version string) *StatsHandler {
@@ -30,6 +38,7 @@ type StatsResponse struct {
     TotalRequests uint64   `json:"total_requests"`
     TotalErrors   uint64   `json:"total_errors"`
     DBConnected   bool     `json:"db_connected"`
+    Retention     *model.RetentionReport `json:"retention,omitempty"`
     Timestamp    time.Time `json:"timestamp"`
 }
 
@@ -43,6 +52,9 @@ func (h *StatsHandler) Stats(w http.ResponseWriter, r *http.Request) {
         DBConnected:   h.dbConnected.Load(),
         Timestamp:    time.Now().UTC(),
     }
+    if h.retention != nil {
+        stats.Retention = h.retention.LastReport()
+    }
     
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(stats)
@@ -70,6 +82,10 @@ func (h *StatsHandler) SetDBConnected(connected bool) {
     h.dbConnected.Store(connected)
 }
 
+func (h *StatsHandler) SetRetentionSource(source RetentionSource) {
+    h.retention = source
+}
+
 func (h *StatsHandler) Middleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         h.IncrementRequests()
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"audit-service/internal/service"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Интервал пустых сообщений, которые не дают nginx и клиентам закрыть
+	// простаивающую ленту (proxy_read_timeout 30s)
+	streamHeartbeat = 15 * time.Second
+	// Дедлайн записи одного сообщения в WebSocket
+	streamWriteTimeout = 10 * time.Second
+)
+
+// Сообщение об ошибке в конце ленты: подписчик не успевал читать события
+const streamLaggedMessage = "stream closed: client is too slow to keep up with events"
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// StreamEvents отдаёт новые события, подходящие под те же фильтры, что и
+// /events/query, по мере их записи любой репликой. По умолчанию это
+// Server-Sent Events, запрос с Upgrade: websocket переключается на WebSocket
+// с одним JSON-событием в сообщении.
+func (h *AuditHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseQueryFilters(r.URL.Query())
+	if err != nil {
+		respondFilterError(w, err)
+		return
+	}
+
+	sub, err := h.service.SubscribeEvents(r.Context(), filters)
+	if err != nil {
+		respondServiceError(w, err, "Failed to subscribe to events")
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamWebSocket(w, r, sub)
+		return
+	}
+	streamSSE(w, r, sub)
+}
+
+func streamSSE(w http.ResponseWriter, r *http.Request, sub *service.Subscription) {
+	rc := http.NewResponseController(w)
+	// WriteTimeout сервера оборвал бы ленту через 30 секунд
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Отключает буферизацию ответа в nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+
+		case event, ok := <-sub.Events:
+			if !ok {
+				if sub.Lagged() {
+					data, _ := json.Marshal(map[string]string{"error": streamLaggedMessage})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+					rc.Flush()
+				}
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: audit_event\ndata: %s\n\n", event.ID, data); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func streamWebSocket(w http.ResponseWriter, r *http.Request, sub *service.Subscription) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту ошибкой
+		return
+	}
+	defer conn.Close()
+
+	// Клиент ничего не присылает, чтение нужно только чтобы заметить закрытие
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+
+		case event, ok := <-sub.Events:
+			if !ok {
+				message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+				if sub.Lagged() {
+					message = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, streamLaggedMessage)
+				}
+				conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(streamWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+
+	"audit-service/internal/auth"
+	"audit-service/internal/tenant"
+
+	"github.com/gorilla/mux"
+)
+
+// ResolveTenant - middleware, которое определяет арендатора запроса после
+// аутентификации. Клиент, привязанный к арендатору, работает только с ним.
+// Администратор без арендатора выбирает его заголовком X-Tenant-ID, а без
+// заголовка читает события всех арендаторов и пишет в default. Остальные
+// клиенты без арендатора работают с default. С выключенной
+// аутентификацией арендатора выбирает заголовок.
+func ResolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested := r.Header.Get(tenant.Header)
+		if requested != "" && !tenant.Valid(requested) {
+			respondWithError(w, http.StatusBadRequest, "Invalid "+tenant.Header)
+			return
+		}
+
+		id := requested
+		principal := auth.FromContext(r.Context())
+		if principal != nil {
+			bound := principal.Tenant
+			if bound == "" && !principal.HasScope(auth.ScopeAdmin) {
+				bound = tenant.Default
+			}
+			if bound != "" {
+				if requested != "" && requested != bound {
+					respondWithError(w, http.StatusForbidden, "Credentials are not allowed to access tenant "+requested)
+					return
+				}
+				id = bound
+			}
+		}
+
+		if id != "" {
+			r = r.WithContext(tenant.WithTenant(r.Context(), id))
+		} else {
+			r = r.WithContext(tenant.WithAll(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllTenants - middleware операций над всем кластером (архив, цепочка, ключи
+// шифрования): клиенту, привязанному к арендатору, они недоступны, а
+// X-Tenant-ID администратора их не сужает
+func AllTenants() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if principal := auth.FromContext(r.Context()); principal != nil && principal.Tenant != "" {
+				respondWithError(w, http.StatusForbidden, "Operation is not available to tenant-bound credentials")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(tenant.WithAll(r.Context())))
+		})
+	}
+}
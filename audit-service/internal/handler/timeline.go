@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"audit-service/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// SessionTimeline отдаёт хронологию событий сессии
+func (h *AuditHandler) SessionTimeline(w http.ResponseWriter, r *http.Request) {
+	h.timeline(w, r, service.TimelineSession)
+}
+
+// RequestTimeline отдаёт хронологию событий запроса
+func (h *AuditHandler) RequestTimeline(w http.ResponseWriter, r *http.Request) {
+	h.timeline(w, r, service.TimelineRequest)
+}
+
+func (h *AuditHandler) timeline(w http.ResponseWriter, r *http.Request, kind string) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid "+kind+" id")
+		return
+	}
+
+	timeline, err := h.service.Timeline(r.Context(), kind, id)
+	if errors.Is(err, service.ErrTimelineNotFound) {
+		respondWithError(w, http.StatusNotFound, "No events found for "+kind+" "+strconv.FormatInt(id, 10))
+		return
+	}
+	if err != nil {
+		respondServiceError(w, err, "Failed to build timeline")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, timeline)
+}
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// Интервалы временных корзин агрегации и соответствующие единицы date_trunc
+var AggregateIntervals = map[string]string{
+	"1m": "minute",
+	"1h": "hour",
+	"1d": "day",
+}
+
+type AggregateRequest struct {
+	Filters EventFilters
+	// user, component, operation или путь attributes.<путь> / res.<путь>
+	GroupBy string
+	// 1m, 1h или 1d; пустая строка - без разбиения по времени
+	Interval string
+	// Сколько самых частых значений GroupBy возвращать
+	Top int
+}
+
+type GroupCount struct {
+	Value *string `json:"value"`
+	Count int64   `json:"count"`
+}
+
+type AggregateBucket struct {
+	Time          time.Time    `json:"time"`
+	Count         int64        `json:"count"`
+	DistinctUsers int64        `json:"distinct_users"`
+	Top           []GroupCount `json:"top,omitempty"`
+}
+
+type AggregateResult struct {
+	GroupBy       string            `json:"group_by,omitempty"`
+	Interval      string            `json:"interval,omitempty"`
+	Total         int64             `json:"total"`
+	DistinctUsers int64             `json:"distinct_users"`
+	Top           []GroupCount      `json:"top,omitempty"`
+	Buckets       []AggregateBucket `json:"buckets,omitempty"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// APIKey - ключ доступа к API. Сам ключ хранится только хешем и отдаётся
+// клиенту один раз, при создании.
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Tenant - арендатор, к которому привязан ключ; пустой - не привязан
+	Tenant string `json:"tenant,omitempty"`
+	// Кто создал ключ - идентичность из auth.Principal
+	CreatedBy string     `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Key       string     `json:"key,omitempty"`
+}
+
+// APIKeyRequest - запрос на создание ключа
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Tenant string   `json:"tenant,omitempty"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// ArchiveRestoreReport - итог восстановления событий из холодного архива.
+// Сегменты восстанавливаются целиком, поэтому вернуться могут и события
+// за пределами запрошенного диапазона, но в пределах тех же дней.
+type ArchiveRestoreReport struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Segments []string  `json:"segments"`
+	Restored int64     `json:"restored"`
+	// До этого момента архиватор не вернёт восстановленные дни в архив
+	HeldUntil time.Time `json:"held_until"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Причины разрыва цепочки хешей
+const (
+	// Содержимое события не соответствует его хешу
+	ChainHashMismatch = "hash_mismatch"
+	// prev_hash не совпадает с хешем предыдущего звена
+	ChainPrevHashMismatch = "prev_hash_mismatch"
+	// Звено удалено не штатно: его нет ни в audit_events, ни среди удалённых
+	ChainLinkMissing = "missing"
+	// Два события с одним номером звена
+	ChainLinkDuplicate = "duplicate"
+)
+
+// ChainBreak - первое найденное нарушение цепочки
+type ChainBreak struct {
+	Seq      int64  `json:"seq"`
+	ID       int64  `json:"id,omitempty"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// ChainVerification - итог проверки цепочки хешей на диапазоне звеньев
+type ChainVerification struct {
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	FirstSeq int64      `json:"first_seq"`
+	LastSeq  int64      `json:"last_seq"`
+	// Проверено сохранённых событий и пройдено штатно удалённых звеньев
+	Checked int64       `json:"checked"`
+	Pruned  int64       `json:"pruned"`
+	Valid   bool        `json:"valid"`
+	Broken  *ChainBreak `json:"broken,omitempty"`
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// Checkpoint - подписанный корень дерева Меркла над хешами звеньев цепочки
+// [FirstSeq, LastSeq]. Подписывается chain.CheckpointMessage, хеши и ключи
+// в hex.
+type Checkpoint struct {
+	ID        int64     `json:"id"`
+	FirstSeq  int64     `json:"first_seq"`
+	LastSeq   int64     `json:"last_seq"`
+	Root      string    `json:"root"`
+	LastHash  string    `json:"last_hash"`
+	Signature string    `json:"signature"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// InclusionProof доказывает, что событие входит в контрольную точку: лист с
+// номером LeafIndex (хеш звена события) вместе с путём Path даёт корень
+// точки по RFC 6962
+type InclusionProof struct {
+	Event      *AuditEvent `json:"event"`
+	Checkpoint *Checkpoint `json:"checkpoint"`
+	LeafIndex  int64       `json:"leaf_index"`
+	TreeSize   int64       `json:"tree_size"`
+	Path       []string    `json:"path"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// EventCursor указывает на последнее событие страницы. Следующая страница
+// начинается строго после пары (timestamp, id) в порядке сортировки выдачи.
+type EventCursor struct {
+	Timestamp time.Time `json:"ts"`
+	ID        int64     `json:"id"`
+}
+
+// Страница результатов поиска
+type EventPage struct {
+	Events     []*AuditEvent `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor превращает курсор в непрозрачную для клиента строку
+func EncodeCursor(c EventCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*EventCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c EventCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 || c.Timestamp.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
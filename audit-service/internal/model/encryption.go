@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// KeyRotationReport - итог ротации ключей шифрования полей: сколько ключей
+// строк перешифровано активным ключом
+type KeyRotationReport struct {
+	ActiveKey  string    `json:"active_key"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Rewrapped  int64     `json:"rewrapped"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// EncryptionStatus - ключи связки и число событий, зашифрованных каждым ключом
+type EncryptionStatus struct {
+	ActiveKey string           `json:"active_key"`
+	Keys      []string         `json:"keys"`
+	Events    map[string]int64 `json:"events"`
+	// Ключи, которыми зашифрованы события, но которых нет в связке: такие
+	// события не прочитать и не перешифровать
+	MissingKeys  []string           `json:"missing_keys,omitempty"`
+	Rotating     bool               `json:"rotating"`
+	LastRotation *KeyRotationReport `json:"last_rotation,omitempty"`
+}
@@ -5,19 +5,40 @@ import (
     "encoding/json"
     "errors"
     "time"
+
+    "audit-service/internal/query"
 )
 
 type AuditEvent struct {
-    ID         int64           `json:"id" db:"id"`
-    Timestamp  time.Time       `json:"timestamp" db:"timestamp"`
-    User       string          `json:"user" db:"user_id"`
-    Component  *string         `json:"component,omitempty" db:"component"`
-    Operation  string          `json:"op" db:"operation"`
-    SessionID  *int64          `json:"session_id,omitempty" db:"session_id"`
-    RequestID  *int64          `json:"req_id,omitempty" db:"request_id"`
-    Response   *JSONB          `json:"res,omitempty" db:"response"`
-    Attributes *JSONB          `json:"attributes,omitempty" db:"attributes"`
-    CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+    ID             int64           `json:"id" db:"id"`
+    EventID        string          `json:"event_id,omitempty" db:"event_id"`
+    IdempotencyKey string          `json:"idempotency_key,omitempty" db:"idempotency_key"`
+    Timestamp      time.Time       `json:"timestamp" db:"timestamp"`
+    User           string          `json:"user" db:"user_id"`
+    Component      *string         `json:"component,omitempty" db:"component"`
+    Operation      string          `json:"op" db:"operation"`
+    SessionID      *int64          `json:"session_id,omitempty" db:"session_id"`
+    RequestID      *int64          `json:"req_id,omitempty" db:"request_id"`
+    Response       *JSONB          `json:"res,omitempty" db:"response"`
+    Attributes     *JSONB          `json:"attributes,omitempty" db:"attributes"`
+    CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+    // Звено цепочки хешей: порядковый номер в цепочке, хеш предыдущего
+    // звена и хеш этого (hex). Заполняются при сохранении, переданные
+    // клиентом значения игнорируются.
+    ChainSeq       int64           `json:"chain_seq,omitempty" db:"chain_seq"`
+    PrevHash       string          `json:"prev_hash,omitempty" db:"prev_hash"`
+    Hash           string          `json:"hash,omitempty" db:"hash"`
+    // Ключ связки и зашифрованный им ключ данных (hex) события с
+    // зашифрованными полями. У расшифрованного события пустые, переданные
+    // клиентом значения игнорируются.
+    EncKeyID       string          `json:"enc_key_id,omitempty" db:"enc_key_id"`
+    EncDEK         string          `json:"enc_dek,omitempty" db:"enc_dek"`
+    // Кто записал событие: идентичность клиента (api_key:<id>). Ставится
+    // сервисом при приёме, переданное клиентом значение игнорируется.
+    Actor          string          `json:"actor,omitempty" db:"actor"`
+    // Арендатор (команда), которой принадлежит событие. Ставится сервисом
+    // по учётным данным клиента или заголовку X-Tenant-ID.
+    TenantID       string          `json:"tenant_id,omitempty" db:"tenant_id"`
 }
 
 type EventFilters struct {
@@ -30,6 +51,45 @@ type EventFilters struct {
     SessionIDs    []int64            `json:"ev_session_id,omitempty"`
     RequestIDs    []int64            `json:"ev_req_id,omitempty"`
     Attributes    map[string][]string `json:"-"`
+    Response      map[string][]string `json:"-"`
+    // Постраничная выдача: размер страницы и позиция после предыдущей страницы
+    Limit         int                `json:"-"`
+    Cursor        *EventCursor       `json:"-"`
+    // Выражение из параметра q=, объединяется с остальными фильтрами через AND
+    Query         query.Node         `json:"-"`
+    // Ограничение выборки конкретными id, используется живой лентой событий
+    IDs           []int64            `json:"-"`
+    // Подмешивать события из холодного архива (include_archive=true)
+    IncludeArchive bool              `json:"-"`
+    // События из архива для подмешивания, заполняет сервис
+    Archived      []*AuditEvent      `json:"-"`
+    // Арендатор, которым ограничена выборка; пустой - все. Хранилища ставят
+    // его сами из context запроса.
+    Tenant        string             `json:"-"`
+}
+
+// Результат обработки одного элемента пакетной загрузки
+type BatchItemResult struct {
+    Index   int    `json:"index"`
+    ID      int64  `json:"id,omitempty"`
+    EventID   string `json:"event_id,omitempty"`
+    Spooled   bool   `json:"spooled,omitempty"`
+    Duplicate bool   `json:"duplicate,omitempty"`
+    Error     string `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+    Accepted int               `json:"accepted"`
+    Rejected int               `json:"rejected"`
+    Spooled  int               `json:"spooled,omitempty"`
+    Items    []BatchItemResult `json:"items"`
+}
+
+// Квитанция о приёме события в асинхронную очередь
+type Receipt struct {
+    ReceiptID  string    `json:"receipt_id"`
+    Status     string    `json:"status"`
+    AcceptedAt time.Time `json:"accepted_at"`
 }
 
 type JSONB map[string]interface{}
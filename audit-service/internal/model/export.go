@@ -0,0 +1,15 @@
+package model
+
+// Форматы выгрузки событий
+const (
+	ExportCSV     = "csv"
+	ExportNDJSON  = "ndjson"
+	ExportParquet = "parquet"
+)
+
+// ExportColumns - пути к значениям внутри attributes и res, которые при
+// выгрузке в CSV и Parquet становятся отдельными колонками
+type ExportColumns struct {
+	Attributes []string
+	Response   []string
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// RetentionRule задаёт срок хранения событий. Условия правила объединяются
+// через AND; событие подчиняется первому подходящему правилу в списке, а
+// если ни одно не подошло - сроку по умолчанию.
+type RetentionRule struct {
+	Name      string `json:"name"`
+	Component string `json:"component,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	// Путь в attributes через точку и значение, например level = debug
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+	KeepDays  int    `json:"keep_days"`
+}
+
+// Итог применения одного правила. В режиме dry-run Purged - сколько
+// событий было бы удалено.
+type RetentionRuleResult struct {
+	Rule     string    `json:"rule"`
+	KeepDays int       `json:"keep_days"`
+	Cutoff   time.Time `json:"cutoff"`
+	Purged   int64     `json:"purged"`
+}
+
+// RetentionReport - итог одного прогона очистки
+type RetentionReport struct {
+	DryRun     bool                  `json:"dry_run"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at"`
+	Rules      []RetentionRuleResult `json:"rules"`
+	// Месячные секции, удалённые целиком, и число строк в них
+	DroppedPartitions []string `json:"dropped_partitions,omitempty"`
+	DroppedRows       int64    `json:"dropped_rows"`
+	Total             int64    `json:"total"`
+	Error             string   `json:"error,omitempty"`
+}
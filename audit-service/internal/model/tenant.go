@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// TenantQuota - расход квоты арендатора в текущем окне
+type TenantQuota struct {
+	Tenant string `json:"tenant"`
+	// Limit - событий в минуту
+	Limit int `json:"limit"`
+	Used  int `json:"used"`
+	// Rejected - отклонено событий в текущем окне
+	Rejected uint64 `json:"rejected"`
+}
+
+// TenantQuotaReport - расход квот арендаторов всеми репликами в текущем окне
+type TenantQuotaReport struct {
+	WindowStart time.Time     `json:"window_start"`
+	Tenants     []TenantQuota `json:"tenants"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Шаг хронологии: событие и время, прошедшее с начала и с предыдущего шага
+type TimelineStep struct {
+	Event    *AuditEvent `json:"event"`
+	OffsetMs int64       `json:"offset_ms"`
+	GapMs    int64       `json:"gap_ms"`
+	Outcome  string      `json:"outcome"`
+}
+
+// Timeline - события одной сессии или одного запроса в хронологическом порядке
+type Timeline struct {
+	// session или request
+	Kind           string         `json:"kind"`
+	ID             int64          `json:"id"`
+	Start          time.Time      `json:"start"`
+	End            time.Time      `json:"end"`
+	DurationMs     int64          `json:"duration_ms"`
+	EventCount     int            `json:"event_count"`
+	Components     []string       `json:"components"`
+	FirstOperation string         `json:"first_operation"`
+	LastOperation  string         `json:"last_operation"`
+	Outcomes       map[string]int `json:"outcomes"`
+	// Событий больше лимита, в хронологии только самые поздние
+	Truncated bool           `json:"truncated,omitempty"`
+	Steps     []TimelineStep `json:"steps"`
+}
@@ -0,0 +1,99 @@
+package query
+
+import "fmt"
+
+// Node - узел дерева разбора выражения q=
+type Node interface {
+	Pos() int
+}
+
+// Логические операторы
+const (
+	OpAnd = "AND"
+	OpOr  = "OR"
+)
+
+// Операторы сравнения
+const (
+	OpEq      = "="
+	OpNe      = "!="
+	OpLt      = "<"
+	OpLe      = "<="
+	OpGt      = ">"
+	OpGe      = ">="
+	OpPrefix  = "^="
+	OpIn      = "IN"
+	OpNotIn   = "NOT IN"
+	OpBetween = "BETWEEN"
+	// Только для полей attributes и res
+	OpContains  = "@>"
+	OpExists    = "EXISTS"
+	OpNotExists = "NOT EXISTS"
+)
+
+type BinaryExpr struct {
+	Op    string
+	Left  Node
+	Right Node
+	pos   int
+}
+
+func (e *BinaryExpr) Pos() int { return e.pos }
+
+type NotExpr struct {
+	Expr Node
+	pos  int
+}
+
+func (e *NotExpr) Pos() int { return e.pos }
+
+// Comparison сравнивает поле события с одним или несколькими значениями:
+// одно значение для =, !=, <, <=, >, >=, ^=, @>, список для IN и NOT IN,
+// два значения (нижняя и верхняя граница) для BETWEEN, ни одного для
+// EXISTS и NOT EXISTS.
+type Comparison struct {
+	Field  Field
+	Op     string
+	Values []Value
+	pos    int
+}
+
+func (c *Comparison) Pos() int { return c.pos }
+
+// Field - имя поля, для attributes и res с путём через точку (attributes.ip)
+type Field struct {
+	Name string
+	Path []string
+	Pos  int
+}
+
+func (f Field) String() string {
+	s := f.Name
+	for _, p := range f.Path {
+		s += "." + p
+	}
+	return s
+}
+
+// Value - значение из выражения. Для полей attributes и res значение без
+// кавычек типизируется: true/false, null и числа сравниваются как JSON-значения
+// соответствующего типа, всё остальное - как строки.
+type Value struct {
+	Text   string
+	Quoted bool
+	Pos    int
+}
+
+// Error - ошибка разбора или компиляции с позицией (с единицы) в исходной строке
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("query error at position %d: %s", e.Pos, e.Msg)
+}
+
+func Errorf(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,385 @@
+package query
+
+import (
+	"strings"
+)
+
+// Грамматика выражения q=:
+//
+//	expr       := and { OR and }
+//	and        := unary { AND unary }
+//	unary      := NOT unary | primary
+//	primary    := '(' expr ')' | comparison
+//	comparison := field op value
+//	            | field [NOT] IN '(' value { ',' value } ')'
+//	            | field BETWEEN value AND value
+//	            | field [NOT] EXISTS
+//	op         := '=' | '!=' | '<' | '<=' | '>' | '>=' | '^=' | '@>'
+//	value      := слово | "строка" | 'строка'
+//
+// '^=' - сравнение по префиксу. '@>' и EXISTS применимы к attributes и res:
+// attributes.http @> '{"status": 500}', res.error EXISTS.
+//
+// Ключевые слова не зависят от регистра. Значение, совпадающее с ключевым
+// словом, нужно взять в кавычки.
+const (
+	MaxLength = 4096
+	MaxDepth  = 32
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokComma
+	tokOp
+	tokWord
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return "string \"" + t.text + "\""
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+// Parse разбирает выражение q= в дерево
+func Parse(input string) (Node, error) {
+	if len(input) > MaxLength {
+		return nil, Errorf(MaxLength+1, "query is longer than %d characters", MaxLength)
+	}
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	node, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, Errorf(tok.pos, "unexpected %s", tok.describe())
+	}
+
+	return node, nil
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		pos := i + 1
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: pos})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: pos})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: pos})
+			i++
+		case c == '"' || c == '\'':
+			text, n, err := lexString(input[i:], pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: pos})
+			i += n
+		case isContainsOp(input, i):
+			tokens = append(tokens, token{kind: tokOp, text: OpContains, pos: pos})
+			i += len(OpContains)
+		case strings.IndexByte("=!<>^", c) >= 0:
+			op := string(c)
+			if i+1 < len(input) && input[i+1] == '=' {
+				op += "="
+			}
+			switch op {
+			case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe, OpPrefix:
+			default:
+				return nil, Errorf(pos, "unknown operator '%s'", op)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: pos})
+			i += len(op)
+		default:
+			start := i
+			for i < len(input) && !isDelimiter(input[i]) && !isContainsOp(input, i) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokWord, text: input[start:i], pos: pos})
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(input) + 1}), nil
+}
+
+// @ допустим внутри слов (адреса почты), оператором считается только @>
+func isContainsOp(input string, i int) bool {
+	return strings.HasPrefix(input[i:], OpContains)
+}
+
+func isDelimiter(c byte) bool {
+	return strings.IndexByte(" \t\n\r(),\"'=!<>^", c) >= 0
+}
+
+// lexString читает строку в кавычках, внутри которой обратная косая черта
+// экранирует кавычку и саму себя
+func lexString(input string, pos int) (string, int, error) {
+	quote := input[0]
+	var b strings.Builder
+	for i := 1; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == '\\' && i+1 < len(input) && (input[i+1] == quote || input[i+1] == '\\'):
+			b.WriteByte(input[i+1])
+			i++
+		case c == quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, Errorf(pos, "unterminated string")
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.kind == tokWord && strings.EqualFold(tok.text, kw)
+}
+
+func isKeyword(text string) bool {
+	switch strings.ToUpper(text) {
+	case "AND", "OR", "NOT", "IN", "BETWEEN", "EXISTS":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr(depth int) (Node, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword(OpOr) {
+		tok := p.next()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: OpOr, Left: left, Right: right, pos: tok.pos}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (Node, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword(OpAnd) {
+		tok := p.next()
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: OpAnd, Left: left, Right: right, pos: tok.pos}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary(depth int) (Node, error) {
+	if !p.isKeyword("NOT") {
+		return p.parsePrimary(depth)
+	}
+
+	tok := p.next()
+	if depth+1 > MaxDepth {
+		return nil, Errorf(tok.pos, "query is nested deeper than %d levels", MaxDepth)
+	}
+
+	expr, err := p.parseUnary(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotExpr{Expr: expr, pos: tok.pos}, nil
+}
+
+func (p *parser) parsePrimary(depth int) (Node, error) {
+	tok := p.peek()
+
+	switch {
+	case tok.kind == tokLParen:
+		p.next()
+		if depth+1 > MaxDepth {
+			return nil, Errorf(tok.pos, "query is nested deeper than %d levels", MaxDepth)
+		}
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, Errorf(closing.pos, "expected ')' to close '(' at position %d, got %s", tok.pos, closing.describe())
+		}
+		return expr, nil
+	case tok.kind == tokWord && !isKeyword(tok.text):
+		return p.parseComparison()
+	default:
+		return nil, Errorf(tok.pos, "expected field name or '(', got %s", tok.describe())
+	}
+}
+
+func (p *parser) parseComparison() (Node, error) {
+	fieldTok := p.next()
+	field, err := parseField(fieldTok)
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.kind == tokOp:
+		p.next()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &Comparison{Field: field, Op: tok.text, Values: []Value{value}, pos: tok.pos}, nil
+
+	case p.isKeyword("IN"):
+		p.next()
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &Comparison{Field: field, Op: OpIn, Values: values, pos: tok.pos}, nil
+
+	case p.isKeyword("EXISTS"):
+		p.next()
+		return &Comparison{Field: field, Op: OpExists, pos: tok.pos}, nil
+
+	case p.isKeyword("NOT"):
+		p.next()
+		if p.isKeyword("EXISTS") {
+			p.next()
+			return &Comparison{Field: field, Op: OpNotExists, pos: tok.pos}, nil
+		}
+		if !p.isKeyword("IN") {
+			next := p.peek()
+			return nil, Errorf(next.pos, "expected IN or EXISTS after NOT, got %s", next.describe())
+		}
+		p.next()
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &Comparison{Field: field, Op: OpNotIn, Values: values, pos: tok.pos}, nil
+
+	case p.isKeyword("BETWEEN"):
+		p.next()
+		low, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("AND") {
+			next := p.peek()
+			return nil, Errorf(next.pos, "expected AND in BETWEEN, got %s", next.describe())
+		}
+		p.next()
+		high, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &Comparison{Field: field, Op: OpBetween, Values: []Value{low, high}, pos: tok.pos}, nil
+	}
+
+	return nil, Errorf(tok.pos, "expected operator after field '%s', got %s", field, tok.describe())
+}
+
+func (p *parser) parseList() ([]Value, error) {
+	if open := p.next(); open.kind != tokLParen {
+		return nil, Errorf(open.pos, "expected '(' to start a list, got %s", open.describe())
+	}
+
+	var values []Value
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return values, nil
+		}
+		if tok.kind != tokComma {
+			return nil, Errorf(tok.pos, "expected ',' or ')' in list, got %s", tok.describe())
+		}
+	}
+}
+
+func (p *parser) parseValue() (Value, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokString:
+		return Value{Text: tok.text, Quoted: true, Pos: tok.pos}, nil
+	case tok.kind == tokWord && !isKeyword(tok.text):
+		return Value{Text: tok.text, Pos: tok.pos}, nil
+	case tok.kind == tokWord:
+		return Value{}, Errorf(tok.pos, "keyword %s cannot be used as a value, put it in quotes", tok.describe())
+	}
+	return Value{}, Errorf(tok.pos, "expected value, got %s", tok.describe())
+}
+
+func parseField(tok token) (Field, error) {
+	parts := strings.Split(tok.text, ".")
+	for _, part := range parts {
+		if part == "" {
+			return Field{}, Errorf(tok.pos, "invalid field name '%s'", tok.text)
+		}
+	}
+
+	return Field{Name: strings.ToLower(parts[0]), Path: parts[1:], Pos: tok.pos}, nil
+}
@@ -0,0 +1,219 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// render записывает дерево со всеми скобками, чтобы по нему было видно
+// приоритет операторов
+func render(node Node) string {
+	switch n := node.(type) {
+	case *BinaryExpr:
+		return "(" + render(n.Left) + " " + n.Op + " " + render(n.Right) + ")"
+	case *NotExpr:
+		return "(NOT " + render(n.Expr) + ")"
+	case *Comparison:
+		values := make([]string, len(n.Values))
+		for i, v := range n.Values {
+			values[i] = v.Text
+			if v.Quoted {
+				values[i] = strconv.Quote(v.Text)
+			}
+		}
+		switch n.Op {
+		case OpIn, OpNotIn:
+			return n.Field.String() + " " + n.Op + " (" + strings.Join(values, ", ") + ")"
+		case OpBetween:
+			return n.Field.String() + " BETWEEN " + values[0] + " AND " + values[1]
+		case OpExists, OpNotExists:
+			return n.Field.String() + " " + n.Op
+		}
+		return n.Field.String() + " " + n.Op + " " + values[0]
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{`user = alice`, `user = alice`},
+		{`USER = Alice`, `user = Alice`},
+		{`user != alice`, `user != alice`},
+		{`id<10`, `id < 10`},
+		{`id <= 10`, `id <= 10`},
+		{`id>10`, `id > 10`},
+		{`id >= 10`, `id >= 10`},
+		{`op ^= log`, `op ^= log`},
+		{`user = alice@example.com`, `user = alice@example.com`},
+		{`user = "and"`, `user = "and"`},
+		{`user = 'a\'b'`, `user = "a'b"`},
+		{`user = "a\\b"`, `user = "a\\b"`},
+		{`user = "a\nb"`, `user = "a\\nb"`},
+		{`user in (a, 'b', c)`, `user IN (a, "b", c)`},
+		{`user not in (a)`, `user NOT IN (a)`},
+		{`ts between 2024-01-01 and 2024-02-01`, `ts BETWEEN 2024-01-01 AND 2024-02-01`},
+		{`attributes.http.status >= 500`, `attributes.http.status >= 500`},
+		{`attributes.http @> '{"status": 500}'`, `attributes.http @> "{\"status\": 500}"`},
+		{`res.error exists`, `res.error EXISTS`},
+		{`res.error NOT EXISTS`, `res.error NOT EXISTS`},
+
+		// AND связывает сильнее OR, оба левоассоциативны
+		{`a = 1 OR b = 2 AND c = 3`, `(a = 1 OR (b = 2 AND c = 3))`},
+		{`a = 1 AND b = 2 OR c = 3`, `((a = 1 AND b = 2) OR c = 3)`},
+		{`a = 1 OR b = 2 OR c = 3`, `((a = 1 OR b = 2) OR c = 3)`},
+		{`a = 1 AND b = 2 AND c = 3`, `((a = 1 AND b = 2) AND c = 3)`},
+		{`(a = 1 OR b = 2) AND c = 3`, `((a = 1 OR b = 2) AND c = 3)`},
+		// NOT связывает сильнее AND
+		{`NOT a = 1 AND b = 2`, `((NOT a = 1) AND b = 2)`},
+		{`NOT (a = 1 AND b = 2)`, `(NOT (a = 1 AND b = 2))`},
+		{`not not a = 1`, `(NOT (NOT a = 1))`},
+		// AND внутри BETWEEN не считается логическим
+		{`ts BETWEEN 1 AND 2 AND a = 1`, `(ts BETWEEN 1 AND 2 AND a = 1)`},
+		{`((((a = 1))))`, `a = 1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			node, err := Parse(tt.q)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.q, err)
+			}
+			if got := render(node); got != tt.want {
+				t.Fatalf("Parse(%q) = %s, want %s", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	node, err := Parse(`user = a OR  NOT ts IN ("x", y)`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	or := node.(*BinaryExpr)
+	not := or.Right.(*NotExpr)
+	in := not.Expr.(*Comparison)
+	eq := or.Left.(*Comparison)
+
+	positions := []struct {
+		name      string
+		got, want int
+	}{
+		{"OR", or.Pos(), 10},
+		{"NOT", not.Pos(), 14},
+		{"field", in.Field.Pos, 18},
+		{"IN", in.Pos(), 21},
+		{"first value", in.Values[0].Pos, 25},
+		{"second value", in.Values[1].Pos, 30},
+		{"=", eq.Pos(), 6},
+	}
+	for _, p := range positions {
+		if p.got != p.want {
+			t.Errorf("position of %s = %d, want %d", p.name, p.got, p.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		q   string
+		pos int
+		msg string
+	}{
+		{``, 1, "expected field name or '(', got end of query"},
+		{`   `, 4, "expected field name or '(', got end of query"},
+		{`user`, 5, "expected operator after field 'user', got end of query"},
+		{`user alice`, 6, "expected operator after field 'user', got 'alice'"},
+		{`user = `, 8, "expected value, got end of query"},
+		{`user = and`, 8, "keyword 'and' cannot be used as a value, put it in quotes"},
+		{`user = a b`, 10, "unexpected 'b'"},
+		{`user = a)`, 9, "unexpected ')'"},
+		{`(user = a`, 10, "expected ')' to close '(' at position 1, got end of query"},
+		{`user = 'abc`, 8, "unterminated string"},
+		{`user == a`, 6, "unknown operator '=='"},
+		{`user ! a`, 6, "unknown operator '!'"},
+		{`user NOT x`, 10, "expected IN or EXISTS after NOT, got 'x'"},
+		{`ts BETWEEN a OR b`, 14, "expected AND in BETWEEN, got 'OR'"},
+		{`user IN a`, 9, "expected '(' to start a list, got 'a'"},
+		{`user IN (a b)`, 12, "expected ',' or ')' in list, got 'b'"},
+		{`user IN ()`, 10, "expected value, got ')'"},
+		{`a..b = 1`, 1, "invalid field name 'a..b'"},
+		{`AND = 1`, 1, "expected field name or '(', got 'AND'"},
+		{`user = a AND`, 13, "expected field name or '(', got end of query"},
+		{`"user" = a`, 1, "expected field name or '(', got string \"user\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			_, err := Parse(tt.q)
+			assertError(t, err, tt.pos, tt.msg)
+		})
+	}
+}
+
+func TestParseMaxLength(t *testing.T) {
+	base := `user = a`
+
+	q := base + strings.Repeat(" ", MaxLength-len(base))
+	if _, err := Parse(q); err != nil {
+		t.Fatalf("query of %d characters rejected: %v", len(q), err)
+	}
+
+	_, err := Parse(q + " ")
+	assertError(t, err, MaxLength+1, fmt.Sprintf("query is longer than %d characters", MaxLength))
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	parens := func(depth int) string {
+		return strings.Repeat("(", depth) + "a = 1" + strings.Repeat(")", depth)
+	}
+	nots := func(depth int) string {
+		return strings.Repeat("NOT ", depth) + "a = 1"
+	}
+	msg := fmt.Sprintf("query is nested deeper than %d levels", MaxDepth)
+
+	if _, err := Parse(parens(MaxDepth)); err != nil {
+		t.Fatalf("%d nested parentheses rejected: %v", MaxDepth, err)
+	}
+	_, err := Parse(parens(MaxDepth + 1))
+	assertError(t, err, MaxDepth+1, msg)
+
+	if _, err := Parse(nots(MaxDepth)); err != nil {
+		t.Fatalf("%d nested NOT rejected: %v", MaxDepth, err)
+	}
+	_, err = Parse(nots(MaxDepth + 1))
+	assertError(t, err, 4*MaxDepth+1, msg)
+
+	// Скобки и NOT считаются вместе
+	mixed := strings.Repeat("NOT (", MaxDepth/2) + "a = 1" + strings.Repeat(")", MaxDepth/2)
+	if _, err := Parse(mixed); err != nil {
+		t.Fatalf("%d mixed levels rejected: %v", MaxDepth, err)
+	}
+	_, err = Parse("(" + mixed + ")")
+	assertError(t, err, 5*(MaxDepth/2)+1, msg)
+
+	// Глубина - это вложенность, а не число скобок
+	siblings := strings.TrimSuffix(strings.Repeat(parens(MaxDepth)+" OR ", 3), " OR ")
+	if _, err := Parse(siblings); err != nil {
+		t.Fatalf("sibling groups rejected: %v", err)
+	}
+}
+
+func assertError(t *testing.T, err error, pos int, msg string) {
+	t.Helper()
+
+	var qerr *Error
+	if !errors.As(err, &qerr) {
+		t.Fatalf("expected query error %q at %d, got %v", msg, pos, err)
+	}
+	if qerr.Pos != pos || qerr.Msg != msg {
+		t.Fatalf("got error %q at %d, want %q at %d", qerr.Msg, qerr.Pos, msg, pos)
+	}
+}
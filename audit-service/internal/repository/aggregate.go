@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"audit-service/internal/encryption"
+	"audit-service/internal/model"
+
+	"github.com/lib/pq"
+)
+
+// Максимальное число временных корзин в ответе
+const maxAggregateBuckets = 10000
+
+// Поля, по которым можно группировать, кроме путей в attributes и res
+var groupByColumns = map[string]string{
+	"user":      "user_id",
+	"component": "component",
+	"op":        "operation",
+	"operation": "operation",
+	"actor":     "actor",
+	"tenant":    "tenant_id",
+}
+
+// AggregateEvents считает события по фильтрам целиком в Postgres: общее
+// число и число различных пользователей, top-N значений GroupBy, а при
+// заданном Interval - то же самое по временным корзинам date_trunc.
+func (r *postgresRepository) AggregateEvents(ctx context.Context, req model.AggregateRequest) (*model.AggregateResult, error) {
+	req.Filters = scopeFilters(ctx, req.Filters)
+
+	var result *model.AggregateResult
+	err := readTenant(ctx, r.db, func(q queryer) error {
+		var err error
+		result, err = r.aggregate(ctx, q, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *postgresRepository) aggregate(ctx context.Context, q queryer, req model.AggregateRequest) (*model.AggregateResult, error) {
+	conditions, args, err := buildFilterConditions(req.Filters, r.enc)
+	if err != nil {
+		return nil, err
+	}
+	where := whereClause(conditions)
+
+	result := &model.AggregateResult{GroupBy: req.GroupBy, Interval: req.Interval}
+
+	totalsQuery := "SELECT count(*), count(DISTINCT user_id) FROM audit_events" + where
+	if err := q.QueryRowContext(ctx, totalsQuery, args...).Scan(&result.Total, &result.DistinctUsers); err != nil {
+		return nil, fmt.Errorf("failed to aggregate events: %w", err)
+	}
+
+	var groupExpr string
+	if req.GroupBy != "" {
+		groupExpr, args, err = groupByExpr(req.GroupBy, args, r.enc)
+		if err != nil {
+			return nil, err
+		}
+
+		topQuery := fmt.Sprintf(
+			"SELECT %s AS value, count(*) FROM audit_events%s GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $%d",
+			groupExpr, where, len(args)+1)
+		result.Top, err = queryGroupCounts(ctx, q, topQuery, append(args, req.Top)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Interval == "" {
+		return result, nil
+	}
+
+	unit := model.AggregateIntervals[req.Interval]
+	bucketExpr := fmt.Sprintf("date_trunc('%s', timestamp)", unit)
+
+	bucketsQuery := fmt.Sprintf(
+		"SELECT %s, count(*), count(DISTINCT user_id) FROM audit_events%s GROUP BY 1 ORDER BY 1 LIMIT %d",
+		bucketExpr, where, maxAggregateBuckets)
+	rows, err := q.QueryContext(ctx, bucketsQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate events by time: %w", err)
+	}
+	defer rows.Close()
+
+	index := make(map[int64]int)
+	for rows.Next() {
+		var bucket model.AggregateBucket
+		if err := rows.Scan(&bucket.Time, &bucket.Count, &bucket.DistinctUsers); err != nil {
+			return nil, fmt.Errorf("failed to scan bucket: %w", err)
+		}
+		index[bucket.Time.UnixNano()] = len(result.Buckets)
+		result.Buckets = append(result.Buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if groupExpr == "" {
+		return result, nil
+	}
+
+	// Top-N значений внутри каждой корзины через оконную функцию
+	bucketTopQuery := fmt.Sprintf(`
+        SELECT bucket, value, cnt FROM (
+            SELECT %s AS bucket, %s AS value, count(*) AS cnt,
+                   row_number() OVER (PARTITION BY %s ORDER BY count(*) DESC, %s) AS rn
+            FROM audit_events%s
+            GROUP BY 1, 2
+        ) t
+        WHERE rn <= $%d
+        ORDER BY bucket, rn`,
+		bucketExpr, groupExpr, bucketExpr, groupExpr, where, len(args)+1)
+	topRows, err := q.QueryContext(ctx, bucketTopQuery, append(args, req.Top)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate top values by time: %w", err)
+	}
+	defer topRows.Close()
+
+	for topRows.Next() {
+		var bucketTime time.Time
+		var value sql.NullString
+		var count int64
+		if err := topRows.Scan(&bucketTime, &value, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan bucket top value: %w", err)
+		}
+		i, ok := index[bucketTime.UnixNano()]
+		if !ok {
+			continue
+		}
+		result.Buckets[i].Top = append(result.Buckets[i].Top, model.GroupCount{Value: nullStringPtr(value), Count: count})
+	}
+	if err := topRows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return result, nil
+}
+
+func queryGroupCounts(ctx context.Context, q queryer, query string, args ...interface{}) ([]model.GroupCount, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate top values: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []model.GroupCount
+	for rows.Next() {
+		var value sql.NullString
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan top value: %w", err)
+		}
+		counts = append(counts, model.GroupCount{Value: nullStringPtr(value), Count: count})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return counts, nil
+}
+
+// groupByExpr возвращает SQL-выражение для группировки; путь внутри JSONB
+// передаётся параметром. Зашифрованные поля в Postgres не сгруппировать.
+func groupByExpr(groupBy string, args []interface{}, enc *encryption.Encryptor) (string, []interface{}, error) {
+	if column, ok := groupByColumns[groupBy]; ok {
+		return column, args, nil
+	}
+
+	parts := strings.Split(groupBy, ".")
+	column, ok := jsonColumns[parts[0]]
+	if !ok || len(parts) < 2 {
+		return "", nil, fmt.Errorf("cannot group by '%s'", groupBy)
+	}
+	if field, ok := enc.Field(column, parts[1:]); ok {
+		return "", nil, &EncryptedFieldError{Field: field.String(), Reason: "events cannot be grouped by it"}
+	}
+	args = append(args, pq.Array(parts[1:]))
+	return fmt.Sprintf("%s #>> $%d::text[]", column, len(args)), args, nil
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"audit-service/internal/model"
+
+	"github.com/lib/pq"
+)
+
+// ErrAPIKeyNotFound - ключа с запрошенным id нет
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyRepository хранит ключи API вместе с хешами. Реализации есть для
+// всех хранилищ событий.
+type APIKeyRepository interface {
+	CreateKey(ctx context.Context, key *model.APIKey, hash []byte) error
+	// GetKey возвращает ключ и его хеш, ErrAPIKeyNotFound - ключа нет
+	GetKey(ctx context.Context, id string) (*model.APIKey, []byte, error)
+	ListKeys(ctx context.Context) ([]*model.APIKey, error)
+	// RevokeKey отзывает ключ. Повторный отзыв время отзыва не меняет.
+	RevokeKey(ctx context.Context, id string, at time.Time) error
+}
+
+type postgresAPIKeyRepository struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &postgresAPIKeyRepository{db: db}
+}
+
+const apiKeyColumns = "id, name, scopes, COALESCE(tenant_id, ''), COALESCE(created_by, ''), created_at, revoked_at"
+
+func (r *postgresAPIKeyRepository) CreateKey(ctx context.Context, key *model.APIKey, hash []byte) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO api_keys (id, name, key_hash, scopes, tenant_id, created_by, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, key.ID, key.Name, hash, pq.Array(key.Scopes), nullableString(key.Tenant), nullableString(key.CreatedBy), key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresAPIKeyRepository) GetKey(ctx context.Context, id string) (*model.APIKey, []byte, error) {
+	var hash []byte
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx,
+		"SELECT "+apiKeyColumns+", key_hash FROM api_keys WHERE id = $1", id), &hash)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return key, hash, nil
+}
+
+func (r *postgresAPIKeyRepository) ListKeys(ctx context.Context) ([]*model.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *postgresAPIKeyRepository) RevokeKey(ctx context.Context, id string, at time.Time) error {
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1", id, at)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner, extra ...interface{}) (*model.APIKey, error) {
+	var key model.APIKey
+	var revokedAt sql.NullTime
+	dest := append([]interface{}{&key.ID, &key.Name, pq.Array(&key.Scopes), &key.Tenant, &key.CreatedBy, &key.CreatedAt, &revokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
+
+// memoryAPIKeyRepository - ключи хранилища в памяти, теряются при
+// перезапуске вместе с событиями
+type memoryAPIKeyRepository struct {
+	mu     sync.RWMutex
+	keys   map[string]*model.APIKey
+	hashes map[string][]byte
+}
+
+func NewMemoryAPIKeyRepository() APIKeyRepository {
+	return &memoryAPIKeyRepository{
+		keys:   make(map[string]*model.APIKey),
+		hashes: make(map[string][]byte),
+	}
+}
+
+func (r *memoryAPIKeyRepository) CreateKey(ctx context.Context, key *model.APIKey, hash []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[key.ID]; ok {
+		return fmt.Errorf("failed to create api key: id %s already exists", key.ID)
+	}
+	r.keys[key.ID] = cloneAPIKey(key)
+	r.hashes[key.ID] = append([]byte(nil), hash...)
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) GetKey(ctx context.Context, id string) (*model.APIKey, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+	return cloneAPIKey(key), r.hashes[id], nil
+}
+
+func (r *memoryAPIKeyRepository) ListKeys(ctx context.Context) ([]*model.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]*model.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, cloneAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (r *memoryAPIKeyRepository) RevokeKey(ctx context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		at := storedTime(at)
+		key.RevokedAt = &at
+	}
+	return nil
+}
+
+func cloneAPIKey(key *model.APIKey) *model.APIKey {
+	clone := *key
+	clone.Scopes = append([]string(nil), key.Scopes...)
+	clone.CreatedAt = storedTime(key.CreatedAt)
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		clone.RevokedAt = &revokedAt
+	}
+	clone.Key = ""
+	return &clone
+}
+
+// sqliteAPIKeyRepository хранит права через пробел, время - как в
+// audit_events
+type sqliteAPIKeyRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &sqliteAPIKeyRepository{db: db}
+}
+
+const sqliteAPIKeyColumns = "id, name, scopes, COALESCE(tenant_id, ''), COALESCE(created_by, ''), created_at, revoked_at"
+
+func (r *sqliteAPIKeyRepository) CreateKey(ctx context.Context, key *model.APIKey, hash []byte) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO api_keys (id, name, key_hash, scopes, tenant_id, created_by, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, key.ID, key.Name, hash, strings.Join(key.Scopes, " "), nullableString(key.Tenant), nullableString(key.CreatedBy), sqliteTime(key.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	return nil
+}
+
+func (r *sqliteAPIKeyRepository) GetKey(ctx context.Context, id string) (*model.APIKey, []byte, error) {
+	var hash []byte
+	key, err := scanSQLiteAPIKey(r.db.QueryRowContext(ctx,
+		"SELECT "+sqliteAPIKeyColumns+", key_hash FROM api_keys WHERE id = ?", id), &hash)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	return key, hash, nil
+}
+
+func (r *sqliteAPIKeyRepository) ListKeys(ctx context.Context) ([]*model.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+sqliteAPIKeyColumns+" FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*model.APIKey{}
+	for rows.Next() {
+		key, err := scanSQLiteAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *sqliteAPIKeyRepository) RevokeKey(ctx context.Context, id string, at time.Time) error {
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", sqliteTime(at), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func scanSQLiteAPIKey(row rowScanner, extra ...interface{}) (*model.APIKey, error) {
+	var key model.APIKey
+	var scopes, createdAt string
+	var revokedAt sql.NullString
+	dest := append([]interface{}{&key.ID, &key.Name, &scopes, &key.Tenant, &key.CreatedBy, &createdAt, &revokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	var err error
+	if key.CreatedAt, err = time.ParseInLocation(sqliteTimeLayout, createdAt, time.UTC); err != nil {
+		return nil, fmt.Errorf("invalid created_at %q: %w", createdAt, err)
+	}
+	if revokedAt.Valid {
+		t, err := time.ParseInLocation(sqliteTimeLayout, revokedAt.String, time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("invalid revoked_at %q: %w", revokedAt.String, err)
+		}
+		key.RevokedAt = &t
+	}
+	return &key, nil
+}
@@ -0,0 +1,279 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"audit-service/internal/model"
+
+	"github.com/lib/pq"
+)
+
+type ArchiveRepository struct {
+	db *sql.DB
+}
+
+func NewArchiveRepository(db *sql.DB) *ArchiveRepository {
+	return &ArchiveRepository{db: db}
+}
+
+// ArchiveSession - операции переноса событий в архив и обратно на
+// соединении, которое держит advisory-блокировку архива
+type ArchiveSession struct {
+	conn *sql.Conn
+}
+
+// WithLock выполняет fn под блокировкой архива. Если архивом уже занимается
+// другая реплика, fn не вызывается и возвращается false.
+func (r *ArchiveRepository) WithLock(ctx context.Context, fn func(s *ArchiveSession) error) (bool, error) {
+	return withAdvisoryLock(ctx, r.db, archiveLockKey, func(conn *sql.Conn) error {
+		return fn(&ArchiveSession{conn: conn})
+	})
+}
+
+type archiveHold struct {
+	start, end time.Time
+}
+
+// NextChunk находит самый ранний день с событиями старше before, не
+// попадающими в действующие удержания, и возвращает диапазон [from, to)
+// этого дня, который можно архивировать. ok=false - архивировать нечего.
+func (s *ArchiveSession) NextChunk(ctx context.Context, before, now time.Time) (from, to time.Time, ok bool, err error) {
+	holds, err := s.activeHolds(ctx, now)
+	if err != nil {
+		return from, to, false, err
+	}
+
+	var cursor time.Time
+	for {
+		var oldest sql.NullTime
+		err := s.conn.QueryRowContext(ctx,
+			"SELECT min(timestamp) FROM audit_events WHERE timestamp >= $1 AND timestamp < $2", cursor, before,
+		).Scan(&oldest)
+		if err != nil {
+			return from, to, false, fmt.Errorf("failed to find oldest event: %w", err)
+		}
+		if !oldest.Valid {
+			return from, to, false, nil
+		}
+
+		ts := oldest.Time
+		held := false
+		for _, hold := range holds {
+			if !ts.Before(hold.start) && ts.Before(hold.end) {
+				cursor = hold.end
+				held = true
+				break
+			}
+		}
+		if held {
+			continue
+		}
+
+		from = time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)
+		to = from.AddDate(0, 0, 1)
+		if before.Before(to) {
+			to = before
+		}
+		// Кусок не должен заходить в следующее удержание
+		for _, hold := range holds {
+			if hold.start.After(ts) && hold.start.Before(to) {
+				to = hold.start
+			}
+		}
+		return from, to, true, nil
+	}
+}
+
+func (s *ArchiveSession) activeHolds(ctx context.Context, now time.Time) ([]archiveHold, error) {
+	rows, err := s.conn.QueryContext(ctx,
+		"SELECT range_start, range_end FROM archive_holds WHERE hold_until > $1 ORDER BY range_start", now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load archive holds: %w", err)
+	}
+	defer rows.Close()
+
+	var holds []archiveHold
+	for rows.Next() {
+		var hold archiveHold
+		if err := rows.Scan(&hold.start, &hold.end); err != nil {
+			return nil, fmt.Errorf("failed to scan archive hold: %w", err)
+		}
+		holds = append(holds, hold)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return holds, nil
+}
+
+// EventsInRange возвращает до limit событий из [from, to) в порядке id так,
+// как они хранятся: зашифрованные поля попадают в архив зашифрованными
+func (s *ArchiveSession) EventsInRange(ctx context.Context, from, to time.Time, limit int) ([]*model.AuditEvent, error) {
+	query := "SELECT " + eventColumns + " FROM audit_events WHERE timestamp >= $1 AND timestamp < $2 ORDER BY id LIMIT $3"
+
+	rows, err := s.conn.QueryContext(ctx, query, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events to archive: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*model.AuditEvent
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return events, nil
+}
+
+// DeleteEvents удаляет заархивированные события из [from, to) вместе с их
+// идентичностями, запоминая хеши их звеньев, и возвращает число удалённых
+// событий
+func (s *ArchiveSession) DeleteEvents(ctx context.Context, from, to time.Time, events []*model.AuditEvent) (int64, error) {
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+
+	tx, err := beginPurge(ctx, s.conn)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Диапазон времени рядом с id позволяет отсечь лишние секции
+	var deleted int64
+	err = tx.QueryRowContext(ctx, `
+        WITH deleted AS (
+            DELETE FROM audit_events WHERE timestamp >= $1 AND timestamp < $2 AND id = ANY($3)
+            RETURNING chain_seq, hash
+        ), pruned AS (
+            INSERT INTO audit_chain_pruned (chain_seq, hash)
+            SELECT chain_seq, hash FROM deleted WHERE chain_seq IS NOT NULL
+            ON CONFLICT DO NOTHING
+        )
+        SELECT count(*) FROM deleted
+    `, from, to, pq.Array(ids)).Scan(&deleted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived events: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM audit_event_identities WHERE timestamp >= $1 AND timestamp < $2 AND id = ANY($3)", from, to, pq.Array(ids))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived event identities: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit archived events deletion: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// RestoreEvents возвращает события из архива в audit_events с исходными id,
+// created_at и звеньями цепочки хешей. Уже присутствующие события
+// пропускаются. Возвращает число вставленных.
+func (s *ArchiveSession) RestoreEvents(ctx context.Context, events []*model.AuditEvent) (int64, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	eventStmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO audit_events
+        (id, event_id, idempotency_key, timestamp, user_id, component, operation, session_id, request_id, response, attributes,
+         created_at, chain_seq, prev_hash, hash, enc_key_id, enc_dek, actor, tenant_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+        ON CONFLICT DO NOTHING
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare restore: %w", err)
+	}
+	defer eventStmt.Close()
+
+	// Ключ идемпотентности мог за это время занять другой event: тогда
+	// восстановленное событие остаётся без идентичности, но само не теряется
+	identityStmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO audit_event_identities (event_id, idempotency_key, id, timestamp, tenant_id)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT DO NOTHING
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare identity restore: %w", err)
+	}
+	defer identityStmt.Close()
+
+	var restored int64
+	for _, event := range events {
+		res, err := eventStmt.ExecContext(ctx,
+			event.ID,
+			nullableString(event.EventID),
+			nullableString(event.IdempotencyKey),
+			event.Timestamp,
+			event.User,
+			event.Component,
+			event.Operation,
+			event.SessionID,
+			event.RequestID,
+			event.Response,
+			event.Attributes,
+			event.CreatedAt,
+			nullableSeq(event.ChainSeq),
+			hashBytes(event.PrevHash),
+			hashBytes(event.Hash),
+			nullableString(event.EncKeyID),
+			hashBytes(event.EncDEK),
+			nullableString(event.Actor),
+			event.TenantID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to restore audit event %d: %w", event.ID, err)
+		}
+		n, _ := res.RowsAffected()
+		restored += n
+		if n == 0 || event.EventID == "" {
+			continue
+		}
+
+		_, err = identityStmt.ExecContext(ctx, event.EventID, nullableString(event.IdempotencyKey), event.ID, event.Timestamp, event.TenantID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to restore event identity %d: %w", event.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit restored events: %w", err)
+	}
+
+	return restored, nil
+}
+
+// AddHold запрещает архивировать события из [from, to) до until
+func (s *ArchiveSession) AddHold(ctx context.Context, from, to, until time.Time) error {
+	_, err := s.conn.ExecContext(ctx,
+		"INSERT INTO archive_holds (range_start, range_end, hold_until) VALUES ($1, $2, $3)", from, to, until)
+	if err != nil {
+		return fmt.Errorf("failed to save archive hold: %w", err)
+	}
+	return nil
+}
+
+// nullableSeq - номер звена или NULL у событий вне цепочки
+func nullableSeq(seq int64) interface{} {
+	if seq == 0 {
+		return nil
+	}
+	return seq
+}
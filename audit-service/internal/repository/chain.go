@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"audit-service/internal/encryption"
+	"audit-service/internal/model"
+	"audit-service/internal/tenant"
+)
+
+// lockChainHead блокирует голову цепочки хешей до конца транзакции и
+// возвращает номер и хеш последнего звена. Все вставки в audit_events
+// проходят через эту блокировку, поэтому звенья идут в порядке коммитов.
+// Блокировку нужно брать до вставки в audit_event_identities, иначе две
+// транзакции могут ждать друг друга.
+func lockChainHead(ctx context.Context, tx *sql.Tx) (int64, string, error) {
+	var seq int64
+	var hash string
+	err := tx.QueryRowContext(ctx,
+		"SELECT seq, encode(hash, 'hex') FROM audit_chain_head WHERE id = 1 FOR UPDATE",
+	).Scan(&seq, &hash)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to lock chain head: %w", err)
+	}
+	return seq, hash, nil
+}
+
+func saveChainHead(ctx context.Context, tx *sql.Tx, seq int64, hash string) error {
+	_, err := tx.ExecContext(ctx, "UPDATE audit_chain_head SET seq = $1, hash = $2 WHERE id = 1", seq, hashBytes(hash))
+	if err != nil {
+		return fmt.Errorf("failed to update chain head: %w", err)
+	}
+	return nil
+}
+
+// hashBytes переводит hex-хеш в значение для колонки BYTEA
+func hashBytes(hash string) interface{} {
+	if hash == "" {
+		return nil
+	}
+	b, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// transactionTime возвращает время начала транзакции, его же получает
+// created_at по умолчанию
+func transactionTime(ctx context.Context, tx *sql.Tx) (time.Time, error) {
+	var createdAt time.Time
+	if err := tx.QueryRowContext(ctx, "SELECT NOW()::timestamp").Scan(&createdAt); err != nil {
+		return createdAt, fmt.Errorf("failed to get transaction timestamp: %w", err)
+	}
+	return createdAt, nil
+}
+
+// ChainRepository отдаёт звенья расшифрованными: хеш звена считается по
+// открытым значениям полей
+type ChainRepository struct {
+	db  *sql.DB
+	enc *encryption.Encryptor
+}
+
+func NewChainRepository(db *sql.DB, enc *encryption.Encryptor) *ChainRepository {
+	return &ChainRepository{db: db, enc: enc}
+}
+
+// Range переводит диапазон времени создания событий в диапазон номеров
+// звеньев: от первого звена, созданного не раньше from, до последнего,
+// созданного не позже to. Без from - с начала цепочки, без to - до её
+// головы. first = 0 - в диапазоне нет звеньев. Цепочка общая для всех
+// арендаторов, поэтому звенья читаются без ограничения арендатором.
+func (r *ChainRepository) Range(ctx context.Context, from, to *time.Time) (first, last int64, err error) {
+	err = readTenant(tenant.WithAll(ctx), r.db, func(q queryer) error {
+		first, last, err = chainRange(ctx, q, from, to)
+		return err
+	})
+	return first, last, err
+}
+
+func chainRange(ctx context.Context, q queryer, from, to *time.Time) (first, last int64, err error) {
+	if from == nil {
+		first = 1
+	} else {
+		err = q.QueryRowContext(ctx, `
+            SELECT chain_seq FROM audit_events
+            WHERE created_at >= $1 AND chain_seq IS NOT NULL
+            ORDER BY created_at, chain_seq LIMIT 1
+        `, *from).Scan(&first)
+		if err == sql.ErrNoRows {
+			return 0, 0, nil
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to find first chain link: %w", err)
+		}
+	}
+
+	if to == nil {
+		err = q.QueryRowContext(ctx, "SELECT seq FROM audit_chain_head WHERE id = 1").Scan(&last)
+	} else {
+		err = q.QueryRowContext(ctx, `
+            SELECT chain_seq FROM audit_events
+            WHERE created_at <= $1 AND chain_seq IS NOT NULL
+            ORDER BY created_at DESC, chain_seq DESC LIMIT 1
+        `, *to).Scan(&last)
+	}
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find last chain link: %w", err)
+	}
+	if last < first {
+		return 0, 0, nil
+	}
+
+	return first, last, nil
+}
+
+// Links возвращает звенья с номерами из [fromSeq, toSeq]: сохранённые
+// события всех арендаторов в порядке номеров и хеши штатно удалённых звеньев
+// по номерам
+func (r *ChainRepository) Links(ctx context.Context, fromSeq, toSeq int64) (events []*model.AuditEvent, pruned map[int64]string, err error) {
+	err = readTenant(tenant.WithAll(ctx), r.db, func(q queryer) error {
+		events, pruned, err = r.links(ctx, q, fromSeq, toSeq)
+		return err
+	})
+	return events, pruned, err
+}
+
+func (r *ChainRepository) links(ctx context.Context, q queryer, fromSeq, toSeq int64) ([]*model.AuditEvent, map[int64]string, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT "+eventColumns+" FROM audit_events WHERE chain_seq BETWEEN $1 AND $2 ORDER BY chain_seq", fromSeq, toSeq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query chain links: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*model.AuditEvent
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan chain link: %w", err)
+		}
+		if err := r.enc.Open(event); err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	prunedRows, err := q.QueryContext(ctx,
+		"SELECT chain_seq, encode(hash, 'hex') FROM audit_chain_pruned WHERE chain_seq BETWEEN $1 AND $2", fromSeq, toSeq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query pruned chain links: %w", err)
+	}
+	defer prunedRows.Close()
+
+	pruned := make(map[int64]string)
+	for prunedRows.Next() {
+		var seq int64
+		var hash string
+		if err := prunedRows.Scan(&seq, &hash); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan pruned chain link: %w", err)
+		}
+		pruned[seq] = hash
+	}
+	if err := prunedRows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return events, pruned, nil
+}
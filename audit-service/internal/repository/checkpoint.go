@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"audit-service/internal/model"
+)
+
+// ErrCheckpointNotFound - звено ещё не вошло ни в одну контрольную точку
+var ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+const checkpointColumns = `id, first_seq, last_seq, encode(root, 'hex'), encode(last_hash, 'hex'),
+    encode(signature, 'hex'), encode(public_key, 'hex'), created_at`
+
+type CheckpointRepository struct {
+	db *sql.DB
+}
+
+func NewCheckpointRepository(db *sql.DB) *CheckpointRepository {
+	return &CheckpointRepository{db: db}
+}
+
+// WithLock выполняет fn под блокировкой построения контрольных точек. Если
+// точки строит другая реплика, fn не вызывается и возвращается false.
+// Повторную запись той же точки не допускает и уникальность first_seq,
+// блокировка лишь избавляет реплики от одинаковой работы.
+func (r *CheckpointRepository) WithLock(ctx context.Context, fn func() error) (bool, error) {
+	return withAdvisoryLock(ctx, r.db, checkpointLockKey, func(*sql.Conn) error {
+		return fn()
+	})
+}
+
+// Head возвращает номер последнего звена цепочки
+func (r *CheckpointRepository) Head(ctx context.Context) (int64, error) {
+	var seq int64
+	if err := r.db.QueryRowContext(ctx, "SELECT seq FROM audit_chain_head WHERE id = 1").Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to get chain head: %w", err)
+	}
+	return seq, nil
+}
+
+// Last возвращает последнюю контрольную точку или nil, если точек ещё нет
+func (r *CheckpointRepository) Last(ctx context.Context) (*model.Checkpoint, error) {
+	cp, err := scanCheckpoint(r.db.QueryRowContext(ctx,
+		"SELECT "+checkpointColumns+" FROM audit_checkpoints ORDER BY last_seq DESC LIMIT 1"))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// Covering возвращает контрольную точку, в окно которой входит звено seq
+func (r *CheckpointRepository) Covering(ctx context.Context, seq int64) (*model.Checkpoint, error) {
+	cp, err := scanCheckpoint(r.db.QueryRowContext(ctx,
+		"SELECT "+checkpointColumns+" FROM audit_checkpoints WHERE first_seq <= $1 AND last_seq >= $1", seq))
+	if err == sql.ErrNoRows {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// List возвращает до limit последних контрольных точек, новые первыми
+func (r *CheckpointRepository) List(ctx context.Context, limit int) ([]*model.Checkpoint, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+checkpointColumns+" FROM audit_checkpoints ORDER BY last_seq DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := []*model.Checkpoint{}
+	for rows.Next() {
+		cp, err := scanCheckpoint(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan checkpoint: %w", err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return checkpoints, nil
+}
+
+// Save записывает контрольную точку и заполняет её id и created_at
+func (r *CheckpointRepository) Save(ctx context.Context, cp *model.Checkpoint) error {
+	err := r.db.QueryRowContext(ctx, `
+        INSERT INTO audit_checkpoints (first_seq, last_seq, root, last_hash, signature, public_key)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `, cp.FirstSeq, cp.LastSeq, hashBytes(cp.Root), hashBytes(cp.LastHash), hashBytes(cp.Signature), hashBytes(cp.PublicKey),
+	).Scan(&cp.ID, &cp.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+func scanCheckpoint(row rowScanner) (*model.Checkpoint, error) {
+	var cp model.Checkpoint
+	err := row.Scan(&cp.ID, &cp.FirstSeq, &cp.LastSeq, &cp.Root, &cp.LastHash, &cp.Signature, &cp.PublicKey, &cp.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
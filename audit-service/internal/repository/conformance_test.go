@@ -0,0 +1,788 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"audit-service/db"
+	"audit-service/internal/chain"
+	"audit-service/internal/model"
+	"audit-service/internal/query"
+	"audit-service/internal/tenant"
+	"audit-service/pkg/sqlite"
+)
+
+// Проверка совместимости хранилищ: одни и те же события и запросы должны
+// давать одинаковый результат во всех реализациях AuditRepository. Эталон -
+// хранилище в памяти, его ответы на часть запросов проверены явно. Postgres
+// проверяется, если в AUDIT_TEST_POSTGRES_DSN задана пустая база.
+
+type conformanceBackend struct {
+	name string
+	repo AuditRepository
+	// event_id фикстуры -> id, выданный хранилищем
+	ids map[string]int64
+	// Соединение Postgres для проверок, которые есть только у него
+	db *sql.DB
+}
+
+func conformanceBackends(t *testing.T) []*conformanceBackend {
+	backends := []*conformanceBackend{{name: "memory", repo: NewMemoryRepository()}}
+
+	conn, err := sqlite.NewConnection(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := db.RunSQLiteMigrations(conn); err != nil {
+		t.Fatalf("failed to migrate sqlite: %v", err)
+	}
+	backends = append(backends, &conformanceBackend{name: "sqlite", repo: NewSQLiteRepository(conn)})
+
+	if dsn := os.Getenv("AUDIT_TEST_POSTGRES_DSN"); dsn != "" {
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatalf("failed to open postgres: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := db.RunMigrations(conn); err != nil {
+			t.Fatalf("failed to migrate postgres: %v", err)
+		}
+		// Таблица только для добавления, очистить её тест не может
+		var count int
+		if err := conn.QueryRow("SELECT count(*) FROM audit_events").Scan(&count); err != nil {
+			t.Fatalf("failed to count postgres events: %v", err)
+		}
+		if count > 0 {
+			t.Fatalf("AUDIT_TEST_POSTGRES_DSN must point to an empty database, audit_events has %d rows", count)
+		}
+		backends = append(backends, &conformanceBackend{name: "postgres", repo: NewAuditRepository(conn, nil), db: conn})
+	}
+
+	return backends
+}
+
+func fixtureID(n int) string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", n)
+}
+
+func fixtureJSON(s string) *model.JSONB {
+	if s == "" {
+		return nil
+	}
+	var j model.JSONB
+	if err := json.Unmarshal([]byte(s), &j); err != nil {
+		panic(err)
+	}
+	return &j
+}
+
+func strPtr(s string) *string { return &s }
+
+func int64Ptr(n int64) *int64 { return &n }
+
+func conformanceFixtures() []*model.AuditEvent {
+	at := func(day, hour, min int) time.Time { return time.Date(2024, 3, day, hour, min, 0, 0, time.UTC) }
+	event := func(n int, ts time.Time, user string, component *string, op string, session, request *int64, attributes, response string) *model.AuditEvent {
+		return &model.AuditEvent{
+			EventID:    fixtureID(n),
+			Timestamp:  ts,
+			User:       user,
+			Component:  component,
+			Operation:  op,
+			SessionID:  session,
+			RequestID:  request,
+			Attributes: fixtureJSON(attributes),
+			Response:   fixtureJSON(response),
+		}
+	}
+
+	events := []*model.AuditEvent{
+		event(1, at(1, 10, 0), "alice", strPtr("api"), "login", int64Ptr(1), int64Ptr(100),
+			`{"ip": "10.0.0.1", "http": {"status": 200, "method": "GET"}, "tags": ["a", "b"]}`, `{"ok": true}`),
+		event(2, at(1, 10, 5), "bob", strPtr("api"), "login", int64Ptr(2), int64Ptr(101),
+			`{"ip": "10.0.0.2", "http": {"status": 500}, "tags": ["b"]}`, `{"ok": false, "error": "boom"}`),
+		event(3, at(1, 10, 5), "alice", strPtr("web"), "view", int64Ptr(1), int64Ptr(102),
+			`{"http": {"status": 404}, "retries": 3}`, `{"items": [{"id": 1}, {"id": 2}]}`),
+		event(4, at(1, 10, 30), "carol", nil, "logout", nil, nil, "", ""),
+		event(5, at(1, 11, 0), "alice", strPtr("api"), "delete", int64Ptr(3), int64Ptr(103),
+			`{"ip": null, "level": 2.5, "flag": false}`, `{"error": null}`),
+		event(6, at(1, 11, 45), "dave", strPtr("db"), "query", nil, int64Ptr(104),
+			`{"http": [{"status": 500}, {"status": 200}], "nested": {"a": {"b": "deep"}}}`, `{"rows": 10}`),
+		event(7, at(1, 12, 10), "bob", strPtr("web"), "view", int64Ptr(2), nil,
+			`{"tags": "a", "weird key": "x"}`, `{"ok": true, "items": []}`),
+		event(8, at(2, 9, 0), "alice", strPtr("api"), "login", int64Ptr(4), nil,
+			`{"http": {"status": 201, "method": "POST"}}`, `{}`),
+		event(9, at(2, 9, 0), "bob", strPtr("api"), "login", int64Ptr(5), nil,
+			`{"count": 10}`, ""),
+		// Время с поясом и наносекундами хранится как показания часов с
+		// точностью до микросекунд
+		event(10, time.Date(2024, 3, 2, 12, 0, 0, 123456789, time.FixedZone("MSK", 3*3600)), "carol", strPtr("api"), "export", nil, nil,
+			`{"size": "big"}`, `{"ok": true}`),
+	}
+	events[8].IdempotencyKey = "key-9"
+	events[0].Actor = "api_key:0000000000000001"
+	events[1].Actor = "api_key:0000000000000001"
+	events[4].Actor = "api_key:0000000000000002"
+	return events
+}
+
+// conformanceView - поля события, которые должны совпадать во всех
+// хранилищах. id, created_at и хеши у хранилищ свои.
+type conformanceView struct {
+	EventID        string
+	IdempotencyKey string
+	Timestamp      string
+	User           string
+	Component      *string
+	Operation      string
+	SessionID      *int64
+	RequestID      *int64
+	Response       *model.JSONB
+	Attributes     *model.JSONB
+	Actor          string
+	TenantID       string
+}
+
+func viewEvent(event *model.AuditEvent) conformanceView {
+	return conformanceView{
+		EventID:        event.EventID,
+		IdempotencyKey: event.IdempotencyKey,
+		Timestamp:      event.Timestamp.UTC().Format(time.RFC3339Nano),
+		User:           event.User,
+		Component:      event.Component,
+		Operation:      event.Operation,
+		SessionID:      event.SessionID,
+		RequestID:      event.RequestID,
+		Response:       event.Response,
+		Attributes:     event.Attributes,
+		Actor:          event.Actor,
+		TenantID:       event.TenantID,
+	}
+}
+
+func eventIDs(events []*model.AuditEvent) []string {
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.EventID)
+	}
+	return ids
+}
+
+func fixtureIDs(ns ...int) []string {
+	ids := []string{}
+	for _, n := range ns {
+		ids = append(ids, fixtureID(n))
+	}
+	return ids
+}
+
+// errorText - текст ошибки для сравнения между хранилищами
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// assertSame сравнивает результаты хранилищ с эталоном (первым)
+func assertSame(t *testing.T, backends []*conformanceBackend, results []interface{}) {
+	t.Helper()
+	want, _ := json.Marshal(results[0])
+	for i, backend := range backends[1:] {
+		got, _ := json.Marshal(results[i+1])
+		if string(got) != string(want) {
+			t.Errorf("%s differs from %s:\n got: %s\nwant: %s", backend.name, backends[0].name, got, want)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	// Запросы без арендатора - как у администратора кластера: Postgres без
+	// пометки о всех арендаторах не отдаёт ни одной строки
+	ctx := tenant.WithAll(context.Background())
+	backends := conformanceBackends(t)
+
+	// Первое событие - по одному, остальные - пачкой
+	for _, backend := range backends {
+		events := conformanceFixtures()
+		if _, err := backend.repo.StoreEvent(ctx, events[0]); err != nil {
+			t.Fatalf("%s: failed to store event: %v", backend.name, err)
+		}
+		duplicates, err := backend.repo.StoreEvents(ctx, events[1:])
+		if err != nil {
+			t.Fatalf("%s: failed to store events: %v", backend.name, err)
+		}
+		for i, duplicate := range duplicates {
+			if duplicate {
+				t.Fatalf("%s: event %s reported as duplicate", backend.name, events[i+1].EventID)
+			}
+		}
+
+		backend.ids = make(map[string]int64)
+		for _, event := range events {
+			backend.ids[event.EventID] = event.ID
+		}
+	}
+
+	find := func(t *testing.T, filters func(*conformanceBackend) model.EventFilters, want []string) {
+		t.Helper()
+		var results []interface{}
+		for _, backend := range backends {
+			events, err := backend.repo.FindEvents(ctx, filters(backend))
+			if err != nil {
+				results = append(results, errorText(err))
+				continue
+			}
+			results = append(results, eventIDs(events))
+		}
+		assertSame(t, backends, results)
+		if want != nil && !reflect.DeepEqual(results[0], want) {
+			t.Errorf("%s: got %v, want %v", backends[0].name, results[0], want)
+		}
+	}
+	static := func(filters model.EventFilters) func(*conformanceBackend) model.EventFilters {
+		return func(*conformanceBackend) model.EventFilters { return filters }
+	}
+	timeAt := func(s string) *time.Time {
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &ts
+	}
+
+	t.Run("GetEvent", func(t *testing.T) {
+		var results []interface{}
+		for _, backend := range backends {
+			var views []conformanceView
+			for _, fixture := range conformanceFixtures() {
+				event, err := backend.repo.GetEvent(ctx, backend.ids[fixture.EventID])
+				if err != nil {
+					t.Fatalf("%s: failed to get event: %v", backend.name, err)
+				}
+				views = append(views, viewEvent(event))
+			}
+			if _, err := backend.repo.GetEvent(ctx, 1<<40); !errors.Is(err, ErrEventNotFound) {
+				t.Errorf("%s: got %v for missing event, want ErrEventNotFound", backend.name, err)
+			}
+			results = append(results, views)
+		}
+		assertSame(t, backends, results)
+
+		if got := results[0].([]conformanceView)[9].Timestamp; got != "2024-03-02T12:00:00.123457Z" {
+			t.Errorf("stored timestamp %s, want wall clock rounded to microseconds", got)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		cases := []struct {
+			name    string
+			filters model.EventFilters
+			want    []string
+		}{
+			{"all", model.EventFilters{}, fixtureIDs(10, 9, 8, 7, 6, 5, 4, 3, 2, 1)},
+			{"limit", model.EventFilters{Limit: 3}, fixtureIDs(10, 9, 8)},
+			{"users", model.EventFilters{Users: []string{"alice", "carol"}}, fixtureIDs(10, 8, 5, 4, 3, 1)},
+			{"components", model.EventFilters{Components: []string{"web", "db"}}, fixtureIDs(7, 6, 3)},
+			{"operations", model.EventFilters{Operations: []string{"login"}}, fixtureIDs(9, 8, 2, 1)},
+			{"sessions", model.EventFilters{SessionIDs: []int64{1, 2}}, fixtureIDs(7, 3, 2, 1)},
+			{"requests", model.EventFilters{RequestIDs: []int64{101, 104}}, fixtureIDs(6, 2)},
+			{"timestamp", model.EventFilters{Timestamp: timeAt("2024-03-01T10:05:00Z")}, fixtureIDs(3, 2)},
+			{"range", model.EventFilters{
+				TimestampStart: timeAt("2024-03-01T10:05:00Z"),
+				TimestampEnd:   timeAt("2024-03-01T11:00:00Z"),
+			}, fixtureIDs(5, 4, 3, 2)},
+			{"range with zone", model.EventFilters{
+				TimestampStart: timeAt("2024-03-02T12:00:00+03:00"),
+			}, fixtureIDs(10)},
+			{"attribute", model.EventFilters{Attributes: map[string][]string{"http.status": {"500", "404"}}}, fixtureIDs(3, 2)},
+			{"attribute array", model.EventFilters{Attributes: map[string][]string{"tags": {`["a", "b"]`, "a"}}}, fixtureIDs(7, 1)},
+			{"attribute index", model.EventFilters{Attributes: map[string][]string{"http.-1.status": {"200"}}}, fixtureIDs(6)},
+			{"attribute number", model.EventFilters{Attributes: map[string][]string{"level": {"2.5"}}}, fixtureIDs(5)},
+			{"response", model.EventFilters{Response: map[string][]string{"ok": {"true"}}}, fixtureIDs(10, 7, 1)},
+			{"combined", model.EventFilters{
+				Users:      []string{"alice"},
+				Components: []string{"api"},
+				Response:   map[string][]string{"ok": {"true", "false"}},
+			}, fixtureIDs(1)},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) { find(t, static(c.filters), c.want) })
+		}
+	})
+
+	t.Run("IDs", func(t *testing.T) {
+		find(t, func(backend *conformanceBackend) model.EventFilters {
+			return model.EventFilters{
+				IDs:   []int64{backend.ids[fixtureID(2)], backend.ids[fixtureID(5)], backend.ids[fixtureID(7)], 1 << 40},
+				Users: []string{"alice", "bob"},
+			}
+		}, fixtureIDs(7, 5, 2))
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		cases := []struct {
+			q    string
+			want []string
+		}{
+			{`user = alice`, fixtureIDs(8, 5, 3, 1)},
+			{`user != alice`, fixtureIDs(10, 9, 7, 6, 4, 2)},
+			{`user IN (alice, bob) AND op = login`, fixtureIDs(9, 8, 2, 1)},
+			{`user NOT IN (alice, bob)`, fixtureIDs(10, 6, 4)},
+			{`NOT component = api`, fixtureIDs(7, 6, 4, 3)},
+			{`component != api`, nil},
+			{`component = api OR session_id > 3`, nil},
+			{`session_id BETWEEN 1 AND 2`, fixtureIDs(7, 3, 2, 1)},
+			{`NOT session_id BETWEEN 1 AND 2`, nil},
+			{`req_id >= 103 OR req_id < 101`, nil},
+			{`ts >= '2024-03-01T11:00:00Z' AND ts < '2024-03-02T09:00:00Z'`, fixtureIDs(7, 6, 5)},
+			{`user ^= ca`, fixtureIDs(10, 4)},
+			{`op ^= 'log' AND NOT (user = bob OR user = carol)`, fixtureIDs(8, 1)},
+			{`event_id = ` + fixtureID(3), fixtureIDs(3)},
+			{`id > 0 AND user = dave`, fixtureIDs(6)},
+			{`actor = "api_key:0000000000000001"`, fixtureIDs(2, 1)},
+			{`actor ^= "api_key:" AND NOT actor = "api_key:0000000000000001"`, fixtureIDs(5)},
+			{`attributes.http.status = 500`, nil},
+			{`attributes.http.status != 500`, nil},
+			{`attributes.http.status > 250`, nil},
+			{`attributes.http.status >= 200 AND attributes.http.status < 300`, nil},
+			{`attributes.http.status IN (200, 201)`, nil},
+			{`attributes.http.status NOT IN (200, 201)`, nil},
+			{`attributes.http.status BETWEEN 200 AND 404`, nil},
+			{`attributes.http.method = GET`, fixtureIDs(1)},
+			{`attributes.http.method != GET`, nil},
+			{`attributes.http.method ^= P`, fixtureIDs(8)},
+			{`attributes.ip EXISTS`, fixtureIDs(5, 2, 1)},
+			{`attributes.ip NOT EXISTS`, nil},
+			{`res.error EXISTS`, fixtureIDs(5, 2)},
+			{`attributes.ip = null`, fixtureIDs(5)},
+			{`attributes.flag = false`, fixtureIDs(5)},
+			{`attributes.level >= 2`, fixtureIDs(5)},
+			{`attributes.retries < "5"`, nil},
+			{`attributes.retries = "3"`, nil},
+			{`attributes.nested.a.b = deep`, fixtureIDs(6)},
+			{`attributes.nested @> '{"a": {"b": "deep"}}'`, fixtureIDs(6)},
+			{`attributes.tags = a`, nil},
+			{`attributes.tags @> '["a"]'`, fixtureIDs(1)},
+			{`attributes.tags @> '"a"'`, nil},
+			{`attributes.http @> '{"status": 500}'`, nil},
+			{`res.ok = true`, fixtureIDs(10, 7, 1)},
+			{`res.ok != true`, nil},
+			{`res.items.id = 2`, nil},
+			{`res.rows > 5 OR attributes.count > 5`, fixtureIDs(9, 6)},
+			{`NOT res.ok = true`, nil},
+			// Ошибки компиляции должны совпадать дословно
+			{`foo = 1`, nil},
+			{`session_id = abc`, nil},
+			{`ts > yesterday`, nil},
+			{`user @> '{}'`, nil},
+			{`user EXISTS`, nil},
+			{`attributes.tags @> '[broken'`, nil},
+			{`event_id = not-a-uuid`, nil},
+		}
+		for _, c := range cases {
+			t.Run(c.q, func(t *testing.T) {
+				node, err := query.Parse(c.q)
+				if err != nil {
+					t.Fatalf("failed to parse %q: %v", c.q, err)
+				}
+				find(t, static(model.EventFilters{Query: node}), c.want)
+			})
+		}
+	})
+
+	t.Run("Cursor", func(t *testing.T) {
+		var results []interface{}
+		for _, backend := range backends {
+			var pages [][]string
+			filters := model.EventFilters{Limit: 3, Users: []string{"alice", "bob", "carol"}}
+			for {
+				events, err := backend.repo.FindEvents(ctx, filters)
+				if err != nil {
+					t.Fatalf("%s: failed to find events: %v", backend.name, err)
+				}
+				if len(events) == 0 {
+					break
+				}
+				pages = append(pages, eventIDs(events))
+				last := events[len(events)-1]
+				filters.Cursor = &model.EventCursor{Timestamp: last.Timestamp, ID: last.ID}
+			}
+			results = append(results, pages)
+		}
+		assertSame(t, backends, results)
+
+		want := [][]string{fixtureIDs(10, 9, 8), fixtureIDs(7, 5, 4), fixtureIDs(3, 2, 1)}
+		if !reflect.DeepEqual(results[0], want) {
+			t.Errorf("got pages %v, want %v", results[0], want)
+		}
+	})
+
+	t.Run("Archived", func(t *testing.T) {
+		// Первое событие уже есть в хранилище и не должно задвоиться
+		find(t, func(backend *conformanceBackend) model.EventFilters {
+			present := conformanceFixtures()[0]
+			present.ID = backend.ids[present.EventID]
+			archived := &model.AuditEvent{
+				ID:         1 << 40,
+				EventID:    fixtureID(11),
+				Timestamp:  time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC),
+				User:       "alice",
+				Operation:  "login",
+				Attributes: fixtureJSON(`{"http": {"status": 200}}`),
+			}
+			return model.EventFilters{
+				Users:    []string{"alice"},
+				Query:    mustParse(t, `attributes.http.status = 200`),
+				Archived: []*model.AuditEvent{present, archived},
+			}
+		}, fixtureIDs(1, 11))
+	})
+
+	t.Run("Aggregate", func(t *testing.T) {
+		requests := []model.AggregateRequest{
+			{Top: 10},
+			{GroupBy: "user", Top: 2},
+			{GroupBy: "component", Top: 10},
+			{GroupBy: "op", Interval: "1h", Top: 1},
+			{GroupBy: "attributes.http.status", Top: 10},
+			{GroupBy: "attributes.tags", Top: 10},
+			{GroupBy: "res.ok", Interval: "1d", Top: 10},
+			{Interval: "1m", Top: 10, Filters: model.EventFilters{Users: []string{"alice"}}},
+			{GroupBy: "user", Top: 10, Filters: model.EventFilters{Query: mustParse(t, `attributes.http.status >= 400`)}},
+			{GroupBy: "actor", Top: 10},
+			{GroupBy: "session_id", Top: 10},
+			{GroupBy: "attributes", Top: 10},
+		}
+		for _, req := range requests {
+			t.Run(fmt.Sprintf("%s/%s", req.GroupBy, req.Interval), func(t *testing.T) {
+				var results []interface{}
+				for _, backend := range backends {
+					result, err := backend.repo.AggregateEvents(ctx, req)
+					if err != nil {
+						results = append(results, errorText(err))
+						continue
+					}
+					for i := range result.Buckets {
+						result.Buckets[i].Time = result.Buckets[i].Time.UTC()
+					}
+					results = append(results, result)
+				}
+				assertSame(t, backends, results)
+			})
+		}
+
+		result, err := backends[0].repo.AggregateEvents(ctx, model.AggregateRequest{GroupBy: "user", Interval: "1d", Top: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Total != 10 || result.DistinctUsers != 4 || len(result.Buckets) != 2 {
+			t.Fatalf("got total %d, users %d, %d buckets", result.Total, result.DistinctUsers, len(result.Buckets))
+		}
+		if top := result.Top; len(top) != 2 || *top[0].Value != "alice" || top[0].Count != 4 || *top[1].Value != "bob" {
+			t.Errorf("unexpected top %+v", top)
+		}
+	})
+
+	t.Run("ExportColumns", func(t *testing.T) {
+		for _, limit := range []int{100, 3} {
+			var results []interface{}
+			for _, backend := range backends {
+				columns, err := backend.repo.ExportColumns(ctx, model.EventFilters{}, limit)
+				if err != nil {
+					t.Fatalf("%s: failed to get export columns: %v", backend.name, err)
+				}
+				results = append(results, columns)
+			}
+			assertSame(t, backends, results)
+		}
+
+		columns, err := backends[0].repo.ExportColumns(ctx, model.EventFilters{Users: []string{"dave"}}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"http", "nested.a.b"}; !reflect.DeepEqual(columns.Attributes, want) {
+			t.Errorf("got columns %v, want %v", columns.Attributes, want)
+		}
+	})
+
+	t.Run("ExportEvents", func(t *testing.T) {
+		var results []interface{}
+		for _, backend := range backends {
+			var columns *model.ExportColumns
+			var views []conformanceView
+			filters := model.EventFilters{Operations: []string{"login", "view"}, Query: mustParse(t, `NOT user = carol`)}
+			err := backend.repo.ExportEvents(ctx, filters, 100, func(c *model.ExportColumns) error {
+				if views != nil {
+					t.Errorf("%s: columns passed after %d events", backend.name, len(views))
+				}
+				columns = c
+				return nil
+			}, func(event *model.AuditEvent) error {
+				views = append(views, viewEvent(event))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to export events: %v", backend.name, err)
+			}
+
+			// Колонки те же, что отдельным проходом
+			want, err := backend.repo.ExportColumns(ctx, filters, 100)
+			if err != nil {
+				t.Fatalf("%s: failed to get export columns: %v", backend.name, err)
+			}
+			if !reflect.DeepEqual(columns, want) {
+				t.Errorf("%s: export columns %+v, want %+v", backend.name, columns, want)
+			}
+			results = append(results, []interface{}{columns, views})
+		}
+		assertSame(t, backends, results)
+		if got := len(results[0].([]interface{})[1].([]conformanceView)); got != 6 {
+			t.Errorf("exported %d events, want 6", got)
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		var results []interface{}
+		for _, backend := range backends {
+			var result []interface{}
+
+			// Повтор по event_id и по ключу идемпотентности
+			for _, event := range []*model.AuditEvent{
+				{EventID: fixtureID(1), Timestamp: time.Now(), User: "mallory", Operation: "login"},
+				{EventID: fixtureID(12), IdempotencyKey: "key-9", Timestamp: time.Now(), User: "mallory", Operation: "login"},
+			} {
+				stored, err := backend.repo.StoreEvent(ctx, event)
+				if !errors.Is(err, ErrDuplicateEvent) {
+					t.Fatalf("%s: got %v, want ErrDuplicateEvent", backend.name, err)
+				}
+				result = append(result, viewEvent(stored))
+			}
+
+			batch := []*model.AuditEvent{
+				{EventID: fixtureID(2), Timestamp: time.Now(), User: "mallory", Operation: "login"},
+				{EventID: fixtureID(13), Timestamp: time.Date(2024, 3, 3, 8, 0, 0, 0, time.UTC), User: "erin", Operation: "login"},
+				{EventID: fixtureID(14), IdempotencyKey: "key-14", Timestamp: time.Date(2024, 3, 3, 8, 1, 0, 0, time.UTC), User: "erin", Operation: "logout"},
+				{EventID: fixtureID(13), Timestamp: time.Now(), User: "mallory", Operation: "login"},
+				{EventID: fixtureID(15), IdempotencyKey: "key-14", Timestamp: time.Now(), User: "mallory", Operation: "login"},
+			}
+			duplicates, err := backend.repo.StoreEvents(ctx, batch)
+			if err != nil {
+				t.Fatalf("%s: failed to store events: %v", backend.name, err)
+			}
+			result = append(result, duplicates, eventIDs(batch))
+			if batch[3].ID != batch[1].ID || batch[4].ID != batch[2].ID || batch[0].ID != backend.ids[fixtureID(2)] {
+				t.Errorf("%s: duplicates did not get ids of originals", backend.name)
+			}
+
+			replayed, err := backend.repo.ReplayEvents(ctx, []*model.AuditEvent{
+				{EventID: fixtureID(1), Timestamp: time.Now(), User: "mallory", Operation: "login"},
+				{EventID: fixtureID(16), Timestamp: time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC), User: "erin", Operation: "view"},
+				{EventID: fixtureID(16), Timestamp: time.Now(), User: "mallory", Operation: "view"},
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to replay events: %v", backend.name, err)
+			}
+			result = append(result, replayed)
+
+			events, err := backend.repo.FindEvents(ctx, model.EventFilters{Users: []string{"erin", "mallory"}})
+			if err != nil {
+				t.Fatalf("%s: failed to find events: %v", backend.name, err)
+			}
+			result = append(result, eventIDs(events))
+
+			results = append(results, result)
+		}
+		assertSame(t, backends, results)
+
+		if got := results[0].([]interface{})[5]; !reflect.DeepEqual(got, fixtureIDs(16, 14, 13)) {
+			t.Errorf("got %v after duplicates, want only new events", got)
+		}
+	})
+
+	t.Run("Tenants", func(t *testing.T) {
+		acme := tenant.WithTenant(ctx, "acme")
+		globex := tenant.WithTenant(ctx, "globex")
+		at := func(min int) time.Time { return time.Date(2024, 3, 4, 10, min, 0, 0, time.UTC) }
+
+		var results []interface{}
+		for _, backend := range backends {
+			var result []interface{}
+
+			// Ключ идемпотентности уникален в пределах арендатора
+			if _, err := backend.repo.StoreEvent(acme, &model.AuditEvent{
+				EventID: fixtureID(20), IdempotencyKey: "key-9", TenantID: "acme", Timestamp: at(0), User: "zoe", Operation: "login",
+			}); err != nil {
+				t.Fatalf("%s: failed to store acme event: %v", backend.name, err)
+			}
+			duplicates, err := backend.repo.StoreEvents(globex, []*model.AuditEvent{
+				{EventID: fixtureID(21), IdempotencyKey: "key-9", TenantID: "globex", Timestamp: at(1), User: "zoe", Operation: "login"},
+				{EventID: fixtureID(22), TenantID: "globex", Timestamp: at(2), User: "zoe", Operation: "logout"},
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to store globex events: %v", backend.name, err)
+			}
+			result = append(result, duplicates)
+
+			// event_id другого арендатора занят, но его событие не отдаётся
+			if _, err := backend.repo.StoreEvent(globex, &model.AuditEvent{
+				EventID: fixtureID(20), TenantID: "globex", Timestamp: at(3), User: "zoe", Operation: "login",
+			}); !errors.Is(err, ErrEventIDTaken) {
+				t.Errorf("%s: got %v for event_id of another tenant, want ErrEventIDTaken", backend.name, err)
+			}
+			if _, err := backend.repo.StoreEvents(globex, []*model.AuditEvent{
+				{EventID: fixtureID(1), TenantID: "globex", Timestamp: at(3), User: "zoe", Operation: "login"},
+			}); !errors.Is(err, ErrEventIDTaken) {
+				t.Errorf("%s: got %v for batch with event_id of another tenant, want ErrEventIDTaken", backend.name, err)
+			}
+
+			for _, c := range []context.Context{acme, globex, ctx} {
+				events, err := backend.repo.FindEvents(c, model.EventFilters{Users: []string{"zoe"}})
+				if err != nil {
+					t.Fatalf("%s: failed to find events: %v", backend.name, err)
+				}
+				result = append(result, eventIDs(events))
+			}
+			if _, err := backend.repo.GetEvent(acme, backend.ids[fixtureID(1)]); !errors.Is(err, ErrEventNotFound) {
+				t.Errorf("%s: got %v for event of another tenant, want ErrEventNotFound", backend.name, err)
+			}
+			event, err := backend.repo.GetEvent(ctx, backend.ids[fixtureID(1)])
+			if err != nil {
+				t.Fatalf("%s: failed to get event: %v", backend.name, err)
+			}
+			result = append(result, event.TenantID)
+
+			aggregate, err := backend.repo.AggregateEvents(ctx, model.AggregateRequest{GroupBy: "tenant", Top: 10})
+			if err != nil {
+				t.Fatalf("%s: failed to aggregate events: %v", backend.name, err)
+			}
+			result = append(result, aggregate.Top)
+			aggregate, err = backend.repo.AggregateEvents(globex, model.AggregateRequest{})
+			if err != nil {
+				t.Fatalf("%s: failed to aggregate events: %v", backend.name, err)
+			}
+			result = append(result, aggregate.Total)
+
+			var exported []string
+			err = backend.repo.ExportEvents(acme, model.EventFilters{}, 0, nil, func(event *model.AuditEvent) error {
+				exported = append(exported, event.EventID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to export events: %v", backend.name, err)
+			}
+			result = append(result, exported)
+
+			results = append(results, result)
+		}
+		assertSame(t, backends, results)
+
+		result := results[0].([]interface{})
+		for i, want := range [][]string{fixtureIDs(20), fixtureIDs(22, 21), fixtureIDs(22, 21, 20)} {
+			if got := result[i+1]; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v for tenant query %d, want %v", got, i, want)
+			}
+		}
+		if got := result[4]; got != tenant.Default {
+			t.Errorf("event stored without tenant belongs to %v, want %s", got, tenant.Default)
+		}
+		if got := result[6]; got != int64(2) {
+			t.Errorf("got %v events in globex, want 2", got)
+		}
+	})
+
+	// Оригиналы ищутся по event_id и ключу идемпотентности только у своего
+	// арендатора
+	t.Run("FindStored", func(t *testing.T) {
+		var results []interface{}
+		for _, backend := range backends {
+			stored, err := backend.repo.FindStored(ctx, []*model.AuditEvent{
+				{EventID: fixtureID(1), TenantID: tenant.Default},
+				{EventID: fixtureID(99), TenantID: tenant.Default},
+				{IdempotencyKey: "key-9", TenantID: "globex"},
+				{EventID: fixtureID(20), TenantID: "globex"},
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to find stored events: %v", backend.name, err)
+			}
+			found := make([]string, len(stored))
+			for i, event := range stored {
+				if event != nil {
+					found[i] = event.EventID
+				}
+			}
+			results = append(results, found)
+		}
+		assertSame(t, backends, results)
+
+		if got, want := results[0], []string{fixtureID(1), "", fixtureID(21), ""}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	// Цепочка общая для всех арендаторов: звенья globex между событиями acme
+	// должны читаться и тогда, когда доказательство просит клиент acme
+	t.Run("ChainAcrossTenants", func(t *testing.T) {
+		acme := tenant.WithTenant(ctx, "acme")
+		for _, backend := range backends {
+			if backend.db == nil {
+				continue
+			}
+			chains := NewChainRepository(backend.db, nil)
+
+			first, last, err := chains.Range(acme, nil, nil)
+			if err != nil {
+				t.Fatalf("%s: failed to get chain range: %v", backend.name, err)
+			}
+			events, pruned, err := chains.Links(acme, first, last)
+			if err != nil {
+				t.Fatalf("%s: failed to get chain links: %v", backend.name, err)
+			}
+			if int64(len(events)+len(pruned)) != last-first+1 {
+				t.Fatalf("%s: got %d links for [%d, %d] as acme", backend.name, len(events)+len(pruned), first, last)
+			}
+
+			var leaves [][]byte
+			index := -1
+			tenants := make(map[string]bool)
+			for _, event := range events {
+				if event.EventID == fixtureID(20) {
+					index = len(leaves)
+				}
+				tenants[event.TenantID] = true
+				leaf, _ := hex.DecodeString(event.Hash)
+				leaves = append(leaves, leaf)
+			}
+			if index < 0 || !tenants["globex"] {
+				t.Fatalf("%s: links lack the acme event or globex links: %v", backend.name, tenants)
+			}
+
+			proof, err := chain.InclusionProof(leaves, index)
+			if err != nil {
+				t.Fatalf("%s: failed to build proof: %v", backend.name, err)
+			}
+			if !chain.VerifyInclusion(leaves[index], int64(index), int64(len(leaves)), proof, chain.MerkleRoot(leaves)) {
+				t.Errorf("%s: proof for the acme event does not verify", backend.name)
+			}
+		}
+	})
+}
+
+func mustParse(t *testing.T, q string) query.Node {
+	t.Helper()
+	node, err := query.Parse(q)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", q, err)
+	}
+	return node
+}
@@ -0,0 +1,414 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"audit-service/internal/model"
+)
+
+// Общая часть встроенных хранилищ (в памяти и SQLite). Фильтры, агрегаты и
+// колонки выгрузки они считают в процессе (eventMatcher, aggregateScan,
+// exportColumnsScan) и должны совпадать с Postgres - это проверяет
+// conformance_test.go.
+
+// EventNotifier реализуют хранилища, работающие внутри процесса: о
+// вставленных событиях они сообщают сами. Для Postgres о вставках всех
+// реплик сообщает EventListener через LISTEN.
+type EventNotifier interface {
+	// NotifyInserts задаёт fn, которая получает вставленные события после
+	// каждой успешной записи
+	NotifyInserts(fn func(InsertedEvents))
+}
+
+// InsertedEvents - уведомление о вставке: id событий и границы их времени.
+// По границам чтение событий отсекает лишние секции audit_events; нулевые
+// границы (уведомление без времени) не ограничивают чтение.
+type InsertedEvents struct {
+	IDs      []int64
+	From, To time.Time
+}
+
+// newInsertedEvents собирает уведомление о вставке events, время - в том
+// виде, в каком оно хранится
+func newInsertedEvents(events []*model.AuditEvent) InsertedEvents {
+	inserted := InsertedEvents{IDs: make([]int64, len(events))}
+	for i, event := range events {
+		inserted.IDs[i] = event.ID
+		ts := storedTime(event.Timestamp)
+		if i == 0 || ts.Before(inserted.From) {
+			inserted.From = ts
+		}
+		if i == 0 || ts.After(inserted.To) {
+			inserted.To = ts
+		}
+	}
+	return inserted
+}
+
+// eventScan передаёт fn события, подходящие под фильтры, от старых к новым.
+// Ошибка fn прерывает перебор.
+type eventScan func(fn func(*model.AuditEvent) error) error
+
+// sliceScan - eventScan по уже отобранным событиям
+func sliceScan(events []*model.AuditEvent) eventScan {
+	return func(fn func(*model.AuditEvent) error) error {
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// storedTime возвращает время в том виде, в каком его хранит колонка
+// timestamp без часового пояса: показания часов без смещения (так Postgres
+// приводит параметр с поясом) с точностью до микросекунд, в UTC
+func storedTime(t time.Time) time.Time {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return wall.Round(time.Microsecond)
+}
+
+// storedEvent возвращает копию события, какой её прочитал бы Postgres после
+// записи: время - storedTime, JSONB разобран заново (числа - float64)
+func storedEvent(event *model.AuditEvent) (*model.AuditEvent, error) {
+	stored := cloneEvent(event)
+	stored.EventID = strings.ToLower(event.EventID)
+	stored.Timestamp = storedTime(event.Timestamp)
+	stored.CreatedAt = storedTime(event.CreatedAt)
+
+	var err error
+	if stored.Response, err = reparseJSONB(event.Response); err != nil {
+		return nil, fmt.Errorf("failed to encode response: %w", err)
+	}
+	if stored.Attributes, err = reparseJSONB(event.Attributes); err != nil {
+		return nil, fmt.Errorf("failed to encode attributes: %w", err)
+	}
+	return stored, nil
+}
+
+func reparseJSONB(j *model.JSONB) (*model.JSONB, error) {
+	if j == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	var parsed model.JSONB
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// cloneEvent копирует событие вместе с указателями и JSONB, чтобы
+// вызывающий код не менял события внутри хранилища
+func cloneEvent(event *model.AuditEvent) *model.AuditEvent {
+	clone := *event
+	if event.Component != nil {
+		component := *event.Component
+		clone.Component = &component
+	}
+	if event.SessionID != nil {
+		sessionID := *event.SessionID
+		clone.SessionID = &sessionID
+	}
+	if event.RequestID != nil {
+		requestID := *event.RequestID
+		clone.RequestID = &requestID
+	}
+	clone.Response = cloneJSONB(event.Response)
+	clone.Attributes = cloneJSONB(event.Attributes)
+	return &clone
+}
+
+func cloneJSONB(j *model.JSONB) *model.JSONB {
+	if j == nil {
+		return nil
+	}
+	var clone model.JSONB
+	if *j != nil {
+		clone = copyJSON(map[string]interface{}(*j)).(map[string]interface{})
+	}
+	return &clone
+}
+
+func copyJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			obj[key] = copyJSON(value)
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, value := range v {
+			arr[i] = copyJSON(value)
+		}
+		return arr
+	}
+	return v
+}
+
+// eventLess - порядок (timestamp, id), в обратном порядке события отдаёт
+// FindEvents
+func eventLess(a, b *model.AuditEvent) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID < b.ID
+}
+
+// withArchived подмешивает к странице FindEvents (по убыванию timestamp и
+// id) подходящие события из архива, которых нет в хранилище, и обрезает
+// результат до limit - как объединение с архивом в Postgres
+func withArchived(events []*model.AuditEvent, filters model.EventFilters, m *eventMatcher, limit int,
+	stored func(*model.AuditEvent) (bool, error)) ([]*model.AuditEvent, error) {
+	for _, archived := range filters.Archived {
+		event, err := storedEvent(archived)
+		if err != nil {
+			return nil, err
+		}
+		if !afterCursor(event, filters.Cursor) || !m.match(event) {
+			continue
+		}
+		exists, err := stored(event)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return eventLess(events[j], events[i]) })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// aggregateScan считает AggregateEvents по событиям scan так же, как
+// запросы Postgres: top-N по убыванию числа, при равенстве - по значению
+// (NULL последним), корзины date_trunc по возрастанию времени
+func aggregateScan(req model.AggregateRequest, scan eventScan) (*model.AggregateResult, error) {
+	var group func(*model.AuditEvent) (string, bool)
+	if req.GroupBy != "" {
+		var err error
+		if group, err = groupByValue(req.GroupBy); err != nil {
+			return nil, err
+		}
+	}
+	unit := model.AggregateIntervals[req.Interval]
+
+	total := newGroupStats()
+	buckets := make(map[time.Time]*groupStats)
+	err := scan(func(event *model.AuditEvent) error {
+		total.add(event, group)
+		if unit != "" {
+			t := truncateTime(event.Timestamp, unit)
+			if buckets[t] == nil {
+				buckets[t] = newGroupStats()
+			}
+			buckets[t].add(event, group)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.AggregateResult{
+		GroupBy:       req.GroupBy,
+		Interval:      req.Interval,
+		Total:         total.count,
+		DistinctUsers: int64(len(total.users)),
+	}
+	if group != nil {
+		result.Top = total.top(req.Top)
+	}
+
+	times := make([]time.Time, 0, len(buckets))
+	for t := range buckets {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	if len(times) > maxAggregateBuckets {
+		times = times[:maxAggregateBuckets]
+	}
+	for _, t := range times {
+		stats := buckets[t]
+		bucket := model.AggregateBucket{Time: t, Count: stats.count, DistinctUsers: int64(len(stats.users))}
+		if group != nil {
+			bucket.Top = stats.top(req.Top)
+		}
+		result.Buckets = append(result.Buckets, bucket)
+	}
+
+	return result, nil
+}
+
+// groupKey - значение группировки; null - NULL в SQL
+type groupKey struct {
+	value string
+	null  bool
+}
+
+type groupStats struct {
+	count  int64
+	users  map[string]bool
+	values map[groupKey]int64
+}
+
+func newGroupStats() *groupStats {
+	return &groupStats{users: make(map[string]bool), values: make(map[groupKey]int64)}
+}
+
+func (s *groupStats) add(event *model.AuditEvent, group func(*model.AuditEvent) (string, bool)) {
+	s.count++
+	s.users[event.User] = true
+	if group != nil {
+		value, ok := group(event)
+		s.values[groupKey{value: value, null: !ok}]++
+	}
+}
+
+// top возвращает n самых частых значений
+func (s *groupStats) top(n int) []model.GroupCount {
+	keys := make([]groupKey, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if s.values[a] != s.values[b] {
+			return s.values[a] > s.values[b]
+		}
+		if a.null != b.null {
+			return b.null
+		}
+		return a.value < b.value
+	})
+	if n >= 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+
+	var counts []model.GroupCount
+	for _, key := range keys {
+		count := model.GroupCount{Count: s.values[key]}
+		if !key.null {
+			value := key.value
+			count.Value = &value
+		}
+		counts = append(counts, count)
+	}
+	return counts
+}
+
+// groupByValue - аналог groupByExpr: значение колонки или текст по пути
+// в attributes и res (как #>>); false - NULL
+func groupByValue(groupBy string) (func(*model.AuditEvent) (string, bool), error) {
+	if column, ok := groupByColumns[groupBy]; ok {
+		get := columnValue(column)
+		return func(e *model.AuditEvent) (string, bool) {
+			value, ok := get(e)
+			if !ok {
+				return "", false
+			}
+			return value.(string), true
+		}, nil
+	}
+
+	parts := strings.Split(groupBy, ".")
+	column, ok := jsonColumns[parts[0]]
+	if !ok || len(parts) < 2 {
+		return nil, fmt.Errorf("cannot group by '%s'", groupBy)
+	}
+	path := parts[1:]
+	return func(e *model.AuditEvent) (string, bool) {
+		doc := e.Response
+		if column == "attributes" {
+			doc = e.Attributes
+		}
+		root, ok := jsonDocument(doc)
+		if !ok {
+			return "", false
+		}
+		value, ok := jsonExtract(root, path)
+		if !ok {
+			return "", false
+		}
+		return jsonText(value)
+	}, nil
+}
+
+// truncateTime - date_trunc с единицей из model.AggregateIntervals
+func truncateTime(t time.Time, unit string) time.Time {
+	switch unit {
+	case "minute":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// exportColumnsScan - ExportColumns по событиям scan: пути ко всем
+// не-объектным значениям attributes и res по порядку, не больше limit на
+// каждое поле
+func exportColumnsScan(limit int, scan eventScan) (*model.ExportColumns, error) {
+	attributes := make(map[string]bool)
+	response := make(map[string]bool)
+	err := scan(func(event *model.AuditEvent) error {
+		if root, ok := jsonDocument(event.Attributes); ok {
+			collectJSONPaths(root, "", attributes)
+		}
+		if root, ok := jsonDocument(event.Response); ok {
+			collectJSONPaths(root, "", response)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.ExportColumns{
+		Attributes: firstPaths(attributes, limit),
+		Response:   firstPaths(response, limit),
+	}, nil
+}
+
+// collectJSONPaths раскрывает вложенные объекты; массивы, как и скаляры,
+// дают одну колонку. prefix - путь родителя с точкой на конце.
+func collectJSONPaths(doc interface{}, prefix string, paths map[string]bool) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key, value := range obj {
+		path := prefix + key
+		if _, ok := value.(map[string]interface{}); ok {
+			collectJSONPaths(value, path+".", paths)
+			continue
+		}
+		paths[path] = true
+	}
+}
+
+func firstPaths(paths map[string]bool, limit int) []string {
+	var sorted []string
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateEvent возвращается вместе с ранее сохранённым событием, если
+// событие с тем же event_id или ключом идемпотентности уже есть в таблице
+var ErrDuplicateEvent = errors.New("event already stored")
+
+// ErrEventNotFound - события с запрошенным id нет
+var ErrEventNotFound = errors.New("event not found")
+
+// ErrEventIDTaken - event_id уже занят событием другого арендатора
+var ErrEventIDTaken = errors.New("event_id is already taken")
+
+// EncryptedFieldError - фильтр или группировка по зашифрованному полю,
+// которые нельзя выполнить без расшифровки
+type EncryptedFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *EncryptedFieldError) Error() string {
+	return fmt.Sprintf("'%s' is encrypted: %s", e.Field, e.Reason)
+}
+
+// isUniqueViolation сообщает, что запись нарушила уникальный индекс
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// IsUnavailable сообщает, что ошибка вызвана недоступностью БД (обрыв
+// соединения, переключение primary в Patroni), а не содержимым запроса.
+// Такие записи имеет смысл отложить и повторить позже.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"25", // read only sql transaction: писали в бывший primary
+			"53", // insufficient resources
+			"57": // operator intervention: admin shutdown, crash shutdown
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
+}
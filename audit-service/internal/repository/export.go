@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"audit-service/internal/encryption"
+	"audit-service/internal/model"
+)
+
+// Сколько строк серверного курсора читается за один FETCH
+const exportFetchSize = 1000
+
+// ExportColumns возвращает пути ко всем скалярным значениям (через точку) в
+// attributes и response событий, подходящих под фильтры. Вложенные объекты
+// раскрываются, массивы считаются скалярами. Возвращается не больше limit
+// путей на каждое поле. Зашифрованное поле даёт колонку своего пути, его
+// содержимое в Postgres не видно и не раскрывается.
+func (r *postgresRepository) ExportColumns(ctx context.Context, filters model.EventFilters, limit int) (*model.ExportColumns, error) {
+	var columns *model.ExportColumns
+	err := readTenant(ctx, r.db, func(q queryer) error {
+		var err error
+		columns, err = r.exportColumns(ctx, q, filters, limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+func (r *postgresRepository) exportColumns(ctx context.Context, q queryer, filters model.EventFilters, limit int) (*model.ExportColumns, error) {
+	filters = scopeFilters(ctx, filters)
+	conditions, args, err := buildFilterConditions(filters, r.enc)
+	if err != nil {
+		return nil, err
+	}
+
+	// jsonb_each от не-объекта - ошибка, поэтому такие значения заменяются
+	// пустым объектом
+	query := fmt.Sprintf(`
+        WITH RECURSIVE filtered AS (
+            SELECT attributes, response FROM audit_events%s
+        ), fields(source, path, value) AS (
+            SELECT 'attributes', ARRAY[e.key], e.value
+            FROM filtered, jsonb_each(CASE WHEN jsonb_typeof(attributes) = 'object' THEN attributes ELSE '{}' END) e
+            UNION ALL
+            SELECT 'response', ARRAY[e.key], e.value
+            FROM filtered, jsonb_each(CASE WHEN jsonb_typeof(response) = 'object' THEN response ELSE '{}' END) e
+            UNION ALL
+            SELECT f.source, f.path || e.key, e.value
+            FROM fields f, jsonb_each(CASE WHEN jsonb_typeof(f.value) = 'object' THEN f.value ELSE '{}' END) e
+        ), paths AS (
+            SELECT DISTINCT source, array_to_string(path, '.') AS path
+            FROM fields
+            WHERE jsonb_typeof(value) <> 'object'
+        ), numbered AS (
+            SELECT source, path, row_number() OVER (PARTITION BY source ORDER BY path) AS n
+            FROM paths
+        )
+        SELECT source, path FROM numbered WHERE n <= $%d ORDER BY source, path
+    `, whereClause(conditions), len(args)+1)
+	args = append(args, limit)
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query export columns: %w", err)
+	}
+	defer rows.Close()
+
+	columns := &model.ExportColumns{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var source, path string
+		if err := rows.Scan(&source, &path); err != nil {
+			return nil, fmt.Errorf("failed to scan export column: %w", err)
+		}
+		path, ok := encryptedColumnPath(path)
+		if !ok || seen[source+":"+path] {
+			continue
+		}
+		seen[source+":"+path] = true
+		if source == "attributes" {
+			columns.Attributes = append(columns.Attributes, path)
+		} else {
+			columns.Response = append(columns.Response, path)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return columns, nil
+}
+
+// encryptedColumnPath сворачивает пути внутри зашифрованного значения в путь
+// самого поля: a.b.$enc -> a.b, a.b.$bi пропускается. Целиком зашифрованная
+// колонка колонок выгрузки не даёт.
+func encryptedColumnPath(path string) (string, bool) {
+	switch {
+	case path == encryption.IndexKey || strings.HasSuffix(path, "."+encryption.IndexKey):
+		return "", false
+	case path == encryption.CipherKey:
+		return "", false
+	case strings.HasSuffix(path, "."+encryption.CipherKey):
+		return strings.TrimSuffix(path, "."+encryption.CipherKey), true
+	}
+	return path, true
+}
+
+// ExportEvents читает все события, подходящие под фильтры, через серверный
+// курсор от старых к новым и передаёт их по одному в fn. В памяти
+// одновременно держится не больше exportFetchSize строк. Ошибка columns или
+// fn прерывает выгрузку. Пути и курсор читаются в одной транзакции
+// REPEATABLE READ: строки, закоммиченные между ними, в выгрузку не попадут.
+func (r *postgresRepository) ExportEvents(ctx context.Context, filters model.EventFilters, columnLimit int,
+	columns func(*model.ExportColumns) error, fn func(*model.AuditEvent) error) error {
+	scoped := scopeFilters(ctx, filters)
+	conditions, args, err := buildFilterConditions(scoped, r.enc)
+	if err != nil {
+		return err
+	}
+
+	// Курсор живёт до конца транзакции
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setTenant(ctx, tx); err != nil {
+		return err
+	}
+	if columnLimit > 0 {
+		paths, err := r.exportColumns(ctx, tx, filters, columnLimit)
+		if err != nil {
+			return err
+		}
+		if err := columns(paths); err != nil {
+			return err
+		}
+	}
+
+	query := "DECLARE export_cursor NO SCROLL CURSOR FOR SELECT " + eventColumns +
+		" FROM audit_events" + whereClause(conditions) + " ORDER BY timestamp, id"
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to declare export cursor: %w", err)
+	}
+
+	fetch := fmt.Sprintf("FETCH %d FROM export_cursor", exportFetchSize)
+	for {
+		n, err := fetchExportRows(ctx, tx, fetch, r.enc, fn)
+		if err != nil {
+			return err
+		}
+		if n < exportFetchSize {
+			return nil
+		}
+	}
+}
+
+func fetchExportRows(ctx context.Context, tx *sql.Tx, fetch string, enc *encryption.Encryptor, fn func(*model.AuditEvent) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch export rows: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		if err := enc.Open(event); err != nil {
+			return 0, err
+		}
+		if err := fn(event); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return n, nil
+}
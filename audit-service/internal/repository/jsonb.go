@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"audit-service/internal/encryption"
+	"audit-service/internal/query"
+
+	"github.com/lib/pq"
+)
+
+// JSONB-колонки, доступные в языке запросов. Путь внутри документа задаётся
+// через точку: attributes.http.status, res.error.code.
+var jsonColumns = map[string]string{
+	"attributes": "attributes",
+	"res":        "response",
+	"response":   "response",
+}
+
+// compileJSONComparison компилирует сравнение по пути внутри JSONB-колонки.
+//
+// Равенство, IN и @> компилируются в проверку вхождения (@>), которую
+// обслуживает GIN-индекс колонки. EXISTS для ключа верхнего уровня - в
+// оператор ?, для вложенного пути - в @? с jsonpath, оба тоже используют GIN.
+// Сравнения <, <=, >, >=, BETWEEN и ^= выполняются через jsonpath с
+// передачей значений переменными: значения разного типа (число и строка)
+// в jsonpath не равны и не сравнимы, поэтому ошибок приведения типов не бывает.
+// Сравнения с зашифрованными полями - в compileEncryptedComparison.
+func (c *queryCompiler) compileJSONComparison(cmp *query.Comparison, column string) (string, error) {
+	path := cmp.Field.Path
+
+	if field, ok := c.enc.Field(column, path); ok {
+		if len(path) > len(field.Path) {
+			return "", query.Errorf(cmp.Field.Pos, "'%s' is inside encrypted '%s' and cannot be filtered", cmp.Field, field)
+		}
+		if cmp.Op != query.OpExists && cmp.Op != query.OpNotExists {
+			return c.compileEncryptedComparison(cmp, column, field)
+		}
+	}
+
+	switch cmp.Op {
+	case query.OpExists, query.OpNotExists:
+		var expr string
+		switch len(path) {
+		case 0:
+			expr = column + " IS NOT NULL"
+		case 1:
+			expr = fmt.Sprintf("%s ? %s", column, c.arg(path[0]))
+		default:
+			expr = fmt.Sprintf("%s @? %s::jsonpath", column, c.arg(jsonPath(path)))
+		}
+		if cmp.Op == query.OpNotExists {
+			return "((" + expr + ") IS NOT TRUE)", nil
+		}
+		return expr, nil
+
+	case query.OpContains:
+		var doc interface{}
+		if err := json.Unmarshal([]byte(cmp.Values[0].Text), &doc); err != nil {
+			return "", query.Errorf(cmp.Values[0].Pos, "operator @> expects a JSON document: %v", err)
+		}
+		for _, field := range c.enc.FieldsWithin(column, path) {
+			if hasPath(doc, field.Path[len(path):]) {
+				return "", query.Errorf(cmp.Values[0].Pos, "operator @> cannot match encrypted '%s', compare it with = instead", field)
+			}
+		}
+		return c.contains(column, path, doc)
+
+	case query.OpEq, query.OpNe, query.OpIn, query.OpNotIn:
+		if len(path) == 0 {
+			return "", query.Errorf(cmp.Field.Pos, "'%s' needs a path, e.g. %s.key, or use @>", cmp.Field, cmp.Field)
+		}
+
+		values := make([]interface{}, len(cmp.Values))
+		for i, v := range cmp.Values {
+			values[i] = jsonValue(v)
+		}
+		return c.anyOf(cmp.Op, column, path, values)
+
+	case query.OpLt, query.OpLe, query.OpGt, query.OpGe, query.OpPrefix, query.OpBetween:
+		if len(path) == 0 {
+			return "", query.Errorf(cmp.Field.Pos, "'%s' needs a path, e.g. %s.key", cmp.Field, cmp.Field)
+		}
+
+		var filter string
+		vars := make(map[string]interface{})
+		switch cmp.Op {
+		case query.OpBetween:
+			filter = "@ >= $lo && @ <= $hi"
+			vars["lo"] = jsonValue(cmp.Values[0])
+			vars["hi"] = jsonValue(cmp.Values[1])
+		case query.OpPrefix:
+			filter = "@ starts with $v"
+			vars["v"] = cmp.Values[0].Text
+		default:
+			filter = "@ " + cmp.Op + " $v"
+			vars["v"] = jsonValue(cmp.Values[0])
+		}
+
+		varsJSON, err := json.Marshal(vars)
+		if err != nil {
+			return "", query.Errorf(cmp.Pos(), "invalid value: %v", err)
+		}
+		return fmt.Sprintf("jsonb_path_exists(%s, %s::jsonpath, %s::jsonb)",
+			column, c.arg(jsonPath(path)+" ? ("+filter+")"), c.arg(string(varsJSON))), nil
+	}
+
+	return "", query.Errorf(cmp.Pos(), "operator %s is not supported for '%s'", cmp.Op, cmp.Field)
+}
+
+// compileEncryptedComparison компилирует сравнение с зашифрованным полем.
+// Значение поля в JSONB заменено объектом {"$enc": ..., "$bi": ...}, поэтому
+// равенство проверяется вхождением слепого индекса значения в "$bi" - так же
+// через @> и GIN-индекс. Порядок и префикс по шифротексту не проверить.
+func (c *queryCompiler) compileEncryptedComparison(cmp *query.Comparison, column string, field encryption.Field) (string, error) {
+	path := cmp.Field.Path
+
+	switch cmp.Op {
+	case query.OpEq, query.OpNe, query.OpIn, query.OpNotIn:
+		if len(path) == 0 {
+			return "", query.Errorf(cmp.Field.Pos, "'%s' needs a path, e.g. %s.key", cmp.Field, cmp.Field)
+		}
+
+		values := make([]interface{}, len(cmp.Values))
+		for i, v := range cmp.Values {
+			index, err := c.enc.BlindIndex(field, jsonValue(v))
+			if err != nil {
+				return "", query.Errorf(v.Pos, "invalid value: %v", err)
+			}
+			values[i] = index
+		}
+		return c.anyOf(cmp.Op, column, append(path[:len(path):len(path)], encryption.IndexKey), values)
+	}
+
+	return "", query.Errorf(cmp.Pos(), "'%s' is encrypted: only =, !=, IN, NOT IN and EXISTS are supported", cmp.Field)
+}
+
+// anyOf строит проверку совпадения значения по пути с одним из values. Как
+// и для обычных колонок, != и NOT IN не выбирают события без этого поля.
+func (c *queryCompiler) anyOf(op, column string, path []string, values []interface{}) (string, error) {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		expr, err := c.contains(column, path, value)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = expr
+	}
+	expr := strings.Join(parts, " OR ")
+	if len(parts) > 1 {
+		expr = "(" + expr + ")"
+	}
+
+	if op == query.OpNe || op == query.OpNotIn {
+		return fmt.Sprintf("(%s #> %s::text[] IS NOT NULL AND NOT %s)", column, c.arg(pq.Array(path)), expr), nil
+	}
+	return expr, nil
+}
+
+// contains строит проверку column @> {"a": {"b": value}} для пути a.b
+func (c *queryCompiler) contains(column string, path []string, value interface{}) (string, error) {
+	doc := value
+	for i := len(path) - 1; i >= 0; i-- {
+		doc = map[string]interface{}{path[i]: doc}
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s @> %s::jsonb", column, c.arg(string(b))), nil
+}
+
+// jsonPath строит выражение jsonpath $."a"."b" с экранированием ключей
+func jsonPath(path []string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, key := range path {
+		quoted, _ := json.Marshal(key)
+		b.WriteString(".")
+		b.Write(quoted)
+	}
+	return b.String()
+}
+
+// jsonValue типизирует значение без кавычек: true/false, null и числа
+// становятся соответствующими JSON-значениями, остальное остаётся строкой
+func jsonValue(v query.Value) interface{} {
+	if v.Quoted {
+		return v.Text
+	}
+
+	switch v.Text {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+
+	if _, err := strconv.ParseFloat(v.Text, 64); err == nil && json.Valid([]byte(v.Text)) {
+		return json.Number(v.Text)
+	}
+	return v.Text
+}
+
+// hasPath сообщает, есть ли в документе путь во вложенных объектах
+func hasPath(doc interface{}, path []string) bool {
+	for _, key := range path {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if doc, ok = obj[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// blindIndexes возвращает слепые индексы значений фильтра по зашифрованному
+// полю. Фильтр сравнивает текст, поэтому "200" должно находить и строку, и
+// число: для значений, похожих на число, true, false или null, индекс
+// считается в обоих вариантах.
+func blindIndexes(enc *encryption.Encryptor, field encryption.Field, path []string, values []string) ([]string, error) {
+	if len(path) > len(field.Path) {
+		return nil, &EncryptedFieldError{Field: field.String(), Reason: "fields inside it cannot be filtered"}
+	}
+
+	indexes := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		variants := []interface{}{value}
+		if typed := jsonValue(query.Value{Text: value}); typed != value {
+			variants = append(variants, typed)
+		}
+		for _, variant := range variants {
+			index, err := enc.BlindIndex(field, variant)
+			if err != nil {
+				return nil, &EncryptedFieldError{Field: field.String(), Reason: err.Error()}
+			}
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, nil
+}
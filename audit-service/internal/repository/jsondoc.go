@@ -0,0 +1,308 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"audit-service/internal/model"
+)
+
+// Операторы JSONB и jsonpath Postgres над документами, разобранными
+// encoding/json (объекты - map[string]interface{}, числа - float64). Нужны
+// встроенным хранилищам, чтобы фильтры давали те же результаты, что и SQL.
+
+// jsonDocument возвращает значение JSONB-колонки; false - NULL в колонке
+func jsonDocument(doc *model.JSONB) (interface{}, bool) {
+	if doc == nil {
+		return nil, false
+	}
+	if *doc == nil {
+		return nil, true
+	}
+	return map[string]interface{}(*doc), true
+}
+
+// jsonExtract - оператор #>: ключи в объектах, индексы (с конца, если
+// отрицательные) в массивах. false - пути нет.
+func jsonExtract(doc interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			value, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			doc = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, false
+			}
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// jsonHasKey - оператор ?: ключ объекта верхнего уровня или строка среди
+// элементов массива
+func jsonHasKey(doc interface{}, key string) bool {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		_, ok := v[key]
+		return ok
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == key {
+				return true
+			}
+		}
+	case string:
+		return v == key
+	}
+	return false
+}
+
+// jsonbContains - оператор @>. Объект содержит объект, если содержит
+// значения всех его ключей; массив содержит массив, если каждый элемент
+// второго содержится в каком-то элементе первого. Скаляр внутри документа
+// с массивом не совпадает, только на верхнем уровне массив может содержать
+// скаляр.
+func jsonbContains(doc, contained interface{}) bool {
+	if _, ok := doc.([]interface{}); ok && !isJSONContainer(contained) {
+		contained = []interface{}{contained}
+	}
+	return jsonContains(doc, contained)
+}
+
+func jsonContains(doc, contained interface{}) bool {
+	switch c := contained.(type) {
+	case map[string]interface{}:
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range c {
+			v, ok := obj[key]
+			if !ok || !jsonContains(v, value) {
+				return false
+			}
+		}
+		return true
+
+	case []interface{}:
+		arr, ok := doc.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range c {
+			found := false
+			for _, v := range arr {
+				if isJSONContainer(value) == isJSONContainer(v) && jsonContains(v, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	if isJSONContainer(doc) {
+		return false
+	}
+	cmp, ok := compareJSONScalars(doc, contained)
+	return ok && cmp == 0
+}
+
+func isJSONContainer(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+	return false
+}
+
+// compareJSONScalars сравнивает скаляры одного типа. false - значения
+// разных типов или не скаляры, они в jsonpath несравнимы.
+func compareJSONScalars(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case nil:
+		return 0, b == nil
+	case bool:
+		b, ok := b.(bool)
+		switch {
+		case !ok:
+			return 0, false
+		case a == b:
+			return 0, true
+		case a:
+			return 1, true
+		}
+		return -1, true
+	case float64:
+		b, ok := b.(float64)
+		switch {
+		case !ok:
+			return 0, false
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
+
+// jsonPathItems возвращает значения по пути $."a"."b" в нестрогом (lax)
+// режиме jsonpath: массив на пути раскрывается на один уровень, элементы
+// без ключа пропускаются
+func jsonPathItems(doc interface{}, path []string) []interface{} {
+	items := []interface{}{doc}
+	for _, key := range path {
+		var next []interface{}
+		for _, item := range items {
+			for _, v := range unwrapArray(item) {
+				if obj, ok := v.(map[string]interface{}); ok {
+					if value, ok := obj[key]; ok {
+						next = append(next, value)
+					}
+				}
+			}
+		}
+		items = next
+	}
+	return items
+}
+
+// unwrapArray раскрывает массив на один уровень, как нестрогий режим
+// jsonpath
+func unwrapArray(v interface{}) []interface{} {
+	if arr, ok := v.([]interface{}); ok {
+		return arr
+	}
+	return []interface{}{v}
+}
+
+// jsonPathCompare - сравнение @ op $v в фильтре jsonpath: истинно, если
+// истинно для какого-нибудь значения раскрытого @. NULL равен только NULL,
+// значения разных типов не сравниваются.
+func jsonPathCompare(item interface{}, op string, value interface{}) bool {
+	for _, v := range unwrapArray(item) {
+		if cmp, ok := compareJSONScalars(v, value); ok && compareResult(cmp, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonText - значение, как его возвращает оператор #>>: строка без
+// кавычек, JSON null - NULL (false), остальное - текст jsonb
+func jsonText(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	}
+
+	var b strings.Builder
+	writeJSONBText(&b, v)
+	return b.String(), true
+}
+
+// writeJSONBText пишет значение в текстовом виде jsonb: ключи объекта
+// упорядочены по длине, затем побайтово, после запятой и двоеточия - пробел,
+// числа - без экспоненты
+func writeJSONBText(b *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		writeJSONBString(b, v)
+	case []interface{}:
+		b.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeJSONBText(b, item)
+		}
+		b.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		b.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeJSONBString(b, key)
+			b.WriteString(": ")
+			writeJSONBText(b, v[key])
+		}
+		b.WriteByte('}')
+	}
+}
+
+// writeJSONBString экранирует строку как escape_json в Postgres: только
+// кавычку, обратную косую черту и управляющие символы
+func writeJSONBString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(b, `\u%04x`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
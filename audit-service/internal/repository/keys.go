@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RowKey - ключ данных строки audit_events, зашифрованный ключом связки
+// KeyID (DEK - hex)
+type RowKey struct {
+	ID        int64
+	Timestamp time.Time
+	KeyID     string
+	DEK       string
+}
+
+// KeyRepository перешифровывает ключи данных строк при ротации ключей
+// связки. Менять enc_key_id и enc_dek может только роль audit_purger,
+// поэтому репозиторий работает на соединении пользователя очистки.
+type KeyRepository struct {
+	db *sql.DB
+}
+
+func NewKeyRepository(db *sql.DB) *KeyRepository {
+	return &KeyRepository{db: db}
+}
+
+// WithLock выполняет fn под блокировкой ротации. Если ротацию уже выполняет
+// другая реплика, fn не вызывается и возвращается false.
+func (r *KeyRepository) WithLock(ctx context.Context, fn func() error) (bool, error) {
+	return withAdvisoryLock(ctx, r.db, rekeyLockKey, func(*sql.Conn) error {
+		return fn()
+	})
+}
+
+// Usage возвращает число зашифрованных строк по id ключа связки
+func (r *KeyRepository) Usage(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT enc_key_id, count(*) FROM audit_events WHERE enc_key_id IS NOT NULL GROUP BY enc_key_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count encrypted events: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var keyID string
+		var count int64
+		if err := rows.Scan(&keyID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan key usage: %w", err)
+		}
+		usage[keyID] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return usage, nil
+}
+
+// Stale возвращает до limit ключей строк, зашифрованных не ключом activeID
+func (r *KeyRepository) Stale(ctx context.Context, activeID string, limit int) ([]RowKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, timestamp, enc_key_id, encode(enc_dek, 'hex')
+        FROM audit_events
+        WHERE enc_key_id IS NOT NULL AND enc_key_id <> $1
+        ORDER BY id
+        LIMIT $2
+    `, activeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stale keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []RowKey
+	for rows.Next() {
+		var key RowKey
+		if err := rows.Scan(&key.ID, &key.Timestamp, &key.KeyID, &key.DEK); err != nil {
+			return nil, fmt.Errorf("failed to scan row key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Rewrap заменяет ключи строк keys результатами rewrap одной транзакцией.
+// Триггер append-only пропускает UPDATE только с audit.rekey = on и только
+// этих двух колонок. Строка, чей ключ успел смениться, не перезаписывается.
+// Возвращает число обновлённых строк.
+func (r *KeyRepository) Rewrap(ctx context.Context, keys []RowKey, rewrap func(RowKey) (RowKey, error)) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('audit.rekey', 'on', true)"); err != nil {
+		return 0, fmt.Errorf("failed to start rekey: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+        UPDATE audit_events SET enc_key_id = $1, enc_dek = $2
+        WHERE id = $3 AND timestamp = $4 AND enc_key_id = $5
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare rekey: %w", err)
+	}
+	defer stmt.Close()
+
+	var rewrapped int64
+	for _, key := range keys {
+		updated, err := rewrap(key)
+		if err != nil {
+			return 0, err
+		}
+		res, err := stmt.ExecContext(ctx, updated.KeyID, hashBytes(updated.DEK), key.ID, key.Timestamp, key.KeyID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rekey event %d: %w", key.ID, err)
+		}
+		n, _ := res.RowsAffected()
+		rewrapped += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rekey: %w", err)
+	}
+
+	return rewrapped, nil
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Канал NOTIFY, в который триггер audit_events_notify публикует вставленные
+// события: "<от>|<до>|<id,id,...>" - границы времени и id через запятую
+const eventChannel = "audit_events"
+
+// Время в уведомлении - timestamp без пояса с микросекундами
+const notifyTimeLayout = "2006-01-02 15:04:05.999999"
+
+// EventListener получает уведомления о новых событиях через LISTEN. NOTIFY
+// не реплицируется на standby, поэтому подключаться нужно к primary (порт
+// записи HAProxy); после переключения primary pq.Listener переподключится сам.
+type EventListener struct {
+	listener *pq.Listener
+}
+
+func NewEventListener(connStr string) (*EventListener, error) {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Event listener connection error: %v", err)
+		}
+	})
+
+	if err := listener.Listen(eventChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen for audit events: %w", err)
+	}
+
+	return &EventListener{listener: listener}, nil
+}
+
+// Run передаёт в fn события из каждого уведомления, пока не отменён ctx
+func (l *EventListener) Run(ctx context.Context, fn func(InsertedEvents)) {
+	// Ping раз в полторы минуты, чтобы заметить разрыв соединения без уведомлений
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-l.listener.Notify:
+			if n == nil {
+				// Соединение переустановлено, уведомления за время разрыва потеряны
+				log.Printf("Event listener reconnected, notifications may have been missed")
+				continue
+			}
+			inserted, err := parseInsertedEvents(n.Extra)
+			if err != nil {
+				log.Printf("Malformed audit event notification: %v", err)
+				continue
+			}
+			fn(inserted)
+		case <-ping.C:
+			go l.listener.Ping()
+		}
+	}
+}
+
+func (l *EventListener) Close() error {
+	return l.listener.Close()
+}
+
+// parseInsertedEvents разбирает уведомление. Уведомления триггера до
+// миграции 015 содержат только id, тогда границы времени остаются нулевыми.
+func parseInsertedEvents(payload string) (InsertedEvents, error) {
+	var inserted InsertedEvents
+	if parts := strings.SplitN(payload, "|", 3); len(parts) == 3 {
+		var err error
+		if inserted.From, err = time.Parse(notifyTimeLayout, parts[0]); err != nil {
+			return InsertedEvents{}, err
+		}
+		if inserted.To, err = time.Parse(notifyTimeLayout, parts[1]); err != nil {
+			return InsertedEvents{}, err
+		}
+		payload = parts[2]
+	}
+
+	var err error
+	if inserted.IDs, err = parseEventIDs(payload); err != nil {
+		return InsertedEvents{}, err
+	}
+	return inserted, nil
+}
+
+func parseEventIDs(payload string) ([]int64, error) {
+	parts := strings.Split(payload, ",")
+	ids := make([]int64, 0, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
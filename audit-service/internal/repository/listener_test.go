@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseInsertedEvents(t *testing.T) {
+	got, err := parseInsertedEvents("2026-01-31 23:59:59.123456|2026-02-01 00:00:00|7,8,9")
+	if err != nil {
+		t.Fatalf("failed to parse notification: %v", err)
+	}
+	expected := InsertedEvents{
+		IDs:  []int64{7, 8, 9},
+		From: time.Date(2026, 1, 31, 23, 59, 59, 123456000, time.UTC),
+		To:   time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parsed %+v, expected %+v", got, expected)
+	}
+
+	// Уведомление триггера до миграции 015 - только id, без границ времени
+	got, err = parseInsertedEvents("7,8")
+	if err != nil || !reflect.DeepEqual(got.IDs, []int64{7, 8}) || !got.From.IsZero() || !got.To.IsZero() {
+		t.Errorf("parsed legacy notification as %+v (%v)", got, err)
+	}
+
+	for _, payload := range []string{"", "x|y|1", "2026-01-01 00:00:00|2026-01-01 00:00:00|1,a"} {
+		if _, err := parseInsertedEvents(payload); err == nil {
+			t.Errorf("malformed notification %q parsed", payload)
+		}
+	}
+}
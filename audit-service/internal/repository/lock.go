@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Ключи advisory-блокировок фоновых задач: каждую задачу в кластере
+// выполняет только одна реплика сервиса
+const (
+	partitionLockKey  = 7_311_001
+	retentionLockKey  = 7_311_002
+	archiveLockKey    = 7_311_003
+	checkpointLockKey = 7_311_004
+	rekeyLockKey      = 7_311_005
+)
+
+// withAdvisoryLock выполняет fn на отдельном соединении под сессионной
+// advisory-блокировкой key. Блокировка живёт на соединении, поэтому fn
+// должна работать только через conn. Если блокировку держит другая
+// реплика, fn не вызывается и возвращается false.
+func withAdvisoryLock(ctx context.Context, db *sql.DB, key int64, fn func(conn *sql.Conn) error) (bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+
+	return true, fn(conn)
+}
@@ -0,0 +1,507 @@
+package repository
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/query"
+)
+
+// eventMatcher проверяет событие на соответствие EventFilters без SQL - им
+// фильтруют встроенные хранилища (в памяти и SQLite). Семантика та же, что
+// у buildFilterConditions и compileQuery в Postgres, включая операторы
+// JSONB, ошибки компиляции q= тоже совпадают. Курсор в фильтр не входит,
+// его проверяет afterCursor. Строки сравниваются побайтово, как в базе с
+// COLLATE "C".
+type eventMatcher struct {
+	filters model.EventFilters
+	// Границы времени в виде, в котором время хранится (storedTime)
+	from, to *time.Time
+	ids      map[int64]bool
+	query    predicate
+}
+
+// predicate - скомпилированное условие выражения q=
+type predicate func(*model.AuditEvent) bool
+
+func newEventMatcher(filters model.EventFilters) (*eventMatcher, error) {
+	m := &eventMatcher{filters: filters}
+
+	if filters.Timestamp != nil {
+		ts := storedTime(*filters.Timestamp)
+		m.from, m.to = &ts, &ts
+	} else {
+		if filters.TimestampStart != nil {
+			from := storedTime(*filters.TimestampStart)
+			m.from = &from
+		}
+		if filters.TimestampEnd != nil {
+			to := storedTime(*filters.TimestampEnd)
+			m.to = &to
+		}
+	}
+
+	if len(filters.IDs) > 0 {
+		m.ids = make(map[int64]bool, len(filters.IDs))
+		for _, id := range filters.IDs {
+			m.ids[id] = true
+		}
+	}
+
+	if filters.Query != nil {
+		p, err := compilePredicate(filters.Query)
+		if err != nil {
+			return nil, err
+		}
+		m.query = p
+	}
+
+	return m, nil
+}
+
+func (m *eventMatcher) match(event *model.AuditEvent) bool {
+	f := m.filters
+
+	if m.from != nil && event.Timestamp.Before(*m.from) {
+		return false
+	}
+	if m.to != nil && event.Timestamp.After(*m.to) {
+		return false
+	}
+	if f.Tenant != "" && event.TenantID != f.Tenant {
+		return false
+	}
+
+	// NULL в колонке не совпадает ни с одним значением списка
+	if len(f.Users) > 0 && !containsString(f.Users, event.User) {
+		return false
+	}
+	if len(f.Components) > 0 && (event.Component == nil || !containsString(f.Components, *event.Component)) {
+		return false
+	}
+	if len(f.Operations) > 0 && !containsString(f.Operations, event.Operation) {
+		return false
+	}
+	if len(f.SessionIDs) > 0 && (event.SessionID == nil || !containsInt(f.SessionIDs, *event.SessionID)) {
+		return false
+	}
+	if len(f.RequestIDs) > 0 && (event.RequestID == nil || !containsInt(f.RequestIDs, *event.RequestID)) {
+		return false
+	}
+	if m.ids != nil && !m.ids[event.ID] {
+		return false
+	}
+
+	// Как column #>> path = ANY(values): текст значения по пути
+	matchJSON := func(doc *model.JSONB, fields map[string][]string) bool {
+		for key, values := range fields {
+			if len(values) == 0 {
+				continue
+			}
+			root, ok := jsonDocument(doc)
+			if !ok {
+				return false
+			}
+			value, ok := jsonExtract(root, strings.Split(key, "."))
+			if !ok {
+				return false
+			}
+			text, ok := jsonText(value)
+			if !ok || !containsString(values, text) {
+				return false
+			}
+		}
+		return true
+	}
+	if !matchJSON(event.Attributes, f.Attributes) || !matchJSON(event.Response, f.Response) {
+		return false
+	}
+
+	return m.query == nil || m.query(event)
+}
+
+// EventMatcher проверяет на соответствие фильтрам уже прочитанные
+// события, например живая лента - события из уведомления о вставке
+type EventMatcher struct {
+	m *eventMatcher
+}
+
+// NewEventMatcher возвращает ошибку компиляции q= той же формы, что и
+// FindEvents. Курсор и лимит не проверяются.
+func NewEventMatcher(filters model.EventFilters) (*EventMatcher, error) {
+	m, err := newEventMatcher(filters)
+	if err != nil {
+		return nil, err
+	}
+	return &EventMatcher{m: m}, nil
+}
+
+func (m *EventMatcher) Match(event *model.AuditEvent) bool {
+	return m.m.match(event)
+}
+
+// afterCursor сообщает, идёт ли событие строго после курсора в порядке
+// выдачи FindEvents (timestamp DESC, id DESC)
+func afterCursor(event *model.AuditEvent, cursor *model.EventCursor) bool {
+	if cursor == nil {
+		return true
+	}
+	ts := storedTime(cursor.Timestamp)
+	return event.Timestamp.Before(ts) || event.Timestamp.Equal(ts) && event.ID < cursor.ID
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int64, value int64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePredicate компилирует дерево выражения q= в проверку события.
+// Сравнение с NULL в колонке ложно, а отрицание, как IS NOT TRUE в SQL,
+// такие события включает, поэтому двузначной логики достаточно.
+func compilePredicate(node query.Node) (predicate, error) {
+	switch n := node.(type) {
+	case *query.BinaryExpr:
+		left, err := compilePredicate(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compilePredicate(n.Right)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == query.OpOr {
+			return func(e *model.AuditEvent) bool { return left(e) || right(e) }, nil
+		}
+		return func(e *model.AuditEvent) bool { return left(e) && right(e) }, nil
+
+	case *query.NotExpr:
+		expr, err := compilePredicate(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(e *model.AuditEvent) bool { return !expr(e) }, nil
+
+	case *query.Comparison:
+		return comparisonPredicate(n)
+	}
+
+	return nil, query.Errorf(node.Pos(), "unsupported expression")
+}
+
+// comparisonPredicate - аналог queryCompiler.compileComparison
+func comparisonPredicate(cmp *query.Comparison) (predicate, error) {
+	if column, ok := jsonColumns[cmp.Field.Name]; ok {
+		return jsonPredicate(cmp, column)
+	}
+
+	switch cmp.Op {
+	case query.OpContains, query.OpExists, query.OpNotExists:
+		return nil, query.Errorf(cmp.Pos(), "operator %s applies only to attributes and res", cmp.Op)
+	}
+
+	column, err := resolveField(cmp.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(cmp.Values))
+	for i, v := range cmp.Values {
+		value, err := convertValue(v, column.typ)
+		if err != nil {
+			return nil, err
+		}
+		switch column.typ {
+		case columnTime:
+			value = storedTime(value.(time.Time))
+		case columnUUID:
+			value = strings.ToLower(value.(string))
+		}
+		values[i] = value
+	}
+
+	get := columnValue(column.sql)
+	compare := func(e *model.AuditEvent, test func(value interface{}) bool) bool {
+		value, ok := get(e)
+		return ok && test(value)
+	}
+
+	switch cmp.Op {
+	case query.OpEq, query.OpNe, query.OpLt, query.OpLe, query.OpGt, query.OpGe:
+		op := cmp.Op
+		return func(e *model.AuditEvent) bool {
+			return compare(e, func(v interface{}) bool { return compareResult(compareColumn(v, values[0]), op) })
+		}, nil
+
+	case query.OpPrefix:
+		if column.typ != columnText {
+			return nil, query.Errorf(cmp.Pos(), "operator ^= applies only to text fields, '%s' is not text", cmp.Field)
+		}
+		prefix := values[0].(string)
+		return func(e *model.AuditEvent) bool {
+			return compare(e, func(v interface{}) bool { return strings.HasPrefix(v.(string), prefix) })
+		}, nil
+
+	case query.OpIn, query.OpNotIn:
+		in := cmp.Op == query.OpIn
+		return func(e *model.AuditEvent) bool {
+			return compare(e, func(v interface{}) bool {
+				for _, value := range values {
+					if compareColumn(v, value) == 0 {
+						return in
+					}
+				}
+				return !in
+			})
+		}, nil
+
+	case query.OpBetween:
+		return func(e *model.AuditEvent) bool {
+			return compare(e, func(v interface{}) bool {
+				return compareColumn(v, values[0]) >= 0 && compareColumn(v, values[1]) <= 0
+			})
+		}, nil
+	}
+
+	return nil, query.Errorf(cmp.Pos(), "unsupported operator %s", cmp.Op)
+}
+
+// columnValue возвращает чтение колонки из queryColumns; false - NULL
+func columnValue(column string) func(*model.AuditEvent) (interface{}, bool) {
+	switch column {
+	case "id":
+		return func(e *model.AuditEvent) (interface{}, bool) { return e.ID, true }
+	case "event_id":
+		return func(e *model.AuditEvent) (interface{}, bool) {
+			return strings.ToLower(e.EventID), e.EventID != ""
+		}
+	case "timestamp":
+		return func(e *model.AuditEvent) (interface{}, bool) { return e.Timestamp, true }
+	case "user_id":
+		return func(e *model.AuditEvent) (interface{}, bool) { return e.User, true }
+	case "component":
+		return func(e *model.AuditEvent) (interface{}, bool) {
+			if e.Component == nil {
+				return nil, false
+			}
+			return *e.Component, true
+		}
+	case "operation":
+		return func(e *model.AuditEvent) (interface{}, bool) { return e.Operation, true }
+	case "session_id":
+		return func(e *model.AuditEvent) (interface{}, bool) {
+			if e.SessionID == nil {
+				return nil, false
+			}
+			return *e.SessionID, true
+		}
+	case "request_id":
+		return func(e *model.AuditEvent) (interface{}, bool) {
+			if e.RequestID == nil {
+				return nil, false
+			}
+			return *e.RequestID, true
+		}
+	case "actor":
+		return func(e *model.AuditEvent) (interface{}, bool) { return e.Actor, e.Actor != "" }
+	case "tenant_id":
+		return func(e *model.AuditEvent) (interface{}, bool) { return e.TenantID, true }
+	}
+	return func(*model.AuditEvent) (interface{}, bool) { return nil, false }
+}
+
+// compareColumn сравнивает значения колонки одного типа: int64, time.Time
+// или string
+func compareColumn(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	return 0
+}
+
+// compareResult применяет оператор сравнения к результату compare
+func compareResult(cmp int, op string) bool {
+	switch op {
+	case query.OpEq:
+		return cmp == 0
+	case query.OpNe:
+		return cmp != 0
+	case query.OpLt:
+		return cmp < 0
+	case query.OpLe:
+		return cmp <= 0
+	case query.OpGt:
+		return cmp > 0
+	case query.OpGe:
+		return cmp >= 0
+	}
+	return false
+}
+
+// jsonPredicate - аналог queryCompiler.compileJSONComparison: те же
+// операторы JSONB и jsonpath над разобранным документом
+func jsonPredicate(cmp *query.Comparison, column string) (predicate, error) {
+	path := cmp.Field.Path
+	doc := func(e *model.AuditEvent) (interface{}, bool) {
+		if column == "attributes" {
+			return jsonDocument(e.Attributes)
+		}
+		return jsonDocument(e.Response)
+	}
+
+	switch cmp.Op {
+	case query.OpExists, query.OpNotExists:
+		var exists predicate
+		switch len(path) {
+		case 0:
+			exists = func(e *model.AuditEvent) bool {
+				_, ok := doc(e)
+				return ok
+			}
+		case 1:
+			exists = func(e *model.AuditEvent) bool {
+				root, ok := doc(e)
+				return ok && jsonHasKey(root, path[0])
+			}
+		default:
+			exists = func(e *model.AuditEvent) bool {
+				root, ok := doc(e)
+				return ok && len(jsonPathItems(root, path)) > 0
+			}
+		}
+		if cmp.Op == query.OpNotExists {
+			return func(e *model.AuditEvent) bool { return !exists(e) }, nil
+		}
+		return exists, nil
+
+	case query.OpContains:
+		var value interface{}
+		if err := json.Unmarshal([]byte(cmp.Values[0].Text), &value); err != nil {
+			return nil, query.Errorf(cmp.Values[0].Pos, "operator @> expects a JSON document: %v", err)
+		}
+		contained := wrapPath(path, value)
+		return func(e *model.AuditEvent) bool {
+			root, ok := doc(e)
+			return ok && jsonbContains(root, contained)
+		}, nil
+
+	case query.OpEq, query.OpNe, query.OpIn, query.OpNotIn:
+		if len(path) == 0 {
+			return nil, query.Errorf(cmp.Field.Pos, "'%s' needs a path, e.g. %s.key, or use @>", cmp.Field, cmp.Field)
+		}
+
+		contained := make([]interface{}, len(cmp.Values))
+		for i, v := range cmp.Values {
+			contained[i] = wrapPath(path, jsonScalar(v))
+		}
+		negate := cmp.Op == query.OpNe || cmp.Op == query.OpNotIn
+		return func(e *model.AuditEvent) bool {
+			root, ok := doc(e)
+			if !ok {
+				return false
+			}
+			// != и NOT IN не выбирают события без этого поля
+			if negate {
+				if _, ok := jsonExtract(root, path); !ok {
+					return false
+				}
+			}
+			for _, c := range contained {
+				if jsonbContains(root, c) {
+					return !negate
+				}
+			}
+			return negate
+		}, nil
+
+	case query.OpLt, query.OpLe, query.OpGt, query.OpGe, query.OpPrefix, query.OpBetween:
+		if len(path) == 0 {
+			return nil, query.Errorf(cmp.Field.Pos, "'%s' needs a path, e.g. %s.key", cmp.Field, cmp.Field)
+		}
+
+		var filter func(item interface{}) bool
+		switch cmp.Op {
+		case query.OpBetween:
+			lo, hi := jsonScalar(cmp.Values[0]), jsonScalar(cmp.Values[1])
+			filter = func(item interface{}) bool {
+				return jsonPathCompare(item, query.OpGe, lo) && jsonPathCompare(item, query.OpLe, hi)
+			}
+		case query.OpPrefix:
+			prefix := cmp.Values[0].Text
+			filter = func(item interface{}) bool {
+				for _, v := range unwrapArray(item) {
+					if s, ok := v.(string); ok && strings.HasPrefix(s, prefix) {
+						return true
+					}
+				}
+				return false
+			}
+		default:
+			op, value := cmp.Op, jsonScalar(cmp.Values[0])
+			filter = func(item interface{}) bool { return jsonPathCompare(item, op, value) }
+		}
+
+		// path ? (filter): фильтр в нестрогом режиме раскрывает массив
+		return func(e *model.AuditEvent) bool {
+			root, ok := doc(e)
+			if !ok {
+				return false
+			}
+			for _, item := range jsonPathItems(root, path) {
+				for _, v := range unwrapArray(item) {
+					if filter(v) {
+						return true
+					}
+				}
+			}
+			return false
+		}, nil
+	}
+
+	return nil, query.Errorf(cmp.Pos(), "operator %s is not supported for '%s'", cmp.Op, cmp.Field)
+}
+
+// jsonScalar - значение jsonValue с числом в виде float64, как после
+// разбора JSON
+func jsonScalar(v query.Value) interface{} {
+	value := jsonValue(v)
+	if n, ok := value.(json.Number); ok {
+		f, _ := n.Float64()
+		return f
+	}
+	return value
+}
+
+// wrapPath строит документ {"a": {"b": value}} для пути a.b
+func wrapPath(path []string, value interface{}) interface{} {
+	for i := len(path) - 1; i >= 0; i-- {
+		value = map[string]interface{}{path[i]: value}
+	}
+	return value
+}
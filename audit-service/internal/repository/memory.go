@@ -0,0 +1,409 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"audit-service/internal/chain"
+	"audit-service/internal/model"
+)
+
+// memoryRepository хранит события в памяти процесса - для разработки и
+// тестов без Postgres. Данные теряются при перезапуске. События лежат
+// копиями в порядке (timestamp, id), наружу тоже отдаются копии.
+type memoryRepository struct {
+	mu        sync.RWMutex
+	events    []*model.AuditEvent
+	byID      map[int64]*model.AuditEvent
+	byEventID map[string]*model.AuditEvent
+	byKey     map[string]*model.AuditEvent
+	lastID    int64
+	chainSeq  int64
+	chainHead string
+	notify    func(InsertedEvents)
+}
+
+func NewMemoryRepository() AuditRepository {
+	return &memoryRepository{
+		byID:      make(map[int64]*model.AuditEvent),
+		byEventID: make(map[string]*model.AuditEvent),
+		byKey:     make(map[string]*model.AuditEvent),
+	}
+}
+
+func (r *memoryRepository) NotifyInserts(fn func(InsertedEvents)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notify = fn
+}
+
+// StoreEvent сохраняет событие очередным звеном цепочки хешей. Если событие
+// с тем же event_id или ключом идемпотентности уже есть, возвращает
+// сохранённое ранее вместе с ErrDuplicateEvent.
+func (r *memoryRepository) StoreEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error) {
+	defaultTenant(event)
+	r.mu.Lock()
+	if existing := r.findByIdentity(event); existing != nil {
+		r.mu.Unlock()
+		if existing.TenantID != event.TenantID {
+			return nil, ErrEventIDTaken
+		}
+		return cloneEvent(existing), ErrDuplicateEvent
+	}
+
+	stored, err := r.link([]*model.AuditEvent{event})
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	notify := r.insert(stored)
+	r.mu.Unlock()
+
+	notify()
+	return event, nil
+}
+
+// findByIdentity ищет событие с тем же event_id или ключом идемпотентности
+// арендатора event. Найденное по event_id может принадлежать другому
+// арендатору.
+func (r *memoryRepository) findByIdentity(event *model.AuditEvent) *model.AuditEvent {
+	if stored := r.byKey[tenantKey(event.TenantID, event.IdempotencyKey)]; event.IdempotencyKey != "" && stored != nil {
+		return stored
+	}
+	if stored := r.byEventID[event.EventID]; event.EventID != "" && stored != nil {
+		return stored
+	}
+	return nil
+}
+
+// StoreEvents записывает пачку событий разом и возвращает для каждого
+// признак дубликата. Дубликаты - уже сохранённые или встретившиеся раньше в
+// пачке - не пишутся, а получают id оригинала.
+func (r *memoryRepository) StoreEvents(ctx context.Context, events []*model.AuditEvent) ([]bool, error) {
+	duplicates := make([]bool, len(events))
+	if len(events) == 0 {
+		return duplicates, nil
+	}
+	defaultTenant(events...)
+
+	r.mu.Lock()
+	originals := make([]*model.AuditEvent, len(events))
+	byEventID := make(map[string]*model.AuditEvent)
+	byKey := make(map[string]*model.AuditEvent)
+	fresh := make([]*model.AuditEvent, 0, len(events))
+	for i, event := range events {
+		original := r.findByIdentity(event)
+		if original == nil && event.EventID != "" {
+			original = byEventID[event.EventID]
+		}
+		if original == nil && event.IdempotencyKey != "" {
+			original = byKey[tenantKey(event.TenantID, event.IdempotencyKey)]
+		}
+		if original != nil && original.TenantID != event.TenantID {
+			r.mu.Unlock()
+			return nil, ErrEventIDTaken
+		}
+		if original != nil {
+			duplicates[i] = true
+			originals[i] = original
+			continue
+		}
+
+		if event.EventID != "" {
+			byEventID[event.EventID] = event
+		}
+		if event.IdempotencyKey != "" {
+			byKey[tenantKey(event.TenantID, event.IdempotencyKey)] = event
+		}
+		fresh = append(fresh, event)
+	}
+
+	stored, err := r.link(fresh)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	notify := r.insert(stored)
+
+	for i, original := range originals {
+		if original == nil {
+			continue
+		}
+		events[i].ID = original.ID
+		events[i].EventID = original.EventID
+		events[i].CreatedAt = original.CreatedAt
+	}
+	r.mu.Unlock()
+
+	notify()
+	return duplicates, nil
+}
+
+// ReplayEvents идемпотентно записывает события, уже получившие event_id:
+// события, которые есть в хранилище, пропускаются. Возвращает число
+// вставленных.
+func (r *memoryRepository) ReplayEvents(ctx context.Context, events []*model.AuditEvent) (int, error) {
+	defaultTenant(events...)
+	for _, event := range events {
+		if event.EventID == "" {
+			return 0, fmt.Errorf("cannot replay event without event_id")
+		}
+	}
+
+	r.mu.Lock()
+	seenIDs := make(map[string]bool)
+	seenKeys := make(map[string]bool)
+	fresh := make([]*model.AuditEvent, 0, len(events))
+	for _, event := range events {
+		key := tenantKey(event.TenantID, event.IdempotencyKey)
+		if r.findByIdentity(event) != nil ||
+			seenIDs[event.EventID] || event.IdempotencyKey != "" && seenKeys[key] {
+			continue
+		}
+		seenIDs[event.EventID] = true
+		if event.IdempotencyKey != "" {
+			seenKeys[key] = true
+		}
+		fresh = append(fresh, event)
+	}
+
+	stored, err := r.link(fresh)
+	if err != nil {
+		r.mu.Unlock()
+		return 0, err
+	}
+	notify := r.insert(stored)
+	r.mu.Unlock()
+
+	notify()
+	return len(fresh), nil
+}
+
+// link делает события подряд идущими звеньями цепочки после её головы и
+// возвращает их сохраняемые копии. Само хранилище не меняется, пока копии
+// не переданы в insert, поэтому ошибка не оставляет половины пачки.
+// Вызывается под r.mu.
+func (r *memoryRepository) link(events []*model.AuditEvent) ([]*model.AuditEvent, error) {
+	createdAt := time.Now().UTC()
+	seq, head := r.chainSeq, r.chainHead
+
+	stored := make([]*model.AuditEvent, len(events))
+	for i, event := range events {
+		event.ID = r.lastID + int64(i) + 1
+		event.CreatedAt = createdAt
+		if err := chain.Link(event, seq+1, head); err != nil {
+			return nil, err
+		}
+		seq, head = event.ChainSeq, event.Hash
+
+		var err error
+		if stored[i], err = storedEvent(event); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// insert добавляет звенья из link в хранилище и возвращает уведомление о
+// них, которое вызывается после снятия блокировки. Вызывается под r.mu.
+func (r *memoryRepository) insert(events []*model.AuditEvent) func() {
+	if len(events) == 0 {
+		return func() {}
+	}
+
+	for _, event := range events {
+		// Почти всегда событие новее всех, тогда это добавление в конец
+		at := sort.Search(len(r.events), func(j int) bool { return eventLess(event, r.events[j]) })
+		r.events = append(r.events, nil)
+		copy(r.events[at+1:], r.events[at:])
+		r.events[at] = event
+
+		r.byID[event.ID] = event
+		if event.EventID != "" {
+			r.byEventID[event.EventID] = event
+		}
+		if event.IdempotencyKey != "" {
+			r.byKey[tenantKey(event.TenantID, event.IdempotencyKey)] = event
+		}
+	}
+
+	last := events[len(events)-1]
+	r.lastID, r.chainSeq, r.chainHead = last.ID, last.ChainSeq, last.Hash
+
+	notify := r.notify
+	inserted := newInsertedEvents(events)
+	return func() {
+		if notify != nil {
+			notify(inserted)
+		}
+	}
+}
+
+func (r *memoryRepository) GetEvent(ctx context.Context, id int64) (*model.AuditEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	event, ok := r.byID[id]
+	if !ok || !tenantVisible(ctx, event) {
+		return nil, ErrEventNotFound
+	}
+	return cloneEvent(event), nil
+}
+
+func (r *memoryRepository) FindStored(ctx context.Context, events []*model.AuditEvent) ([]*model.AuditEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored := make([]*model.AuditEvent, len(events))
+	for i, event := range events {
+		if original := r.findByIdentity(event); original != nil && original.TenantID == event.TenantID {
+			stored[i] = cloneEvent(original)
+		}
+	}
+	return stored, nil
+}
+
+func (r *memoryRepository) FindEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error) {
+	filters = scopeFilters(ctx, filters)
+	m, err := newEventMatcher(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var events []*model.AuditEvent
+	candidates := r.candidates(m)
+	for i := len(candidates) - 1; i >= 0 && len(events) < limit; i-- {
+		event := candidates[i]
+		if afterCursor(event, filters.Cursor) && m.match(event) {
+			events = append(events, cloneEvent(event))
+		}
+	}
+
+	if len(filters.Archived) > 0 {
+		return withArchived(events, filters, m, limit, func(event *model.AuditEvent) (bool, error) {
+			stored, ok := r.byID[event.ID]
+			return ok && stored.Timestamp.Equal(event.Timestamp), nil
+		})
+	}
+
+	return events, nil
+}
+
+// candidates сужает перебор для фильтра m: по id через индекс, по времени -
+// двоичным поиском в упорядоченных событиях. Результат упорядочен по
+// (timestamp, id). Вызывается под r.mu.
+func (r *memoryRepository) candidates(m *eventMatcher) []*model.AuditEvent {
+	if m.ids != nil {
+		var events []*model.AuditEvent
+		for id := range m.ids {
+			if event, ok := r.byID[id]; ok {
+				events = append(events, event)
+			}
+		}
+		sort.Slice(events, func(i, j int) bool { return eventLess(events[i], events[j]) })
+		return events
+	}
+
+	lo, hi := 0, len(r.events)
+	if m.from != nil {
+		lo = sort.Search(len(r.events), func(i int) bool { return !r.events[i].Timestamp.Before(*m.from) })
+	}
+	if m.to != nil {
+		hi = sort.Search(len(r.events), func(i int) bool { return r.events[i].Timestamp.After(*m.to) })
+	}
+	if lo > hi {
+		return nil
+	}
+	return r.events[lo:hi]
+}
+
+// scan перебирает подходящие под фильтры события от старых к новым под
+// блокировкой чтения, поэтому fn не должна обращаться к хранилищу
+func (r *memoryRepository) scan(ctx context.Context, filters model.EventFilters) (eventScan, error) {
+	m, err := newEventMatcher(scopeFilters(ctx, filters))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(fn func(*model.AuditEvent) error) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		for _, event := range r.candidates(m) {
+			if !m.match(event) {
+				continue
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+func (r *memoryRepository) AggregateEvents(ctx context.Context, req model.AggregateRequest) (*model.AggregateResult, error) {
+	scan, err := r.scan(ctx, req.Filters)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateScan(req, scan)
+}
+
+func (r *memoryRepository) ExportColumns(ctx context.Context, filters model.EventFilters, limit int) (*model.ExportColumns, error) {
+	scan, err := r.scan(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	return exportColumnsScan(limit, scan)
+}
+
+// ExportEvents передаёт подходящие события в fn от старых к новым. fn
+// пишет в сеть, поэтому вызывается не под блокировкой, а по снимку
+// подходящих событий; по нему же считаются колонки.
+func (r *memoryRepository) ExportEvents(ctx context.Context, filters model.EventFilters, columnLimit int,
+	columns func(*model.ExportColumns) error, fn func(*model.AuditEvent) error) error {
+	scan, err := r.scan(ctx, filters)
+	if err != nil {
+		return err
+	}
+
+	var events []*model.AuditEvent
+	err = scan(func(event *model.AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if columnLimit > 0 {
+		paths, err := exportColumnsScan(columnLimit, sliceScan(events))
+		if err != nil {
+			return err
+		}
+		if err := columns(paths); err != nil {
+			return err
+		}
+	}
+
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(cloneEvent(event)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
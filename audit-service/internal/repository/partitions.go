@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Имена месячных секций audit_events: audit_events_p202401
+const partitionPrefix = "audit_events_p"
+
+type PartitionReport struct {
+	Created  []string
+	Detached []string
+}
+
+type PartitionRepository struct {
+	db *sql.DB
+}
+
+func NewPartitionRepository(db *sql.DB) *PartitionRepository {
+	return &PartitionRepository{db: db}
+}
+
+// Maintain создаёт секции с месяца now по месяц now+ahead включительно и
+// отсоединяет секции, которые целиком старше detachBefore (нулевое время -
+// не отсоединять). Отсоединённые секции остаются отдельными таблицами.
+// Если блокировку держит другая реплика, ничего не делает и возвращает
+// false.
+func (r *PartitionRepository) Maintain(ctx context.Context, now time.Time, ahead int, detachBefore time.Time) (*PartitionReport, bool, error) {
+	report := &PartitionReport{}
+
+	locked, err := withAdvisoryLock(ctx, r.db, partitionLockKey, func(conn *sql.Conn) error {
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i <= ahead; i++ {
+			created, err := createPartition(ctx, conn, month.AddDate(0, i, 0))
+			if err != nil {
+				return err
+			}
+			if created != "" {
+				report.Created = append(report.Created, created)
+			}
+		}
+
+		if detachBefore.IsZero() {
+			return nil
+		}
+		detached, err := detachPartitions(ctx, conn, detachBefore)
+		report.Detached = detached
+		return err
+	})
+
+	return report, locked, err
+}
+
+// createPartition создаёт секцию месяца, если её ещё нет, и возвращает её имя.
+// Секции создаёт функция audit_create_partition с правами владельца таблицы:
+// у пользователя сервиса их нет.
+func createPartition(ctx context.Context, conn *sql.Conn, month time.Time) (string, error) {
+	var name sql.NullString
+	if err := conn.QueryRowContext(ctx, "SELECT audit_create_partition($1)", month.Format("2006-01-02")).Scan(&name); err != nil {
+		return "", fmt.Errorf("failed to create partition %s: %w", partitionPrefix+month.Format("200601"), err)
+	}
+	return name.String, nil
+}
+
+type monthlyPartition struct {
+	name  string
+	month time.Time
+}
+
+// listMonthlyPartitions возвращает подключённые секции, созданные менеджером
+// (audit_events_pYYYYMM), от старых к новым
+func listMonthlyPartitions(ctx context.Context, conn *sql.Conn) ([]monthlyPartition, error) {
+	rows, err := conn.QueryContext(ctx, `
+        SELECT c.relname
+        FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'audit_events'::regclass AND c.relname LIKE 'audit_events\_p%'
+        ORDER BY c.relname
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []monthlyPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		month, err := time.Parse("200601", strings.TrimPrefix(name, partitionPrefix))
+		if err != nil {
+			// Секция создана не менеджером, не трогаем
+			continue
+		}
+		partitions = append(partitions, monthlyPartition{name: name, month: month})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return partitions, nil
+}
+
+// detachPartitions отсоединяет месячные секции, верхняя граница которых не
+// позже before
+func detachPartitions(ctx context.Context, conn *sql.Conn, before time.Time) ([]string, error) {
+	partitions, err := listMonthlyPartitions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var detached []string
+	for _, p := range partitions {
+		if p.month.AddDate(0, 1, 0).After(before) {
+			continue
+		}
+		// Функция запоминает хеши звеньев секции: отсоединённая секция выпадает
+		// из цепочки так же, как удалённая
+		if _, err := conn.ExecContext(ctx, "SELECT audit_detach_partition($1)", p.name); err != nil {
+			return detached, fmt.Errorf("failed to detach partition %s: %w", p.name, err)
+		}
+		detached = append(detached, p.name)
+	}
+
+	return detached, nil
+}
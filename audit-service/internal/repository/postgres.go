@@ -3,36 +3,114 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"audit-service/internal/chain"
+	"audit-service/internal/encryption"
 	"audit-service/internal/model"
+	"audit-service/internal/tenant"
 
 	"github.com/lib/pq"
 )
 
 type AuditRepository interface {
 	StoreEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error)
+	StoreEvents(ctx context.Context, events []*model.AuditEvent) ([]bool, error)
+	ReplayEvents(ctx context.Context, events []*model.AuditEvent) (int, error)
+	GetEvent(ctx context.Context, id int64) (*model.AuditEvent, error)
+	// FindStored возвращает для каждого из events уже сохранённое событие
+	// его арендатора с тем же event_id или ключом идемпотентности (с id,
+	// event_id и created_at) или nil, если такого нет
+	FindStored(ctx context.Context, events []*model.AuditEvent) ([]*model.AuditEvent, error)
 	FindEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error)
+	AggregateEvents(ctx context.Context, req model.AggregateRequest) (*model.AggregateResult, error)
+	ExportColumns(ctx context.Context, filters model.EventFilters, limit int) (*model.ExportColumns, error)
+	// ExportEvents передаёт в fn все события, подходящие под фильтры, от
+	// старых к новым. Если columnLimit больше нуля, до первого события в
+	// columns передаются пути JSONB выборки, как от ExportColumns. Пути и
+	// события читаются из одного снимка данных, поэтому у каждого
+	// выгруженного значения есть колонка.
+	ExportEvents(ctx context.Context, filters model.EventFilters, columnLimit int,
+		columns func(*model.ExportColumns) error, fn func(*model.AuditEvent) error) error
 }
 
+// postgresRepository шифрует настроенные поля событий при записи и
+// расшифровывает при чтении; enc может быть nil
 type postgresRepository struct {
-	db *sql.DB
+	db  *sql.DB
+	enc *encryption.Encryptor
 }
 
-func NewAuditRepository(db *sql.DB) AuditRepository {
-	return &postgresRepository{db: db}
+func NewAuditRepository(db *sql.DB, enc *encryption.Encryptor) AuditRepository {
+	return &postgresRepository{db: db, enc: enc}
 }
 
-func (r *postgresRepository) StoreEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error) {
-	query := `
-        INSERT INTO audit_events 
-        (timestamp, user_id, component, operation, session_id, request_id, response, attributes)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-        RETURNING id, created_at
+// Колонки события в порядке, который ожидает scanEvent
+const eventColumns = `id, COALESCE(event_id::text, ''), COALESCE(idempotency_key, ''), timestamp, user_id, component, operation, session_id, request_id, response, attributes, created_at,
+    COALESCE(chain_seq, 0), COALESCE(encode(prev_hash, 'hex'), ''), COALESCE(encode(hash, 'hex'), ''),
+    COALESCE(enc_key_id, ''), COALESCE(encode(enc_dek, 'hex'), ''), COALESCE(actor, ''), tenant_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEvent(row rowScanner) (*model.AuditEvent, error) {
+	var event model.AuditEvent
+	err := row.Scan(
+		&event.ID,
+		&event.EventID,
+		&event.IdempotencyKey,
+		&event.Timestamp,
+		&event.User,
+		&event.Component,
+		&event.Operation,
+		&event.SessionID,
+		&event.RequestID,
+		&event.Response,
+		&event.Attributes,
+		&event.CreatedAt,
+		&event.ChainSeq,
+		&event.PrevHash,
+		&event.Hash,
+		&event.EncKeyID,
+		&event.EncDEK,
+		&event.Actor,
+		&event.TenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// insertEventQuery вставляет одно событие, если его event_id и ключ
+// идемпотентности ещё не заняты. Глобальная уникальность проверяется в
+// audit_event_identities: в секционированной audit_events уникальный индекс
+// обязан включать timestamp. Вставка в обе таблицы - один оператор, поэтому
+// они не расходятся. Параметры - в порядке insertEventArgs.
+const insertEventQuery = `
+        WITH identity AS (
+            INSERT INTO audit_event_identities (event_id, idempotency_key, id, timestamp, tenant_id)
+            VALUES ($1, $2, $11, $3, $19)
+            ON CONFLICT DO NOTHING
+            RETURNING id
+        )
+        INSERT INTO audit_events
+        (id, event_id, idempotency_key, timestamp, user_id, component, operation, session_id, request_id, response, attributes,
+         created_at, chain_seq, prev_hash, hash, enc_key_id, enc_dek, actor, tenant_id)
+        SELECT id, $1::uuid, $2::text, $3::timestamp, $4::text, $5::text, $6::text, $7::bigint, $8::bigint, $9::jsonb, $10::jsonb,
+            $12::timestamp, $13::bigint, $14::bytea, $15::bytea, $16::text, $17::bytea, $18::text, $19::text
+        FROM identity
     `
 
-	err := r.db.QueryRowContext(ctx, query,
+func insertEventArgs(event *model.AuditEvent) []interface{} {
+	return []interface{}{
+		nullableString(event.EventID),
+		nullableString(event.IdempotencyKey),
 		event.Timestamp,
 		event.User,
 		event.Component,
@@ -41,20 +119,584 @@ func (r *postgresRepository) StoreEvent(ctx context.Context, event *model.AuditE
 		event.RequestID,
 		event.Response,
 		event.Attributes,
-	).Scan(&event.ID, &event.CreatedAt)
+		event.ID,
+		event.CreatedAt,
+		event.ChainSeq,
+		hashBytes(event.PrevHash),
+		hashBytes(event.Hash),
+		nullableString(event.EncKeyID),
+		hashBytes(event.EncDEK),
+		nullableString(event.Actor),
+		event.TenantID,
+	}
+}
+
+// StoreEvent сохраняет событие очередным звеном цепочки хешей. Если событие
+// с тем же event_id или ключом идемпотентности уже есть, возвращает
+// сохранённое ранее вместе с ErrDuplicateEvent.
+func (r *postgresRepository) StoreEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error) {
+	defaultTenant(event)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setTenant(ctx, tx); err != nil {
+		return nil, err
+	}
+	ids, err := reserveEventIDs(ctx, tx, 1)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := transactionTime(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	event.ID = ids[0]
+	event.CreatedAt = createdAt
+
+	seq, head, err := lockChainHead(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := chain.Link(event, seq+1, head); err != nil {
+		return nil, err
+	}
+	sealed, err := r.enc.Seal(event)
+	if err != nil {
+		return nil, err
+	}
 
+	res, err := tx.ExecContext(ctx, insertEventQuery, insertEventArgs(sealed)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to store audit event: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Ничего не вставлено из-за конфликта: это повтор уже сохранённого события
+		tx.Rollback()
+		existing, err := r.findByIdentity(ctx, event.TenantID, event.EventID, event.IdempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		return existing, ErrDuplicateEvent
+	}
+
+	if err := saveChainHead(ctx, tx, event.ChainSeq, event.Hash); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit audit event: %w", err)
+	}
 
 	return event, nil
 }
 
-func (r *postgresRepository) FindEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error) {
+// findByIdentity ищет событие арендатора tenantID с тем же event_id или
+// ключом идемпотентности. Если event_id занят событием другого арендатора,
+// возвращает ErrEventIDTaken.
+func (r *postgresRepository) findByIdentity(ctx context.Context, tenantID, eventID, idempotencyKey string) (*model.AuditEvent, error) {
+	// По (id, timestamp) из audit_event_identities поиск сужается до одной секции
+	query := "SELECT " + eventColumns + ` FROM audit_events WHERE tenant_id = $3 AND (id, timestamp) IN (
+            SELECT id, timestamp FROM audit_event_identities
+            WHERE (tenant_id = $3 AND idempotency_key = $1) OR event_id = $2 LIMIT 1
+        )`
+
+	var event *model.AuditEvent
+	err := readTenant(ctx, r.db, func(q queryer) error {
+		var err error
+		event, err = scanEvent(q.QueryRowContext(ctx, query, nullableString(idempotencyKey), nullableString(eventID), tenantID))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrEventIDTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored audit event: %w", err)
+	}
+	if err := r.enc.Open(event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// GetEvent возвращает событие по id; событие другого арендатора не найдено
+func (r *postgresRepository) GetEvent(ctx context.Context, id int64) (*model.AuditEvent, error) {
+	query := "SELECT " + eventColumns + " FROM audit_events WHERE id = $1"
+	args := []interface{}{id}
+	if tenantID := tenant.FromContext(ctx); tenantID != "" {
+		query += " AND tenant_id = $2"
+		args = append(args, tenantID)
+	}
+
+	var event *model.AuditEvent
+	err := readTenant(ctx, r.db, func(q queryer) error {
+		var err error
+		event, err = scanEvent(q.QueryRowContext(ctx, query, args...))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrEventNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit event: %w", err)
+	}
+	if err := r.enc.Open(event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// StoreEvents записывает пачку событий одной транзакцией через COPY и
+// возвращает для каждого события признак дубликата. COPY не умеет ни
+// RETURNING, ни ON CONFLICT, поэтому:
+//   - события, чей event_id или ключ идемпотентности уже есть в таблице или
+//     встречался раньше в этой же пачке, не пишутся, а получают id оригинала;
+//   - идентификаторы резервируются заранее из последовательности, а
+//     created_at берётся из времени начала транзакции.
+//
+// Поиск сохранённых событий идёт под блокировкой головы цепочки, которую
+// держит до коммита любая вставка событий, поэтому повтор пачки, пока
+// оригинал ещё пишется, дождётся его коммита и увидит дубликаты. Если COPY
+// всё же нарушил уникальность (идентичность вставлена в обход цепочки,
+// например восстановлением из архива), пачка один раз записывается заново:
+// второй проход увидит закоммиченные идентичности дубликатами.
+func (r *postgresRepository) StoreEvents(ctx context.Context, events []*model.AuditEvent) ([]bool, error) {
+	if len(events) == 0 {
+		return []bool{}, nil
+	}
+	defaultTenant(events...)
+
+	duplicates, err := r.storeEvents(ctx, events)
+	if errors.Is(err, errIdentityConflict) {
+		resetStored(events)
+		duplicates, err = r.storeEvents(ctx, events)
+	}
+	if errors.Is(err, errIdentityConflict) {
+		// Под блокировкой и после повтора конфликт остался: занятая
+		// идентичность скрыта RLS, то есть принадлежит другому арендатору
+		return nil, ErrEventIDTaken
+	}
+	return duplicates, err
+}
+
+// errIdentityConflict - COPY пачки нарушил уникальность event_id или ключа
+// идемпотентности
+var errIdentityConflict = errors.New("event identity conflict")
+
+func (r *postgresRepository) storeEvents(ctx context.Context, events []*model.AuditEvent) ([]bool, error) {
+	duplicates := make([]bool, len(events))
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setTenant(ctx, tx); err != nil {
+		return nil, err
+	}
+	seq, head, err := lockChainHead(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	byEventID, byKey, err := findStoredIdentities(ctx, tx, events)
+	if err != nil {
+		return nil, err
+	}
+
+	originals := make([]*model.AuditEvent, len(events))
+	fresh := make([]*model.AuditEvent, 0, len(events))
+	for i, event := range events {
+		original := byEventID[event.EventID]
+		if original == nil && event.IdempotencyKey != "" {
+			original = byKey[tenantKey(event.TenantID, event.IdempotencyKey)]
+		}
+		if original != nil && original.TenantID != event.TenantID {
+			return nil, ErrEventIDTaken
+		}
+		if original != nil {
+			duplicates[i] = true
+			originals[i] = original
+			continue
+		}
+
+		if event.EventID != "" {
+			byEventID[event.EventID] = event
+		}
+		if event.IdempotencyKey != "" {
+			byKey[tenantKey(event.TenantID, event.IdempotencyKey)] = event
+		}
+		fresh = append(fresh, event)
+	}
+
+	if len(fresh) > 0 {
+		if err := copyEvents(ctx, tx, r.enc, fresh, seq, head); err != nil {
+			if isUniqueViolation(err) {
+				return nil, errIdentityConflict
+			}
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit audit events: %w", err)
+	}
+
+	for i, original := range originals {
+		if original == nil {
+			continue
+		}
+		events[i].ID = original.ID
+		events[i].EventID = original.EventID
+		events[i].CreatedAt = original.CreatedAt
+	}
+
+	return duplicates, nil
+}
+
+func (r *postgresRepository) FindStored(ctx context.Context, events []*model.AuditEvent) ([]*model.AuditEvent, error) {
+	stored := make([]*model.AuditEvent, len(events))
+	err := readTenant(ctx, r.db, func(q queryer) error {
+		byEventID, byKey, err := findStoredIdentities(ctx, q, events)
+		if err != nil {
+			return err
+		}
+		for i, event := range events {
+			original := byEventID[event.EventID]
+			if original == nil && event.IdempotencyKey != "" {
+				original = byKey[tenantKey(event.TenantID, event.IdempotencyKey)]
+			}
+			if original != nil && original.TenantID == event.TenantID {
+				stored[i] = original
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// resetStored стирает поля, выставленные событиям откаченной записью пачки
+func resetStored(events []*model.AuditEvent) {
+	for _, event := range events {
+		event.ID = 0
+		event.CreatedAt = time.Time{}
+		event.ChainSeq = 0
+		event.PrevHash = ""
+		event.Hash = ""
+	}
+}
+
+// findStoredIdentities возвращает уже сохранённые события пачки, проиндексированные
+// по event_id и по tenantKey арендатора и ключа идемпотентности. В пачке
+// асинхронной записи бывают события разных арендаторов.
+func findStoredIdentities(ctx context.Context, q queryer, events []*model.AuditEvent) (map[string]*model.AuditEvent, map[string]*model.AuditEvent, error) {
+	byEventID := make(map[string]*model.AuditEvent)
+	byKey := make(map[string]*model.AuditEvent)
+
+	var eventIDs, keyTenants, keys []string
+	for _, event := range events {
+		if event.EventID != "" {
+			eventIDs = append(eventIDs, event.EventID)
+		}
+		if event.IdempotencyKey != "" {
+			keyTenants = append(keyTenants, event.TenantID)
+			keys = append(keys, event.IdempotencyKey)
+		}
+	}
+	if len(eventIDs) == 0 && len(keys) == 0 {
+		return byEventID, byKey, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `
+        SELECT id, COALESCE(event_id::text, ''), COALESCE(idempotency_key, ''), created_at, tenant_id
+        FROM audit_events
+        WHERE (id, timestamp) IN (
+            SELECT id, timestamp FROM audit_event_identities
+            WHERE event_id = ANY($1::uuid[])
+                OR (tenant_id, idempotency_key) IN (SELECT * FROM unnest($2::text[], $3::text[]))
+        )
+    `, pq.Array(eventIDs), pq.Array(keyTenants), pq.Array(keys))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up stored events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event model.AuditEvent
+		if err := rows.Scan(&event.ID, &event.EventID, &event.IdempotencyKey, &event.CreatedAt, &event.TenantID); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan stored event: %w", err)
+		}
+		if event.EventID != "" {
+			byEventID[event.EventID] = &event
+		}
+		if event.IdempotencyKey != "" {
+			byKey[tenantKey(event.TenantID, event.IdempotencyKey)] = &event
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return byEventID, byKey, nil
+}
+
+// copyEvents записывает события подряд идущими звеньями цепочки хешей
+// после звена seq с хешем head. Голова цепочки уже заблокирована в tx.
+func copyEvents(ctx context.Context, tx *sql.Tx, enc *encryption.Encryptor, events []*model.AuditEvent, seq int64, head string) error {
+	ids, err := reserveEventIDs(ctx, tx, len(events))
+	if err != nil {
+		return err
+	}
+	createdAt, err := transactionTime(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for i, event := range events {
+		event.ID = ids[i]
+		event.CreatedAt = createdAt
+		if err := chain.Link(event, seq+int64(i)+1, head); err != nil {
+			return err
+		}
+		head = event.Hash
+	}
+
+	if err := copyIdentities(ctx, tx, events); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("audit_events",
+		"id", "event_id", "idempotency_key", "timestamp", "user_id", "component", "operation",
+		"session_id", "request_id", "response", "attributes", "created_at", "chain_seq", "prev_hash", "hash",
+		"enc_key_id", "enc_dek", "actor", "tenant_id",
+	))
+	if err != nil {
+		return fmt.Errorf("failed to prepare copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		event, err := enc.Seal(event)
+		if err != nil {
+			return err
+		}
+		response, err := jsonbText(event.Response)
+		if err != nil {
+			return fmt.Errorf("failed to encode response: %w", err)
+		}
+		attributes, err := jsonbText(event.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to encode attributes: %w", err)
+		}
+
+		_, err = stmt.ExecContext(ctx,
+			event.ID,
+			nullableString(event.EventID),
+			nullableString(event.IdempotencyKey),
+			event.Timestamp,
+			event.User,
+			event.Component,
+			event.Operation,
+			event.SessionID,
+			event.RequestID,
+			response,
+			attributes,
+			event.CreatedAt,
+			event.ChainSeq,
+			hashBytes(event.PrevHash),
+			hashBytes(event.Hash),
+			nullableString(event.EncKeyID),
+			hashBytes(event.EncDEK),
+			nullableString(event.Actor),
+			event.TenantID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to copy audit event: %w", err)
+		}
+	}
+
+	// Пустой Exec сбрасывает буфер COPY на сервер
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to flush copy: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to close copy: %w", err)
+	}
+
+	return saveChainHead(ctx, tx, events[len(events)-1].ChainSeq, head)
+}
+
+// copyIdentities занимает event_id и ключи идемпотентности пачки. Запись
+// того же события в обход блокировки цепочки между findStoredIdentities и
+// COPY приведёт к нарушению уникальности и откату всей пачки, а не к дублю.
+func copyIdentities(ctx context.Context, tx *sql.Tx, events []*model.AuditEvent) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("audit_event_identities",
+		"event_id", "idempotency_key", "id", "timestamp", "tenant_id",
+	))
+	if err != nil {
+		return fmt.Errorf("failed to prepare identity copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		if event.EventID == "" {
+			continue
+		}
+		_, err := stmt.ExecContext(ctx, event.EventID, nullableString(event.IdempotencyKey), event.ID, event.Timestamp, event.TenantID)
+		if err != nil {
+			return fmt.Errorf("failed to copy event identity: %w", err)
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to flush identity copy: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to close identity copy: %w", err)
+	}
+
+	return nil
+}
+
+// ReplayEvents идемпотентно записывает события, уже получившие event_id:
+// события, которые есть в таблице, пропускаются. Вставленные события
+// становятся очередными звеньями цепочки хешей. Возвращает число вставленных.
+func (r *postgresRepository) ReplayEvents(ctx context.Context, events []*model.AuditEvent) (int, error) {
+	defaultTenant(events...)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setTenant(ctx, tx); err != nil {
+		return 0, err
+	}
+	ids, err := reserveEventIDs(ctx, tx, len(events))
+	if err != nil {
+		return 0, err
+	}
+	createdAt, err := transactionTime(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	seq, head, err := lockChainHead(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, insertEventQuery)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare replay: %w", err)
+	}
+	defer stmt.Close()
+
+	inserted := 0
+	for i, event := range events {
+		if event.EventID == "" {
+			return 0, fmt.Errorf("cannot replay event without event_id")
+		}
+
+		event.ID = ids[i]
+		event.CreatedAt = createdAt
+		if err := chain.Link(event, seq+1, head); err != nil {
+			return 0, err
+		}
+		sealed, err := r.enc.Seal(event)
+		if err != nil {
+			return 0, err
+		}
+		res, err := stmt.ExecContext(ctx, insertEventArgs(sealed)...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to replay audit event: %w", err)
+		}
+		// Пропущенный дубликат звеном не становится
+		if n, _ := res.RowsAffected(); n > 0 {
+			seq, head = event.ChainSeq, event.Hash
+			inserted++
+		}
+	}
+
+	if inserted > 0 {
+		if err := saveChainHead(ctx, tx, seq, head); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit replayed events: %w", err)
+	}
+
+	return inserted, nil
+}
+
+func reserveEventIDs(ctx context.Context, tx *sql.Tx, n int) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT nextval(pg_get_serial_sequence('audit_events', 'id')) FROM generate_series(1, $1)", n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve event ids: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, n)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan event id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return ids, nil
+}
+
+// jsonbText готовит JSONB для COPY: []byte драйвер кодирует как bytea,
+// поэтому значение передаётся строкой.
+func jsonbText(j *model.JSONB) (interface{}, error) {
+	if j == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// tenantKey - ключ идемпотентности в пределах арендатора
+func tenantKey(tenantID, idempotencyKey string) string {
+	return tenantID + "\x00" + idempotencyKey
+}
+
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// buildFilterConditions переводит фильтры поиска в условия WHERE и их
+// параметры. Используется всеми запросами, принимающими EventFilters.
+// Фильтры по полям, зашифрованным enc, сравнивают слепые индексы.
+func buildFilterConditions(filters model.EventFilters, enc *encryption.Encryptor) ([]string, []interface{}, error) {
 	var conditions []string
 	var args []interface{}
 	argCounter := 1
 
+	if filters.Tenant != "" {
+		conditions = append(conditions, fmt.Sprintf("tenant_id = $%d", argCounter))
+		args = append(args, filters.Tenant)
+		argCounter++
+	}
+
 	// Обработка временных фильтров
 	if filters.Timestamp != nil {
 		conditions = append(conditions, fmt.Sprintf("timestamp = $%d", argCounter))
@@ -95,54 +737,202 @@ func (r *postgresRepository) FindEvents(ctx context.Context, filters model.Event
 	addListFilter(filters.Operations, "operation")
 	addIntListFilter(filters.SessionIDs, "session_id")
 	addIntListFilter(filters.RequestIDs, "request_id")
+	addIntListFilter(filters.IDs, "id")
 
-	// Обработка фильтров по атрибутам JSONB
-	for key, values := range filters.Attributes {
-		if len(values) > 0 {
-			jsonPath := fmt.Sprintf("attributes->>'%s'", key)
-			conditions = append(conditions, fmt.Sprintf("%s = ANY($%d)", jsonPath, argCounter))
-			args = append(args, pq.Array(values))
-			argCounter++
+	// Обработка фильтров по атрибутам и ответу JSONB. Ключ с точками задаёт
+	// путь во вложенных объектах, путь передаётся параметром. У зашифрованного
+	// поля сравнивается его слепой индекс "$bi".
+	addJSONFilter := func(column string, fields map[string][]string) error {
+		for key, values := range fields {
+			if len(values) == 0 {
+				continue
+			}
+			path := strings.Split(key, ".")
+			if field, ok := enc.Field(column, path); ok {
+				indexes, err := blindIndexes(enc, field, path, values)
+				if err != nil {
+					return err
+				}
+				path, values = append(path, encryption.IndexKey), indexes
+			}
+			conditions = append(conditions, fmt.Sprintf("%s #>> $%d::text[] = ANY($%d)", column, argCounter, argCounter+1))
+			args = append(args, pq.Array(path), pq.Array(values))
+			argCounter += 2
 		}
+		return nil
 	}
 
-	// Сборка запроса
-	query := "SELECT id, timestamp, user_id, component, operation, session_id, request_id, response, attributes, created_at FROM audit_events"
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	if err := addJSONFilter("attributes", filters.Attributes); err != nil {
+		return nil, nil, err
+	}
+	if err := addJSONFilter("response", filters.Response); err != nil {
+		return nil, nil, err
 	}
-	query += " ORDER BY timestamp DESC LIMIT 1000"
 
-	rows, err := r.db.QueryContext(ctx, query, args...)
+	// Выражение на языке запросов
+	if filters.Query != nil {
+		expr, compiledArgs, err := compileQuery(filters.Query, args, enc)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditions = append(conditions, expr)
+		args = compiledArgs
+	}
+
+	return conditions, args, nil
+}
+
+func (r *postgresRepository) FindEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error) {
+	filters = scopeFilters(ctx, filters)
+	conditions, args, err := buildFilterConditions(filters, r.enc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query events: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
+	argCounter := len(args) + 1
 
-	var events []*model.AuditEvent
-	for rows.Next() {
-		var event model.AuditEvent
-		err := rows.Scan(
-			&event.ID,
-			&event.Timestamp,
-			&event.User,
-			&event.Component,
-			&event.Operation,
-			&event.SessionID,
-			&event.RequestID,
-			&event.Response,
-			&event.Attributes,
-			&event.CreatedAt,
-		)
+	// Keyset-пагинация: строки строго после (timestamp, id) из курсора.
+	// Условие timestamp <= $n отдельно от уточнения по id, чтобы планировщик
+	// мог ограничить диапазон по idx_audit_events_timestamp.
+	if filters.Cursor != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			"timestamp <= $%d AND (timestamp < $%d OR id < $%d)", argCounter, argCounter, argCounter+1))
+		args = append(args, filters.Cursor.Timestamp, filters.Cursor.ID)
+		argCounter += 2
+	}
+
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	// События из архива подмешиваются к таблице под её же именем, чтобы
+	// фильтры и курсор применялись к ним так же, как к горячим
+	source := "audit_events"
+	if len(filters.Archived) > 0 {
+		rows, err := archivedRows(filters.Archived)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan event: %w", err)
+			return nil, err
 		}
-		events = append(events, &event)
+		source = fmt.Sprintf(`(
+            SELECT * FROM audit_events
+            UNION ALL
+            SELECT * FROM jsonb_populate_recordset(NULL::audit_events, $%d::jsonb) a
+            WHERE NOT EXISTS (SELECT 1 FROM audit_events e WHERE e.id = a.id AND e.timestamp = a.timestamp)
+        ) audit_events`, argCounter)
+		args = append(args, rows)
+		argCounter++
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows iteration error: %w", err)
+	// Сборка запроса
+	query := "SELECT " + eventColumns + " FROM " + source + whereClause(conditions)
+	query += fmt.Sprintf(" ORDER BY timestamp DESC, id DESC LIMIT $%d", argCounter)
+	args = append(args, limit)
+
+	var events []*model.AuditEvent
+	err = readTenant(ctx, r.db, func(q queryer) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query events: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			event, err := scanEvent(rows)
+			if err != nil {
+				return fmt.Errorf("failed to scan event: %w", err)
+			}
+			if err := r.enc.Open(event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("rows iteration error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return events, nil
 }
+
+// archivedRow - событие в виде строки audit_events для jsonb_populate_recordset
+type archivedRow struct {
+	ID             int64        `json:"id"`
+	EventID        *string      `json:"event_id"`
+	IdempotencyKey *string      `json:"idempotency_key"`
+	Timestamp      string       `json:"timestamp"`
+	User           string       `json:"user_id"`
+	Component      *string      `json:"component"`
+	Operation      string       `json:"operation"`
+	SessionID      *int64       `json:"session_id"`
+	RequestID      *int64       `json:"request_id"`
+	Response       *model.JSONB `json:"response"`
+	Attributes     *model.JSONB `json:"attributes"`
+	CreatedAt      string       `json:"created_at"`
+	ChainSeq       *int64       `json:"chain_seq"`
+	PrevHash       *string      `json:"prev_hash"`
+	Hash           *string      `json:"hash"`
+	EncKeyID       *string      `json:"enc_key_id"`
+	EncDEK         *string      `json:"enc_dek"`
+	Actor          *string      `json:"actor"`
+	TenantID       string       `json:"tenant_id"`
+}
+
+// Формат timestamp без часового пояса, как в колонках audit_events
+const archivedTimestampLayout = "2006-01-02T15:04:05.999999"
+
+// archivedRows кодирует события из архива одним JSON-массивом строк
+func archivedRows(events []*model.AuditEvent) (string, error) {
+	rows := make([]archivedRow, len(events))
+	for i, event := range events {
+		rows[i] = archivedRow{
+			ID:         event.ID,
+			Timestamp:  event.Timestamp.UTC().Format(archivedTimestampLayout),
+			User:       event.User,
+			Component:  event.Component,
+			Operation:  event.Operation,
+			SessionID:  event.SessionID,
+			RequestID:  event.RequestID,
+			Response:   event.Response,
+			Attributes: event.Attributes,
+			CreatedAt:  event.CreatedAt.UTC().Format(archivedTimestampLayout),
+			TenantID:   event.TenantID,
+		}
+		if event.EventID != "" {
+			rows[i].EventID = &event.EventID
+		}
+		if event.IdempotencyKey != "" {
+			rows[i].IdempotencyKey = &event.IdempotencyKey
+		}
+		if event.ChainSeq != 0 {
+			rows[i].ChainSeq = &event.ChainSeq
+		}
+		// Текстовый ввод BYTEA в hex-формате
+		if event.PrevHash != "" {
+			prev := `\x` + event.PrevHash
+			rows[i].PrevHash = &prev
+		}
+		if event.Hash != "" {
+			hash := `\x` + event.Hash
+			rows[i].Hash = &hash
+		}
+		if event.EncKeyID != "" {
+			dek := `\x` + event.EncDEK
+			rows[i].EncKeyID = &event.EncKeyID
+			rows[i].EncDEK = &dek
+		}
+		if event.Actor != "" {
+			rows[i].Actor = &event.Actor
+		}
+	}
+
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode archived events: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Таблицы журнала, которые сервис может только читать и дописывать
+var appendOnlyTables = []string{"audit_events", "audit_event_identities", "audit_chain_pruned", "audit_checkpoints"}
+
+// ExcessPrivileges возвращает права пользователя соединения сверх нужных
+// сервису: суперпользователь, UPDATE (хотя бы одной колонки), DELETE или
+// TRUNCATE на таблицах журнала и их секциях (в том числе через владение или
+// членство в ролях), членство в audit_purger и BYPASSRLS - при них не
+// действует изоляция арендаторов. Пустой список - прав ровно столько,
+// сколько нужно.
+func ExcessPrivileges(ctx context.Context, db *sql.DB) ([]string, error) {
+	var excess []string
+
+	var superuser, bypassRLS, purger bool
+	err := db.QueryRowContext(ctx, `
+        SELECT r.rolsuper, r.rolbypassrls,
+            CASE WHEN EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'audit_purger')
+                THEN pg_has_role(current_user, 'audit_purger', 'MEMBER') ELSE false END
+        FROM pg_roles r WHERE r.rolname = current_user
+    `).Scan(&superuser, &bypassRLS, &purger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check database role: %w", err)
+	}
+	if superuser {
+		// Суперпользователю доступно всё, перечислять права незачем
+		return []string{"superuser"}, nil
+	}
+	if bypassRLS {
+		excess = append(excess, "bypassrls")
+	}
+	if purger {
+		excess = append(excess, "member of audit_purger")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+        WITH tables AS (
+            SELECT to_regclass(name)::oid AS oid FROM unnest($1::text[]) AS name
+            UNION
+            SELECT inhrelid FROM pg_inherits WHERE inhparent = 'audit_events'::regclass
+        )
+        SELECT c.relname, p.privilege
+        FROM tables t
+        JOIN pg_class c ON c.oid = t.oid
+        CROSS JOIN unnest(ARRAY['UPDATE', 'DELETE', 'TRUNCATE']) AS p(privilege)
+        WHERE CASE WHEN p.privilege = 'UPDATE'
+            THEN has_any_column_privilege(current_user, c.oid, 'UPDATE')
+            ELSE has_table_privilege(current_user, c.oid, p.privilege) END
+        ORDER BY c.relname, p.privilege
+    `, pq.Array(appendOnlyTables))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check table privileges: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, privilege string
+		if err := rows.Scan(&table, &privilege); err != nil {
+			return nil, fmt.Errorf("failed to scan table privilege: %w", err)
+		}
+		excess = append(excess, privilege+" on "+table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return excess, nil
+}
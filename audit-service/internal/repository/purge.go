@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// beginPurge открывает транзакцию очистки: только в ней триггеры append-only
+// пропускают DELETE из audit_events и audit_event_identities. Удалять при
+// этом может лишь роль audit_purger, поэтому транзакция открывается на
+// соединении пользователя очистки (DB_PURGE_USER).
+func beginPurge(ctx context.Context, conn *sql.Conn) (*sql.Tx, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('audit.purge', 'on', true)"); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to start purge: %w", err)
+	}
+	return tx, nil
+}
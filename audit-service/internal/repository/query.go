@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"audit-service/internal/encryption"
+	"audit-service/internal/query"
+)
+
+type columnType int
+
+const (
+	columnText columnType = iota
+	columnInt
+	columnTime
+	columnUUID
+)
+
+type queryColumn struct {
+	sql string
+	typ columnType
+}
+
+// Поля языка запросов и соответствующие им колонки audit_events
+var queryColumns = map[string]queryColumn{
+	"id":         {"id", columnInt},
+	"event_id":   {"event_id", columnUUID},
+	"ts":         {"timestamp", columnTime},
+	"timestamp":  {"timestamp", columnTime},
+	"user":       {"user_id", columnText},
+	"component":  {"component", columnText},
+	"op":         {"operation", columnText},
+	"operation":  {"operation", columnText},
+	"session_id": {"session_id", columnInt},
+	"req_id":     {"request_id", columnInt},
+	"request_id": {"request_id", columnInt},
+	"actor":      {"actor", columnText},
+	"tenant":     {"tenant_id", columnText},
+}
+
+// queryCompiler превращает дерево выражения q= в параметризованный SQL.
+// Значения всегда передаются параметрами, в текст запроса попадают только
+// имена колонок из queryColumns. Поля, зашифрованные enc, сравниваются по
+// слепому индексу.
+type queryCompiler struct {
+	args []interface{}
+	enc  *encryption.Encryptor
+}
+
+// compileQuery дописывает параметры выражения к args; номера плейсхолдеров
+// продолжают уже занятые
+func compileQuery(node query.Node, args []interface{}, enc *encryption.Encryptor) (string, []interface{}, error) {
+	c := &queryCompiler{args: args, enc: enc}
+	sql, err := c.compile(node)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, c.args, nil
+}
+
+func (c *queryCompiler) arg(value interface{}) string {
+	c.args = append(c.args, value)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+func (c *queryCompiler) compile(node query.Node) (string, error) {
+	switch n := node.(type) {
+	case *query.BinaryExpr:
+		left, err := c.compile(n.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := c.compile(n.Right)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + n.Op + " " + right + ")", nil
+
+	case *query.NotExpr:
+		expr, err := c.compile(n.Expr)
+		if err != nil {
+			return "", err
+		}
+		// IS NOT TRUE вместо NOT, чтобы строки с NULL в поле тоже попадали
+		// под отрицание: NOT component = 'web' должно включать события без component
+		return "((" + expr + ") IS NOT TRUE)", nil
+
+	case *query.Comparison:
+		return c.compileComparison(n)
+	}
+
+	return "", query.Errorf(node.Pos(), "unsupported expression")
+}
+
+func (c *queryCompiler) compileComparison(cmp *query.Comparison) (string, error) {
+	if column, ok := jsonColumns[cmp.Field.Name]; ok {
+		return c.compileJSONComparison(cmp, column)
+	}
+
+	switch cmp.Op {
+	case query.OpContains, query.OpExists, query.OpNotExists:
+		return "", query.Errorf(cmp.Pos(), "operator %s applies only to attributes and res", cmp.Op)
+	}
+
+	column, err := resolveField(cmp.Field)
+	if err != nil {
+		return "", err
+	}
+
+	values := make([]interface{}, len(cmp.Values))
+	for i, v := range cmp.Values {
+		values[i], err = convertValue(v, column.typ)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch cmp.Op {
+	case query.OpEq, query.OpNe, query.OpLt, query.OpLe, query.OpGt, query.OpGe:
+		op := cmp.Op
+		if op == query.OpNe {
+			op = "<>"
+		}
+		return fmt.Sprintf("%s %s %s", column.sql, op, c.typedArg(values[0], column.typ)), nil
+
+	case query.OpPrefix:
+		if column.typ != columnText {
+			return "", query.Errorf(cmp.Pos(), "operator ^= applies only to text fields, '%s' is not text", cmp.Field)
+		}
+		return fmt.Sprintf("%s LIKE %s", column.sql, c.arg(escapeLike(values[0].(string))+"%")), nil
+
+	case query.OpIn, query.OpNotIn:
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = c.typedArg(v, column.typ)
+		}
+		op := "IN"
+		if cmp.Op == query.OpNotIn {
+			op = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", column.sql, op, strings.Join(placeholders, ", ")), nil
+
+	case query.OpBetween:
+		return fmt.Sprintf("%s BETWEEN %s AND %s",
+			column.sql, c.typedArg(values[0], column.typ), c.typedArg(values[1], column.typ)), nil
+	}
+
+	return "", query.Errorf(cmp.Pos(), "unsupported operator %s", cmp.Op)
+}
+
+func resolveField(field query.Field) (queryColumn, error) {
+	column, ok := queryColumns[field.Name]
+	if !ok || len(field.Path) > 0 {
+		return queryColumn{}, query.Errorf(field.Pos, "unknown field '%s'", field)
+	}
+
+	return column, nil
+}
+
+func (c *queryCompiler) typedArg(value interface{}, typ columnType) string {
+	if typ == columnUUID {
+		return c.arg(value) + "::uuid"
+	}
+	return c.arg(value)
+}
+
+func convertValue(v query.Value, typ columnType) (interface{}, error) {
+	switch typ {
+	case columnInt:
+		n, err := strconv.ParseInt(v.Text, 10, 64)
+		if err != nil {
+			return nil, query.Errorf(v.Pos, "expected integer, got '%s'", v.Text)
+		}
+		return n, nil
+	case columnTime:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, v.Text); err == nil {
+				return t, nil
+			}
+		}
+		return nil, query.Errorf(v.Pos, "expected timestamp in RFC 3339 format, got '%s'", v.Text)
+	case columnUUID:
+		if !isUUIDText(v.Text) {
+			return nil, query.Errorf(v.Pos, "expected UUID, got '%s'", v.Text)
+		}
+	}
+	return v.Text, nil
+}
+
+func isUUIDText(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if c != '-' {
+				return false
+			}
+			continue
+		}
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
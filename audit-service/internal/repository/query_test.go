@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"audit-service/internal/query"
+
+	"github.com/lib/pq"
+)
+
+func TestCompileQuery(t *testing.T) {
+	uuid := "0f8fad5b-d9cb-469f-a165-70867728950e"
+
+	tests := []struct {
+		q    string
+		sql  string
+		args []interface{}
+	}{
+		{`user = alice`, `user_id = $1`, []interface{}{"alice"}},
+		{`op != login`, `operation <> $1`, []interface{}{"login"}},
+		{`component < b`, `component < $1`, []interface{}{"b"}},
+		{`id >= 10`, `id >= $1`, []interface{}{int64(10)}},
+		{`req_id > 5`, `request_id > $1`, []interface{}{int64(5)}},
+		{`event_id = ` + uuid, `event_id = $1::uuid`, []interface{}{uuid}},
+		{`tenant = acme`, `tenant_id = $1`, []interface{}{"acme"}},
+		{`ts >= 2024-01-02`, `timestamp >= $1`, []interface{}{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		{`timestamp < 2024-01-02T03:04:05`, `timestamp < $1`, []interface{}{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{`ts BETWEEN 2024-01-01 AND "2024-02-01T00:00:00+03:00"`, `timestamp BETWEEN $1 AND $2`, []interface{}{
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 0, 0, 0, 0, time.FixedZone("", 3*60*60)),
+		}},
+		{`user ^= a_b%c`, `user_id LIKE $1`, []interface{}{`a\_b\%c%`}},
+		{`user ^= 'a\\b'`, `user_id LIKE $1`, []interface{}{`a\\b%`}},
+		{`user IN (a, "b")`, `user_id IN ($1, $2)`, []interface{}{"a", "b"}},
+		{`session_id NOT IN (1, 2)`, `session_id NOT IN ($1, $2)`, []interface{}{int64(1), int64(2)}},
+		{`event_id IN (` + uuid + `)`, `event_id IN ($1::uuid)`, []interface{}{uuid}},
+		{`NOT component = web`, `((component = $1) IS NOT TRUE)`, []interface{}{"web"}},
+
+		// Значения только в параметрах, текст запроса их не содержит
+		{`user = "x' OR 1=1 --"`, `user_id = $1`, []interface{}{"x' OR 1=1 --"}},
+		{`op ^= "'; DROP TABLE audit_events; --"`, `operation LIKE $1`, []interface{}{`'; DROP TABLE audit\_events; --%`}},
+
+		// Скобки в SQL повторяют приоритет разбора
+		{`user = a OR op = b AND component = c`, `(user_id = $1 OR (operation = $2 AND component = $3))`,
+			[]interface{}{"a", "b", "c"}},
+		{`(user = a OR op = b) AND NOT component = c`, `((user_id = $1 OR operation = $2) AND ((component = $3) IS NOT TRUE))`,
+			[]interface{}{"a", "b", "c"}},
+
+		// JSONB: равенство и IN - вхождением, значения без кавычек типизируются
+		{`attributes.ip = 10.0.0.1`, `attributes @> $1::jsonb`, []interface{}{`{"ip":"10.0.0.1"}`}},
+		{`attributes.http.status = 500`, `attributes @> $1::jsonb`, []interface{}{`{"http":{"status":500}}`}},
+		{`attributes.http.status = "500"`, `attributes @> $1::jsonb`, []interface{}{`{"http":{"status":"500"}}`}},
+		{`res.ok = true`, `response @> $1::jsonb`, []interface{}{`{"ok":true}`}},
+		{`res.error = null`, `response @> $1::jsonb`, []interface{}{`{"error":null}`}},
+		{`attributes.code IN (1, x)`, `(attributes @> $1::jsonb OR attributes @> $2::jsonb)`,
+			[]interface{}{`{"code":1}`, `{"code":"x"}`}},
+		{`attributes.code != 1`, `(attributes #> $2::text[] IS NOT NULL AND NOT attributes @> $1::jsonb)`,
+			[]interface{}{`{"code":1}`, pq.Array([]string{"code"})}},
+		{`attributes.a.b NOT IN (x, y)`, `(attributes #> $3::text[] IS NOT NULL AND NOT (attributes @> $1::jsonb OR attributes @> $2::jsonb))`,
+			[]interface{}{`{"a":{"b":"x"}}`, `{"a":{"b":"y"}}`, pq.Array([]string{"a", "b"})}},
+		{`attributes.http @> '{"status": 500}'`, `attributes @> $1::jsonb`, []interface{}{`{"http":{"status":500}}`}},
+		{`attributes @> '{"a": [1]}'`, `attributes @> $1::jsonb`, []interface{}{`{"a":[1]}`}},
+
+		// EXISTS: колонка целиком, ключ верхнего уровня, вложенный путь
+		{`attributes EXISTS`, `attributes IS NOT NULL`, nil},
+		{`res.error EXISTS`, `response ? $1`, []interface{}{"error"}},
+		{`res.error.code NOT EXISTS`, `((response @? $1::jsonpath) IS NOT TRUE)`, []interface{}{`$."error"."code"`}},
+
+		// Сравнения по порядку - через jsonpath с переменными
+		{`attributes.n > 5`, `jsonb_path_exists(attributes, $1::jsonpath, $2::jsonb)`,
+			[]interface{}{`$."n" ? (@ > $v)`, `{"v":5}`}},
+		{`attributes.n <= "5"`, `jsonb_path_exists(attributes, $1::jsonpath, $2::jsonb)`,
+			[]interface{}{`$."n" ? (@ <= $v)`, `{"v":"5"}`}},
+		{`res.latency BETWEEN 1 AND 9.5`, `jsonb_path_exists(response, $1::jsonpath, $2::jsonb)`,
+			[]interface{}{`$."latency" ? (@ >= $lo && @ <= $hi)`, `{"hi":9.5,"lo":1}`}},
+		{`attributes.path ^= /api`, `jsonb_path_exists(attributes, $1::jsonpath, $2::jsonb)`,
+			[]interface{}{`$."path" ? (@ starts with $v)`, `{"v":"/api"}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			node, err := query.Parse(tt.q)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.q, err)
+			}
+			sql, args, err := compileQuery(node, nil, nil)
+			if err != nil {
+				t.Fatalf("compileQuery(%q) failed: %v", tt.q, err)
+			}
+			if sql != tt.sql {
+				t.Errorf("compileQuery(%q) sql = %s, want %s", tt.q, sql, tt.sql)
+			}
+			if !reflect.DeepEqual(normalizeArgs(args), normalizeArgs(tt.args)) {
+				t.Errorf("compileQuery(%q) args = %#v, want %#v", tt.q, args, tt.args)
+			}
+		})
+	}
+}
+
+// normalizeArgs приводит время к одному представлению, чтобы сравнивать
+// моменты, а не зоны
+func normalizeArgs(args []interface{}) []interface{} {
+	normalized := make([]interface{}, len(args))
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			arg = t.UTC()
+		}
+		normalized[i] = arg
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+func TestCompileQueryContinuesPlaceholders(t *testing.T) {
+	node, err := query.Parse(`user = a OR attributes.n > 1`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	sql, args, err := compileQuery(node, []interface{}{"acme", int64(7)}, nil)
+	if err != nil {
+		t.Fatalf("compileQuery failed: %v", err)
+	}
+
+	want := `(user_id = $3 OR jsonb_path_exists(attributes, $4::jsonpath, $5::jsonb))`
+	if sql != want {
+		t.Errorf("sql = %s, want %s", sql, want)
+	}
+	wantArgs := []interface{}{"acme", int64(7), "a", `$."n" ? (@ > $v)`, `{"v":1}`}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	tests := []struct {
+		q   string
+		pos int
+		msg string
+	}{
+		{`unknown = 1`, 1, "unknown field 'unknown'"},
+		{`user.name = 1`, 1, "unknown field 'user.name'"},
+		{`id = abc`, 6, "expected integer, got 'abc'"},
+		{`session_id IN (1, x)`, 19, "expected integer, got 'x'"},
+		{`ts > yesterday`, 6, "expected timestamp in RFC 3339 format, got 'yesterday'"},
+		{`event_id = nope`, 12, "expected UUID, got 'nope'"},
+		{`id ^= 1`, 4, "operator ^= applies only to text fields, 'id' is not text"},
+		{`user @> '{}'`, 6, "operator @> applies only to attributes and res"},
+		{`user EXISTS`, 6, "operator EXISTS applies only to attributes and res"},
+		{`attributes = 1`, 1, "'attributes' needs a path, e.g. attributes.key, or use @>"},
+		{`res > 1`, 1, "'res' needs a path, e.g. res.key"},
+		{`attributes.a @> nope`, 17, "operator @> expects a JSON document: invalid character 'o' in literal null (expecting 'u')"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			node, err := query.Parse(tt.q)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.q, err)
+			}
+			_, _, err = compileQuery(node, nil, nil)
+
+			var qerr *query.Error
+			if !errors.As(err, &qerr) {
+				t.Fatalf("expected query error %q at %d, got %v", tt.msg, tt.pos, err)
+			}
+			if qerr.Pos != tt.pos || qerr.Msg != tt.msg {
+				t.Fatalf("got error %q at %d, want %q at %d", qerr.Msg, qerr.Pos, tt.msg, tt.pos)
+			}
+		})
+	}
+}
+
+// Значения @> проходят через разбор JSON и попадают в запрос заново
+// сериализованными, а не исходной строкой
+func TestCompileQueryContainsReencodes(t *testing.T) {
+	node, err := query.Parse(`attributes @> '{"a": "x\"y",   "b": 1}'`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	_, args, err := compileQuery(node, nil, nil)
+	if err != nil {
+		t.Fatalf("compileQuery failed: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(args[0].(string)), &doc); err != nil {
+		t.Fatalf("argument is not JSON: %v", err)
+	}
+	if doc["a"] != `x"y` || doc["b"] != float64(1) {
+		t.Fatalf("unexpected document %v", doc)
+	}
+}
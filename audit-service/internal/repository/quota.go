@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"audit-service/internal/model"
+)
+
+// QuotaRepository ведёт счётчики квот арендаторов по окнам. В Postgres
+// счётчики общие для всех реплик. Встроенные хранилища принадлежат одному
+// процессу, поэтому им хватает счётчиков в памяти.
+type QuotaRepository interface {
+	// Take списывает до n событий арендатора в окне window, не выходя за
+	// limit, остальные засчитывает отклонёнными. Возвращает, сколько списано.
+	Take(ctx context.Context, tenantID string, window time.Time, n, limit int) (int, error)
+	// Refund возвращает n списанных событий арендатору в окне window
+	Refund(ctx context.Context, tenantID string, window time.Time, n int) error
+	// Usage возвращает расход арендаторов, писавших в окне window. Limit не
+	// заполняется.
+	Usage(ctx context.Context, window time.Time) ([]model.TenantQuota, error)
+	// Prune удаляет счётчики окон, начавшихся раньше before
+	Prune(ctx context.Context, before time.Time) error
+}
+
+type postgresQuotaRepository struct {
+	db *sql.DB
+}
+
+func NewQuotaRepository(db *sql.DB) QuotaRepository {
+	return &postgresQuotaRepository{db: db}
+}
+
+func (r *postgresQuotaRepository) Take(ctx context.Context, tenantID string, window time.Time, n, limit int) (int, error) {
+	var taken int
+	err := r.db.QueryRowContext(ctx, "SELECT audit_take_quota($1, $2, $3, $4)", tenantID, window, n, limit).Scan(&taken)
+	if err != nil {
+		return 0, fmt.Errorf("failed to take tenant quota: %w", err)
+	}
+	return taken, nil
+}
+
+func (r *postgresQuotaRepository) Refund(ctx context.Context, tenantID string, window time.Time, n int) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE tenant_quota_usage SET used = GREATEST(used - $3, 0) WHERE tenant_id = $1 AND window_start = $2",
+		tenantID, window, n)
+	if err != nil {
+		return fmt.Errorf("failed to refund tenant quota: %w", err)
+	}
+	return nil
+}
+
+func (r *postgresQuotaRepository) Usage(ctx context.Context, window time.Time) ([]model.TenantQuota, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT tenant_id, used, rejected FROM tenant_quota_usage WHERE window_start = $1 ORDER BY tenant_id", window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenant quota usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []model.TenantQuota
+	for rows.Next() {
+		var quota model.TenantQuota
+		if err := rows.Scan(&quota.Tenant, &quota.Used, &quota.Rejected); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant quota usage: %w", err)
+		}
+		usage = append(usage, quota)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return usage, nil
+}
+
+func (r *postgresQuotaRepository) Prune(ctx context.Context, before time.Time) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM tenant_quota_usage WHERE window_start < $1", before); err != nil {
+		return fmt.Errorf("failed to prune tenant quota usage: %w", err)
+	}
+	return nil
+}
+
+// memoryQuotaRepository хранит счётчики только последнего окна, поэтому их
+// не больше, чем арендаторов, писавших за одно окно
+type memoryQuotaRepository struct {
+	mu     sync.Mutex
+	window time.Time
+	usage  map[string]*model.TenantQuota
+}
+
+func NewMemoryQuotaRepository() QuotaRepository {
+	return &memoryQuotaRepository{usage: make(map[string]*model.TenantQuota)}
+}
+
+func (r *memoryQuotaRepository) Take(ctx context.Context, tenantID string, window time.Time, n, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if window.After(r.window) {
+		r.window = window
+		r.usage = make(map[string]*model.TenantQuota)
+	}
+	quota, ok := r.usage[tenantID]
+	if !ok {
+		quota = &model.TenantQuota{Tenant: tenantID}
+		r.usage[tenantID] = quota
+	}
+
+	taken := limit - quota.Used
+	if taken > n {
+		taken = n
+	}
+	if taken < 0 {
+		taken = 0
+	}
+	quota.Used += taken
+	quota.Rejected += uint64(n - taken)
+	return taken, nil
+}
+
+func (r *memoryQuotaRepository) Refund(ctx context.Context, tenantID string, window time.Time, n int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if quota, ok := r.usage[tenantID]; ok && window.Equal(r.window) {
+		quota.Used -= n
+		if quota.Used < 0 {
+			quota.Used = 0
+		}
+	}
+	return nil
+}
+
+func (r *memoryQuotaRepository) Usage(ctx context.Context, window time.Time) ([]model.TenantQuota, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !window.Equal(r.window) {
+		return nil, nil
+	}
+	usage := make([]model.TenantQuota, 0, len(r.usage))
+	for _, quota := range r.usage {
+		usage = append(usage, *quota)
+	}
+	return usage, nil
+}
+
+// Prune ничего не делает: прошлые окна сбрасывает Take
+func (r *memoryQuotaRepository) Prune(ctx context.Context, before time.Time) error {
+	return nil
+}
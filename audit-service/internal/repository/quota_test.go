@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"audit-service/db"
+)
+
+// quotaRepositories - счётчик в памяти и, если задан
+// AUDIT_TEST_POSTGRES_DSN, счётчик в Postgres
+func quotaRepositories(t *testing.T) map[string]QuotaRepository {
+	repos := map[string]QuotaRepository{"memory": NewMemoryQuotaRepository()}
+	if dsn := os.Getenv("AUDIT_TEST_POSTGRES_DSN"); dsn != "" {
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatalf("failed to open postgres: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := db.RunMigrations(conn); err != nil {
+			t.Fatalf("failed to migrate postgres: %v", err)
+		}
+		repos["postgres"] = NewQuotaRepository(conn)
+	}
+	return repos
+}
+
+func TestQuotaTake(t *testing.T) {
+	ctx := context.Background()
+	window := time.Date(2001, 2, 3, 4, 5, 0, 0, time.UTC)
+
+	for name, repo := range quotaRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := repo.Prune(ctx, window.Add(2*time.Minute)); err != nil {
+				t.Fatalf("failed to prune: %v", err)
+			}
+
+			steps := []struct {
+				tenant      string
+				n, expected int
+			}{
+				{"acme", 3, 3},
+				{"acme", 4, 2},
+				{"acme", 1, 0},
+				{"globex", 5, 5},
+			}
+			for _, step := range steps {
+				taken, err := repo.Take(ctx, step.tenant, window, step.n, 5)
+				if err != nil {
+					t.Fatalf("failed to take quota: %v", err)
+				}
+				if taken != step.expected {
+					t.Errorf("%s took %d of %d, expected %d", step.tenant, taken, step.n, step.expected)
+				}
+			}
+
+			usage, err := repo.Usage(ctx, window)
+			if err != nil {
+				t.Fatalf("failed to load usage: %v", err)
+			}
+			got := make(map[string][2]int)
+			for _, quota := range usage {
+				got[quota.Tenant] = [2]int{quota.Used, int(quota.Rejected)}
+			}
+			if got["acme"] != [2]int{5, 3} || got["globex"] != [2]int{5, 0} || len(got) != 2 {
+				t.Errorf("unexpected usage (used, rejected): %v", got)
+			}
+
+			// Новое окно начинается с полной квоты
+			next := window.Add(time.Minute)
+			if taken, err := repo.Take(ctx, "acme", next, 4, 5); err != nil || taken != 4 {
+				t.Errorf("next window took %d (%v), expected 4", taken, err)
+			}
+			if err := repo.Prune(ctx, next); err != nil {
+				t.Fatalf("failed to prune: %v", err)
+			}
+			if usage, err := repo.Usage(ctx, window); err != nil || len(usage) != 0 {
+				t.Errorf("pruned window still has usage %v (%v)", usage, err)
+			}
+		})
+	}
+}
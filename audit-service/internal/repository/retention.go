@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"audit-service/internal/encryption"
+	"audit-service/internal/model"
+
+	"github.com/lib/pq"
+)
+
+// Пауза между пачками удаления, чтобы очистка не вытесняла запись событий
+const retentionBatchPause = 100 * time.Millisecond
+
+type RetentionRepository struct {
+	db  *sql.DB
+	enc *encryption.Encryptor
+}
+
+func NewRetentionRepository(db *sql.DB, enc *encryption.Encryptor) *RetentionRepository {
+	return &RetentionRepository{db: db, enc: enc}
+}
+
+// Purge удаляет события старше срока хранения по правилам rules и сроку по
+// умолчанию defaultDays (0 - хранить бессрочно). Если все сроки конечны,
+// месячные секции, целиком вышедшие за самый длинный из них, удаляются
+// целиком; остальное удаляется пачками по batchSize строк. В режиме dryRun
+// только считает, что было бы удалено: строки удаляемых секций входят в
+// счётчики правил и в Total повторно не добавляются. Итог сохраняется в
+// retention_runs. Если очистку уже выполняет другая реплика, возвращает false.
+func (r *RetentionRepository) Purge(ctx context.Context, rules []model.RetentionRule, defaultDays int, now time.Time, batchSize int, dryRun bool) (*model.RetentionReport, bool, error) {
+	report := &model.RetentionReport{DryRun: dryRun, StartedAt: now}
+
+	locked, err := withAdvisoryLock(ctx, r.db, retentionLockKey, func(conn *sql.Conn) error {
+		if err := dropExpiredPartitions(ctx, conn, rules, defaultDays, now, dryRun, report); err != nil {
+			return err
+		}
+
+		for i, rule := range rules {
+			if rule.KeepDays <= 0 {
+				continue
+			}
+			result := model.RetentionRuleResult{
+				Rule:     rule.Name,
+				KeepDays: rule.KeepDays,
+				Cutoff:   now.AddDate(0, 0, -rule.KeepDays),
+			}
+			// Событие подчиняется первому подходящему правилу
+			var args []interface{}
+			conditions := []string{retentionCondition(rule, &args, r.enc)}
+			for _, earlier := range rules[:i] {
+				conditions = append(conditions, "("+retentionCondition(earlier, &args, r.enc)+") IS NOT TRUE")
+			}
+
+			purged, err := purgeMatching(ctx, conn, conditions, args, result.Cutoff, batchSize, dryRun)
+			result.Purged = purged
+			report.Rules = append(report.Rules, result)
+			report.Total += purged
+			if err != nil {
+				return err
+			}
+		}
+
+		if defaultDays > 0 {
+			result := model.RetentionRuleResult{
+				Rule:     "default",
+				KeepDays: defaultDays,
+				Cutoff:   now.AddDate(0, 0, -defaultDays),
+			}
+			var args []interface{}
+			var conditions []string
+			for _, rule := range rules {
+				conditions = append(conditions, "("+retentionCondition(rule, &args, r.enc)+") IS NOT TRUE")
+			}
+
+			purged, err := purgeMatching(ctx, conn, conditions, args, result.Cutoff, batchSize, dryRun)
+			result.Purged = purged
+			report.Rules = append(report.Rules, result)
+			report.Total += purged
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if !locked {
+		return nil, false, err
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if saveErr := saveRetentionRun(ctx, r.db, report); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
+	return report, true, err
+}
+
+// LastRun возвращает итог последнего прогона очистки любой реплики или nil
+func (r *RetentionRepository) LastRun(ctx context.Context) (*model.RetentionReport, error) {
+	var raw []byte
+	err := r.db.QueryRowContext(ctx, "SELECT report FROM retention_runs ORDER BY id DESC LIMIT 1").Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retention run: %w", err)
+	}
+
+	var report model.RetentionReport
+	if err := json.Unmarshal(raw, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode retention run: %w", err)
+	}
+
+	return &report, nil
+}
+
+func saveRetentionRun(ctx context.Context, db *sql.DB, report *model.RetentionReport) error {
+	raw, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+        INSERT INTO retention_runs (started_at, finished_at, dry_run, total, report)
+        VALUES ($1, $2, $3, $4, $5)
+    `, report.StartedAt, report.FinishedAt, report.DryRun, report.Total, string(raw))
+	if err != nil {
+		return fmt.Errorf("failed to save retention run: %w", err)
+	}
+
+	return nil
+}
+
+// retentionCondition переводит условия правила в SQL, дописывая параметры в args.
+// Зашифрованный атрибут сравнивается по слепому индексу; правила с путём
+// внутри зашифрованного поля отклоняются при запуске.
+func retentionCondition(rule model.RetentionRule, args *[]interface{}, enc *encryption.Encryptor) string {
+	arg := func(value interface{}) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	var conditions []string
+	if rule.Component != "" {
+		conditions = append(conditions, "component = "+arg(rule.Component))
+	}
+	if rule.Operation != "" {
+		conditions = append(conditions, "operation = "+arg(rule.Operation))
+	}
+	if rule.Attribute != "" {
+		path, values := strings.Split(rule.Attribute, "."), []string{rule.Value}
+		if field, ok := enc.Field("attributes", path); ok {
+			if indexes, err := blindIndexes(enc, field, path, values); err == nil {
+				path, values = append(path, encryption.IndexKey), indexes
+			}
+		}
+		conditions = append(conditions, fmt.Sprintf("attributes #>> %s::text[] = ANY(%s)", arg(pq.Array(path)), arg(pq.Array(values))))
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// purgeMatching удаляет пачками (или считает при dryRun) события старше
+// cutoff, подходящие под все conditions
+func purgeMatching(ctx context.Context, conn *sql.Conn, conditions []string, args []interface{}, cutoff time.Time, batchSize int, dryRun bool) (int64, error) {
+	args = append(args, cutoff)
+	conditions = append(conditions, fmt.Sprintf("timestamp < $%d", len(args)))
+	where := whereClause(conditions)
+
+	if dryRun {
+		var count int64
+		if err := conn.QueryRowContext(ctx, "SELECT count(*) FROM audit_events"+where, args...).Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to count expired events: %w", err)
+		}
+		return count, nil
+	}
+
+	// Вместе с событиями удаляются их идентичности, иначе таблица
+	// audit_event_identities росла бы бесконечно, а хеши звеньев
+	// запоминаются для проверки цепочки
+	query := fmt.Sprintf(`
+        WITH batch AS (
+            SELECT id, timestamp FROM audit_events%s LIMIT $%d
+        ), deleted AS (
+            DELETE FROM audit_events e USING batch b
+            WHERE e.id = b.id AND e.timestamp = b.timestamp
+            RETURNING e.event_id, e.chain_seq, e.hash
+        ), forgotten AS (
+            DELETE FROM audit_event_identities WHERE event_id IN (SELECT event_id FROM deleted)
+        ), pruned AS (
+            INSERT INTO audit_chain_pruned (chain_seq, hash)
+            SELECT chain_seq, hash FROM deleted WHERE chain_seq IS NOT NULL
+            ON CONFLICT DO NOTHING
+        )
+        SELECT count(*) FROM deleted
+    `, where, len(args)+1)
+	args = append(args, batchSize)
+
+	var total int64
+	for {
+		tx, err := beginPurge(ctx, conn)
+		if err != nil {
+			return total, err
+		}
+		var n int64
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&n)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return total, fmt.Errorf("failed to purge expired events: %w", err)
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(retentionBatchPause):
+		}
+	}
+}
+
+// dropExpiredPartitions удаляет месячные секции, все события которых старше
+// самого длинного срока хранения. Возможно, только если бессрочных правил нет.
+func dropExpiredPartitions(ctx context.Context, conn *sql.Conn, rules []model.RetentionRule, defaultDays int, now time.Time, dryRun bool, report *model.RetentionReport) error {
+	maxDays := defaultDays
+	for _, rule := range rules {
+		if rule.KeepDays <= 0 || defaultDays <= 0 {
+			return nil
+		}
+		if rule.KeepDays > maxDays {
+			maxDays = rule.KeepDays
+		}
+	}
+	if maxDays <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -maxDays)
+
+	partitions, err := listMonthlyPartitions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		end := p.month.AddDate(0, 1, 0)
+		if end.After(cutoff) {
+			continue
+		}
+
+		var rows int64
+		err := conn.QueryRowContext(ctx,
+			"SELECT count(*) FROM audit_events WHERE timestamp >= $1 AND timestamp < $2", p.month, end).Scan(&rows)
+		if err != nil {
+			return fmt.Errorf("failed to count rows in partition %s: %w", p.name, err)
+		}
+
+		// Вместе с секцией функция удаляет идентичности её событий и
+		// запоминает хеши звеньев
+		if !dryRun {
+			if _, err := conn.ExecContext(ctx, "SELECT audit_drop_partition($1)", p.name); err != nil {
+				return fmt.Errorf("failed to drop partition %s: %w", p.name, err)
+			}
+		}
+
+		report.DroppedPartitions = append(report.DroppedPartitions, p.name)
+		report.DroppedRows += rows
+		if !dryRun {
+			report.Total += rows
+		}
+	}
+
+	return nil
+}
+
+// RetentionPolicy применяет те же правила, что и Purge, к событиям вне БД -
+// к сегментам холодного архива. События в сегментах хранятся в том же виде,
+// что и в audit_events, поэтому зашифрованный атрибут сравнивается по
+// слепому индексу, как в retentionCondition.
+type RetentionPolicy struct {
+	rules       []model.RetentionRule
+	defaultDays int
+	enc         *encryption.Encryptor
+}
+
+// NewRetentionPolicy возвращает nil, если все сроки бессрочные
+func NewRetentionPolicy(rules []model.RetentionRule, defaultDays int, enc *encryption.Encryptor) *RetentionPolicy {
+	p := &RetentionPolicy{rules: rules, defaultDays: defaultDays, enc: enc}
+	if p.MinKeepDays() == 0 {
+		return nil
+	}
+	return p
+}
+
+// MinKeepDays - кратчайший конечный срок хранения, 0 - конечных сроков нет
+func (p *RetentionPolicy) MinKeepDays() int {
+	days := p.defaultDays
+	for _, rule := range p.rules {
+		if rule.KeepDays > 0 && (days == 0 || rule.KeepDays < days) {
+			days = rule.KeepDays
+		}
+	}
+	return days
+}
+
+// Fingerprint меняется вместе с правилами: по нему архив узнаёт сегменты,
+// сроки которых посчитаны по старым правилам
+func (p *RetentionPolicy) Fingerprint() string {
+	raw, _ := json.Marshal(struct {
+		Rules       []model.RetentionRule `json:"rules"`
+		DefaultDays int                   `json:"default_days"`
+	}{p.rules, p.defaultDays})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ExpiresAt - когда истекает срок хранения события; false - событие
+// бессрочное. Событие подчиняется первому подходящему правилу.
+func (p *RetentionPolicy) ExpiresAt(event *model.AuditEvent) (time.Time, bool) {
+	days := p.defaultDays
+	for _, rule := range p.rules {
+		if p.matches(rule, event) {
+			days = rule.KeepDays
+			break
+		}
+	}
+	if days <= 0 {
+		return time.Time{}, false
+	}
+	return event.Timestamp.AddDate(0, 0, days), true
+}
+
+func (p *RetentionPolicy) matches(rule model.RetentionRule, event *model.AuditEvent) bool {
+	if rule.Component != "" && (event.Component == nil || *event.Component != rule.Component) {
+		return false
+	}
+	if rule.Operation != "" && event.Operation != rule.Operation {
+		return false
+	}
+	if rule.Attribute != "" {
+		path, values := strings.Split(rule.Attribute, "."), []string{rule.Value}
+		if field, ok := p.enc.Field("attributes", path); ok {
+			if indexes, err := blindIndexes(p.enc, field, path, values); err == nil {
+				path, values = append(path, encryption.IndexKey), indexes
+			}
+		}
+		doc, ok := jsonDocument(event.Attributes)
+		if !ok {
+			return false
+		}
+		value, ok := jsonExtract(doc, path)
+		if !ok {
+			return false
+		}
+		text, ok := jsonText(value)
+		if !ok || !containsString(values, text) {
+			return false
+		}
+	}
+	return true
+}
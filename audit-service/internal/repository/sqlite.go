@@ -0,0 +1,611 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"audit-service/internal/chain"
+	"audit-service/internal/model"
+)
+
+// sqliteRepository - встроенное хранилище в файле SQLite (схема из
+// db/sqlite_migrations). Время, списки, id и курсор фильтрует сам SQLite
+// по индексам, а фильтры по attributes и res и выражение q= проверяет
+// eventMatcher над прочитанными строками - так их семантика совпадает с
+// Postgres без перевода JSONB-операторов в функции json1.
+type sqliteRepository struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	notify func(InsertedEvents)
+}
+
+func NewSQLiteRepository(db *sql.DB) AuditRepository {
+	return &sqliteRepository{db: db}
+}
+
+func (r *sqliteRepository) NotifyInserts(fn func(InsertedEvents)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notify = fn
+}
+
+func (r *sqliteRepository) publish(events []*model.AuditEvent) {
+	r.mu.Lock()
+	notify := r.notify
+	r.mu.Unlock()
+
+	if notify != nil && len(events) > 0 {
+		notify(newInsertedEvents(events))
+	}
+}
+
+// Время хранится текстом фиксированной ширины, чтобы порядок строк совпадал
+// с порядком времени
+const sqliteTimeLayout = "2006-01-02 15:04:05.000000"
+
+func sqliteTime(t time.Time) string {
+	return storedTime(t).Format(sqliteTimeLayout)
+}
+
+// Колонки события в порядке, который ожидает scanSQLiteEvent
+const sqliteEventColumns = `id, COALESCE(event_id, ''), COALESCE(idempotency_key, ''), timestamp, user_id, component, operation,
+    session_id, request_id, response, attributes, created_at, chain_seq, prev_hash, hash, COALESCE(actor, ''), tenant_id`
+
+func scanSQLiteEvent(row rowScanner) (*model.AuditEvent, error) {
+	var event model.AuditEvent
+	var timestamp, createdAt string
+	var response, attributes sql.NullString
+	err := row.Scan(
+		&event.ID,
+		&event.EventID,
+		&event.IdempotencyKey,
+		&timestamp,
+		&event.User,
+		&event.Component,
+		&event.Operation,
+		&event.SessionID,
+		&event.RequestID,
+		&response,
+		&attributes,
+		&createdAt,
+		&event.ChainSeq,
+		&event.PrevHash,
+		&event.Hash,
+		&event.Actor,
+		&event.TenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if event.Timestamp, err = time.ParseInLocation(sqliteTimeLayout, timestamp, time.UTC); err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q: %w", timestamp, err)
+	}
+	if event.CreatedAt, err = time.ParseInLocation(sqliteTimeLayout, createdAt, time.UTC); err != nil {
+		return nil, fmt.Errorf("invalid created_at %q: %w", createdAt, err)
+	}
+	if event.Response, err = parseSQLiteJSON(response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if event.Attributes, err = parseSQLiteJSON(attributes); err != nil {
+		return nil, fmt.Errorf("invalid attributes: %w", err)
+	}
+
+	return &event, nil
+}
+
+func parseSQLiteJSON(value sql.NullString) (*model.JSONB, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	var doc model.JSONB
+	if err := json.Unmarshal([]byte(value.String), &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func sqliteJSON(j *model.JSONB) (interface{}, error) {
+	if j == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+type sqliteQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// findSQLiteIdentity возвращает сохранённое событие арендатора event с тем
+// же event_id или ключом идемпотентности, nil - такого нет. event_id,
+// занятый другим арендатором, - ErrEventIDTaken.
+func findSQLiteIdentity(ctx context.Context, q sqliteQuerier, event *model.AuditEvent) (*model.AuditEvent, error) {
+	if event.EventID == "" && event.IdempotencyKey == "" {
+		return nil, nil
+	}
+
+	existing, err := scanSQLiteEvent(q.QueryRowContext(ctx,
+		"SELECT "+sqliteEventColumns+" FROM audit_events WHERE (tenant_id = ? AND idempotency_key = ?) OR event_id = ? LIMIT 1",
+		event.TenantID, nullableString(event.IdempotencyKey), nullableString(strings.ToLower(event.EventID))))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored audit event: %w", err)
+	}
+	if existing.TenantID != event.TenantID {
+		return nil, ErrEventIDTaken
+	}
+	return existing, nil
+}
+
+// insertSQLiteEvents записывает события подряд идущими звеньями цепочки
+// хешей. Транзакция начата с BEGIN IMMEDIATE (pkg/sqlite), поэтому голова
+// цепочки и следующий id до коммита не меняются.
+func insertSQLiteEvents(ctx context.Context, tx *sql.Tx, events []*model.AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var seq, lastID int64
+	var head string
+	if err := tx.QueryRowContext(ctx, "SELECT seq, hash FROM audit_chain_head WHERE id = 1").Scan(&seq, &head); err != nil {
+		return fmt.Errorf("failed to read chain head: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM audit_events").Scan(&lastID); err != nil {
+		return fmt.Errorf("failed to read last event id: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO audit_events
+        (id, event_id, idempotency_key, timestamp, user_id, component, operation, session_id, request_id, response, attributes,
+         created_at, chain_seq, prev_hash, hash, actor, tenant_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	createdAt := time.Now().UTC()
+	for i, event := range events {
+		event.ID = lastID + int64(i) + 1
+		event.CreatedAt = createdAt
+		if err := chain.Link(event, seq+1, head); err != nil {
+			return err
+		}
+		seq, head = event.ChainSeq, event.Hash
+
+		response, err := sqliteJSON(event.Response)
+		if err != nil {
+			return fmt.Errorf("failed to encode response: %w", err)
+		}
+		attributes, err := sqliteJSON(event.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to encode attributes: %w", err)
+		}
+
+		_, err = stmt.ExecContext(ctx,
+			event.ID,
+			nullableString(strings.ToLower(event.EventID)),
+			nullableString(event.IdempotencyKey),
+			sqliteTime(event.Timestamp),
+			event.User,
+			event.Component,
+			event.Operation,
+			event.SessionID,
+			event.RequestID,
+			response,
+			attributes,
+			sqliteTime(event.CreatedAt),
+			event.ChainSeq,
+			event.PrevHash,
+			event.Hash,
+			nullableString(event.Actor),
+			event.TenantID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store audit event: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE audit_chain_head SET seq = ?, hash = ? WHERE id = 1", seq, head); err != nil {
+		return fmt.Errorf("failed to update chain head: %w", err)
+	}
+	return nil
+}
+
+// StoreEvent сохраняет событие очередным звеном цепочки хешей. Если событие
+// с тем же event_id или ключом идемпотентности уже есть, возвращает
+// сохранённое ранее вместе с ErrDuplicateEvent.
+func (r *sqliteRepository) StoreEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error) {
+	defaultTenant(event)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := findSQLiteIdentity(ctx, tx, event)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, ErrDuplicateEvent
+	}
+
+	if err := insertSQLiteEvents(ctx, tx, []*model.AuditEvent{event}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit audit event: %w", err)
+	}
+
+	r.publish([]*model.AuditEvent{event})
+	return event, nil
+}
+
+// StoreEvents записывает пачку событий одной транзакцией и возвращает для
+// каждого признак дубликата. Дубликаты - уже сохранённые или встретившиеся
+// раньше в пачке - не пишутся, а получают id оригинала.
+func (r *sqliteRepository) StoreEvents(ctx context.Context, events []*model.AuditEvent) ([]bool, error) {
+	duplicates := make([]bool, len(events))
+	if len(events) == 0 {
+		return duplicates, nil
+	}
+	defaultTenant(events...)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	originals := make([]*model.AuditEvent, len(events))
+	byEventID := make(map[string]*model.AuditEvent)
+	byKey := make(map[string]*model.AuditEvent)
+	fresh := make([]*model.AuditEvent, 0, len(events))
+	for i, event := range events {
+		original := byEventID[event.EventID]
+		if original == nil && event.IdempotencyKey != "" {
+			original = byKey[tenantKey(event.TenantID, event.IdempotencyKey)]
+		}
+		if original == nil {
+			if original, err = findSQLiteIdentity(ctx, tx, event); err != nil {
+				return nil, err
+			}
+		}
+		if original != nil && original.TenantID != event.TenantID {
+			return nil, ErrEventIDTaken
+		}
+		if original != nil {
+			duplicates[i] = true
+			originals[i] = original
+			continue
+		}
+
+		if event.EventID != "" {
+			byEventID[event.EventID] = event
+		}
+		if event.IdempotencyKey != "" {
+			byKey[tenantKey(event.TenantID, event.IdempotencyKey)] = event
+		}
+		fresh = append(fresh, event)
+	}
+
+	if err := insertSQLiteEvents(ctx, tx, fresh); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit audit events: %w", err)
+	}
+
+	for i, original := range originals {
+		if original == nil {
+			continue
+		}
+		events[i].ID = original.ID
+		events[i].EventID = original.EventID
+		events[i].CreatedAt = original.CreatedAt
+	}
+
+	r.publish(fresh)
+
+	return duplicates, nil
+}
+
+// ReplayEvents идемпотентно записывает события, уже получившие event_id:
+// события, которые есть в таблице, пропускаются. Возвращает число
+// вставленных.
+func (r *sqliteRepository) ReplayEvents(ctx context.Context, events []*model.AuditEvent) (int, error) {
+	defaultTenant(events...)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var inserted []*model.AuditEvent
+	for _, event := range events {
+		if event.EventID == "" {
+			return 0, fmt.Errorf("cannot replay event without event_id")
+		}
+
+		// Вставленные раньше в этой же транзакции тоже находятся
+		existing, err := findSQLiteIdentity(ctx, tx, event)
+		if err == ErrEventIDTaken {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if existing != nil {
+			continue
+		}
+		if err := insertSQLiteEvents(ctx, tx, []*model.AuditEvent{event}); err != nil {
+			return 0, err
+		}
+		inserted = append(inserted, event)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit replayed events: %w", err)
+	}
+
+	r.publish(inserted)
+	return len(inserted), nil
+}
+
+func (r *sqliteRepository) FindStored(ctx context.Context, events []*model.AuditEvent) ([]*model.AuditEvent, error) {
+	stored := make([]*model.AuditEvent, len(events))
+	for i, event := range events {
+		original, err := findSQLiteIdentity(ctx, r.db, event)
+		if err == ErrEventIDTaken {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stored[i] = original
+	}
+	return stored, nil
+}
+
+func (r *sqliteRepository) GetEvent(ctx context.Context, id int64) (*model.AuditEvent, error) {
+	event, err := scanSQLiteEvent(r.db.QueryRowContext(ctx, "SELECT "+sqliteEventColumns+" FROM audit_events WHERE id = ?", id))
+	if err == nil && !tenantVisible(ctx, event) {
+		err = sql.ErrNoRows
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrEventNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit event: %w", err)
+	}
+	return event, nil
+}
+
+// sqliteConditions переводит в условия WHERE фильтры, которые SQLite
+// выполняет по индексам. Остальное проверяет eventMatcher, который
+// повторяет и эти условия.
+func sqliteConditions(filters model.EventFilters) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filters.Tenant != "" {
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, filters.Tenant)
+	}
+	if filters.Timestamp != nil {
+		conditions = append(conditions, "timestamp = ?")
+		args = append(args, sqliteTime(*filters.Timestamp))
+	} else {
+		if filters.TimestampStart != nil {
+			conditions = append(conditions, "timestamp >= ?")
+			args = append(args, sqliteTime(*filters.TimestampStart))
+		}
+		if filters.TimestampEnd != nil {
+			conditions = append(conditions, "timestamp <= ?")
+			args = append(args, sqliteTime(*filters.TimestampEnd))
+		}
+	}
+
+	addListFilter := func(column string, values []interface{}) {
+		if len(values) == 0 {
+			return
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		conditions = append(conditions, fmt.Sprintf("%s IN (%s)", column, placeholders))
+		args = append(args, values...)
+	}
+	strs := func(values []string) []interface{} {
+		out := make([]interface{}, len(values))
+		for i, v := range values {
+			out[i] = v
+		}
+		return out
+	}
+	ints := func(values []int64) []interface{} {
+		out := make([]interface{}, len(values))
+		for i, v := range values {
+			out[i] = v
+		}
+		return out
+	}
+
+	addListFilter("user_id", strs(filters.Users))
+	addListFilter("component", strs(filters.Components))
+	addListFilter("operation", strs(filters.Operations))
+	addListFilter("session_id", ints(filters.SessionIDs))
+	addListFilter("request_id", ints(filters.RequestIDs))
+	addListFilter("id", ints(filters.IDs))
+
+	return conditions, args
+}
+
+func (r *sqliteRepository) FindEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error) {
+	filters = scopeFilters(ctx, filters)
+	m, err := newEventMatcher(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	conditions, args := sqliteConditions(filters)
+	if filters.Cursor != nil {
+		ts := sqliteTime(filters.Cursor.Timestamp)
+		conditions = append(conditions, "timestamp <= ? AND (timestamp < ? OR id < ?)")
+		args = append(args, ts, ts, filters.Cursor.ID)
+	}
+
+	// Строки читаются по одной, пока не наберётся limit подходящих
+	query := "SELECT " + sqliteEventColumns + " FROM audit_events" + whereClause(conditions) + " ORDER BY timestamp DESC, id DESC"
+	events, err := r.queryEvents(ctx, query, args, m, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(filters.Archived) > 0 {
+		return withArchived(events, filters, m, limit, func(event *model.AuditEvent) (bool, error) {
+			var exists bool
+			err := r.db.QueryRowContext(ctx,
+				"SELECT EXISTS (SELECT 1 FROM audit_events WHERE id = ? AND timestamp = ?)",
+				event.ID, sqliteTime(event.Timestamp)).Scan(&exists)
+			if err != nil {
+				return false, fmt.Errorf("failed to check archived event: %w", err)
+			}
+			return exists, nil
+		})
+	}
+
+	return events, nil
+}
+
+func (r *sqliteRepository) queryEvents(ctx context.Context, query string, args []interface{}, m *eventMatcher, limit int) ([]*model.AuditEvent, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*model.AuditEvent
+	for len(events) < limit && rows.Next() {
+		event, err := scanSQLiteEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		if m.match(event) {
+			events = append(events, event)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return events, nil
+}
+
+// scan перебирает подходящие под фильтры события от старых к новым, читая
+// строки по одной
+func (r *sqliteRepository) scan(ctx context.Context, filters model.EventFilters) (eventScan, error) {
+	return r.scanIn(ctx, r.db, filters)
+}
+
+// scanIn - scan, читающий через q: пул или соединение с открытой транзакцией
+func (r *sqliteRepository) scanIn(ctx context.Context, q queryer, filters model.EventFilters) (eventScan, error) {
+	filters = scopeFilters(ctx, filters)
+	m, err := newEventMatcher(filters)
+	if err != nil {
+		return nil, err
+	}
+	conditions, args := sqliteConditions(filters)
+	query := "SELECT " + sqliteEventColumns + " FROM audit_events" + whereClause(conditions) + " ORDER BY timestamp, id"
+
+	return func(fn func(*model.AuditEvent) error) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query events: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			event, err := scanSQLiteEvent(rows)
+			if err != nil {
+				return fmt.Errorf("failed to scan event: %w", err)
+			}
+			if !m.match(event) {
+				continue
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("rows iteration error: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+func (r *sqliteRepository) AggregateEvents(ctx context.Context, req model.AggregateRequest) (*model.AggregateResult, error) {
+	scan, err := r.scan(ctx, req.Filters)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateScan(req, scan)
+}
+
+func (r *sqliteRepository) ExportColumns(ctx context.Context, filters model.EventFilters, limit int) (*model.ExportColumns, error) {
+	scan, err := r.scan(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+	return exportColumnsScan(limit, scan)
+}
+
+// ExportEvents передаёт подходящие события в fn от старых к новым. Обе
+// выборки идут в одной транзакции чтения на отдельном соединении: в WAL она
+// видит один снимок и не мешает записи. BeginTx не подходит - пул открывает
+// транзакции с блокировкой записи (BEGIN IMMEDIATE).
+func (r *sqliteRepository) ExportEvents(ctx context.Context, filters model.EventFilters, columnLimit int,
+	columns func(*model.ExportColumns) error, fn func(*model.AuditEvent) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN DEFERRED"); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	scan, err := r.scanIn(ctx, conn, filters)
+	if err != nil {
+		return err
+	}
+	if columnLimit > 0 {
+		paths, err := exportColumnsScan(columnLimit, scan)
+		if err != nil {
+			return err
+		}
+		if err := columns(paths); err != nil {
+			return err
+		}
+	}
+	return scan(fn)
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"audit-service/internal/model"
+	"audit-service/internal/tenant"
+)
+
+// queryer - общее у пула и транзакции для чтения
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// scopeFilters ограничивает выборку арендатором запроса. Так делает каждый
+// метод хранилища, читающий события, поэтому вызывающему коду не нужно
+// помнить об арендаторе. Без арендатора в ctx остаётся заданный в фильтрах.
+func scopeFilters(ctx context.Context, filters model.EventFilters) model.EventFilters {
+	if id := tenant.FromContext(ctx); id != "" {
+		filters.Tenant = id
+	}
+	return filters
+}
+
+// tenantVisible сообщает, виден ли event запросу с арендатором из ctx
+func tenantVisible(ctx context.Context, event *model.AuditEvent) bool {
+	id := tenant.FromContext(ctx)
+	return id == "" || event.TenantID == id
+}
+
+// defaultTenant относит события без арендатора - например, из спула,
+// записанного до появления арендаторов, - к tenant.Default
+func defaultTenant(events ...*model.AuditEvent) {
+	for _, event := range events {
+		if event.TenantID == "" {
+			event.TenantID = tenant.Default
+		}
+	}
+}
+
+// errNoTenantScope - запрос к Postgres без арендатора и без пометки
+// tenant.WithAll: политики RLS не пропустили бы ни одной строки, поэтому
+// такой вызов - ошибка в коде сервиса
+var errNoTenantScope = errors.New("query has neither tenant nor all-tenants scope")
+
+// setTenant задаёт арендатора запроса на время транзакции tx: по нему
+// политики RLS в Postgres отсекают чужие строки. Запрос, помеченный
+// tenant.WithAll, получает tenant.All и видит всех арендаторов.
+func setTenant(ctx context.Context, tx *sql.Tx) error {
+	id := tenant.FromContext(ctx)
+	if id == "" {
+		if !tenant.IsAll(ctx) {
+			return errNoTenantScope
+		}
+		id = tenant.All
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('audit.tenant_id', $1, true)", id); err != nil {
+		return fmt.Errorf("failed to set tenant: %w", err)
+	}
+	return nil
+}
+
+// readTenant выполняет чтения fn в транзакции только для чтения с
+// арендатором запроса
+func readTenant(ctx context.Context, db *sql.DB, fn func(q queryer) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setTenant(ctx, tx); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
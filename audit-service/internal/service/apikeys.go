@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"audit-service/internal/auth"
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+	"audit-service/internal/tenant"
+)
+
+// ErrUnauthenticated - ключ или токен не передан, неизвестен, отозван
+// или просрочен
+var ErrUnauthenticated = errors.New("invalid or missing credentials")
+
+var ErrAPIKeyNotFound = repository.ErrAPIKeyNotFound
+
+// Сколько проверенный ключ живёт в кеше. Отзыв через эту реплику действует
+// сразу, через другие - не позже, чем через keyCacheTTL.
+const keyCacheTTL = 30 * time.Second
+
+type cachedKey struct {
+	key     *model.APIKey
+	hash    []byte
+	expires time.Time
+}
+
+// APIKeyService выпускает, отзывает и проверяет ключи API. Ключ из
+// AUTH_BOOTSTRAP_KEY не хранится в БД и даёт право admin - им создаются
+// первые ключи.
+type APIKeyService struct {
+	repo      repository.APIKeyRepository
+	bootstrap []byte
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+// bootstrapKey может быть пустым
+func NewAPIKeyService(repo repository.APIKeyRepository, bootstrapKey string) *APIKeyService {
+	s := &APIKeyService{repo: repo, cache: make(map[string]cachedKey)}
+	if bootstrapKey != "" {
+		s.bootstrap = auth.HashKey(bootstrapKey)
+	}
+	return s
+}
+
+// Create выпускает ключ. Сам ключ есть только в возвращённом значении.
+// Администратор, привязанный к арендатору, выпускает ключи только своего
+// арендатора.
+func (s *APIKeyService) Create(ctx context.Context, req model.APIKeyRequest) (*model.APIKey, error) {
+	if req.Name == "" {
+		return nil, invalidRequest("field 'name' is required")
+	}
+	if len(req.Name) > 100 {
+		return nil, invalidRequest("name field too long")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, invalidRequest("field 'scopes' is required")
+	}
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			return nil, invalidRequest(fmt.Sprintf("unknown scope %q, must be one of %v", scope, auth.Scopes))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if req.Tenant != "" && !tenant.Valid(req.Tenant) {
+		return nil, invalidRequest("tenant must be 1-63 lowercase letters, digits, '-' or '_'")
+	}
+	if own := callerTenant(ctx); own != "" {
+		if req.Tenant != "" && req.Tenant != own {
+			return nil, invalidRequest("tenant must match the tenant of the caller")
+		}
+		req.Tenant = own
+	}
+
+	id, secret, err := auth.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	key := &model.APIKey{
+		ID:        id,
+		Name:      req.Name,
+		Scopes:    scopes,
+		Tenant:    req.Tenant,
+		CreatedBy: auth.Actor(ctx),
+		CreatedAt: time.Now().UTC().Round(time.Microsecond),
+	}
+	if err := s.repo.CreateKey(ctx, key, auth.HashKey(secret)); err != nil {
+		return nil, err
+	}
+
+	key.Key = secret
+	return key, nil
+}
+
+// List возвращает ключи; привязанному к арендатору - только ключи арендатора
+func (s *APIKeyService) List(ctx context.Context) ([]*model.APIKey, error) {
+	keys, err := s.repo.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	own := callerTenant(ctx)
+	if own == "" {
+		return keys, nil
+	}
+
+	visible := []*model.APIKey{}
+	for _, key := range keys {
+		if key.Tenant == own {
+			visible = append(visible, key)
+		}
+	}
+	return visible, nil
+}
+
+// Revoke отзывает ключ. События, записанные с ним, сохраняют его id. Ключ
+// другого арендатора для привязанного к арендатору не существует.
+func (s *APIKeyService) Revoke(ctx context.Context, id string) error {
+	if own := callerTenant(ctx); own != "" {
+		key, _, err := s.repo.GetKey(ctx, id)
+		if err != nil {
+			return err
+		}
+		if key.Tenant != own {
+			return ErrAPIKeyNotFound
+		}
+	}
+	if err := s.repo.RevokeKey(ctx, id, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.cache, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Authenticate проверяет ключ и возвращает его владельца
+func (s *APIKeyService) Authenticate(ctx context.Context, secret string) (*auth.Principal, error) {
+	hash := auth.HashKey(secret)
+	if s.bootstrap != nil && subtle.ConstantTimeCompare(hash, s.bootstrap) == 1 {
+		return &auth.Principal{Actor: "bootstrap", Scopes: []string{auth.ScopeAdmin}}, nil
+	}
+
+	id, ok := auth.KeyID(secret)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	key, stored, err := s.lookup(ctx, id)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hash, stored) != 1 || key.RevokedAt != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	return &auth.Principal{Actor: auth.APIKeyActor(key.ID), Scopes: key.Scopes, Tenant: key.Tenant}, nil
+}
+
+// callerTenant - арендатор, к которому привязан клиент запроса
+func callerTenant(ctx context.Context) string {
+	if p := auth.FromContext(ctx); p != nil {
+		return p.Tenant
+	}
+	return ""
+}
+
+// lookup читает ключ из кеша или хранилища. Неизвестные id не кешируются.
+func (s *APIKeyService) lookup(ctx context.Context, id string) (*model.APIKey, []byte, error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.cache[id]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.key, cached.hash, nil
+	}
+
+	key, hash, err := s.repo.GetKey(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[id] = cachedKey{key: key, hash: hash, expires: now.Add(keyCacheTTL)}
+	s.mu.Unlock()
+	return key, hash, nil
+}
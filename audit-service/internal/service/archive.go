@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"audit-service/internal/archive"
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+	"audit-service/internal/tenant"
+)
+
+// ErrArchiveBusy - архивом сейчас занимается другая реплика
+var ErrArchiveBusy = errors.New("archive is busy, try again later")
+
+// Сколько событий из архива можно подмешать к одному запросу поиска
+const maxArchivedEvents = 50000
+
+type ArchiveConfig struct {
+	// Через сколько дней события уходят в архив, 0 - не архивировать
+	AfterDays int
+	// Как часто искать события для архивации
+	Interval time.Duration
+	// Максимум событий в одном сегменте
+	SegmentRows int
+	// Сколько восстановленные события не возвращаются в архив
+	RestoreHold time.Duration
+	// Сроки хранения, по которым из архива удаляются истёкшие события;
+	// nil - архив хранится бессрочно
+	Retention *repository.RetentionPolicy
+}
+
+// Archiver переносит старые события из audit_events в сегменты холодного
+// архива и восстанавливает их обратно. Запускается на каждой реплике,
+// работу делает та, что взяла advisory-блокировку архива.
+type Archiver struct {
+	repo    *repository.ArchiveRepository
+	archive *archive.Archive
+	cfg     ArchiveConfig
+}
+
+func NewArchiver(repo *repository.ArchiveRepository, arch *archive.Archive, cfg ArchiveConfig) *Archiver {
+	return &Archiver{repo: repo, archive: arch, cfg: cfg}
+}
+
+// Enabled сообщает, есть ли фоновая работа: архивация или очистка архива
+// по срокам хранения
+func (a *Archiver) Enabled() bool {
+	return a.cfg.AfterDays > 0 || a.cfg.Retention != nil
+}
+
+func (a *Archiver) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		a.maintain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// maintain под блокировкой архива удаляет из сегментов события с истёкшим
+// сроком хранения и архивирует новые
+func (a *Archiver) maintain(ctx context.Context) {
+	now := time.Now().UTC()
+	var archived, expired int64
+	var segments int
+	locked, err := a.repo.WithLock(ctx, func(s *repository.ArchiveSession) error {
+		var err error
+		if a.cfg.Retention != nil {
+			if expired, err = a.purgeExpired(ctx, now); err != nil {
+				return err
+			}
+		}
+		if a.cfg.AfterDays > 0 {
+			archived, segments, err = a.archiveOld(ctx, s, now)
+		}
+		return err
+	})
+	if locked && expired > 0 {
+		log.Printf("Purged %d expired events from the archive", expired)
+	}
+	if locked && segments > 0 {
+		log.Printf("Archived %d events into %d segments", archived, segments)
+	}
+	if err != nil {
+		log.Printf("Archiving failed: %v", err)
+	}
+}
+
+// archiveOld архивирует события старше AfterDays по дням, от старых к новым.
+// Для каждого сегмента: запись файла, запись в оглавление, удаление из БД.
+// Сбой между шагами приводит лишь к повторной архивации тех же событий.
+func (a *Archiver) archiveOld(ctx context.Context, s *repository.ArchiveSession, now time.Time) (archived int64, segments int, err error) {
+	cutoff := now.AddDate(0, 0, -a.cfg.AfterDays)
+	for {
+		if err := ctx.Err(); err != nil {
+			return archived, segments, err
+		}
+
+		from, to, ok, err := s.NextChunk(ctx, cutoff, now)
+		if err != nil || !ok {
+			return archived, segments, err
+		}
+		events, err := s.EventsInRange(ctx, from, to, a.cfg.SegmentRows)
+		if err != nil || len(events) == 0 {
+			return archived, segments, err
+		}
+
+		if _, err := a.archive.WriteSegment(ctx, from, events, a.retention(events)); err != nil {
+			return archived, segments, err
+		}
+		deleted, err := s.DeleteEvents(ctx, from, to, events)
+		if err != nil {
+			return archived, segments, err
+		}
+		if deleted == 0 {
+			// Иначе следующий шаг выбрал бы те же события снова
+			return archived, segments, fmt.Errorf("archived events from %s were not deleted", from.Format("2006-01-02"))
+		}
+		archived += deleted
+		segments++
+	}
+}
+
+// purgeExpired удаляет из архива события с истёкшим сроком хранения.
+// Читаются только сегменты, у которых срок уже наступил или посчитан по
+// другим правилам; сегмент без оставшихся событий удаляется целиком.
+func (a *Archiver) purgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	manifest, err := a.archive.Manifest(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	policy := a.cfg.Retention.Fingerprint()
+	var purged int64
+	for _, segment := range manifest.Segments {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		if r := segment.Retention; r != nil && r.Policy == policy && (r.ExpiresAt.IsZero() || r.ExpiresAt.After(now)) {
+			continue
+		}
+
+		events, err := a.archive.ReadSegment(ctx, segment)
+		if err != nil {
+			return purged, err
+		}
+		kept := events[:0]
+		for _, event := range events {
+			if expiresAt, ok := a.cfg.Retention.ExpiresAt(event); !ok || !expiresAt.Before(now) {
+				kept = append(kept, event)
+			}
+		}
+
+		if len(kept) == len(events) {
+			err = a.archive.SetRetention(ctx, segment, a.retention(kept))
+		} else {
+			_, err = a.archive.RewriteSegment(ctx, segment, kept, a.retention(kept))
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged += int64(len(events) - len(kept))
+	}
+	return purged, nil
+}
+
+// retention считает сроки хранения событий сегмента; nil без правил
+func (a *Archiver) retention(events []*model.AuditEvent) *archive.Retention {
+	if a.cfg.Retention == nil {
+		return nil
+	}
+
+	r := &archive.Retention{Policy: a.cfg.Retention.Fingerprint()}
+	for _, event := range events {
+		expiresAt, ok := a.cfg.Retention.ExpiresAt(event)
+		if ok && (r.ExpiresAt.IsZero() || expiresAt.Before(r.ExpiresAt)) {
+			r.ExpiresAt = expiresAt
+		}
+	}
+	return r
+}
+
+// Manifest отдаёт оглавление архива
+func (a *Archiver) Manifest(ctx context.Context) (*archive.Manifest, error) {
+	return a.archive.Manifest(ctx)
+}
+
+// Restore возвращает в audit_events сегменты, пересекающиеся с [from, to],
+// и убирает их из архива. Восстановленные дни защищаются от повторной
+// архивации на RestoreHold.
+func (a *Archiver) Restore(ctx context.Context, from, to time.Time) (*model.ArchiveRestoreReport, error) {
+	if to.Before(from) {
+		return nil, invalidRequest("from cannot be after to")
+	}
+
+	now := time.Now().UTC()
+	report := &model.ArchiveRestoreReport{From: from, To: to, Segments: []string{}}
+	locked, err := a.repo.WithLock(ctx, func(s *repository.ArchiveSession) error {
+		segments, err := a.archive.Segments(ctx, from, to)
+		if err != nil || len(segments) == 0 {
+			return err
+		}
+
+		// Удержание ставится до вставки, чтобы частично восстановленный
+		// диапазон тоже не ушёл обратно в архив
+		holdFrom, holdTo := segments[0].From, segments[0].To
+		for _, segment := range segments[1:] {
+			if segment.From.Before(holdFrom) {
+				holdFrom = segment.From
+			}
+			if segment.To.After(holdTo) {
+				holdTo = segment.To
+			}
+		}
+		holdFrom = time.Date(holdFrom.Year(), holdFrom.Month(), holdFrom.Day(), 0, 0, 0, 0, time.UTC)
+		holdTo = time.Date(holdTo.Year(), holdTo.Month(), holdTo.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+		report.HeldUntil = now.Add(a.cfg.RestoreHold)
+		if err := s.AddHold(ctx, holdFrom, holdTo, report.HeldUntil); err != nil {
+			return err
+		}
+
+		for _, segment := range segments {
+			events, err := a.archive.ReadSegment(ctx, segment)
+			if err != nil {
+				return err
+			}
+			restored, err := s.RestoreEvents(ctx, events)
+			if err != nil {
+				return err
+			}
+			report.Restored += restored
+			report.Segments = append(report.Segments, segment.Name)
+		}
+
+		return a.archive.RemoveSegments(ctx, report.Segments)
+	})
+	if !locked && err == nil {
+		return nil, ErrArchiveBusy
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(report.Segments) > 0 {
+		log.Printf("Restored %d events from %d archive segments", report.Restored, len(report.Segments))
+	}
+	return report, nil
+}
+
+// archivedEvents читает из архива события для подмешивания к поиску. Здесь
+// отсекается только явно лишнее (время, простые списки); окончательно
+// фильтры применяет запрос в БД.
+func (s *auditService) archivedEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error) {
+	if s.archive == nil {
+		return nil, invalidRequest("cold archive is not configured")
+	}
+
+	var from, to time.Time
+	switch {
+	case filters.Timestamp != nil:
+		from, to = *filters.Timestamp, *filters.Timestamp
+	case filters.TimestampStart != nil:
+		from, to = *filters.TimestampStart, time.Now().UTC()
+		if filters.TimestampEnd != nil {
+			to = *filters.TimestampEnd
+		}
+	default:
+		return nil, invalidRequest("include_archive requires ev_ts or ev_ts_start")
+	}
+	// Следующие страницы идут строго до курсора
+	if filters.Cursor != nil && filters.Cursor.Timestamp.Before(to) {
+		to = filters.Cursor.Timestamp
+	}
+	from, to = wallClock(from), wallClock(to)
+
+	segments, err := s.archive.Segments(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Сегменты общие для всех арендаторов
+	tenantID := tenant.FromContext(ctx)
+	var matched []*model.AuditEvent
+	for _, segment := range segments {
+		events, err := s.archive.ReadSegment(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if tenantID != "" && event.TenantID != tenantID {
+				continue
+			}
+			if event.Timestamp.Before(from) || event.Timestamp.After(to) || !archivedEventMatches(event, filters) {
+				continue
+			}
+			matched = append(matched, event)
+			if len(matched) > maxArchivedEvents {
+				return nil, invalidRequest(fmt.Sprintf(
+					"archived range holds more than %d matching events, narrow the time range or filters", maxArchivedEvents))
+			}
+		}
+	}
+
+	return matched, nil
+}
+
+// wallClock отбрасывает часовой пояс так же, как PostgreSQL при сравнении с
+// колонкой TIMESTAMP без пояса
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func archivedEventMatches(event *model.AuditEvent, filters model.EventFilters) bool {
+	if len(filters.Users) > 0 && !containsValue(filters.Users, event.User) {
+		return false
+	}
+	if len(filters.Operations) > 0 && !containsValue(filters.Operations, event.Operation) {
+		return false
+	}
+	if len(filters.Components) > 0 && (event.Component == nil || !containsValue(filters.Components, *event.Component)) {
+		return false
+	}
+	if len(filters.SessionIDs) > 0 && (event.SessionID == nil || !containsValue(filters.SessionIDs, *event.SessionID)) {
+		return false
+	}
+	if len(filters.RequestIDs) > 0 && (event.RequestID == nil || !containsValue(filters.RequestIDs, *event.RequestID)) {
+		return false
+	}
+	return true
+}
+
+func containsValue[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
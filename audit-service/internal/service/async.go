@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+	"audit-service/internal/spool"
+	"audit-service/internal/tenant"
+)
+
+var (
+	ErrQueueFull    = errors.New("async write queue is full")
+	ErrWriterClosed = errors.New("async writer is shutting down")
+)
+
+type AsyncConfig struct {
+	QueueSize     int
+	Workers       int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// AsyncWriter принимает события в ограниченную очередь в памяти, а пул
+// воркеров сбрасывает их в БД пачками (групповой коммит через COPY).
+type AsyncWriter struct {
+	repo  repository.AuditRepository
+	spool *spool.Spool
+	cfg   AsyncConfig
+	queue chan *model.AuditEvent
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// sp может быть nil, тогда пачки, которые не удалось записать, теряются
+func NewAsyncWriter(repo repository.AuditRepository, sp *spool.Spool, cfg AsyncConfig) *AsyncWriter {
+	w := &AsyncWriter{
+		repo:  repo,
+		spool: sp,
+		cfg:   cfg,
+		queue: make(chan *model.AuditEvent, cfg.QueueSize),
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		w.wg.Add(1)
+		go w.worker()
+	}
+
+	return w
+}
+
+// Enqueue ставит событие в очередь. При переполненной очереди не
+// блокируется, а возвращает ErrQueueFull.
+func (w *AsyncWriter) Enqueue(event *model.AuditEvent) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.queue <- event:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown перестаёт принимать события и ждёт, пока воркеры запишут всё,
+// что уже лежит в очереди.
+func (w *AsyncWriter) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *AsyncWriter) worker() {
+	defer w.wg.Done()
+
+	batch := make([]*model.AuditEvent, 0, w.cfg.BatchSize)
+	timer := time.NewTimer(w.cfg.FlushInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) < w.cfg.BatchSize {
+				continue
+			}
+		case <-timer.C:
+		}
+
+		w.flush(batch)
+		batch = batch[:0]
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(w.cfg.FlushInterval)
+	}
+}
+
+func (w *AsyncWriter) flush(events []*model.AuditEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	// Несколько попыток с увеличивающейся паузой, чтобы пережить кратковременный сбой primary
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		// Пачка собрана из запросов разных арендаторов, каждое событие уже несёт своего
+		ctx, cancel := context.WithTimeout(tenant.WithAll(context.Background()), 10*time.Second)
+		_, err = w.repo.StoreEvents(ctx, events)
+		cancel()
+		if err == nil {
+			return
+		}
+		// Одно событие с чужим event_id не должно стоить всей пачки
+		if errors.Is(err, repository.ErrEventIDTaken) {
+			w.storeEach(events)
+			return
+		}
+		log.Printf("Async flush of %d events failed (attempt %d): %v", len(events), attempt, err)
+		// БД недоступна: не ждём, события сразу уходят в спул
+		if w.spool != nil && repository.IsUnavailable(err) {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if w.spool != nil && repository.IsUnavailable(err) {
+		spoolErr := w.spool.Append(events)
+		if spoolErr == nil {
+			log.Printf("Async flush spooled %d events until the database is back", len(events))
+			return
+		}
+		err = spoolErr
+	}
+
+	for _, event := range events {
+		log.Printf("Async event lost, receipt %s: %v", event.EventID, err)
+	}
+}
+
+// storeEach записывает события пачки по одному, отбрасывая те, чей
+// event_id занят другим арендатором
+func (w *AsyncWriter) storeEach(events []*model.AuditEvent) {
+	for _, event := range events {
+		ctx, cancel := context.WithTimeout(tenant.WithAll(context.Background()), 10*time.Second)
+		_, err := w.repo.StoreEvent(ctx, event)
+		cancel()
+		if err == nil || errors.Is(err, repository.ErrDuplicateEvent) {
+			continue
+		}
+		if w.spool != nil && repository.IsUnavailable(err) {
+			if err = w.spool.Append([]*model.AuditEvent{event}); err == nil {
+				continue
+			}
+		}
+		log.Printf("Async event lost, receipt %s: %v", event.EventID, err)
+	}
+}
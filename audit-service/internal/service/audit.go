@@ -2,27 +2,279 @@ package service
 
 import (
     "context"
+    "crypto/rand"
+    "errors"
     "fmt"
+    "log"
+    "strings"
     "time"
 
+    "audit-service/internal/archive"
+    "audit-service/internal/auth"
     "audit-service/internal/model"
     "audit-service/internal/repository"
+    "audit-service/internal/spool"
+    "audit-service/internal/tenant"
 )
 
+// ErrEventSpooled означает, что БД недоступна и событие сохранено в локальный
+// спул: оно будет записано в audit_events после восстановления соединения.
+var ErrEventSpooled = errors.New("database unavailable, event spooled")
+
+// ValidationError описывает некорректное событие или фильтр, в отличие от
+// сбоев БД это ошибка клиента
+type ValidationError struct {
+    Message string
+}
+
+func (e *ValidationError) Error() string {
+    return e.Message
+}
+
+func invalidRequest(message string) error {
+    return &ValidationError{Message: message}
+}
+
+// ErrDuplicateEvent возвращается вместе с ранее сохранённым событием при
+// повторной отправке с тем же event_id или ключом идемпотентности
+var ErrDuplicateEvent = repository.ErrDuplicateEvent
+
+var ErrEventNotFound = repository.ErrEventNotFound
+
+// Ответ на event_id, занятый событием другого арендатора. Чьим - не
+// сообщается.
+const eventIDTakenMessage = "event_id is already taken"
+
 type AuditService interface {
     StoreEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error)
-    FindEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error)
+    StoreEvents(ctx context.Context, events []*model.AuditEvent) (*model.BatchResult, error)
+    EnqueueEvent(ctx context.Context, event *model.AuditEvent) (*model.Receipt, error)
+    AsyncEnabled() bool
+    GetEvent(ctx context.Context, id int64) (*model.AuditEvent, error)
+    FindEvents(ctx context.Context, filters model.EventFilters) (*model.EventPage, error)
+    AggregateEvents(ctx context.Context, req model.AggregateRequest) (*model.AggregateResult, error)
+    SubscribeEvents(ctx context.Context, filters model.EventFilters) (*Subscription, error)
+    Timeline(ctx context.Context, kind string, id int64) (*model.Timeline, error)
 }
 
 type auditService struct {
-    repo repository.AuditRepository
+    repo   repository.AuditRepository
+    async  *AsyncWriter
+    spool  *spool.Spool
+    stream *EventStream
+    archive *archive.Archive
+    quotas *TenantQuotas
+}
+
+// async, sp, arch и quotas могут быть nil: тогда асинхронный режим, спул,
+// поиск по холодному архиву и квоты арендаторов выключены
+func NewAuditService(repo repository.AuditRepository, async *AsyncWriter, sp *spool.Spool, stream *EventStream, arch *archive.Archive, quotas *TenantQuotas) AuditService {
+    return &auditService{repo: repo, async: async, spool: sp, stream: stream, archive: arch, quotas: quotas}
+}
+
+// allow списывает n событий арендатора с его квоты и возвращает, сколько
+// из них можно записать
+func (s *auditService) allow(ctx context.Context, tenantID string, n int) int {
+    if s.quotas == nil {
+        return n
+    }
+    return s.quotas.Allow(ctx, tenantID, n)
+}
+
+// refund возвращает квоту n событий, которые оказались повторами уже
+// сохранённых
+func (s *auditService) refund(ctx context.Context, tenantID string, n int) {
+    if s.quotas != nil {
+        s.quotas.Refund(ctx, tenantID, n)
+    }
 }
 
-func NewAuditService(repo repository.AuditRepository) AuditService {
-    return &auditService{repo: repo}
+// findStored ищет уже сохранённые оригиналы событий, которым не хватило
+// квоты: повтор записанного события отдаёт его, а не 429. Без квот и при
+// ошибке поиска оригиналов нет.
+func (s *auditService) findStored(ctx context.Context, events []*model.AuditEvent) []*model.AuditEvent {
+    if len(events) == 0 {
+        return nil
+    }
+    stored, err := s.repo.FindStored(ctx, events)
+    if err != nil {
+        log.Printf("Failed to look up stored events over quota: %v", err)
+        return make([]*model.AuditEvent, len(events))
+    }
+    return stored
 }
 
 func (s *auditService) StoreEvent(ctx context.Context, event *model.AuditEvent) (*model.AuditEvent, error) {
+    if err := validateEvent(ctx, event); err != nil {
+        return nil, err
+    }
+    if s.allow(ctx, event.TenantID, 1) == 0 {
+        if original := s.findStored(ctx, []*model.AuditEvent{event})[0]; original != nil {
+            stored, err := s.repo.GetEvent(ctx, original.ID)
+            if err != nil {
+                return nil, err
+            }
+            return stored, ErrDuplicateEvent
+        }
+        return nil, ErrQuotaExceeded
+    }
+    
+    stored, err := s.repo.StoreEvent(ctx, event)
+    if errors.Is(err, repository.ErrDuplicateEvent) {
+        s.refund(ctx, event.TenantID, 1)
+    }
+    if errors.Is(err, repository.ErrEventIDTaken) {
+        return nil, invalidRequest(eventIDTakenMessage)
+    }
+    if err != nil && s.spool != nil && repository.IsUnavailable(err) {
+        if spoolErr := s.spool.Append([]*model.AuditEvent{event}); spoolErr != nil {
+            log.Printf("Failed to spool event %s: %v", event.EventID, spoolErr)
+            return nil, err
+        }
+        return event, ErrEventSpooled
+    }
+    
+    return stored, err
+}
+
+// StoreEvents проверяет каждое событие пачки по тем же правилам, что и
+// StoreEvent, и сохраняет только корректные. События сверх квоты
+// арендатора отклоняются так же, как некорректные, если это не повторы уже
+// сохранённых. Ошибка возвращается лишь при сбое записи в БД.
+func (s *auditService) StoreEvents(ctx context.Context, events []*model.AuditEvent) (*model.BatchResult, error) {
+    result := &model.BatchResult{Items: make([]model.BatchItemResult, len(events))}
+    
+    valid := make([]*model.AuditEvent, 0, len(events))
+    validIdx := make([]int, 0, len(events))
+    for i, event := range events {
+        result.Items[i].Index = i
+        if event == nil {
+            result.Items[i].Error = "event is null"
+            result.Rejected++
+            continue
+        }
+        if err := validateEvent(ctx, event); err != nil {
+            result.Items[i].Error = err.Error()
+            result.Rejected++
+            continue
+        }
+        valid = append(valid, event)
+        validIdx = append(validIdx, i)
+    }
+    
+    // Все события пачки принадлежат арендатору запроса
+    tenantID := tenant.ForWrite(ctx)
+    if allowed := s.allow(ctx, tenantID, len(valid)); allowed < len(valid) {
+        originals := s.findStored(ctx, valid[allowed:])
+        for j, i := range validIdx[allowed:] {
+            if original := originals[j]; original != nil {
+                result.Items[i].ID = original.ID
+                result.Items[i].EventID = original.EventID
+                result.Items[i].Duplicate = true
+                result.Accepted++
+                continue
+            }
+            result.Items[i].Error = ErrQuotaExceeded.Error()
+            result.Rejected++
+        }
+        valid, validIdx = valid[:allowed], validIdx[:allowed]
+    }
+    
+    duplicates, err := s.repo.StoreEvents(ctx, valid)
+    if errors.Is(err, repository.ErrEventIDTaken) {
+        return nil, invalidRequest(eventIDTakenMessage)
+    }
+    if err != nil && s.spool != nil && repository.IsUnavailable(err) {
+        if spoolErr := s.spool.Append(valid); spoolErr != nil {
+            log.Printf("Failed to spool %d events: %v", len(valid), spoolErr)
+            return nil, err
+        }
+        for j, event := range valid {
+            result.Items[validIdx[j]].EventID = event.EventID
+            result.Items[validIdx[j]].Spooled = true
+        }
+        result.Accepted += len(valid)
+        result.Spooled = len(valid)
+        return result, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    
+    replayed := 0
+    for j, event := range valid {
+        result.Items[validIdx[j]].ID = event.ID
+        result.Items[validIdx[j]].EventID = event.EventID
+        result.Items[validIdx[j]].Duplicate = duplicates[j]
+        if duplicates[j] {
+            replayed++
+        }
+    }
+    result.Accepted += len(valid)
+    s.refund(ctx, tenantID, replayed)
+    
+    return result, nil
+}
+
+// EnqueueEvent проверяет событие и ставит его в асинхронную очередь записи.
+// Повтор уже сохранённого события распознаётся только при записи очереди:
+// клиент получает квитанцию, а не сохранённое событие, и квота за повтор не
+// возвращается.
+func (s *auditService) EnqueueEvent(ctx context.Context, event *model.AuditEvent) (*model.Receipt, error) {
+    if s.async == nil {
+        return nil, fmt.Errorf("async writes are disabled")
+    }
+    if err := validateEvent(ctx, event); err != nil {
+        return nil, err
+    }
+    if s.allow(ctx, event.TenantID, 1) == 0 {
+        return nil, ErrQuotaExceeded
+    }
+    
+    if err := s.async.Enqueue(event); err != nil {
+        return nil, err
+    }
+    
+    return &model.Receipt{
+        ReceiptID:  event.EventID,
+        Status:     "queued",
+        AcceptedAt: time.Now().UTC(),
+    }, nil
+}
+
+func (s *auditService) AsyncEnabled() bool {
+    return s.async != nil
+}
+
+// validateEvent проверяет событие и дополняет его тем, что ставит сервис:
+// event_id, время, actor - клиента, от которого событие пришло, и
+// арендатора запроса.
+func validateEvent(ctx context.Context, event *model.AuditEvent) error {
+    // Обязательные поля
+    if event.User == "" {
+        return invalidRequest("field 'user' is required")
+    }
+    if event.Operation == "" {
+        return invalidRequest("field 'op' is required")
+    }
+    
+    // Идентичность события, по ней повторная запись не создаёт дубликатов.
+    // Клиент может передать свой UUID, чтобы безопасно повторять отправку.
+    if event.EventID == "" {
+        id, err := newEventID()
+        if err != nil {
+            return fmt.Errorf("failed to generate event id: %w", err)
+        }
+        event.EventID = id
+    } else if !isUUID(event.EventID) {
+        return invalidRequest("event_id must be a UUID")
+    } else {
+        event.EventID = strings.ToLower(event.EventID)
+    }
+    if len(event.IdempotencyKey) > 255 {
+        return invalidRequest("idempotency key too long")
+    }
+    
     // Валидация временной метки
     if event.Timestamp.IsZero() {
         event.Timestamp = time.Now().UTC()
@@ -30,32 +282,201 @@ func (s *auditService) StoreEvent(ctx context.Context, event *model.AuditEvent)
     
     // Ограничение на будущие даты
     if event.Timestamp.After(time.Now().Add(5 * time.Minute)) {
-        return nil, fmt.Errorf("timestamp cannot be more than 5 minutes in the future")
+        return invalidRequest("timestamp cannot be more than 5 minutes in the future")
     }
     
     // Базовая валидация
     if len(event.User) > 255 {
-        return nil, fmt.Errorf("user field too long")
+        return invalidRequest("user field too long")
     }
     if len(event.Operation) > 100 {
-        return nil, fmt.Errorf("operation field too long")
+        return invalidRequest("operation field too long")
+    }
+    
+    // Номер, время записи, звено цепочки и ключи шифрования ставит
+    // хранилище. Переданные клиентом значения попали бы в базу как есть:
+    // чужой enc_key_id, например, ломал бы чтение каждого диапазона с
+    // этим событием.
+    event.ID = 0
+    event.CreatedAt = time.Time{}
+    event.ChainSeq, event.PrevHash, event.Hash = 0, "", ""
+    event.EncKeyID, event.EncDEK = "", ""
+    
+    // Клиент не может выдать себя за другого или писать в чужого арендатора.
+    // principal равен nil, если аутентификация выключена.
+    event.TenantID = tenant.ForWrite(ctx)
+    event.Actor = ""
+    if principal := auth.FromContext(ctx); principal != nil {
+        event.Actor = principal.Actor
+        // В строгом режиме пользователь события - тот, кто прислал токен
+        if principal.User != "" && event.User != principal.User {
+            return invalidRequest("field 'user' must match the authenticated subject")
+        }
+    }
+    
+    return nil
+}
+
+const (
+    defaultPageSize = 1000
+    maxPageSize     = 5000
+)
+
+func (s *auditService) GetEvent(ctx context.Context, id int64) (*model.AuditEvent, error) {
+    if id <= 0 {
+        return nil, ErrEventNotFound
     }
     
-    return s.repo.StoreEvent(ctx, event)
+    return s.repo.GetEvent(ctx, id)
 }
 
-func (s *auditService) FindEvents(ctx context.Context, filters model.EventFilters) ([]*model.AuditEvent, error) {
+func validateFilters(filters model.EventFilters) error {
     // Валидация временных диапазонов
     if filters.TimestampStart != nil && filters.TimestampEnd != nil {
         if filters.TimestampStart.After(*filters.TimestampEnd) {
-            return nil, fmt.Errorf("timestamp_start cannot be after timestamp_end")
+            return invalidRequest("timestamp_start cannot be after timestamp_end")
         }
         
         // Ограничение диапазона 30 дней для производительности
         if filters.TimestampEnd.Sub(*filters.TimestampStart) > 30*24*time.Hour {
-            return nil, fmt.Errorf("date range cannot exceed 30 days")
+            return invalidRequest("date range cannot exceed 30 days")
+        }
+    }
+    
+    return nil
+}
+
+func (s *auditService) FindEvents(ctx context.Context, filters model.EventFilters) (*model.EventPage, error) {
+    if err := validateFilters(filters); err != nil {
+        return nil, err
+    }
+    
+    // Размер страницы
+    limit := filters.Limit
+    if limit == 0 {
+        limit = defaultPageSize
+    }
+    if limit < 0 || limit > maxPageSize {
+        return nil, invalidRequest(fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+    }
+    
+    if filters.IncludeArchive {
+        archived, err := s.archivedEvents(ctx, filters)
+        if err != nil {
+            return nil, err
         }
+        filters.Archived = archived
+    }
+    
+    // Запрашиваем на одну строку больше, чтобы понять, есть ли следующая страница
+    filters.Limit = limit + 1
+    events, err := s.repo.FindEvents(ctx, filters)
+    if err != nil {
+        return nil, err
+    }
+    
+    page := &model.EventPage{Events: events}
+    if len(events) > limit {
+        page.Events = events[:limit]
+        last := page.Events[limit-1]
+        page.NextCursor = model.EncodeCursor(model.EventCursor{Timestamp: last.Timestamp, ID: last.ID})
+    }
+    if page.Events == nil {
+        page.Events = []*model.AuditEvent{}
     }
     
-    return s.repo.FindEvents(ctx, filters)
-}
\ No newline at end of file
+    return page, nil
+}
+
+const (
+    defaultAggregateTop = 10
+    maxAggregateTop     = 100
+)
+
+func (s *auditService) AggregateEvents(ctx context.Context, req model.AggregateRequest) (*model.AggregateResult, error) {
+    if err := validateFilters(req.Filters); err != nil {
+        return nil, err
+    }
+    
+    if req.Interval != "" {
+        if _, ok := model.AggregateIntervals[req.Interval]; !ok {
+            return nil, invalidRequest("interval must be one of 1m, 1h, 1d")
+        }
+    }
+    
+    if req.GroupBy != "" && !isGroupByField(req.GroupBy) {
+        return nil, invalidRequest("group_by must be user, component, operation, actor, tenant, attributes.<path> or res.<path>")
+    }
+    
+    if req.Top == 0 {
+        req.Top = defaultAggregateTop
+    }
+    if req.Top < 0 || req.Top > maxAggregateTop {
+        return nil, invalidRequest(fmt.Sprintf("top must be between 1 and %d", maxAggregateTop))
+    }
+    
+    return s.repo.AggregateEvents(ctx, req)
+}
+
+// SubscribeEvents подписывает на новые события, подходящие под фильтры.
+// Ограничение диапазона в 30 дней к ленте не применяется: она выбирает
+// только что вставленные строки по id.
+func (s *auditService) SubscribeEvents(ctx context.Context, filters model.EventFilters) (*Subscription, error) {
+    if filters.TimestampStart != nil && filters.TimestampEnd != nil && filters.TimestampStart.After(*filters.TimestampEnd) {
+        return nil, invalidRequest("timestamp_start cannot be after timestamp_end")
+    }
+    // Курсор и размер страницы к ленте не относятся
+    filters.Cursor = nil
+    filters.Limit = 0
+    
+    return s.stream.Subscribe(ctx, filters)
+}
+
+func isGroupByField(groupBy string) bool {
+    switch groupBy {
+    case "user", "component", "op", "operation", "actor", "tenant":
+        return true
+    }
+    
+    parts := strings.Split(groupBy, ".")
+    if len(parts) < 2 || (parts[0] != "attributes" && parts[0] != "res") {
+        return false
+    }
+    for _, part := range parts[1:] {
+        if part == "" {
+            return false
+        }
+    }
+    return true
+}
+
+// newEventID генерирует UUID версии 4
+func newEventID() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    b[6] = (b[6] & 0x0f) | 0x40
+    b[8] = (b[8] & 0x3f) | 0x80
+    
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func isUUID(value string) bool {
+    if len(value) != 36 {
+        return false
+    }
+    for i, c := range value {
+        switch i {
+        case 8, 13, 18, 23:
+            if c != '-' {
+                return false
+            }
+        default:
+            if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+                return false
+            }
+        }
+    }
+    return true
+}
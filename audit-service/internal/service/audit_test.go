@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+	"audit-service/internal/tenant"
+)
+
+// Поля, которые ставит хранилище, клиент подменить не может: иначе
+// выдуманный enc_key_id попал бы в базу и ломал чтение событий
+func TestStoreEventIgnoresServerFields(t *testing.T) {
+	ctx := tenant.WithAll(context.Background())
+	svc := NewAuditService(repository.NewMemoryRepository(), nil, nil, nil, nil, nil)
+
+	event := &model.AuditEvent{
+		ID:        42,
+		User:      "alice",
+		Operation: "login",
+		ChainSeq:  7,
+		PrevHash:  "00",
+		Hash:      "ff",
+		EncKeyID:  "bogus",
+		EncDEK:    "deadbeef",
+		Actor:     "api_key:forged",
+		TenantID:  "globex",
+	}
+	stored, err := svc.StoreEvent(ctx, event)
+	if err != nil {
+		t.Fatalf("failed to store event: %v", err)
+	}
+
+	got, err := svc.GetEvent(ctx, stored.ID)
+	if err != nil {
+		t.Fatalf("failed to read event back: %v", err)
+	}
+	if got.EncKeyID != "" || got.EncDEK != "" {
+		t.Errorf("client encryption fields stored: key %q, dek %q", got.EncKeyID, got.EncDEK)
+	}
+	if got.ID == 42 || got.ChainSeq != 1 || got.PrevHash == "00" || got.Hash == "ff" || got.Hash == "" {
+		t.Errorf("client chain fields stored: id %d, seq %d, prev %q, hash %q", got.ID, got.ChainSeq, got.PrevHash, got.Hash)
+	}
+	if got.Actor != "" || got.TenantID != tenant.Default {
+		t.Errorf("client actor or tenant stored: %q, %q", got.Actor, got.TenantID)
+	}
+
+	page, err := svc.FindEvents(ctx, model.EventFilters{})
+	if err != nil {
+		t.Fatalf("failed to query events: %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].EventID != stored.EventID {
+		t.Errorf("query returned %+v, expected the stored event", page.Events)
+	}
+}
+
+// Повтор уже записанного события отдаёт его и не тратит квоту, даже когда
+// квота исчерпана
+func TestReplayDoesNotSpendQuota(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	quotas := NewTenantQuotas(repository.NewMemoryQuotaRepository(), map[string]int{"acme": 2})
+	svc := NewAuditService(repository.NewMemoryRepository(), nil, nil, nil, nil, quotas)
+
+	newEvent := func(key string) *model.AuditEvent {
+		return &model.AuditEvent{User: "alice", Operation: "login", IdempotencyKey: key}
+	}
+
+	first, err := svc.StoreEvent(ctx, newEvent("k1"))
+	if err != nil {
+		t.Fatalf("failed to store event: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		replayed, err := svc.StoreEvent(ctx, newEvent("k1"))
+		if !errors.Is(err, ErrDuplicateEvent) || replayed.ID != first.ID {
+			t.Fatalf("replay %d: got %v, %+v, expected the stored event", i, err, replayed)
+		}
+	}
+
+	if _, err := svc.StoreEvent(ctx, newEvent("k2")); err != nil {
+		t.Fatalf("second event rejected after replays: %v", err)
+	}
+	if _, err := svc.StoreEvent(ctx, newEvent("k3")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v for event over quota, expected ErrQuotaExceeded", err)
+	}
+
+	// Квота исчерпана, но повторы отдают сохранённые события
+	replayed, err := svc.StoreEvent(ctx, newEvent("k1"))
+	if !errors.Is(err, ErrDuplicateEvent) || replayed.ID != first.ID {
+		t.Errorf("replay over quota: got %v, %+v, expected the stored event", err, replayed)
+	}
+	result, err := svc.StoreEvents(ctx, []*model.AuditEvent{newEvent("k2"), newEvent("k4")})
+	if err != nil {
+		t.Fatalf("failed to store batch: %v", err)
+	}
+	if !result.Items[0].Duplicate || result.Items[1].Error != ErrQuotaExceeded.Error() || result.Accepted != 1 || result.Rejected != 1 {
+		t.Errorf("unexpected batch result over quota: %+v", result)
+	}
+
+	report, err := quotas.Report(ctx)
+	if err != nil {
+		t.Fatalf("failed to load quota report: %v", err)
+	}
+	if len(report.Tenants) != 1 || report.Tenants[0].Used != 2 {
+		t.Errorf("unexpected quota usage: %+v", report.Tenants)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"audit-service/internal/auth"
+)
+
+// Authenticator проверяет учётные данные запроса: ключ API, JWT, если
+// настроен JWKS, и клиентский сертификат, если включён mTLS
+type Authenticator struct {
+	keys   *APIKeyService
+	tokens *auth.TokenVerifier
+	certs  *auth.CertMapper
+}
+
+// tokens и certs могут быть nil: тогда JWT или сертификаты не принимаются
+func NewAuthenticator(keys *APIKeyService, tokens *auth.TokenVerifier, certs *auth.CertMapper) *Authenticator {
+	return &Authenticator{keys: keys, tokens: tokens, certs: certs}
+}
+
+// Authenticate возвращает клиента по ключу API или токену,
+// ErrUnauthenticated - учётные данные не подошли
+func (a *Authenticator) Authenticate(ctx context.Context, credential string) (*auth.Principal, error) {
+	principal, err := a.keys.Authenticate(ctx, credential)
+	if !errors.Is(err, ErrUnauthenticated) || a.tokens == nil || !looksLikeJWT(credential) {
+		return principal, err
+	}
+
+	principal, err = a.tokens.Verify(ctx, credential)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// AuthenticateCertificate возвращает клиента по сертификату соединения;
+// nil - сертификата нет или mTLS выключен
+func (a *Authenticator) AuthenticateCertificate(state *tls.ConnectionState) *auth.Principal {
+	if a.certs == nil {
+		return nil
+	}
+	return a.certs.Principal(state)
+}
+
+// looksLikeJWT - компактная форма JWS: три части через точку
+func looksLikeJWT(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"audit-service/internal/chain"
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+)
+
+// Сколько звеньев читается из БД за раз при проверке цепочки
+const chainVerifyBatch = 1000
+
+// ChainVerifier проверяет цепочку хешей над сохранёнными событиями
+type ChainVerifier struct {
+	repo *repository.ChainRepository
+}
+
+func NewChainVerifier(repo *repository.ChainRepository) *ChainVerifier {
+	return &ChainVerifier{repo: repo}
+}
+
+// Verify проходит звенья, созданные в [from, to], начиная с предыдущего
+// звена, и останавливается на первом нарушении: хеш не сходится с
+// содержимым, prev_hash не совпадает с хешем предыдущего звена или звено
+// пропало без записи о штатном удалении
+func (v *ChainVerifier) Verify(ctx context.Context, from, to *time.Time) (*model.ChainVerification, error) {
+	if from != nil && to != nil && from.After(*to) {
+		return nil, invalidRequest("from cannot be after to")
+	}
+
+	result := &model.ChainVerification{From: from, To: to, Valid: true}
+	first, last, err := v.repo.Range(ctx, from, to)
+	if err != nil || first == 0 {
+		return result, err
+	}
+	result.FirstSeq, result.LastSeq = first, last
+
+	if err := v.walk(ctx, first, last, result, nil); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// walk проверяет звенья [first, last] вместе со связью первого из них с
+// предыдущим звеном, дополняя result, и передаёт в visit хеш каждого звена
+// диапазона, пока цепочка цела
+func (v *ChainVerifier) walk(ctx context.Context, first, last int64, result *model.ChainVerification, visit func(hash string)) error {
+	// С предыдущего звена, чтобы проверить и связь первого звена диапазона.
+	// У первого звена всей цепочки предыдущего нет, его prev_hash пуст.
+	start := first
+	var prev string
+	linked := true
+	if first > 1 {
+		start = first - 1
+		linked = false
+	}
+
+	for lo := start; lo <= last; lo += chainVerifyBatch {
+		hi := lo + chainVerifyBatch - 1
+		if hi > last {
+			hi = last
+		}
+
+		events, pruned, err := v.repo.Links(ctx, lo, hi)
+		if err != nil {
+			return err
+		}
+
+		bySeq := make(map[int64]*model.AuditEvent, len(events))
+		duplicates := make(map[int64]int64)
+		for _, event := range events {
+			if _, ok := bySeq[event.ChainSeq]; ok {
+				duplicates[event.ChainSeq] = event.ID
+			}
+			bySeq[event.ChainSeq] = event
+		}
+
+		for seq := lo; seq <= hi && result.Broken == nil; seq++ {
+			if id, ok := duplicates[seq]; ok {
+				result.Broken = &model.ChainBreak{Seq: seq, ID: id, Reason: model.ChainLinkDuplicate}
+				continue
+			}
+			if event, ok := bySeq[seq]; ok {
+				if expected, err := chain.Hash(event.PrevHash, event); err != nil || expected != event.Hash {
+					result.Broken = &model.ChainBreak{
+						Seq: seq, ID: event.ID, Reason: model.ChainHashMismatch, Expected: expected, Actual: event.Hash,
+					}
+				} else if linked && event.PrevHash != prev {
+					result.Broken = &model.ChainBreak{
+						Seq: seq, ID: event.ID, Reason: model.ChainPrevHashMismatch, Expected: prev, Actual: event.PrevHash,
+					}
+				} else {
+					prev, linked = event.Hash, true
+					result.Checked++
+					if visit != nil && seq >= first {
+						visit(event.Hash)
+					}
+				}
+				continue
+			}
+
+			if hash, ok := pruned[seq]; ok {
+				prev, linked = hash, true
+				result.Pruned++
+				if visit != nil && seq >= first {
+					visit(hash)
+				}
+				continue
+			}
+
+			result.Broken = &model.ChainBreak{Seq: seq, Reason: model.ChainLinkMissing}
+		}
+
+		if result.Broken != nil {
+			result.Valid = false
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
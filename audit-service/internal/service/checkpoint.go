@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"audit-service/internal/chain"
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+)
+
+// ErrNotCheckpointed - событие вне цепочки хешей или его окно ещё не
+// закрыто контрольной точкой
+var ErrNotCheckpointed = errors.New("event is not covered by a checkpoint yet")
+
+// ErrCheckpointMismatch - сохранённые звенья окна больше не дают корень
+// подписанной контрольной точки
+var ErrCheckpointMismatch = errors.New("checkpoint does not match stored events")
+
+// Сколько контрольных точек можно запросить за раз
+const maxCheckpointsLimit = 1000
+
+type CheckpointConfig struct {
+	// Сколько звеньев цепочки входит в одну контрольную точку
+	Window int64
+	// Как часто проверять, не набралось ли новое окно
+	Interval time.Duration
+}
+
+// Checkpointer строит подписанные контрольные точки над окнами звеньев
+// цепочки и выдаёт по ним доказательства включения событий. Строит точки
+// та реплика, что взяла advisory-блокировку; без ключа точки не строятся.
+type Checkpointer struct {
+	repo     *repository.CheckpointRepository
+	events   repository.AuditRepository
+	verifier *ChainVerifier
+	key      ed25519.PrivateKey
+	cfg      CheckpointConfig
+}
+
+func NewCheckpointer(repo *repository.CheckpointRepository, events repository.AuditRepository, verifier *ChainVerifier,
+	key ed25519.PrivateKey, cfg CheckpointConfig) *Checkpointer {
+	return &Checkpointer{repo: repo, events: events, verifier: verifier, key: key, cfg: cfg}
+}
+
+func (c *Checkpointer) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.buildPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// buildPending закрывает контрольными точками все полные окна после
+// последней точки. Неполное окно у головы цепочки ждёт следующего запуска.
+func (c *Checkpointer) buildPending(ctx context.Context) {
+	var built int
+	locked, err := c.repo.WithLock(ctx, func() error {
+		first := int64(1)
+		last, err := c.repo.Last(ctx)
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			first = last.LastSeq + 1
+		}
+		head, err := c.repo.Head(ctx)
+		if err != nil {
+			return err
+		}
+
+		for first+c.cfg.Window-1 <= head {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := c.build(ctx, first, first+c.cfg.Window-1); err != nil {
+				return err
+			}
+			built++
+			first += c.cfg.Window
+		}
+		return nil
+	})
+	if locked && built > 0 {
+		log.Printf("Built %d checkpoints", built)
+	}
+	if err != nil {
+		log.Printf("Checkpoint failed: %v", err)
+	}
+}
+
+// build проверяет звенья [first, last], подписывает корень дерева над их
+// хешами и сохраняет точку. По разорванной цепочке точка не строится.
+func (c *Checkpointer) build(ctx context.Context, first, last int64) error {
+	leaves, lastHash, broken, err := c.leaves(ctx, first, last)
+	if err != nil {
+		return err
+	}
+	if broken != nil {
+		return fmt.Errorf("hash chain is broken at link %d (%s), checkpoint %d-%d not built",
+			broken.Seq, broken.Reason, first, last)
+	}
+
+	root := hex.EncodeToString(chain.MerkleRoot(leaves))
+	signature := ed25519.Sign(c.key, chain.CheckpointMessage(first, last, root, lastHash))
+	return c.repo.Save(ctx, &model.Checkpoint{
+		FirstSeq:  first,
+		LastSeq:   last,
+		Root:      root,
+		LastHash:  lastHash,
+		Signature: hex.EncodeToString(signature),
+		PublicKey: hex.EncodeToString(c.key.Public().(ed25519.PublicKey)),
+	})
+}
+
+// leaves проверяет звенья [first, last] и их связь с предыдущим звеном и
+// возвращает хеши звеньев как листья дерева и хеш последнего звена
+func (c *Checkpointer) leaves(ctx context.Context, first, last int64) ([][]byte, string, *model.ChainBreak, error) {
+	result := &model.ChainVerification{FirstSeq: first, LastSeq: last, Valid: true}
+	leaves := make([][]byte, 0, last-first+1)
+	var lastHash string
+	err := c.verifier.walk(ctx, first, last, result, func(hash string) {
+		leaf, _ := hex.DecodeString(hash)
+		leaves = append(leaves, leaf)
+		lastHash = hash
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return leaves, lastHash, result.Broken, nil
+}
+
+// List отдаёт до limit последних контрольных точек
+func (c *Checkpointer) List(ctx context.Context, limit int) ([]*model.Checkpoint, error) {
+	if limit <= 0 || limit > maxCheckpointsLimit {
+		return nil, invalidRequest(fmt.Sprintf("limit must be between 1 and %d", maxCheckpointsLimit))
+	}
+	return c.repo.List(ctx, limit)
+}
+
+// Proof строит доказательство включения события id в контрольную точку.
+// Перед выдачей окно точки проверяется заново: подпись, цепочка и корень
+// должны сходиться с тем, что сейчас лежит в БД.
+func (c *Checkpointer) Proof(ctx context.Context, id int64) (*model.InclusionProof, error) {
+	event, err := c.events.GetEvent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if event.ChainSeq == 0 {
+		return nil, ErrNotCheckpointed
+	}
+
+	cp, err := c.repo.Covering(ctx, event.ChainSeq)
+	if errors.Is(err, repository.ErrCheckpointNotFound) {
+		return nil, ErrNotCheckpointed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, _ := hex.DecodeString(cp.PublicKey)
+	signature, _ := hex.DecodeString(cp.Signature)
+	if len(publicKey) != ed25519.PublicKeySize ||
+		!ed25519.Verify(publicKey, chain.CheckpointMessage(cp.FirstSeq, cp.LastSeq, cp.Root, cp.LastHash), signature) {
+		return nil, fmt.Errorf("%w: checkpoint %d has an invalid signature", ErrCheckpointMismatch, cp.ID)
+	}
+
+	leaves, lastHash, broken, err := c.leaves(ctx, cp.FirstSeq, cp.LastSeq)
+	if err != nil {
+		return nil, err
+	}
+	if broken != nil {
+		return nil, fmt.Errorf("%w: hash chain is broken at link %d (%s)", ErrCheckpointMismatch, broken.Seq, broken.Reason)
+	}
+	if lastHash != cp.LastHash || hex.EncodeToString(chain.MerkleRoot(leaves)) != cp.Root {
+		return nil, fmt.Errorf("%w: root of checkpoint %d differs", ErrCheckpointMismatch, cp.ID)
+	}
+
+	index := event.ChainSeq - cp.FirstSeq
+	path, err := chain.InclusionProof(leaves, int(index))
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &model.InclusionProof{
+		Event:      event,
+		Checkpoint: cp,
+		LeafIndex:  index,
+		TreeSize:   int64(len(leaves)),
+		Path:       make([]string, len(path)),
+	}
+	for i, node := range path {
+		proof.Path[i] = hex.EncodeToString(node)
+	}
+
+	return proof, nil
+}
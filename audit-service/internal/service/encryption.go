@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"audit-service/internal/encryption"
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+)
+
+// EncryptedFieldError - фильтр или группировка по зашифрованному полю
+type EncryptedFieldError = repository.EncryptedFieldError
+
+// KeyRotator по запросу перешифровывает ключи данных событий активным ключом
+// связки. Сами поля не перешифровываются: ключ данных у события прежний,
+// меняется только ключ, которым он зашифрован. Ротацию выполняет реплика,
+// взявшая advisory-блокировку, пачками по batchSize строк.
+type KeyRotator struct {
+	repo      *repository.KeyRepository
+	enc       *encryption.Encryptor
+	batchSize int
+	trigger   chan struct{}
+	running   atomic.Bool
+	last      atomic.Pointer[model.KeyRotationReport]
+}
+
+func NewKeyRotator(repo *repository.KeyRepository, enc *encryption.Encryptor, batchSize int) *KeyRotator {
+	return &KeyRotator{
+		repo:      repo,
+		enc:       enc,
+		batchSize: batchSize,
+		trigger:   make(chan struct{}, 1),
+	}
+}
+
+// Trigger просит запустить ротацию. Не блокируется: если запуск уже
+// запрошен, повторный вызов ничего не делает.
+func (k *KeyRotator) Trigger() {
+	select {
+	case k.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (k *KeyRotator) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-k.trigger:
+		}
+
+		k.rotate(ctx)
+	}
+}
+
+func (k *KeyRotator) rotate(ctx context.Context) {
+	k.running.Store(true)
+	defer k.running.Store(false)
+
+	report := &model.KeyRotationReport{ActiveKey: k.enc.ActiveKeyID(), StartedAt: time.Now().UTC()}
+	locked, err := k.repo.WithLock(ctx, func() error {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			keys, err := k.repo.Stale(ctx, report.ActiveKey, k.batchSize)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				return nil
+			}
+
+			n, err := k.repo.Rewrap(ctx, keys, func(key repository.RowKey) (repository.RowKey, error) {
+				keyID, dek, err := k.enc.Rewrap(key.KeyID, key.DEK)
+				return repository.RowKey{ID: key.ID, Timestamp: key.Timestamp, KeyID: keyID, DEK: dek}, err
+			})
+			report.Rewrapped += n
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.New("no event keys were rewrapped, rotation stopped")
+			}
+		}
+	})
+	if !locked {
+		if err != nil {
+			log.Printf("Key rotation failed: %v", err)
+		} else {
+			log.Printf("Key rotation is already running on another replica")
+		}
+		return
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	if err != nil {
+		report.Error = err.Error()
+		log.Printf("Key rotation to %q stopped after %d events: %v", report.ActiveKey, report.Rewrapped, err)
+	} else {
+		log.Printf("Key rotation to %q rewrapped %d events", report.ActiveKey, report.Rewrapped)
+	}
+	k.last.Store(report)
+}
+
+// Status отдаёт ключи связки, число событий на каждом ключе и итог последней
+// ротации на этой реплике
+func (k *KeyRotator) Status(ctx context.Context) (*model.EncryptionStatus, error) {
+	usage, err := k.repo.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &model.EncryptionStatus{
+		ActiveKey:    k.enc.ActiveKeyID(),
+		Keys:         k.enc.KeyIDs(),
+		Events:       usage,
+		Rotating:     k.running.Load(),
+		LastRotation: k.last.Load(),
+	}
+	known := make(map[string]bool, len(status.Keys))
+	for _, id := range status.Keys {
+		known[id] = true
+	}
+	for id := range usage {
+		if !known[id] {
+			status.MissingKeys = append(status.MissingKeys, id)
+		}
+	}
+	sort.Strings(status.MissingKeys)
+
+	return status, nil
+}
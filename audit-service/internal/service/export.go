@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"audit-service/internal/export"
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+)
+
+// Сколько разных путей внутри attributes и внутри res может стать колонками
+const maxExportColumns = 1000
+
+type ExportService interface {
+	// ExportEvents пишет в w все события, подходящие под фильтры. Ошибки
+	// валидации возвращаются до первой записи в w.
+	ExportEvents(ctx context.Context, filters model.EventFilters, format string, w io.Writer) error
+}
+
+type exportService struct {
+	repo repository.AuditRepository
+}
+
+// repo должен смотреть на реплику для чтения: выгрузка читает всю выборку
+// и не должна нагружать primary
+func NewExportService(repo repository.AuditRepository) ExportService {
+	return &exportService{repo: repo}
+}
+
+func (s *exportService) ExportEvents(ctx context.Context, filters model.EventFilters, format string, w io.Writer) error {
+	if _, ok := export.ContentType(format); !ok {
+		return invalidRequest("format must be one of csv, ndjson, parquet")
+	}
+	// Ограничение в 30 дней из FindEvents здесь не действует: выгрузка не
+	// держит выборку в памяти и читает с реплики
+	if filters.TimestampStart != nil && filters.TimestampEnd != nil && filters.TimestampStart.After(*filters.TimestampEnd) {
+		return invalidRequest("timestamp_start cannot be after timestamp_end")
+	}
+	filters.Cursor = nil
+	filters.Limit = 0
+
+	// Колонки CSV и Parquet нужны до первой строки, поэтому пути внутри
+	// JSONB собираются отдельным проходом в том же снимке, что и строки
+	var writer export.Writer
+	start := func(columns *model.ExportColumns) error {
+		if len(columns.Attributes) > maxExportColumns || len(columns.Response) > maxExportColumns {
+			return invalidRequest(fmt.Sprintf(
+				"events have more than %d distinct attribute or res fields, narrow the filters or use format=ndjson", maxExportColumns))
+		}
+		var err error
+		writer, err = export.NewWriter(format, w, columns)
+		return err
+	}
+
+	columnLimit := 0
+	if format != model.ExportNDJSON {
+		columnLimit = maxExportColumns + 1
+	} else if err := start(&model.ExportColumns{}); err != nil {
+		return err
+	}
+
+	err := s.repo.ExportEvents(ctx, filters, columnLimit, start, func(event *model.AuditEvent) error {
+		return writer.Write(event)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"audit-service/internal/repository"
+)
+
+type PartitionConfig struct {
+	// На сколько месяцев вперёд держать готовые секции
+	MonthsAhead int
+	// Сколько полных месяцев хранить в audit_events, 0 - не отсоединять
+	RetainMonths int
+	// Как часто проверять секции
+	Interval time.Duration
+}
+
+// PartitionManager заранее создаёт месячные секции audit_events и
+// отсоединяет устаревшие. Запускается на каждой реплике, но работу делает
+// та, что взяла advisory-блокировку.
+type PartitionManager struct {
+	repo *repository.PartitionRepository
+	cfg  PartitionConfig
+}
+
+func NewPartitionManager(repo *repository.PartitionRepository, cfg PartitionConfig) *PartitionManager {
+	return &PartitionManager{repo: repo, cfg: cfg}
+}
+
+func (m *PartitionManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.maintain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *PartitionManager) maintain(ctx context.Context) {
+	now := time.Now().UTC()
+
+	var detachBefore time.Time
+	if m.cfg.RetainMonths > 0 {
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		detachBefore = month.AddDate(0, -m.cfg.RetainMonths, 0)
+	}
+
+	report, locked, err := m.repo.Maintain(ctx, now, m.cfg.MonthsAhead, detachBefore)
+	if !locked && err == nil {
+		return
+	}
+	if report != nil {
+		for _, name := range report.Created {
+			log.Printf("Created partition %s", name)
+		}
+		for _, name := range report.Detached {
+			log.Printf("Detached partition %s", name)
+		}
+	}
+	if err != nil {
+		log.Printf("Partition maintenance failed: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+)
+
+// ErrQuotaExceeded - арендатор исчерпал квоту событий в текущей минуте
+var ErrQuotaExceeded = errors.New("tenant event quota exceeded")
+
+// Длина окна квоты
+const quotaWindow = time.Minute
+
+// TenantQuotas ограничивает число событий, которые арендатор записывает за
+// минуту. Окна фиксированные, счётчики ведёт хранилище: с Postgres они общие
+// для всех реплик.
+type TenantQuotas struct {
+	repo repository.QuotaRepository
+	// Лимиты по арендаторам, "*" - для арендаторов без своего лимита.
+	// Арендатор без лимита не ограничен.
+	limits map[string]int
+
+	mu          sync.Mutex
+	window      time.Time
+	unavailable bool
+}
+
+func NewTenantQuotas(repo repository.QuotaRepository, limits map[string]int) *TenantQuotas {
+	return &TenantQuotas{repo: repo, limits: limits}
+}
+
+func (q *TenantQuotas) limit(tenantID string) int {
+	if limit, ok := q.limits[tenantID]; ok {
+		return limit
+	}
+	return q.limits["*"]
+}
+
+// Allow списывает до n событий с квоты арендатора и возвращает, сколько
+// списано. Остальные n считаются отклонёнными. Пока счётчики недоступны,
+// квота не ограничивает запись: события при недоступной БД уходят в спул,
+// и отказывать им из-за квоты незачем.
+func (q *TenantQuotas) Allow(ctx context.Context, tenantID string, n int) int {
+	limit := q.limit(tenantID)
+	if limit <= 0 {
+		return n
+	}
+
+	window := time.Now().Truncate(quotaWindow)
+	q.prune(ctx, window)
+
+	allowed, err := q.repo.Take(ctx, tenantID, window, n, limit)
+	q.setUnavailable(err)
+	if err != nil {
+		return n
+	}
+	return allowed
+}
+
+// Refund возвращает на квоту арендатора n событий, списанных Allow, но не
+// записанных: повтор уже сохранённого события квоту не тратит. Если окно
+// успело смениться, возврат достаётся новому окну.
+func (q *TenantQuotas) Refund(ctx context.Context, tenantID string, n int) {
+	if n <= 0 || q.limit(tenantID) <= 0 {
+		return
+	}
+	if err := q.repo.Refund(ctx, tenantID, time.Now().Truncate(quotaWindow), n); err != nil {
+		log.Printf("Failed to refund tenant quota: %v", err)
+	}
+}
+
+// prune удаляет счётчики прошлых окон, когда реплика впервые пишет в новом
+func (q *TenantQuotas) prune(ctx context.Context, window time.Time) {
+	q.mu.Lock()
+	fresh := window.After(q.window)
+	if fresh {
+		q.window = window
+	}
+	q.mu.Unlock()
+
+	if fresh {
+		if err := q.repo.Prune(ctx, window); err != nil {
+			log.Printf("Failed to prune tenant quota usage: %v", err)
+		}
+	}
+}
+
+// setUnavailable пишет в лог только смену доступности счётчиков, а не
+// каждую запись
+func (q *TenantQuotas) setUnavailable(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch {
+	case err != nil && !q.unavailable:
+		log.Printf("Tenant quotas are not enforced until the counters are back: %v", err)
+	case err == nil && q.unavailable:
+		log.Printf("Tenant quotas are enforced again")
+	}
+	q.unavailable = err != nil
+}
+
+// QuotaRetryAfter - сколько ждать начала следующего окна квот
+func QuotaRetryAfter() time.Duration {
+	now := time.Now()
+	return now.Truncate(quotaWindow).Add(quotaWindow).Sub(now)
+}
+
+// Report возвращает расход квот в текущем окне арендаторами с лимитом -
+// заданным явно или уже писавшими в этом окне
+func (q *TenantQuotas) Report(ctx context.Context) (*model.TenantQuotaReport, error) {
+	window := time.Now().Truncate(quotaWindow)
+	usage, err := q.repo.Usage(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make(map[string]model.TenantQuota)
+	for id := range q.limits {
+		if id != "*" {
+			tenants[id] = model.TenantQuota{Tenant: id}
+		}
+	}
+	for _, quota := range usage {
+		tenants[quota.Tenant] = quota
+	}
+
+	report := &model.TenantQuotaReport{WindowStart: window, Tenants: make([]model.TenantQuota, 0, len(tenants))}
+	for id, quota := range tenants {
+		quota.Limit = q.limit(id)
+		report.Tenants = append(report.Tenants, quota)
+	}
+	sort.Slice(report.Tenants, func(i, j int) bool { return report.Tenants[i].Tenant < report.Tenants[j].Tenant })
+	return report, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+	"audit-service/internal/spool"
+	"audit-service/internal/tenant"
+)
+
+// Replayer переносит события из локального спула в audit_events, когда БД
+// снова доступна. Запись идёт через ReplayEvents с ON CONFLICT по event_id,
+// поэтому повторное воспроизведение сегмента после сбоя не создаёт дублей.
+type Replayer struct {
+	repo    repository.AuditRepository
+	spool   *spool.Spool
+	trigger chan struct{}
+}
+
+func NewReplayer(repo repository.AuditRepository, sp *spool.Spool) *Replayer {
+	return &Replayer{
+		repo:    repo,
+		spool:   sp,
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// Trigger просит воспроизвести спул. Не блокируется: если запуск уже
+// запрошен, повторный вызов ничего не делает.
+func (r *Replayer) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Replayer) Run(ctx context.Context) {
+	// В спуле события всех арендаторов
+	ctx = tenant.WithAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.trigger:
+		}
+
+		segments, _, err := r.spool.Pending()
+		if err != nil {
+			log.Printf("Failed to inspect spool: %v", err)
+			continue
+		}
+		if segments == 0 {
+			continue
+		}
+
+		inserted := 0
+		replayed, err := r.spool.Replay(ctx, func(ctx context.Context, events []*model.AuditEvent) error {
+			n, err := r.repo.ReplayEvents(ctx, events)
+			inserted += n
+			return err
+		})
+		if err != nil {
+			log.Printf("Spool replay stopped after %d events: %v", replayed, err)
+			continue
+		}
+
+		log.Printf("Spool replayed: %d events read, %d inserted, %d already stored",
+			replayed, inserted, replayed-inserted)
+	}
+}
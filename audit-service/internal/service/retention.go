@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+)
+
+type RetentionConfig struct {
+	Rules       []model.RetentionRule
+	DefaultDays int
+	Interval    time.Duration
+	BatchSize   int
+	DryRun      bool
+}
+
+// RetentionPurger по расписанию удаляет события с истёкшим сроком хранения.
+// Запускается на каждой реплике, очистку выполняет та, что взяла
+// advisory-блокировку; итог последнего прогона видят все реплики.
+type RetentionPurger struct {
+	repo *repository.RetentionRepository
+	cfg  RetentionConfig
+	last atomic.Pointer[model.RetentionReport]
+}
+
+func NewRetentionPurger(repo *repository.RetentionRepository, cfg RetentionConfig) *RetentionPurger {
+	return &RetentionPurger{repo: repo, cfg: cfg}
+}
+
+// Enabled сообщает, есть ли хоть один конечный срок хранения
+func (p *RetentionPurger) Enabled() bool {
+	if p.cfg.DefaultDays > 0 {
+		return true
+	}
+	for _, rule := range p.cfg.Rules {
+		if rule.KeepDays > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LastReport - итог последнего прогона очистки в кластере или nil
+func (p *RetentionPurger) LastReport() *model.RetentionReport {
+	return p.last.Load()
+}
+
+func (p *RetentionPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *RetentionPurger) purge(ctx context.Context) {
+	report, locked, err := p.repo.Purge(ctx, p.cfg.Rules, p.cfg.DefaultDays, time.Now().UTC(), p.cfg.BatchSize, p.cfg.DryRun)
+	if err != nil {
+		log.Printf("Retention purge failed: %v", err)
+	}
+	if locked && report != nil {
+		verb := "purged"
+		if report.DryRun {
+			verb = "would purge"
+		}
+		log.Printf("Retention %s %d events (%d partitions dropped)", verb, report.Total, len(report.DroppedPartitions))
+	}
+
+	// Прогон мог выполнить другая реплика
+	last, err := p.repo.LastRun(ctx)
+	if err != nil {
+		log.Printf("Failed to load last retention run: %v", err)
+		return
+	}
+	p.last.Store(last)
+}
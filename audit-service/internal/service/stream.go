@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"audit-service/internal/model"
+	"audit-service/internal/repository"
+	"audit-service/internal/tenant"
+)
+
+// Сколько событий может ждать отправки одному подписчику. Подписчик, который
+// не успевает читать, отключается, чтобы не задерживать остальных.
+const subscriptionBuffer = 1024
+
+// Subscription - подписка живой ленты на события, подходящие под фильтры.
+// Канал Events закрывается при отмене подписки, отставании подписчика или
+// остановке ленты.
+type Subscription struct {
+	Events  chan *model.AuditEvent
+	matcher *repository.EventMatcher
+	lagged  bool
+}
+
+// Lagged сообщает, что подписка закрыта из-за переполнения буфера. Читать
+// можно только после закрытия Events.
+func (s *Subscription) Lagged() bool {
+	return s.lagged
+}
+
+// EventStream раздаёт новые события подписчикам живой ленты. О вставках
+// узнаёт через LISTEN/NOTIFY, поэтому видит события, записанные любой
+// репликой сервиса. По каждому уведомлению события из него читаются один
+// раз, а фильтры подписчиков проверяются в памяти с той же семантикой, что
+// и в поиске.
+type EventStream struct {
+	repo repository.AuditRepository
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewEventStream(repo repository.AuditRepository) *EventStream {
+	return &EventStream{
+		repo: repo,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe создаёт подписку, которая отменяется вместе с ctx. Подписчик
+// получает только события арендатора из ctx. После Close подписка
+// создаётся уже закрытой.
+func (s *EventStream) Subscribe(ctx context.Context, filters model.EventFilters) (*Subscription, error) {
+	filters.Tenant = tenant.FromContext(ctx)
+	matcher, err := repository.NewEventMatcher(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &Subscription{
+		Events:  make(chan *model.AuditEvent, subscriptionBuffer),
+		matcher: matcher,
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		close(sub.Events)
+		return sub, nil
+	}
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.unsubscribe(sub)
+	}()
+
+	return sub, nil
+}
+
+func (s *EventStream) unsubscribe(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+	delete(s.subs, sub)
+	close(sub.Events)
+}
+
+// Close закрывает все подписки и перестаёт принимать новые. Ленты
+// заканчиваются сразу, поэтому остановка сервера не ждёт их до дедлайна.
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for sub := range s.subs {
+		delete(s.subs, sub)
+		close(sub.Events)
+	}
+}
+
+// Publish читает вставленные события и отправляет каждому подписчику
+// подходящие под его фильтры, от старых к новым. Чтение ограничено и по
+// времени событий, чтобы Postgres не просматривал все секции. Вызывается из
+// одной горутины слушателя уведомлений.
+func (s *EventStream) Publish(inserted repository.InsertedEvents) {
+	s.mu.Lock()
+	subscribed := len(s.subs) > 0
+	s.mu.Unlock()
+	if !subscribed {
+		return
+	}
+
+	// События всех арендаторов: каждый подписчик отбирает свои
+	ctx, cancel := context.WithTimeout(tenant.WithAll(context.Background()), 5*time.Second)
+	filters := model.EventFilters{IDs: inserted.IDs, Limit: len(inserted.IDs)}
+	switch {
+	case inserted.From.IsZero():
+	case inserted.From.Equal(inserted.To):
+		filters.Timestamp = &inserted.From
+	default:
+		filters.TimestampStart, filters.TimestampEnd = &inserted.From, &inserted.To
+	}
+	events, err := s.repo.FindEvents(ctx, filters)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to load events for live tail: %v", err)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subs {
+		s.deliverLocked(sub, events)
+	}
+}
+
+func (s *EventStream) deliverLocked(sub *Subscription, events []*model.AuditEvent) {
+	// FindEvents отдаёт от новых к старым, в ленте наоборот
+	for i := len(events) - 1; i >= 0; i-- {
+		if !sub.matcher.Match(events[i]) {
+			continue
+		}
+		select {
+		case sub.Events <- events[i]:
+		default:
+			delete(s.subs, sub)
+			sub.lagged = true
+			close(sub.Events)
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"audit-service/internal/model"
+)
+
+// Виды хронологий
+const (
+	TimelineSession = "session"
+	TimelineRequest = "request"
+)
+
+// Больше событий в одну хронологию не попадает
+const maxTimelineEvents = 10000
+
+// ErrTimelineNotFound - у сессии или запроса нет ни одного события
+var ErrTimelineNotFound = errors.New("no events for timeline")
+
+// errTimelineFull останавливает чтение после maxTimelineEvents событий
+var errTimelineFull = errors.New("timeline is full")
+
+// Timeline восстанавливает ход сессии или запроса по событиям с данным
+// session_id или request_id
+func (s *auditService) Timeline(ctx context.Context, kind string, id int64) (*model.Timeline, error) {
+	var filters model.EventFilters
+	switch kind {
+	case TimelineSession:
+		filters.SessionIDs = []int64{id}
+	case TimelineRequest:
+		filters.RequestIDs = []int64{id}
+	default:
+		return nil, fmt.Errorf("unknown timeline kind %q", kind)
+	}
+
+	// Читаем от старых к новым, чтобы при усечении терялся хвост, а не
+	// начало сессии
+	timeline := &model.Timeline{Kind: kind, ID: id, Outcomes: make(map[string]int)}
+	var events []*model.AuditEvent
+	err := s.repo.ExportEvents(ctx, filters, 0, nil, func(event *model.AuditEvent) error {
+		if len(events) == maxTimelineEvents {
+			timeline.Truncated = true
+			return errTimelineFull
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errTimelineFull) {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, ErrTimelineNotFound
+	}
+
+	first, last := events[0], events[len(events)-1]
+	timeline.Start = first.Timestamp
+	timeline.End = last.Timestamp
+	timeline.DurationMs = last.Timestamp.Sub(first.Timestamp).Milliseconds()
+	timeline.EventCount = len(events)
+	timeline.FirstOperation = first.Operation
+	timeline.LastOperation = last.Operation
+
+	components := make(map[string]bool)
+	timeline.Steps = make([]model.TimelineStep, len(events))
+	for i, event := range events {
+		step := model.TimelineStep{
+			Event:    event,
+			OffsetMs: event.Timestamp.Sub(first.Timestamp).Milliseconds(),
+			Outcome:  eventOutcome(event),
+		}
+		if i > 0 {
+			step.GapMs = event.Timestamp.Sub(events[i-1].Timestamp).Milliseconds()
+		}
+		timeline.Steps[i] = step
+
+		timeline.Outcomes[step.Outcome]++
+		if event.Component != nil {
+			components[*event.Component] = true
+		}
+	}
+
+	timeline.Components = make([]string, 0, len(components))
+	for component := range components {
+		timeline.Components = append(timeline.Components, component)
+	}
+	sort.Strings(timeline.Components)
+
+	return timeline, nil
+}
+
+// eventOutcome сводит res события к короткому итогу:
+//   - "none", если res нет;
+//   - "error", если в res есть непустое поле error;
+//   - значение res.status (строка или число), если оно есть;
+//   - "ok" в остальных случаях.
+func eventOutcome(event *model.AuditEvent) string {
+	if event.Response == nil {
+		return "none"
+	}
+	res := *event.Response
+
+	if v, ok := res["error"]; ok && v != nil && v != false && v != "" {
+		return "error"
+	}
+
+	switch status := res["status"].(type) {
+	case string:
+		if status != "" {
+			return status
+		}
+	case float64:
+		return strconv.FormatFloat(status, 'f', -1, 64)
+	}
+
+	return "ok"
+}
@@ -0,0 +1,421 @@
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"audit-service/internal/model"
+)
+
+// Формат записи в сегменте:
+//
+//	uint32 длина полезной нагрузки | uint32 CRC32C нагрузки | JSON события
+//
+// Сегменты только дописываются. Активный сегмент закрывается при достижении
+// maxSegmentSize или перед воспроизведением, закрытые сегменты удаляются
+// после успешной записи всех событий в БД.
+const (
+	segmentExt    = ".seg"
+	corruptExt    = ".corrupt"
+	headerSize    = 8
+	maxRecordSize = 16 << 20
+	// Сколько событий передаётся в БД за один вызов при воспроизведении
+	replayChunkSize = 500
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errChecksum = errors.New("spool record checksum mismatch")
+)
+
+type Spool struct {
+	dir            string
+	maxSegmentSize int64
+
+	mu         sync.Mutex
+	active     *os.File
+	activeSeq  uint64
+	activeSize int64
+	nextSeq    uint64
+}
+
+func Open(dir string, maxSegmentSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+
+	if err := recoverTmpSegments(dir); err != nil {
+		return nil, err
+	}
+
+	seqs, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxSegmentSize: maxSegmentSize, nextSeq: 1}
+	if len(seqs) > 0 {
+		s.nextSeq = seqs[len(seqs)-1] + 1
+	}
+
+	return s, nil
+}
+
+// Append дописывает события в активный сегмент и синхронизирует файл на диск,
+// после возврата без ошибки события переживут перезапуск процесса.
+func (s *Spool) Append(events []*model.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil || s.activeSize >= s.maxSegmentSize {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.appendLocked(events); err != nil {
+		// Откатываем недописанный хвост, чтобы следующая запись легла на границу
+		if truncErr := s.active.Truncate(s.activeSize); truncErr != nil {
+			log.Printf("Failed to truncate spool segment after error: %v", truncErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *Spool) appendLocked(events []*model.AuditEvent) error {
+	w := bufio.NewWriter(s.active)
+	var written int64
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode spooled event: %w", err)
+		}
+
+		var header [headerSize]byte
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+
+		if _, err := w.Write(header[:]); err != nil {
+			return fmt.Errorf("failed to write spool record: %w", err)
+		}
+		if _, err := w.Write(payload); err != nil {
+			return fmt.Errorf("failed to write spool record: %w", err)
+		}
+		written += int64(headerSize + len(payload))
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+	s.activeSize += written
+
+	return nil
+}
+
+// Replay закрывает активный сегмент и по порядку передаёт события из всех
+// закрытых сегментов в store. Сегмент удаляется только после того, как store
+// успешно принял все его события, поэтому при сбое часть событий будет
+// передана повторно: store обязан быть идемпотентным по event_id.
+//
+// Повреждённые записи пропускаются: чтение продолжается со следующей
+// целой записи, а сам сегмент после воспроизведения откладывается с
+// суффиксом .corrupt для ручного разбора. Уцелевшие записи из него уже
+// переданы в store, повторная загрузка отложенного сегмента их не задвоит.
+func (s *Spool) Replay(ctx context.Context, store func(ctx context.Context, events []*model.AuditEvent) error) (int, error) {
+	s.mu.Lock()
+	err := s.sealLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	seqs, err := listSegments(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, seq := range seqs {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		n, corrupted, err := s.replaySegment(ctx, seq, store)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+
+		path := s.segmentPath(seq)
+		if corrupted {
+			log.Printf("Spool segment %s has corrupted records, moving aside", path)
+			if err := os.Rename(path, path+corruptExt); err != nil {
+				return replayed, fmt.Errorf("failed to move corrupted segment: %w", err)
+			}
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return replayed, fmt.Errorf("failed to remove replayed segment: %w", err)
+		}
+	}
+
+	return replayed, nil
+}
+
+// Pending возвращает число сегментов и байт, ожидающих воспроизведения,
+// включая ещё не закрытый активный сегмент
+func (s *Spool) Pending() (int, int64, error) {
+	s.mu.Lock()
+	activeSize := s.activeSize
+	s.mu.Unlock()
+
+	seqs, err := listSegments(s.dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	segments := len(seqs)
+	size := activeSize
+	if activeSize > 0 {
+		segments++
+	}
+	for _, seq := range seqs {
+		info, err := os.Stat(s.segmentPath(seq))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to stat spool segment: %w", err)
+		}
+		size += info.Size()
+	}
+
+	return segments, size, nil
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sealLocked()
+}
+
+// replaySegment передаёт в store события сегмента; corrupted - в сегменте
+// были повреждённые записи, которые пришлось пропустить
+func (s *Spool) replaySegment(ctx context.Context, seq uint64, store func(ctx context.Context, events []*model.AuditEvent) error) (replayed int, corrupted bool, err error) {
+	data, err := os.ReadFile(s.segmentPath(seq))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read spool segment: %w", err)
+	}
+
+	chunk := make([]*model.AuditEvent, 0, replayChunkSize)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := store(ctx, chunk); err != nil {
+			return err
+		}
+		replayed += len(chunk)
+		chunk = chunk[:0]
+		return nil
+	}
+
+	for offset := 0; offset < len(data); {
+		event, size, err := decodeRecord(data[offset:])
+		if err != nil {
+			next := resync(data, offset+1)
+			if next < 0 && errors.Is(err, io.ErrUnexpectedEOF) {
+				// Оборванная последняя запись: процесс упал посреди Append, а
+				// значит клиент не получил подтверждения и эта запись не
+				// считается принятой
+				log.Printf("Spool segment %d has a torn tail record, ignoring it", seq)
+				break
+			}
+			log.Printf("Spool segment %d: skipping corrupted record at offset %d: %v", seq, offset, err)
+			corrupted = true
+			if next < 0 {
+				break
+			}
+			offset = next
+			continue
+		}
+
+		offset += size
+		chunk = append(chunk, event)
+		if len(chunk) == replayChunkSize {
+			if err := flush(); err != nil {
+				return replayed, corrupted, err
+			}
+		}
+	}
+
+	return replayed, corrupted, flush()
+}
+
+// decodeRecord разбирает запись в начале data и возвращает её размер вместе
+// с заголовком. io.ErrUnexpectedEOF - запись не помещается в data.
+func decodeRecord(data []byte) (*model.AuditEvent, int, error) {
+	if len(data) < headerSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	size := binary.BigEndian.Uint32(data[0:4])
+	sum := binary.BigEndian.Uint32(data[4:8])
+	if size > maxRecordSize {
+		return nil, 0, fmt.Errorf("%w: record size %d", errChecksum, size)
+	}
+	if uint64(len(data)-headerSize) < uint64(size) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	payload := data[headerSize : headerSize+int(size)]
+	if crc32.Checksum(payload, crcTable) != sum {
+		return nil, 0, errChecksum
+	}
+
+	var event model.AuditEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errChecksum, err)
+	}
+
+	return &event, headerSize + int(size), nil
+}
+
+// resync ищет, начиная с from, смещение следующей целой записи; -1 - таких
+// записей нет. Нагрузка записи - JSON-объект, поэтому контрольная сумма
+// считается только у кандидатов, которые начинаются с { и кончаются на }.
+func resync(data []byte, from int) int {
+	for offset := from; offset+headerSize < len(data); offset++ {
+		size := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		end := offset + headerSize + size
+		if size < 2 || size > maxRecordSize || end > len(data) {
+			continue
+		}
+		if data[offset+headerSize] != '{' || data[end-1] != '}' {
+			continue
+		}
+		if _, _, err := decodeRecord(data[offset:]); err == nil {
+			return offset
+		}
+	}
+	return -1
+}
+
+func (s *Spool) rotateLocked() error {
+	if err := s.sealLocked(); err != nil {
+		return err
+	}
+
+	seq := s.nextSeq
+	f, err := os.OpenFile(s.segmentPath(seq)+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+
+	s.active = f
+	s.activeSeq = seq
+	s.activeSize = 0
+	s.nextSeq++
+
+	return nil
+}
+
+// sealLocked закрывает активный сегмент и переименовывает его из .tmp,
+// после чего он становится виден для воспроизведения
+func (s *Spool) sealLocked() error {
+	if s.active == nil {
+		return nil
+	}
+
+	tmpPath := s.active.Name()
+	if err := s.active.Close(); err != nil {
+		return fmt.Errorf("failed to close spool segment: %w", err)
+	}
+	s.active = nil
+
+	if s.activeSize == 0 {
+		return os.Remove(tmpPath)
+	}
+	if err := os.Rename(tmpPath, s.segmentPath(s.activeSeq)); err != nil {
+		return fmt.Errorf("failed to seal spool segment: %w", err)
+	}
+
+	return syncDir(s.dir)
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// listSegments возвращает номера закрытых сегментов по возрастанию
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool dir: %w", err)
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	return seqs, nil
+}
+
+// recoverTmpSegments закрывает сегменты .tmp, оставшиеся после падения
+// процесса: всё, что в них успело попасть на диск, было подтверждено клиентам
+func recoverTmpSegments(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt+".tmp") {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dir, name), filepath.Join(dir, strings.TrimSuffix(name, ".tmp"))); err != nil {
+			return fmt.Errorf("failed to recover spool segment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
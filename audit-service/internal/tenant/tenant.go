@@ -0,0 +1,66 @@
+// Package tenant переносит арендатора запроса через context. Хранилища
+// ограничивают им каждый запрос к событиям, поэтому команды, делящие один
+// кластер, не видят событий друг друга.
+package tenant
+
+import (
+	"context"
+	"regexp"
+)
+
+// Default - арендатор событий, записанных до появления арендаторов, и
+// клиентов, которым арендатор не назначен
+const Default = "default"
+
+// Header - заголовок, которым арендатора выбирает клиент без закреплённого
+// арендатора
+const Header = "X-Tenant-ID"
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Valid сообщает, годится ли id в идентификаторы арендатора
+func Valid(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// All - значение audit.tenant_id в Postgres, открывающее строки всех
+// арендаторов. Под idPattern не подходит, поэтому клиент выбрать его не может.
+const All = "*"
+
+type tenantKey struct{}
+
+type allKey struct{}
+
+// WithTenant ограничивает ctx арендатором id, снимая пометку WithAll
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(context.WithValue(ctx, allKey{}, false), tenantKey{}, id)
+}
+
+// FromContext возвращает арендатора запроса; пустая строка - запрос не
+// ограничен арендатором (фоновые задачи, администратор кластера)
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey{}).(string)
+	return id
+}
+
+// WithAll помечает ctx как запрос ко всем арендаторам: администратора
+// кластера без арендатора или фоновой задачи сервиса. Арендатор, заданный
+// раньше, снимается. Запрос без арендатора и без этой пометки Postgres не
+// отдаст ни одной строки.
+func WithAll(ctx context.Context) context.Context {
+	return context.WithValue(context.WithValue(ctx, tenantKey{}, ""), allKey{}, true)
+}
+
+// IsAll сообщает, помечен ли ctx через WithAll
+func IsAll(ctx context.Context) bool {
+	all, _ := ctx.Value(allKey{}).(bool)
+	return all
+}
+
+// ForWrite - арендатор, которому принадлежат события, записанные в ctx
+func ForWrite(ctx context.Context) string {
+	if id := FromContext(ctx); id != "" {
+		return id
+	}
+	return Default
+}
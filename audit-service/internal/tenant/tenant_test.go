@@ -0,0 +1,21 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+)
+
+// Последняя из WithTenant и WithAll определяет, кого видит запрос
+func TestWithAllOverridesTenant(t *testing.T) {
+	acme := WithTenant(context.Background(), "acme")
+
+	all := WithAll(acme)
+	if id := FromContext(all); id != "" || !IsAll(all) {
+		t.Errorf("WithAll over acme: tenant %q, all %v", id, IsAll(all))
+	}
+
+	scoped := WithTenant(all, "globex")
+	if id := FromContext(scoped); id != "globex" || IsAll(scoped) {
+		t.Errorf("WithTenant over WithAll: tenant %q, all %v", id, IsAll(scoped))
+	}
+}
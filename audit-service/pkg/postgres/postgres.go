@@ -9,11 +9,17 @@ import (
     _ "github.com/lib/pq"
 )
 
-func NewConnection(host string, port int, user, password, dbname string) (*sql.DB, error) {
-    connStr := fmt.Sprintf(
+// ConnString собирает строку подключения для lib/pq. Нужна отдельно от
+// NewConnection для соединений вне пула, например pq.Listener.
+func ConnString(host string, port int, user, password, dbname string) string {
+    return fmt.Sprintf(
         "host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
         host, port, user, password, dbname,
     )
+}
+
+func NewConnection(host string, port int, user, password, dbname string) (*sql.DB, error) {
+    connStr := ConnString(host, port, user, password, dbname)
     
     db, err := sql.Open("postgres", connStr)
     if err != nil {
@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "net/url"
+    "time"
+
+    _ "modernc.org/sqlite"
+)
+
+// NewConnection открывает файл базы SQLite. Журнал WAL позволяет читать во
+// время записи, а транзакции сразу берут блокировку записи (BEGIN
+// IMMEDIATE): две записи ждут друг друга по busy_timeout, а не падают при
+// повышении блокировки. Путь должен указывать на файл - у :memory: каждое
+// соединение пула получило бы свою базу.
+func NewConnection(path string) (*sql.DB, error) {
+    params := url.Values{
+        "_pragma": {"journal_mode(WAL)", "busy_timeout(5000)", "foreign_keys(1)"},
+        "_txlock": {"immediate"},
+    }
+    
+    db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+    if err != nil {
+        return nil, fmt.Errorf("failed to open database: %w", err)
+    }
+    
+    db.SetMaxOpenConns(8)
+    db.SetMaxIdleConns(2)
+    db.SetConnMaxIdleTime(2 * time.Minute)
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    if err := db.PingContext(ctx); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to ping database: %w", err)
+    }
+    
+    return db, nil
+}
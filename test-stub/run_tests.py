@@ -78,7 +78,7 @@ class AuditServiceTester:
             print(f"   Ответ: {response.text}")
             return []
         
-        return response.json()
+        return response.json()["events"]
     
     def verify_event_in_db(self, event_id):
         """Проверяет, что событие действительно сохранено в БД"""